@@ -0,0 +1,96 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+// AlertLevel is the severity of a TLS alert.
+type AlertLevel int
+
+const (
+	AlertWarning AlertLevel = C.SSL3_AL_WARNING
+	AlertFatal   AlertLevel = C.SSL3_AL_FATAL
+)
+
+// AlertDescription identifies a TLS alert.
+type AlertDescription int
+
+const (
+	AlertCloseNotify            AlertDescription = C.SSL_AD_CLOSE_NOTIFY
+	AlertUnexpectedMessage      AlertDescription = C.SSL_AD_UNEXPECTED_MESSAGE
+	AlertBadRecordMac           AlertDescription = C.SSL_AD_BAD_RECORD_MAC
+	AlertRecordOverflow         AlertDescription = C.SSL_AD_RECORD_OVERFLOW
+	AlertHandshakeFailure       AlertDescription = C.SSL_AD_HANDSHAKE_FAILURE
+	AlertBadCertificate         AlertDescription = C.SSL_AD_BAD_CERTIFICATE
+	AlertUnsupportedCertificate AlertDescription = C.SSL_AD_UNSUPPORTED_CERTIFICATE
+	AlertCertificateRevoked     AlertDescription = C.SSL_AD_CERTIFICATE_REVOKED
+	AlertCertificateExpired     AlertDescription = C.SSL_AD_CERTIFICATE_EXPIRED
+	AlertCertificateUnknown     AlertDescription = C.SSL_AD_CERTIFICATE_UNKNOWN
+	AlertIllegalParameter       AlertDescription = C.SSL_AD_ILLEGAL_PARAMETER
+	AlertUnknownCA              AlertDescription = C.SSL_AD_UNKNOWN_CA
+	AlertAccessDenied           AlertDescription = C.SSL_AD_ACCESS_DENIED
+	AlertDecodeError            AlertDescription = C.SSL_AD_DECODE_ERROR
+	AlertDecryptError           AlertDescription = C.SSL_AD_DECRYPT_ERROR
+	AlertProtocolVersion        AlertDescription = C.SSL_AD_PROTOCOL_VERSION
+	AlertInsufficientSecurity   AlertDescription = C.SSL_AD_INSUFFICIENT_SECURITY
+	AlertInternalError          AlertDescription = C.SSL_AD_INTERNAL_ERROR
+	AlertUserCancelled          AlertDescription = C.SSL_AD_USER_CANCELLED
+	AlertNoRenegotiation        AlertDescription = C.SSL_AD_NO_RENEGOTIATION
+	AlertUnsupportedExtension   AlertDescription = C.SSL_AD_UNSUPPORTED_EXTENSION
+	AlertUnrecognizedName       AlertDescription = C.SSL_AD_UNRECOGNIZED_NAME
+	// the wire values of alerts newer than some supported OpenSSL versions
+	AlertCertificateRequired   AlertDescription = 116
+	AlertNoApplicationProtocol AlertDescription = 120
+)
+
+// Alert is a TLS alert sent or received on a connection.
+type Alert struct {
+	Level       AlertLevel
+	Description AlertDescription
+}
+
+func (a Alert) String() string {
+	return C.GoString(C.SSL_alert_desc_string_long(
+		C.int(a.Level)<<8 | C.int(a.Description)))
+}
+
+// alertFromStat decodes an alert noted by the message callback, returning
+// nil if there was none.
+func alertFromStat(stat C.ulonglong) *Alert {
+	if stat&C.X_SSL_ALERT_SEEN == 0 {
+		return nil
+	}
+	return &Alert{
+		Level:       AlertLevel(stat >> 8 & 0xff),
+		Description: AlertDescription(stat & 0xff),
+	}
+}
+
+// lastAlerts returns the last alerts sent and received. c.mtx must be held.
+func (c *Conn) lastAlerts() (sent, received *Alert) {
+	counters := &c.msg_state.stats
+	return alertFromStat(counters[C.X_SSL_STAT_ALERT_OUT]),
+		alertFromStat(counters[C.X_SSL_STAT_ALERT_IN])
+}
+
+// LastAlerts returns the last alert the connection sent and the last one it
+// received, either being nil if there was none. The alert that ended a
+// failed handshake or read is also recorded in the returned *Error.
+func (c *Conn) LastAlerts() (sent, received *Alert) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.lastAlerts()
+}
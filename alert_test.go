@@ -0,0 +1,68 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestAlertOnVerifyFailure(t *testing.T) {
+	server := newTestTLSServer(t, "tcp", "localhost:0")
+	defer server.Close()
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.SetVerifyMode(VerifyPeer)
+	dialer := &Dialer{Ctx: ctx, Flags: InsecureSkipHostVerification}
+	_, err = dialer.Dial("tcp", server.Addr().String())
+	var ssl_err *Error
+	if !errors.As(err, &ssl_err) {
+		t.Fatalf("expected *Error, got %T: %v", err, err)
+	}
+	if ssl_err.AlertSent == nil || ssl_err.AlertSent.Level != AlertFatal {
+		t.Fatalf("expected a fatal alert to be sent, got %v",
+			ssl_err.AlertSent)
+	}
+	if ssl_err.AlertSent.String() == "" {
+		t.Fatal("expected an alert description")
+	}
+}
+
+func TestAlertCloseNotify(t *testing.T) {
+	server := newTestTLSServer(t, "tcp", "localhost:0")
+	defer server.Close()
+	dialer := &Dialer{Flags: InsecureSkipHostVerification}
+	conn, err := dialer.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if sent, received := conn.LastAlerts(); sent != nil || received != nil {
+		t.Fatalf("expected no alerts, got %v and %v", sent, received)
+	}
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(conn); err != nil {
+		t.Fatal(err)
+	}
+	_, received := conn.LastAlerts()
+	if received == nil || *received != (Alert{AlertWarning, AlertCloseNotify}) {
+		t.Fatalf("expected close_notify, got %v", received)
+	}
+}
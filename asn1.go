@@ -0,0 +1,292 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"runtime"
+	"time"
+	"unsafe"
+)
+
+// ASN1Class is the class of an ASN.1 tag.
+type ASN1Class int
+
+const (
+	ASN1Universal       ASN1Class = 0
+	ASN1Application     ASN1Class = 1
+	ASN1ContextSpecific ASN1Class = 2
+	ASN1Private         ASN1Class = 3
+)
+
+func (c ASN1Class) String() string {
+	switch c {
+	case ASN1Universal:
+		return "universal"
+	case ASN1Application:
+		return "application"
+	case ASN1ContextSpecific:
+		return "context specific"
+	case ASN1Private:
+		return "private"
+	}
+	return fmt.Sprintf("ASN1Class(%d)", int(c))
+}
+
+// Universal ASN.1 tags with decoding support.
+const (
+	ASN1TagInteger         = 2
+	ASN1TagObjectID        = 6
+	ASN1TagUTCTime         = 23
+	ASN1TagGeneralizedTime = 24
+)
+
+// ASN1Element is a DER encoded tag-length-value element.
+type ASN1Element struct {
+	Class       ASN1Class
+	Tag         int
+	Constructed bool
+	// Offset is the position of the element in the parsed input and
+	// HeaderLength the size of its tag and length octets.
+	Offset       int
+	HeaderLength int
+	// Raw is the whole encoding of the element and Content its value.
+	Raw     []byte
+	Content []byte
+	// Children holds the elements of a constructed element.
+	Children []*ASN1Element
+}
+
+// ParseASN1 parses der into its top level DER elements, recursing into
+// constructed ones. The elements refer to der rather than copy it. The
+// indefinite length form of BER is not supported.
+func ParseASN1(der []byte) ([]*ASN1Element, error) {
+	return parseASN1(der, 0, 0)
+}
+
+// asn1MaxDepth bounds the nesting of elements in ParseASN1.
+const asn1MaxDepth = 64
+
+func parseASN1(der []byte, offset, depth int) ([]*ASN1Element, error) {
+	if depth > asn1MaxDepth {
+		return nil, errors.New("asn1: nested too deeply")
+	}
+	var elements []*ASN1Element
+	for pos := 0; pos < len(der); {
+		e, err := parseASN1Header(der[pos:], offset+pos)
+		if err != nil {
+			return nil, err
+		}
+		if e.Constructed {
+			e.Children, err = parseASN1(e.Content, e.Offset+e.HeaderLength,
+				depth+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		elements = append(elements, e)
+		pos += len(e.Raw)
+	}
+	return elements, nil
+}
+
+func parseASN1Header(der []byte, offset int) (*ASN1Element, error) {
+	if len(der) < 2 {
+		return nil, fmt.Errorf("asn1: truncated element at offset %d", offset)
+	}
+	e := &ASN1Element{
+		Class:       ASN1Class(der[0] >> 6),
+		Constructed: der[0]&0x20 != 0,
+		Tag:         int(der[0] & 0x1f),
+		Offset:      offset,
+	}
+	pos := 1
+	if e.Tag == 0x1f {
+		// high tag number form, base 128
+		e.Tag = 0
+		for {
+			if pos >= len(der) {
+				return nil, fmt.Errorf("asn1: truncated tag at offset %d",
+					offset)
+			}
+			if e.Tag > 1<<23 {
+				return nil, fmt.Errorf("asn1: tag too large at offset %d",
+					offset)
+			}
+			b := der[pos]
+			pos++
+			e.Tag = e.Tag<<7 | int(b&0x7f)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+	if pos >= len(der) {
+		return nil, fmt.Errorf("asn1: truncated length at offset %d", offset)
+	}
+	length := int(der[pos])
+	pos++
+	if length == 0x80 {
+		return nil, fmt.Errorf("asn1: indefinite length at offset %d", offset)
+	}
+	if length > 0x80 {
+		n := length & 0x7f
+		if n > 4 || pos+n > len(der) {
+			return nil, fmt.Errorf("asn1: invalid length at offset %d",
+				offset)
+		}
+		length = 0
+		for _, b := range der[pos : pos+n] {
+			length = length<<8 | int(b)
+		}
+		pos += n
+	}
+	if length < 0 || length > len(der)-pos {
+		return nil, fmt.Errorf("asn1: element at offset %d exceeds its "+
+			"container", offset)
+	}
+	e.HeaderLength = pos
+	e.Raw = der[:pos+length]
+	e.Content = der[pos : pos+length]
+	return e, nil
+}
+
+// WalkASN1 parses der like ParseASN1 and calls fn for every element in
+// depth first order, with the nesting depth of the element, starting at 0.
+// Walking stops at the first error returned by fn.
+func WalkASN1(der []byte, fn func(depth int, e *ASN1Element) error) error {
+	elements, err := ParseASN1(der)
+	if err != nil {
+		return err
+	}
+	return walkASN1(elements, 0, fn)
+}
+
+func walkASN1(elements []*ASN1Element, depth int,
+	fn func(depth int, e *ASN1Element) error) error {
+	for _, e := range elements {
+		if err := fn(depth, e); err != nil {
+			return err
+		}
+		if err := walkASN1(e.Children, depth+1, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DumpASN1 returns a tree dump of der in the format of OpenSSL's
+// asn1parse command, with the contents of unknown elements in hex.
+func DumpASN1(der []byte) (string, error) {
+	if len(der) == 0 {
+		return "", errors.New("empty der block")
+	}
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return "", errors.New("failed creating bio")
+	}
+	defer C.BIO_free(bio)
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.ASN1_parse_dump(bio, (*C.uchar)(unsafe.Pointer(&der[0])),
+		C.long(len(der)), 2, -1) != 1 {
+		return "", errorFromErrorQueue()
+	}
+	dump, err := ioutil.ReadAll(asAnyBio(bio))
+	return string(dump), err
+}
+
+func (e *ASN1Element) isUniversal(tags ...int) bool {
+	if e.Class != ASN1Universal || e.Constructed {
+		return false
+	}
+	for _, tag := range tags {
+		if e.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// OID decodes an OBJECT IDENTIFIER element to its dotted form.
+func (e *ASN1Element) OID() (string, error) {
+	if !e.isUniversal(ASN1TagObjectID) {
+		return "", errors.New("asn1: not an object identifier")
+	}
+	buf := C.CBytes(e.Raw)
+	defer C.free(buf)
+	ptr := (*C.uchar)(buf)
+	obj := C.d2i_ASN1_OBJECT(nil, &ptr, C.long(len(e.Raw)))
+	if obj == nil {
+		return "", errors.New("asn1: invalid object identifier")
+	}
+	defer C.ASN1_OBJECT_free(obj)
+	return objectOID(obj), nil
+}
+
+// OIDName returns the long name OpenSSL knows for an OBJECT IDENTIFIER
+// element, or its dotted form for unknown identifiers.
+func (e *ASN1Element) OIDName() (string, error) {
+	oid, err := e.OID()
+	if err != nil {
+		return "", err
+	}
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	nid := C.OBJ_txt2nid(coid)
+	if nid == C.NID_undef {
+		return oid, nil
+	}
+	return C.GoString(C.OBJ_nid2ln(nid)), nil
+}
+
+// Integer decodes an INTEGER element.
+func (e *ASN1Element) Integer() (*big.Int, error) {
+	if !e.isUniversal(ASN1TagInteger) {
+		return nil, errors.New("asn1: not an integer")
+	}
+	buf := C.CBytes(e.Raw)
+	defer C.free(buf)
+	ptr := (*C.uchar)(buf)
+	ai := C.d2i_ASN1_INTEGER(nil, &ptr, C.long(len(e.Raw)))
+	if ai == nil {
+		return nil, errors.New("asn1: invalid integer")
+	}
+	defer C.ASN1_INTEGER_free(ai)
+	return bigInt(ai)
+}
+
+// Time decodes a UTCTime or GeneralizedTime element to a UTC time.
+func (e *ASN1Element) Time() (time.Time, error) {
+	if !e.isUniversal(ASN1TagUTCTime, ASN1TagGeneralizedTime) {
+		return time.Time{}, errors.New("asn1: not a time")
+	}
+	buf := C.CBytes(e.Raw)
+	defer C.free(buf)
+	ptr := (*C.uchar)(buf)
+	tm := C.d2i_ASN1_TIME(nil, &ptr, C.long(len(e.Raw)))
+	if tm == nil {
+		return time.Time{}, errors.New("asn1: invalid time")
+	}
+	defer C.ASN1_TIME_free(tm)
+	return goTime(tm)
+}
@@ -0,0 +1,154 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseASN1(t *testing.T) {
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := cert.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	elements, err := ParseASN1(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 1 || !bytes.Equal(elements[0].Raw, der) {
+		t.Fatal("expected a single element spanning the certificate")
+	}
+	if len(elements[0].Children) != 3 {
+		t.Fatalf("unexpected certificate elements %d",
+			len(elements[0].Children))
+	}
+
+	// the serial number, signature algorithm and validity come first
+	tbs := elements[0].Children[0]
+	serial, err := tbs.Children[1].Integer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected_serial, err := cert.SerialNumber()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial.Cmp(expected_serial) != 0 {
+		t.Fatalf("unexpected serial number %v", serial)
+	}
+	alg := tbs.Children[2].Children[0]
+	oid, err := alg.OID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oid != "1.2.840.113549.1.1.11" {
+		t.Fatalf("unexpected signature algorithm %s", oid)
+	}
+	name, err := alg.OIDName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "sha256WithRSAEncryption" {
+		t.Fatalf("unexpected signature algorithm name %s", name)
+	}
+	not_before, err := tbs.Children[4].Children[0].Time()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected_not_before, err := cert.NotBefore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !not_before.Equal(expected_not_before) {
+		t.Fatalf("unexpected time %v", not_before)
+	}
+	if _, err := alg.Integer(); err == nil {
+		t.Fatal("expected an error decoding an oid as integer")
+	}
+
+	var count, max_depth int
+	err = WalkASN1(der, func(depth int, e *ASN1Element) error {
+		count++
+		if depth > max_depth {
+			max_depth = depth
+		}
+		if !bytes.Equal(der[e.Offset:e.Offset+len(e.Raw)], e.Raw) {
+			t.Fatalf("element at offset %d misplaced", e.Offset)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count < 20 || max_depth < 4 {
+		t.Fatalf("walked %d elements, %d deep", count, max_depth)
+	}
+	stop := errors.New("stop")
+	if err := WalkASN1(der, func(int, *ASN1Element) error {
+		return stop
+	}); err != stop {
+		t.Fatalf("expected the walk to stop, got %v", err)
+	}
+
+	dump, err := DumpASN1(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dump, "sha256WithRSAEncryption") {
+		t.Fatalf("unexpected dump:\n%s", dump)
+	}
+}
+
+func TestParseASN1Encodings(t *testing.T) {
+	// [APPLICATION 100] in the high tag number form
+	elements, err := ParseASN1([]byte{0x5f, 0x64, 0x01, 0x2a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := elements[0]
+	if e.Class != ASN1Application || e.Tag != 100 || e.HeaderLength != 3 ||
+		!bytes.Equal(e.Content, []byte{0x2a}) {
+		t.Fatalf("unexpected element %+v", e)
+	}
+
+	// an OCTET STRING with a long form length
+	long := append([]byte{0x04, 0x81, 0x80}, make([]byte, 0x80)...)
+	elements, err = ParseASN1(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements[0].Content) != 0x80 {
+		t.Fatalf("unexpected length %d", len(elements[0].Content))
+	}
+
+	for _, invalid := range [][]byte{
+		{0x30},
+		{0x30, 0x80, 0x00, 0x00},
+		{0x04, 0x05, 0x00},
+		{0x30, 0x03, 0x04, 0x05, 0x00},
+		{0x5f, 0x80},
+	} {
+		if _, err := ParseASN1(invalid); err == nil {
+			t.Fatalf("expected an error for %x", invalid)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"fmt"
+)
+
+// ATECCEngineID is the id of the cryptoauthlib engine for Microchip
+// ATECC508A and ATECC608 secure elements.
+const ATECCEngineID = "ateccx08"
+
+// ATECC device types, as numbered by cryptoauthlib.
+const (
+	ATECC508A = 2
+	ATECC608  = 3
+)
+
+// ATECCOptions select how the secure element is reached.
+type ATECCOptions struct {
+	// ConfigFile is an OpenSSL configuration file to load first, e.g. one
+	// whose engines section loads and configures the ateccx08 engine.
+	ConfigFile string
+	// EnginePath is the shared library of the engine (libateccx08.so). If
+	// empty, the engine must be built in, installed in the engines
+	// directory or loaded by ConfigFile.
+	EnginePath string
+	// DeviceType defaults to ATECC608.
+	DeviceType int
+	// I2CAddress is the 8-bit I2C address of the device. Defaults to 0xC0.
+	I2CAddress uint8
+}
+
+// ateccKeyID returns the engine key id of slot, of the form
+// "ATECCx08:<bus>:<device type>:<i2c address>:<slot>" in hex.
+func ateccKeyID(slot int, opts *ATECCOptions) (string, error) {
+	if slot < 0 || slot > 15 {
+		return "", fmt.Errorf("invalid atecc key slot %d", slot)
+	}
+	device_type := opts.DeviceType
+	if device_type == 0 {
+		device_type = ATECC608
+	}
+	address := opts.I2CAddress
+	if address == 0 {
+		address = 0xC0
+	}
+	return fmt.Sprintf("ATECCx08:00:%02X:%02X:%02X", device_type, address,
+		slot), nil
+}
+
+// LoadATECCPrivateKey loads the private key held in slot of an ATECC608 or
+// ATECC508A secure element through the cryptoauthlib engine. The key cannot
+// be exported: signatures with the returned key, including those for TLS
+// client authentication, are computed by the device.
+func LoadATECCPrivateKey(slot int, opts *ATECCOptions) (PrivateKey, error) {
+	if opts == nil {
+		opts = &ATECCOptions{}
+	}
+	key_id, err := ateccKeyID(slot, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ConfigFile != "" {
+		if err := LoadConfigFile(opts.ConfigFile); err != nil {
+			return nil, err
+		}
+	}
+	var engine *Engine
+	if opts.EnginePath == "" {
+		engine, err = EngineLoadByID(ATECCEngineID, nil, nil)
+	} else {
+		engine, err = EngineLoadByID("dynamic", []EngineCommand{
+			{Name: "SO_PATH", Value: opts.EnginePath},
+			{Name: "ID", Value: ATECCEngineID},
+			{Name: "LIST_ADD", Value: "1"},
+			{Name: "LOAD"},
+		}, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := engine.LoadPrivateKey(key_id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load atecc key %s: %v", key_id, err)
+	}
+	return key, nil
+}
+
+// UseATECCPrivateKey configures the context to use the private key held in
+// slot of a secure element for handshakes, see LoadATECCPrivateKey.
+func (c *Ctx) UseATECCPrivateKey(slot int, opts *ATECCOptions) error {
+	key, err := LoadATECCPrivateKey(slot, opts)
+	if err != nil {
+		return err
+	}
+	return c.UsePrivateKey(key)
+}
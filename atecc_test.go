@@ -0,0 +1,54 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"testing"
+)
+
+func TestATECCKeyID(t *testing.T) {
+	id, err := ateccKeyID(0, &ATECCOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "ATECCx08:00:03:C0:00" {
+		t.Fatalf("unexpected key id %s", id)
+	}
+	id, err = ateccKeyID(2, &ATECCOptions{DeviceType: ATECC508A,
+		I2CAddress: 0x6A})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "ATECCx08:00:02:6A:02" {
+		t.Fatalf("unexpected key id %s", id)
+	}
+	if _, err := ateccKeyID(16, &ATECCOptions{}); err == nil {
+		t.Fatal("accepted invalid slot")
+	}
+}
+
+func TestLoadATECCPrivateKey(t *testing.T) {
+	if _, err := LoadATECCPrivateKey(-1, nil); err == nil {
+		t.Fatal("loaded key from invalid slot")
+	}
+	if _, err := LoadATECCPrivateKey(0, &ATECCOptions{
+		EnginePath: "/nonexistent/libateccx08.so"}); err == nil {
+		t.Fatal("loaded key through a missing engine")
+	}
+	if _, err := LoadATECCPrivateKey(0, &ATECCOptions{
+		ConfigFile: "/nonexistent/openssl.cnf"}); err == nil {
+		t.Fatal("loaded key with a missing config")
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"unsafe"
+)
+
+// Base64Mode selects how NewBase64Encoder and NewBase64Decoder handle line
+// breaks.
+type Base64Mode int
+
+const (
+	// Base64Lines wraps encoded output every 64 characters, as in PEM
+	// bodies, and tolerates newlines when decoding.
+	Base64Lines Base64Mode = iota
+	// Base64Strict neither emits nor accepts newlines; the encoding is a
+	// single line.
+	Base64Strict
+)
+
+const base64ChunkSize = 4096
+
+// newBase64BIO returns a base64 filter BIO pushed onto a fresh memory BIO.
+func newBase64BIO(mode Base64Mode) (b64 *C.BIO, mem *C.BIO, err error) {
+	mem = C.BIO_new(C.BIO_s_mem())
+	if mem == nil {
+		return nil, nil, errors.New("failed to allocate memory BIO")
+	}
+	b64 = C.BIO_new(C.BIO_f_base64())
+	if b64 == nil {
+		C.BIO_free(mem)
+		return nil, nil, errors.New("failed to allocate base64 BIO")
+	}
+	if mode == Base64Strict {
+		C.X_BIO_set_flags(b64, C.BIO_FLAGS_BASE64_NO_NL)
+	}
+	return C.BIO_push(b64, mem), mem, nil
+}
+
+type base64Encoder struct {
+	w      io.Writer
+	b64    *C.BIO
+	mem    *C.BIO
+	buf    []byte
+	err    error
+	closed bool
+}
+
+// NewBase64Encoder returns a WriteCloser that base64-encodes everything
+// written to it and writes the result to w. Close must be called to flush
+// the final partial block.
+func NewBase64Encoder(w io.Writer, mode Base64Mode) (io.WriteCloser, error) {
+	b64, mem, err := newBase64BIO(mode)
+	if err != nil {
+		return nil, err
+	}
+	e := &base64Encoder{w: w, b64: b64, mem: mem,
+		buf: make([]byte, base64ChunkSize)}
+	runtime.SetFinalizer(e, func(e *base64Encoder) {
+		e.free()
+	})
+	return e, nil
+}
+
+func (e *base64Encoder) free() {
+	if e.b64 != nil {
+		C.BIO_free_all(e.b64)
+		e.b64 = nil
+	}
+}
+
+// drain moves all encoded output from the memory BIO to the writer.
+func (e *base64Encoder) drain() error {
+	for {
+		n := C.X_BIO_read(e.mem, unsafe.Pointer(&e.buf[0]), C.int(len(e.buf)))
+		if n <= 0 {
+			return nil
+		}
+		if _, err := e.w.Write(e.buf[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *base64Encoder) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write on closed base64 encoder")
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+	written := 0
+	for written < len(p) {
+		chunk := len(p) - written
+		if chunk > base64ChunkSize {
+			chunk = base64ChunkSize
+		}
+		n := C.X_BIO_write(e.b64, unsafe.Pointer(&p[written]), C.int(chunk))
+		if n <= 0 {
+			e.err = errors.New("base64 encoding failed")
+			return written, e.err
+		}
+		written += int(n)
+		if err := e.drain(); err != nil {
+			e.err = err
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (e *base64Encoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	defer e.free()
+	if e.err != nil {
+		return e.err
+	}
+	if C.X_BIO_flush(e.b64) != 1 {
+		return errors.New("base64 flush failed")
+	}
+	return e.drain()
+}
+
+type base64Decoder struct {
+	r      io.Reader
+	ctx    *C.EVP_ENCODE_CTX
+	mode   Base64Mode
+	in     []byte
+	out    []byte
+	ready  []byte
+	padded bool
+	eof    bool
+	err    error
+}
+
+// NewBase64Decoder returns a Reader that base64-decodes the data read from
+// r. In Base64Strict mode the input must be a single line. Bytes outside the
+// base64 alphabet, data after padding and a truncated final quantum are
+// reported as errors.
+func NewBase64Decoder(r io.Reader, mode Base64Mode) (io.Reader, error) {
+	ctx := C.X_EVP_ENCODE_CTX_new()
+	if ctx == nil {
+		return nil, errors.New("failed to allocate base64 decoding context")
+	}
+	C.EVP_DecodeInit(ctx)
+	d := &base64Decoder{r: r, ctx: ctx, mode: mode,
+		in: make([]byte, base64ChunkSize),
+		// room for the chunk plus a partially buffered 64 byte line
+		out: make([]byte, (base64ChunkSize+64)/4*3)}
+	runtime.SetFinalizer(d, func(d *base64Decoder) {
+		C.X_EVP_ENCODE_CTX_free(d.ctx)
+	})
+	return d, nil
+}
+
+// check rejects everything EVP_DecodeUpdate would otherwise skip or treat
+// as an end marker: newlines in strict mode, '-' and other stray bytes, and
+// anything but padding once padding has started.
+func (d *base64Decoder) check(in []byte) error {
+	for _, c := range in {
+		switch {
+		case c == '\n' || c == '\r' || c == ' ' || c == '\t':
+			if d.mode == Base64Strict {
+				return errors.New("invalid base64 input: unexpected whitespace")
+			}
+		case c == '=':
+			d.padded = true
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '+', c == '/':
+			if d.padded {
+				return errors.New("invalid base64 input: data after padding")
+			}
+		default:
+			return fmt.Errorf("invalid base64 input: unexpected byte %q", c)
+		}
+	}
+	return nil
+}
+
+// fill reads the next chunk from r and decodes it into d.ready.
+func (d *base64Decoder) fill() error {
+	m, err := d.r.Read(d.in)
+	if m > 0 {
+		if err := d.check(d.in[:m]); err != nil {
+			return err
+		}
+		var outl C.int
+		if C.EVP_DecodeUpdate(d.ctx, (*C.uchar)(&d.out[0]), &outl,
+			(*C.uchar)(&d.in[0]), C.int(m)) < 0 {
+			return errors.New("invalid base64 input")
+		}
+		d.ready = d.out[:outl]
+	}
+	if err == io.EOF {
+		d.eof = true
+		var outl C.int
+		if C.EVP_DecodeFinal(d.ctx, (*C.uchar)(&d.out[len(d.ready)]),
+			&outl) < 0 {
+			return errors.New("invalid base64 input: truncated quantum")
+		}
+		d.ready = d.out[:len(d.ready)+int(outl)]
+		return nil
+	}
+	return err
+}
+
+func (d *base64Decoder) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for len(d.ready) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.eof {
+			d.err = io.EOF
+			continue
+		}
+		d.err = d.fill()
+	}
+	n := copy(p, d.ready)
+	d.ready = d.ready[n:]
+	return n, nil
+}
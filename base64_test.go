@@ -0,0 +1,132 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestBase64(t *testing.T) {
+	data := make([]byte, 100000)
+	if _, err := io.ReadFull(Rand, data); err != nil {
+		t.Fatal(err)
+	}
+	std := base64.StdEncoding.EncodeToString(data)
+
+	for _, mode := range []Base64Mode{Base64Strict, Base64Lines} {
+		var encoded bytes.Buffer
+		enc, err := NewBase64Encoder(&encoded, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// write in odd-sized pieces to exercise partial blocks
+		for rest := data; len(rest) > 0; {
+			n := 1000 + len(rest)%7
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if _, err := enc.Write(rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		out := encoded.String()
+		if mode == Base64Strict {
+			if out != std {
+				t.Fatal("strict encoding differs from encoding/base64")
+			}
+		} else {
+			lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+			if len(lines[0]) != 64 || strings.Join(lines, "") != std {
+				t.Fatal("line encoding differs from encoding/base64")
+			}
+		}
+
+		dec, err := NewBase64Decoder(iotest.HalfReader(&encoded), mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := ioutil.ReadAll(dec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Fatal("base64 round trip changed the data")
+		}
+	}
+}
+
+func TestBase64DecodeInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  Base64Mode
+		input string
+		want  string
+		bad   bool
+	}{
+		{"lines unterminated", Base64Lines, "aGVsbG8=", "hello", false},
+		{"lines terminated", Base64Lines, "aGVs\nbG8=\n", "hello", false},
+		{"lines crlf", Base64Lines, "aGVsbG8=\r\n", "hello", false},
+		{"strict", Base64Strict, "aGVsbG8=", "hello", false},
+		{"strict empty", Base64Strict, "", "", false},
+		{"strict newline", Base64Strict, "aGVs\nbG8=", "", true},
+		{"lines invalid", Base64Lines, "!!!!", "", true},
+		{"strict invalid", Base64Strict, "!!!!", "", true},
+		{"lines trailing garbage", Base64Lines, "aGVsbG8=garbage!!", "", true},
+		{"strict trailing garbage", Base64Strict, "aGVsbG8=garbage!!", "", true},
+		{"strict data after padding", Base64Strict, "aGVsbG8=aGVs", "", true},
+		{"strict truncated", Base64Strict, "aGVsbG8", "", true},
+		{"lines truncated", Base64Lines, "aGVsbG8\n", "", true},
+		{"lines dash", Base64Lines, "aGVs-bG8=", "", true},
+	}
+	readers := map[string]func(io.Reader) io.Reader{
+		"whole":   func(r io.Reader) io.Reader { return r },
+		"onebyte": iotest.OneByteReader,
+		"half":    iotest.HalfReader,
+	}
+	for _, test := range tests {
+		for rname, wrap := range readers {
+			dec, err := NewBase64Decoder(wrap(strings.NewReader(test.input)),
+				test.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(dec)
+			if test.bad {
+				if err == nil {
+					t.Errorf("%s/%s: expected error, got %q", test.name,
+						rname, got)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s/%s: %v", test.name, rname, err)
+			} else if string(got) != test.want {
+				t.Errorf("%s/%s: got %q, want %q", test.name, rname, got,
+					test.want)
+			}
+		}
+	}
+}
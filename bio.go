@@ -20,38 +20,53 @@ import "C"
 import (
 	"errors"
 	"io"
-	"reflect"
+	"net"
 	"sync"
 	"unsafe"
-	"net"
-
 )
 
 const (
+	// SSLRecordSize is the largest TLS record plaintext, and the default
+	// record size of contexts. See Ctx.SetRecordSize.
 	SSLRecordSize = 16 * 1024
+	// MinRecordSize is the smallest record size OpenSSL allows.
+	MinRecordSize = 512
 )
 
-func nonCopyGoBytes(ptr uintptr, length int) []byte {
-	var slice []byte
-	header := (*reflect.SliceHeader)(unsafe.Pointer(&slice))
-	header.Cap = length
-	header.Len = length
-	header.Data = ptr
-	return slice
-}
-
+// nonCopyCString returns a slice aliasing the size bytes at data, which
+// must not be used after the C memory is reused.
 func nonCopyCString(data *C.char, size C.int) []byte {
-	return nonCopyGoBytes(uintptr(unsafe.Pointer(data)), int(size))
+	if size == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(data)), int(size))
 }
 
 var writeBioMapping = newMapping()
 
+// writeBioSegmentSize is the capacity of the segments output is gathered
+// in, enough for a few full records.
+const writeBioSegmentSize = 4 * SSLRecordSize
+
 type writeBio struct {
-	data_mtx        sync.Mutex
-	op_mtx          sync.Mutex
-	buf             []byte
+	data_mtx sync.Mutex
+	op_mtx   sync.Mutex
+	// bufs holds the output not yet written to conn, starting at off in
+	// the first segment. Records are appended to the last segment until it
+	// is full, so large writes never move what is already buffered, and a
+	// flush hands all segments to conn at once.
+	bufs            net.Buffers
+	off             int
+	buffered        int
+	flushing        net.Buffers
+	written         uint64
 	release_buffers bool
 	conn            net.Conn
+	// max_write limits the size of each write to conn, if positive
+	max_write int
+	// small_segments sizes segments to the records they hold instead of
+	// writeBioSegmentSize
+	small_segments bool
 }
 
 func loadWritePtr(b *C.BIO) *writeBio {
@@ -71,7 +86,7 @@ func bioSetRetryRead(b *C.BIO) {
 func go_write_bio_write(b *C.BIO, data *C.char, size C.int) (rc C.int) {
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Critf("openssl: writeBioWrite panic'd: %v", err)
+			logCallbackPanic("writeBioWrite", err)
 			rc = -1
 		}
 	}()
@@ -82,16 +97,62 @@ func go_write_bio_write(b *C.BIO, data *C.char, size C.int) (rc C.int) {
 	ptr.data_mtx.Lock()
 	defer ptr.data_mtx.Unlock()
 	bioClearRetryFlags(b)
-	ptr.buf = append(ptr.buf, nonCopyCString(data, size)...)
+	// OpenSSL reuses its record buffer as soon as we return, so this is the
+	// one copy a record needs before it reaches the connection
+	ptr.append(nonCopyCString(data, size))
 	return size
 }
 
+// append buffers data, which is never split across segments. data_mtx must
+// be held.
+func (wb *writeBio) append(data []byte) {
+	wb.buffered += len(data)
+	if n := len(wb.bufs); n > 0 {
+		last := wb.bufs[n-1]
+		if cap(last)-len(last) >= len(data) {
+			wb.bufs[n-1] = append(last, data...)
+			return
+		}
+	}
+	size := writeBioSegmentSize
+	if len(data) > size || wb.small_segments {
+		size = len(data)
+	}
+	seg := make([]byte, len(data), size)
+	copy(seg, data)
+	wb.bufs = append(wb.bufs, seg)
+}
+
+// consume drops the first n buffered bytes. data_mtx must be held.
+func (wb *writeBio) consume(n int) {
+	wb.buffered -= n
+	for n > 0 {
+		seg := wb.bufs[0]
+		if len(seg)-wb.off > n {
+			wb.off += n
+			return
+		}
+		n -= len(seg) - wb.off
+		wb.off = 0
+		if len(wb.bufs) == 1 {
+			// keep the last segment around for the next records
+			wb.bufs[0] = seg[:0]
+			if wb.release_buffers || cap(seg) != writeBioSegmentSize {
+				wb.bufs = nil
+			}
+			return
+		}
+		wb.bufs[0] = nil
+		wb.bufs = wb.bufs[1:]
+	}
+}
+
 //export go_write_bio_ctrl
 func go_write_bio_ctrl(b *C.BIO, cmd C.int, arg1 C.long, arg2 unsafe.Pointer) (
 	rc C.long) {
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Critf("openssl: writeBioCtrl panic'd: %v", err)
+			logCallbackPanic("writeBioCtrl", err)
 			rc = -1
 		}
 	}()
@@ -114,7 +175,7 @@ func writeBioPending(b *C.BIO) C.long {
 	}
 	ptr.data_mtx.Lock()
 	defer ptr.data_mtx.Unlock()
-	return C.long(len(ptr.buf))
+	return C.long(ptr.buffered)
 }
 
 func writeBioFlush(b *C.BIO) C.long {
@@ -133,25 +194,73 @@ func (wb *writeBio) WriteToConn() (rv int64, err error) {
 	wb.op_mtx.Lock()
 	defer wb.op_mtx.Unlock()
 
-	// write whatever data we currently have
+	// write whatever data we currently have. Records appended meanwhile go
+	// past the lengths captured here, so the segments can be read unlocked.
 	wb.data_mtx.Lock()
-	data := wb.buf
-	wb.data_mtx.Unlock()
-
-	if len(data) == 0 {
+	if wb.buffered == 0 {
+		wb.data_mtx.Unlock()
 		return 0, nil
 	}
-	n, err := wb.conn.Write(data)
+	bufs := append(wb.flushing[:0], wb.bufs...)
+	bufs[0] = bufs[0][wb.off:]
+	wb.data_mtx.Unlock()
+	wb.flushing = bufs
+
+	var n int64
+	if wb.max_write > 0 {
+		for _, seg := range bufs {
+			for len(seg) > 0 && err == nil {
+				chunk := seg
+				if len(chunk) > wb.max_write {
+					chunk = chunk[:wb.max_write]
+				}
+				var written int
+				written, err = wb.conn.Write(chunk)
+				n += int64(written)
+				seg = seg[written:]
+			}
+		}
+	} else {
+		// writev where conn supports it
+		n, err = bufs.WriteTo(wb.conn)
+	}
+	for i := range wb.flushing {
+		wb.flushing[i] = nil
+	}
 
 	// subtract however much data we wrote from the buffer
 	wb.data_mtx.Lock()
-	wb.buf = wb.buf[:copy(wb.buf, wb.buf[n:])]
-	if wb.release_buffers && len(wb.buf) == 0 {
-		wb.buf = nil
-	}
+	wb.consume(int(n))
+	wb.written += uint64(n)
 	wb.data_mtx.Unlock()
 
-	return int64(n), err
+	return n, err
+}
+
+// Written returns the number of bytes written to conn.
+func (wb *writeBio) Written() uint64 {
+	wb.data_mtx.Lock()
+	defer wb.data_mtx.Unlock()
+	return wb.written
+}
+
+// SetReleaseBuffers sets whether the buffer is freed whenever it runs
+// empty, and frees it if it is empty now.
+func (wb *writeBio) SetReleaseBuffers(release bool) {
+	wb.data_mtx.Lock()
+	defer wb.data_mtx.Unlock()
+	wb.release_buffers = release
+	if release && wb.buffered == 0 {
+		wb.bufs = nil
+		wb.off = 0
+	}
+}
+
+// Buffered returns the number of bytes waiting to be written to conn.
+func (wb *writeBio) Buffered() int {
+	wb.data_mtx.Lock()
+	defer wb.data_mtx.Unlock()
+	return wb.buffered
 }
 
 func (wb *writeBio) Disconnect(b *C.BIO) {
@@ -171,9 +280,16 @@ func (wb *writeBio) MakeCBIO() *C.BIO {
 var readBioMapping = newMapping()
 
 type readBio struct {
-	data_mtx        sync.Mutex
-	op_mtx          sync.Mutex
-	buf             []byte
+	data_mtx sync.Mutex
+	op_mtx   sync.Mutex
+	// buf[off:] is the ciphertext OpenSSL has yet to read. Consumed bytes
+	// are reclaimed by ReadFromConnOnce rather than on every BIO read.
+	buf     []byte
+	off     int
+	reading bool
+	read    uint64
+	// record_size is the space made available to each read from conn
+	record_size     int
 	eof             bool
 	release_buffers bool
 	conn            net.Conn
@@ -187,7 +303,7 @@ func loadReadPtr(b *C.BIO) *readBio {
 func go_read_bio_read(b *C.BIO, data *C.char, size C.int) (rc C.int) {
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Critf("openssl: go_read_bio_read panic'd: %v", err)
+			logCallbackPanic("go_read_bio_read", err)
 			rc = -1
 		}
 	}()
@@ -198,7 +314,8 @@ func go_read_bio_read(b *C.BIO, data *C.char, size C.int) (rc C.int) {
 	ptr.data_mtx.Lock()
 	defer ptr.data_mtx.Unlock()
 	bioClearRetryFlags(b)
-	if len(ptr.buf) == 0 {
+	pending := ptr.buf[ptr.off:]
+	if len(pending) == 0 {
 		if ptr.eof {
 			return 0
 		}
@@ -206,12 +323,16 @@ func go_read_bio_read(b *C.BIO, data *C.char, size C.int) (rc C.int) {
 		return -1
 	}
 	if size == 0 || data == nil {
-		return C.int(len(ptr.buf))
+		return C.int(len(pending))
 	}
-	n := copy(nonCopyCString(data, size), ptr.buf)
-	ptr.buf = ptr.buf[:copy(ptr.buf, ptr.buf[n:])]
-	if ptr.release_buffers && len(ptr.buf) == 0 {
-		ptr.buf = nil
+	n := copy(nonCopyCString(data, size), pending)
+	ptr.off += n
+	if ptr.off == len(ptr.buf) && !ptr.reading {
+		ptr.off = 0
+		ptr.buf = ptr.buf[:0]
+		if ptr.release_buffers {
+			ptr.buf = nil
+		}
 	}
 	return C.int(n)
 }
@@ -222,7 +343,7 @@ func go_read_bio_ctrl(b *C.BIO, cmd C.int, arg1 C.long, arg2 unsafe.Pointer) (
 
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Critf("openssl: readBioCtrl panic'd: %v", err)
+			logCallbackPanic("readBioCtrl", err)
 			rc = -1
 		}
 	}()
@@ -243,38 +364,71 @@ func readBioPending(b *C.BIO) C.long {
 	}
 	ptr.data_mtx.Lock()
 	defer ptr.data_mtx.Unlock()
-	return C.long(len(ptr.buf))
+	return C.long(len(ptr.buf) - ptr.off)
 }
 
 func (rb *readBio) ReadFromConnOnce() (n int, err error) {
 	rb.op_mtx.Lock()
 	defer rb.op_mtx.Unlock()
 
-	// make sure we have a destination that fits at least one SSL record
+	// make sure we have a destination that fits at least one SSL record,
+	// reclaiming what OpenSSL has consumed first. The buffer only shrinks
+	// here, so dst stays valid while we read without the lock.
 	rb.data_mtx.Lock()
-	if cap(rb.buf) < len(rb.buf)+SSLRecordSize {
-		new_buf := make([]byte, len(rb.buf), len(rb.buf)+SSLRecordSize)
-		copy(new_buf, rb.buf)
-		rb.buf = new_buf
+	size := rb.record_size
+	if size == 0 {
+		size = SSLRecordSize
+	}
+	if cap(rb.buf)-len(rb.buf) < size {
+		pending := len(rb.buf) - rb.off
+		if cap(rb.buf) < pending+size {
+			new_buf := make([]byte, pending, pending+size)
+			copy(new_buf, rb.buf[rb.off:])
+			rb.buf = new_buf
+		} else {
+			rb.buf = rb.buf[:copy(rb.buf, rb.buf[rb.off:])]
+		}
+		rb.off = 0
 	}
 	dst := rb.buf[len(rb.buf):cap(rb.buf)]
-	dst_slice := rb.buf
+	rb.reading = true
 	rb.data_mtx.Unlock()
 
 	n, err = rb.conn.Read(dst)
 	rb.data_mtx.Lock()
 	defer rb.data_mtx.Unlock()
-	if n > 0 {
-		if len(dst_slice) != len(rb.buf) {
-			// someone shrunk the buffer, so we read in too far ahead and we
-			// need to slide backwards
-			copy(rb.buf[len(rb.buf):len(rb.buf)+n], dst)
+	rb.reading = false
+	rb.read += uint64(n)
+	rb.buf = rb.buf[:len(rb.buf)+n]
+	if rb.off == len(rb.buf) {
+		rb.off = 0
+		rb.buf = rb.buf[:0]
+		if rb.release_buffers {
+			rb.buf = nil
 		}
-		rb.buf = rb.buf[:len(rb.buf)+n]
 	}
 	return n, err
 }
 
+// SetReleaseBuffers sets whether the buffer is freed whenever it runs
+// empty, and frees it if it is empty and not being read into now.
+func (rb *readBio) SetReleaseBuffers(release bool) {
+	rb.data_mtx.Lock()
+	defer rb.data_mtx.Unlock()
+	rb.release_buffers = release
+	if release && rb.off == len(rb.buf) && !rb.reading {
+		rb.buf = nil
+		rb.off = 0
+	}
+}
+
+// Read returns the number of bytes read from conn.
+func (rb *readBio) Read() uint64 {
+	rb.data_mtx.Lock()
+	defer rb.data_mtx.Unlock()
+	return rb.read
+}
+
 func (rb *readBio) MakeCBIO() *C.BIO {
 	rv := C.X_BIO_new_read_bio()
 	token := readBioMapping.Add(unsafe.Pointer(rb))
@@ -295,6 +449,62 @@ func (rb *readBio) MarkEOF() {
 	rb.eof = true
 }
 
+var readerBioMapping = newMapping()
+
+// readerBio feeds OpenSSL from an io.Reader, e.g. to digest a large input
+// without holding it in memory. Reads block the calling goroutine.
+type readerBio struct {
+	r   io.Reader
+	err error
+}
+
+//export go_reader_bio_read
+func go_reader_bio_read(b *C.BIO, data *C.char, size C.int) (rc C.int) {
+	defer func() {
+		if err := recover(); err != nil {
+			logCallbackPanic("go_reader_bio_read", err)
+			rc = -1
+		}
+	}()
+	ptr := (*readerBio)(readerBioMapping.Get(token(C.X_BIO_get_data(b))))
+	if ptr == nil || size < 0 || ptr.err != nil {
+		return -1
+	}
+	if size == 0 || data == nil {
+		return 0
+	}
+	buf := nonCopyCString(data, size)
+	for {
+		n, err := ptr.r.Read(buf)
+		if n > 0 {
+			return C.int(n)
+		}
+		if err == io.EOF {
+			return 0
+		}
+		if err != nil {
+			ptr.err = err
+			return -1
+		}
+	}
+}
+
+// newReaderBio returns a BIO reading from r, to be released with free.
+func newReaderBio(r io.Reader) (bio *C.BIO, rb *readerBio, free func()) {
+	rb = &readerBio{r: r}
+	bio = C.X_BIO_new_reader_bio()
+	if bio == nil {
+		return nil, nil, nil
+	}
+	token := readerBioMapping.Add(unsafe.Pointer(rb))
+	C.X_BIO_set_data(bio, unsafe.Pointer(token))
+	return bio, rb, func() {
+		readerBioMapping.Del(token)
+		C.X_BIO_set_data(bio, nil)
+		C.BIO_free(bio)
+	}
+}
+
 type anyBio C.BIO
 
 func asAnyBio(b *C.BIO) *anyBio { return (*anyBio)(b) }
@@ -0,0 +1,202 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"math/big"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// IssuanceProfile controls how IssueCertificate turns a certificate signing
+// request into a certificate.
+type IssuanceProfile struct {
+	// Validity is the lifetime of issued certificates. Defaults to one year.
+	Validity time.Duration
+	// Backdate moves the start of the validity period into the past to
+	// tolerate clock skew on relying parties.
+	Backdate time.Duration
+
+	// Digest used to sign the certificate. Defaults to EVP_SHA256, or
+	// EVP_NULL for Ed25519 CA keys.
+	Digest EVP_MD
+
+	// AllowedExtensions lists the requested extensions that are copied from
+	// the request into the certificate. All other requested extensions are
+	// dropped.
+	AllowedExtensions []NID
+	// Extensions are set by the CA, in the textual form accepted by
+	// Certificate.AddExtension. They take precedence over requested
+	// extensions with the same NID. "keyid" authority key identifiers refer
+	// to the CA certificate.
+	Extensions map[NID]string
+	// MustStaple adds a TLS feature extension requiring OCSP stapling
+	// (RFC 7633), unless Extensions sets NID_tlsfeature.
+	MustStaple bool
+	// CustomExtensions are added after all other extensions, in order.
+	// Issuance fails if one duplicates an extension already added.
+	CustomExtensions []CertificateExtension
+
+	// Serial returns the serial number of the next certificate. Defaults to
+	// a random 159-bit number.
+	Serial func() (*big.Int, error)
+}
+
+func randomSerial() (*big.Int, error) {
+	// stay below 2^159 so that the DER encoding fits the 20 octets allowed
+	// by RFC 5280
+	max := new(big.Int).Lsh(big.NewInt(1), 159)
+	for {
+		buf := make([]byte, 20)
+		if _, err := Rand.Read(buf); err != nil {
+			return nil, err
+		}
+		serial := new(big.Int).SetBytes(buf)
+		serial.Mod(serial, max)
+		if serial.Sign() > 0 {
+			return serial, nil
+		}
+	}
+}
+
+// IssueCertificate verifies the signature of csr and issues a certificate
+// for its subject and public key, signed by caKey on behalf of caCert.
+// Requested extensions are filtered through profile.AllowedExtensions and
+// the profile's own extensions are added. A nil profile issues a
+// certificate with the defaults and no extensions. Names outside the name
+// constraints of caCert are refused with a *VerifyError.
+func IssueCertificate(csr *CertificateRequest, caCert *Certificate,
+	caKey PrivateKey, profile *IssuanceProfile) (*Certificate, error) {
+	if profile == nil {
+		profile = &IssuanceProfile{}
+	}
+	if err := csr.Verify(); err != nil {
+		return nil, err
+	}
+	if !caCert.PublicKeyMatches(caKey) {
+		return nil, errors.New("ca key does not match ca certificate")
+	}
+	digest := profile.Digest
+	if digest == EVP_NULL && caKey.KeyType() != KeyTypeED25519 {
+		digest = EVP_SHA256
+	}
+	md, err := signingDigest(caKey, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := csr.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	subject, err := csr.GetSubjectName()
+	if err != nil {
+		return nil, err
+	}
+	newSerial := profile.Serial
+	if newSerial == nil {
+		newSerial = randomSerial
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	validity := profile.Validity
+	if validity == 0 {
+		validity = 365 * 24 * time.Hour
+	}
+	now := time.Now()
+
+	c := &Certificate{x: C.X509_new()}
+	runtime.SetFinalizer(c, func(c *Certificate) {
+		C.X509_free(c.x)
+	})
+	if err := c.SetVersion(X509_V3); err != nil {
+		return nil, err
+	}
+	if err := c.SetSerial(serial); err != nil {
+		return nil, err
+	}
+	if err := c.SetSubjectName(subject); err != nil {
+		return nil, err
+	}
+	if err := c.SetIssuer(caCert); err != nil {
+		return nil, err
+	}
+	if err := c.SetNotBefore(now.Add(-profile.Backdate)); err != nil {
+		return nil, err
+	}
+	if err := c.SetNotAfter(now.Add(validity)); err != nil {
+		return nil, err
+	}
+	if err := c.SetPubKey(pub); err != nil {
+		return nil, err
+	}
+
+	if exts := csr.requestedExtensions(); exts != nil {
+		defer C.X_sk_X509_EXTENSION_pop_free(exts)
+		for _, nid := range profile.AllowedExtensions {
+			if _, overridden := profile.Extensions[nid]; overridden {
+				continue
+			}
+			loc := C.X509v3_get_ext_by_NID(exts, C.int(nid), -1)
+			if loc < 0 {
+				continue
+			}
+			if C.X509_add_ext(c.x, C.X509v3_get_ext(exts, loc), -1) != 1 {
+				return nil, errors.New("failed to copy requested extension")
+			}
+		}
+	}
+
+	extensions := profile.Extensions
+	if _, set := extensions[NID_tlsfeature]; profile.MustStaple && !set {
+		extensions = make(map[NID]string, len(profile.Extensions)+1)
+		for nid, value := range profile.Extensions {
+			extensions[nid] = value
+		}
+		extensions[NID_tlsfeature] = "status_request"
+	}
+	// add in NID order so that the result does not depend on map iteration
+	nids := make([]int, 0, len(extensions))
+	for nid := range extensions {
+		nids = append(nids, int(nid))
+	}
+	sort.Ints(nids)
+	for _, nid := range nids {
+		if err := c.AddExtension(NID(nid), extensions[NID(nid)]); err != nil {
+			return nil, err
+		}
+	}
+	for _, ext := range profile.CustomExtensions {
+		if err := c.AddExtensionByOID(ext.OID, ext.Critical,
+			ext.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := caCert.CheckNameConstraints(c); err != nil {
+		return nil, err
+	}
+	if C.X509_sign(c.x, caKey.evpPKey(), md) <= 0 {
+		return nil, errors.New("failed to sign certificate")
+	}
+	return c, nil
+}
@@ -0,0 +1,264 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestCA(t *testing.T) (*Certificate, PrivateKey) {
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := NewCertificate(&CertificateInfo{
+		Serial:     big.NewInt(1),
+		Expires:    24 * time.Hour,
+		CommonName: "Test CA",
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.SetVersion(X509_V3); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.AddExtension(NID_basic_constraints, "critical,CA:TRUE"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.AddExtension(NID_key_usage, "critical,keyCertSign,cRLSign"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.AddExtension(NID_subject_key_identifier, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.Sign(key, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	return ca, key
+}
+
+func TestIssueCertificate(t *testing.T) {
+	ca, cakey := newTestCA(t)
+
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := NewCertificateRequest(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := req.GetSubjectName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := name.AddTextEntry("CN", "device-0001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := req.AddExtensions(map[NID]string{
+		NID_subject_alt_name:  "DNS:device-0001.example.com",
+		NID_basic_constraints: "critical,CA:TRUE",
+		NID_key_usage:         "keyCertSign",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Sign(key, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+
+	var serial int64 = 1000
+	cert, err := IssueCertificate(req, ca, cakey, &IssuanceProfile{
+		Validity: time.Hour,
+		Backdate: time.Minute,
+		AllowedExtensions: []NID{NID_subject_alt_name, NID_basic_constraints,
+			NID_key_usage},
+		Extensions: map[NID]string{
+			NID_basic_constraints:        "critical,CA:FALSE",
+			NID_key_usage:                "critical,digitalSignature",
+			NID_ext_key_usage:            "clientAuth",
+			NID_subject_key_identifier:   "hash",
+			NID_authority_key_identifier: "keyid:always",
+		},
+		Serial: func() (*big.Int, error) {
+			serial++
+			return big.NewInt(serial), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caDER, err := ca.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdCA, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := cert.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	std, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if std.IsCA || std.KeyUsage != x509.KeyUsageDigitalSignature {
+		t.Fatal("requested CA extensions were not overridden by the profile")
+	}
+	if len(std.DNSNames) != 1 || std.DNSNames[0] != "device-0001.example.com" {
+		t.Fatal("requested subject alternative name was not copied")
+	}
+	if std.SerialNumber.Int64() != 1001 || std.Subject.CommonName != "device-0001" {
+		t.Fatal("unexpected serial or subject")
+	}
+	if got := std.NotAfter.Sub(std.NotBefore); got < time.Hour ||
+		got > time.Hour+time.Minute+time.Second {
+		t.Fatalf("unexpected validity period %v", got)
+	}
+	if !bytes.Equal(std.AuthorityKeyId, stdCA.SubjectKeyId) {
+		t.Fatal("authority key id does not match the CA")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(stdCA)
+	if _, err := std.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// without an allow list nothing is copied from the request
+	cert, err = IssueCertificate(req, ca, cakey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err = cert.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	std, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(std.DNSNames) != 0 {
+		t.Fatal("requested extension copied without being allowed")
+	}
+
+	other, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := IssueCertificate(req, ca, other, nil); err == nil {
+		t.Fatal("expected an error for a mismatched CA key")
+	}
+}
+
+func TestIssueCertificateCustomExtensions(t *testing.T) {
+	ca, cakey := newTestCA(t)
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := NewCertificateRequest(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Sign(key, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	hardware, err := asn1.MarshalWithParams("ATECC608B", "utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const hardwareOID = "1.3.6.1.4.1.55555.1.1"
+	profile := &IssuanceProfile{
+		MustStaple: true,
+		CustomExtensions: []CertificateExtension{
+			{OID: hardwareOID, Critical: true, Value: hardware},
+			{OID: "1.3.6.1.4.1.55555.1.2", Value: []byte{0x05, 0x00}},
+		},
+	}
+	cert, err := IssueCertificate(req, ca, cakey, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staple, err := cert.MustStaple()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !staple {
+		t.Fatal("expected must-staple")
+	}
+	value, critical, err := cert.GetExtension(hardwareOID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !critical || !bytes.Equal(value, hardware) {
+		t.Fatalf("unexpected custom extension %x critical %v", value, critical)
+	}
+	value, critical, err = cert.GetExtension("1.3.6.1.4.1.55555.1.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if critical || !bytes.Equal(value, []byte{0x05, 0x00}) {
+		t.Fatalf("unexpected custom extension %x critical %v", value, critical)
+	}
+
+	der, err := cert.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	std, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsFeature := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+	found := false
+	for _, ext := range std.Extensions {
+		if ext.Id.Equal(tlsFeature) {
+			found = bytes.Equal(ext.Value, []byte{0x30, 0x03, 0x02, 0x01, 0x05})
+		}
+	}
+	if !found {
+		t.Fatal("tls feature extension not encoded as status_request")
+	}
+	if len(std.UnhandledCriticalExtensions) != 1 ||
+		std.UnhandledCriticalExtensions[0].String() != hardwareOID {
+		t.Fatal("custom extension not marked critical")
+	}
+
+	plain, err := IssueCertificate(req, ca, cakey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staple, err := plain.MustStaple(); err != nil || staple {
+		t.Fatal("unexpected must-staple")
+	}
+
+	profile.MustStaple = false
+	profile.CustomExtensions = append(profile.CustomExtensions,
+		CertificateExtension{OID: hardwareOID, Value: hardware})
+	if _, err := IssueCertificate(req, ca, cakey, profile); err == nil {
+		t.Fatal("issued certificate with duplicate extension")
+	}
+}
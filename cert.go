@@ -87,14 +87,15 @@ func NewName() (*Name, error) {
 	return name, nil
 }
 
-// AddTextEntry appends a text entry to an X509 NAME.
+// AddTextEntry appends a text entry to an X509 NAME. field is a short name
+// such as "CN" or a dotted OID, and value is UTF-8.
 func (n *Name) AddTextEntry(field, value string) error {
 	cfield := C.CString(field)
 	defer C.free(unsafe.Pointer(cfield))
 	cvalue := (*C.uchar)(unsafe.Pointer(C.CString(value)))
 	defer C.free(unsafe.Pointer(cvalue))
 	ret := C.X509_NAME_add_entry_by_txt(
-		n.name, cfield, C.MBSTRING_ASC, cvalue, -1, -1, 0)
+		n.name, cfield, C.MBSTRING_UTF8, cvalue, C.int(len(value)), -1, 0)
 	if ret != 1 {
 		return errors.New("failed to add x509 name text entry")
 	}
@@ -111,17 +112,14 @@ func (n *Name) AddTextEntries(entries map[string]string) error {
 	return nil
 }
 
-// GetEntry returns a name entry based on NID.  If no entry, then ("", false) is
-// returned.
+// GetEntry returns the first name entry with the given NID as UTF-8.  If no
+// entry, then ("", false) is returned.
 func (n *Name) GetEntry(nid NID) (entry string, ok bool) {
-	entrylen := C.X509_NAME_get_text_by_NID(n.name, C.int(nid), nil, 0)
-	if entrylen == -1 {
+	loc := C.X509_NAME_get_index_by_NID(n.name, C.int(nid), -1)
+	if loc < 0 {
 		return "", false
 	}
-	buf := (*C.char)(C.malloc(C.size_t(entrylen + 1)))
-	defer C.free(unsafe.Pointer(buf))
-	C.X509_NAME_get_text_by_NID(n.name, C.int(nid), buf, entrylen+1)
-	return C.GoStringN(buf, entrylen), true
+	return nameEntryValue(C.X509_NAME_get_entry(n.name, loc))
 }
 
 // NewCertificate generates a basic certificate based
@@ -136,13 +134,18 @@ func NewCertificate(info *CertificateInfo, key PublicKey) (*Certificate, error)
 	if err != nil {
 		return nil, err
 	}
-	err = name.AddTextEntries(map[string]string{
-		"C":  info.Country,
-		"O":  info.Organization,
-		"CN": info.CommonName,
-	})
-	if err != nil {
-		return nil, err
+	// entries are added in order; empty ones are left out of the name
+	for _, entry := range []struct{ field, value string }{
+		{"C", info.Country},
+		{"O", info.Organization},
+		{"CN", info.CommonName},
+	} {
+		if entry.value == "" {
+			continue
+		}
+		if err := name.AddTextEntry(entry.field, entry.value); err != nil {
+			return nil, err
+		}
 	}
 	// self-issue for now
 	if err := c.SetIssuerName(name); err != nil {
@@ -218,6 +221,10 @@ func (c *Certificate) SetSerial(serial *big.Int) error {
 	defer C.BN_free(bn)
 
 	serialBytes := serial.Bytes()
+	if len(serialBytes) == 0 {
+		// BN_bin2bn treats a zero length input as zero
+		serialBytes = []byte{0}
+	}
 	if bn = C.BN_bin2bn((*C.uchar)(unsafe.Pointer(&serialBytes[0])), C.int(len(serialBytes)), bn); bn == nil {
 		return errors.New("failed to set serial")
 	}
@@ -250,6 +257,22 @@ func (c *Certificate) SetExpireDate(when time.Duration) error {
 	return nil
 }
 
+// SetNotBefore sets the start of the certificate's validity period.
+func (c *Certificate) SetNotBefore(t time.Time) error {
+	if C.ASN1_TIME_set(C.X_X509_get0_notBefore(c.x), C.time_t(t.Unix())) == nil {
+		return errors.New("failed to set issue date")
+	}
+	return nil
+}
+
+// SetNotAfter sets the end of the certificate's validity period.
+func (c *Certificate) SetNotAfter(t time.Time) error {
+	if C.ASN1_TIME_set(C.X_X509_get0_notAfter(c.x), C.time_t(t.Unix())) == nil {
+		return errors.New("failed to set expire date")
+	}
+	return nil
+}
+
 // SetPubKey assigns a new public key to a certificate.
 func (c *Certificate) SetPubKey(pubKey PublicKey) error {
 	c.pubKey = pubKey
@@ -260,17 +283,37 @@ func (c *Certificate) SetPubKey(pubKey PublicKey) error {
 }
 
 // Sign a certificate using a private key and a digest name.
-// Accepted digest names are 'sha256', 'sha384', and 'sha512'.
+// Accepted digest names are 'sha256', 'sha384', and 'sha512'. Ed25519 keys
+// sign without a separate digest and require EVP_NULL.
 func (c *Certificate) Sign(privKey PrivateKey, digest EVP_MD) error {
+	md, err := signingDigest(privKey, digest)
+	if err != nil {
+		return err
+	}
+	if C.X509_sign(c.x, privKey.evpPKey(), md) <= 0 {
+		return errors.New("failed to sign certificate")
+	}
+	return nil
+}
+
+// signingDigest checks that digest is acceptable for signing certificates,
+// requests and CRLs with privKey and returns the matching OpenSSL digest.
+func signingDigest(privKey PrivateKey, digest EVP_MD) (*C.EVP_MD, error) {
+	if privKey.KeyType() == KeyTypeED25519 {
+		if digest != EVP_NULL {
+			return nil, errors.New("ed25519 keys require EVP_NULL as digest")
+		}
+		return nil, nil
+	}
 	switch digest {
 	case EVP_SHA256:
 	case EVP_SHA384:
 	case EVP_SHA512:
 	default:
-		return errors.New("unsupported digest; " +
+		return nil, errors.New("unsupported digest; " +
 			"you're probably looking for 'EVP_SHA256' or 'EVP_SHA512'")
 	}
-	return c.insecureSign(privKey, digest)
+	return getDigestFunction(digest), nil
 }
 
 func (c *Certificate) insecureSign(privKey PrivateKey, digest EVP_MD) error {
@@ -319,8 +362,10 @@ func (c *Certificate) AddExtension(nid NID, value string) error {
 		issuer = c.Issuer
 	}
 	var ctx C.X509V3_CTX
-	C.X509V3_set_ctx(&ctx, c.x, issuer.x, nil, nil, 0)
-	ex := C.X509V3_EXT_conf_nid(nil, &ctx, C.int(nid), C.CString(value))
+	C.X509V3_set_ctx(&ctx, issuer.x, c.x, nil, nil, 0)
+	cvalue := C.CString(value)
+	defer C.free(unsafe.Pointer(cvalue))
+	ex := C.X509V3_EXT_conf_nid(nil, &ctx, C.int(nid), cvalue)
 	if ex == nil {
 		return errors.New("failed to create x509v3 extension")
 	}
@@ -386,6 +431,40 @@ func (c *Certificate) MarshalPEM() (pem_block []byte, err error) {
 	return ioutil.ReadAll(asAnyBio(bio))
 }
 
+// LoadCertificateFromDER loads an X509 certificate from a DER-encoded block.
+func LoadCertificateFromDER(der_block []byte) (*Certificate, error) {
+	if len(der_block) == 0 {
+		return nil, errors.New("empty der block")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	bio := C.BIO_new_mem_buf(unsafe.Pointer(&der_block[0]),
+		C.int(len(der_block)))
+	cert := C.d2i_X509_bio(bio, nil)
+	C.BIO_free(bio)
+	if cert == nil {
+		return nil, errorFromErrorQueue()
+	}
+	x := &Certificate{x: cert}
+	runtime.SetFinalizer(x, func(x *Certificate) {
+		C.X509_free(x.x)
+	})
+	return x, nil
+}
+
+// MarshalDER converts the X509 certificate to DER-encoded format
+func (c *Certificate) MarshalDER() (der_block []byte, err error) {
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+	if int(C.i2d_X509_bio(bio, c.x)) != 1 {
+		return nil, errors.New("failed dumping certificate der")
+	}
+	return ioutil.ReadAll(asAnyBio(bio))
+}
+
 // PublicKey returns the public key embedded in the X509 certificate.
 func (c *Certificate) PublicKey() (PublicKey, error) {
 	pkey := C.X509_get_pubkey(c.x)
@@ -399,6 +478,40 @@ func (c *Certificate) PublicKey() (PublicKey, error) {
 	return key, nil
 }
 
+// PublicKeyMatches reports whether the certificate's public key is the public
+// half of the given private key.
+func (c *Certificate) PublicKeyMatches(key PrivateKey) bool {
+	if key == nil {
+		return false
+	}
+	defer C.ERR_clear_error()
+	return C.X509_check_private_key(c.x, key.evpPKey()) == 1
+}
+
+// CertificateMatchesKey checks that key is the private key of cert's public
+// key. Unlike PublicKeyMatches it returns an error describing the mismatch,
+// such as differing key types, for configuration checks at startup.
+func CertificateMatchesKey(cert *Certificate, key PrivateKey) error {
+	if cert == nil {
+		return errors.New("no certificate")
+	}
+	if key == nil {
+		return errors.New("no private key")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.X509_check_private_key(cert.x, key.evpPKey()) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
+// SubjectNameHash returns the hash of the certificate's subject name used to
+// name files in a hashed CA directory ("%08x.0").
+func (c *Certificate) SubjectNameHash() uint32 {
+	return uint32(C.X509_subject_name_hash(c.x))
+}
+
 // GetSerialNumberHex returns the certificate's serial number in hex format
 func (c *Certificate) GetSerialNumberHex() (serial string) {
 	asn1_i := C.X509_get_serialNumber(c.x)
@@ -410,6 +523,39 @@ func (c *Certificate) GetSerialNumberHex() (serial string) {
 	return
 }
 
+// SerialNumber returns the certificate's serial number.
+func (c *Certificate) SerialNumber() (*big.Int, error) {
+	return bigInt(C.X509_get_serialNumber(c.x))
+}
+
+// NotBefore returns the start of the certificate's validity period.
+func (c *Certificate) NotBefore() (time.Time, error) {
+	return goTime(C.X_X509_get0_notBefore(c.x))
+}
+
+// NotAfter returns the end of the certificate's validity period.
+func (c *Certificate) NotAfter() (time.Time, error) {
+	return goTime(C.X_X509_get0_notAfter(c.x))
+}
+
+// ExpiresWithin reports whether the certificate's validity period ends
+// within d from now, including certificates that have already expired. A
+// certificate with an unreadable expiry date is reported as expiring.
+func (c *Certificate) ExpiresWithin(d time.Duration) bool {
+	notAfter, err := c.NotAfter()
+	if err != nil {
+		return true
+	}
+	return notAfter.Before(time.Now().Add(d))
+}
+
+// SignatureAlgorithm returns the NID of the algorithm the issuer signed the
+// certificate with, e.g. NID_ecdsa_with_SHA256, or NID_undef if it is not
+// known to OpenSSL.
+func (c *Certificate) SignatureAlgorithm() NID {
+	return NID(C.X509_get_signature_nid(c.x))
+}
+
 // GetVersion returns the X509 version of the certificate.
 func (c *Certificate) GetVersion() X509_Version {
 	return X509_Version(C.X_X509_get_version(c.x))
@@ -424,9 +570,13 @@ func (c *Certificate) SetVersion(version X509_Version) error {
 	return nil
 }
 
-// GetExtensionValue returns the value of the given NID's extension.
+// GetExtensionValue returns the value of the given NID's extension, or nil if
+// the certificate does not carry it.
 func (c *Certificate) GetExtensionValue(nid NID) []byte {
 	dataLength := C.int(0)
 	val := C.get_extention(c.x, C.int(nid), &dataLength)
+	if val == nil {
+		return nil
+	}
 	return C.GoBytes(unsafe.Pointer(val), dataLength)
 }
@@ -0,0 +1,39 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"crypto/x509"
+	"errors"
+)
+
+// ToX509 parses the certificate with the standard library, e.g. to use its
+// verification alongside OpenSSL-backed TLS.
+func (c *Certificate) ToX509() (*x509.Certificate, error) {
+	der, err := c.MarshalDER()
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// FromX509 converts a standard library certificate into a Certificate backed
+// by OpenSSL.
+func FromX509(cert *x509.Certificate) (*Certificate, error) {
+	if cert == nil || len(cert.Raw) == 0 {
+		return nil, errors.New("certificate has no raw encoding")
+	}
+	return LoadCertificateFromDER(cert.Raw)
+}
@@ -15,7 +15,13 @@
 package openssl
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"math/big"
+	"net"
 	"testing"
 	"time"
 )
@@ -162,3 +168,592 @@ func TestCertVersion(t *testing.T) {
 		t.Fatalf("bad version: %d", vers)
 	}
 }
+
+func TestCertDER(t *testing.T) {
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := cert.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certBytes)
+	if !bytes.Equal(der, block.Bytes) {
+		t.Fatal("der encoding differs from pem contents")
+	}
+	loaded, err := LoadCertificateFromDER(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem_block, err := loaded.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pem_block, certBytes) {
+		t.Fatal("der round trip changed the certificate")
+	}
+	if _, err := LoadCertificateFromDER([]byte("not a cert")); err == nil {
+		t.Fatal("expected an error for garbage input")
+	}
+}
+
+func TestCertPublicKeyPin(t *testing.T) {
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin, err := cert.PublicKeyPinSHA256()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(certBytes)
+	stdCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(stdCert.RawSubjectPublicKeyInfo)
+	if expected := base64.StdEncoding.EncodeToString(sum[:]); pin != expected {
+		t.Fatalf("expected pin %s, got %s", expected, pin)
+	}
+
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := key.SPKIFingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fingerprint, sum[:]) {
+		t.Fatal("key fingerprint does not match certificate pin")
+	}
+}
+
+func TestCertPublicKeyMatches(t *testing.T) {
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.PublicKeyMatches(key) {
+		t.Fatal("certificate should match its private key")
+	}
+	pub, err := cert.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.Equal(key) || !key.Equal(pub) {
+		t.Fatal("certificate public key should equal the private key")
+	}
+
+	other, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.PublicKeyMatches(other) {
+		t.Fatal("certificate should not match an unrelated key")
+	}
+	if pub.Equal(other) || pub.Equal(nil) {
+		t.Fatal("certificate public key should not equal an unrelated key")
+	}
+}
+
+func TestCertificateMatchesKey(t *testing.T) {
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CertificateMatchesKey(cert, key); err != nil {
+		t.Fatal(err)
+	}
+	other, err := GenerateRSAKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CertificateMatchesKey(cert, other); err == nil {
+		t.Fatal("certificate should not match an unrelated key")
+	}
+	if err := CertificateMatchesKey(cert, nil); err == nil {
+		t.Fatal("certificate should not match a missing key")
+	}
+}
+
+func TestCertBuilderExtensions(t *testing.T) {
+	notBefore := time.Now().Add(-time.Hour).Truncate(time.Second)
+	notAfter := notBefore.Add(365 * 24 * time.Hour)
+
+	cakey, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := NewCertificate(&CertificateInfo{
+		Serial:       big.NewInt(1),
+		Organization: "Device CA",
+		CommonName:   "Device Root",
+	}, cakey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.SetVersion(X509_V3); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.SetNotBefore(notBefore); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.SetNotAfter(notAfter); err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range []struct {
+		nid   NID
+		value string
+	}{
+		{NID_basic_constraints, "critical,CA:TRUE,pathlen:0"},
+		{NID_key_usage, "critical,keyCertSign,cRLSign"},
+		{NID_subject_key_identifier, "hash"},
+		{NID_authority_key_identifier, "keyid:always"},
+	} {
+		if err := ca.AddExtension(ext.nid, ext.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ca.Sign(cakey, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := NewCertificate(&CertificateInfo{
+		Serial:       new(big.Int).Lsh(big.NewInt(1), 100),
+		Organization: "Devices",
+		CommonName:   "device-0001",
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetIssuer(ca); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetVersion(X509_V3); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetNotBefore(notBefore); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetNotAfter(notAfter); err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range []struct {
+		nid   NID
+		value string
+	}{
+		{NID_basic_constraints, "critical,CA:FALSE"},
+		{NID_key_usage, "critical,digitalSignature"},
+		{NID_ext_key_usage, "clientAuth,serverAuth"},
+		{NID_subject_alt_name,
+			"DNS:device-0001.example.com,IP:192.0.2.1,email:ops@example.com"},
+		{NID_subject_key_identifier, "hash"},
+		{NID_authority_key_identifier, "keyid:always"},
+		{NID_crl_distribution_points, "URI:http://ca.example.com/root.crl"},
+		{NID_info_access, "OCSP;URI:http://ocsp.example.com"},
+	} {
+		if err := cert.AddExtension(ext.nid, ext.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cert.Sign(cakey, EVP_SHA384); err != nil {
+		t.Fatal(err)
+	}
+
+	parse := func(c *Certificate) *x509.Certificate {
+		der, err := c.MarshalDER()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	stdCA, stdCert := parse(ca), parse(cert)
+	if !stdCA.IsCA || stdCA.MaxPathLen != 0 || !stdCA.MaxPathLenZero {
+		t.Fatal("unexpected basic constraints on CA")
+	}
+	if !stdCert.NotBefore.Equal(notBefore) || !stdCert.NotAfter.Equal(notAfter) {
+		t.Fatalf("unexpected validity %v - %v", stdCert.NotBefore,
+			stdCert.NotAfter)
+	}
+	if stdCert.SerialNumber.Cmp(new(big.Int).Lsh(big.NewInt(1), 100)) != 0 {
+		t.Fatal("unexpected serial number")
+	}
+	if len(stdCert.DNSNames) != 1 || len(stdCert.IPAddresses) != 1 ||
+		len(stdCert.EmailAddresses) != 1 {
+		t.Fatal("unexpected subject alternative names")
+	}
+	if len(stdCert.ExtKeyUsage) != 2 || len(stdCert.CRLDistributionPoints) != 1 ||
+		len(stdCert.OCSPServer) != 1 {
+		t.Fatal("unexpected extensions")
+	}
+	if !bytes.Equal(stdCert.AuthorityKeyId, stdCA.SubjectKeyId) {
+		t.Fatal("authority key id does not match the CA subject key id")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(stdCA)
+	if _, err := stdCert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		DNSName:   "device-0001.example.com",
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertSignED25519(t *testing.T) {
+	if !ed25519_support {
+		t.Skip("ED25519 not supported on this version of OpenSSL")
+	}
+	key, err := GenerateED25519Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := NewCertificate(&CertificateInfo{
+		Serial:     big.NewInt(0),
+		Expires:    time.Hour,
+		CommonName: "ed25519",
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Sign(key, EVP_SHA256); err == nil {
+		t.Fatal("expected an error signing ed25519 with a digest")
+	}
+	if err := cert.Sign(key, EVP_NULL); err != nil {
+		t.Fatal(err)
+	}
+	der, err := cert.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.CheckSignatureFrom(parsed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertExtensionAccessors(t *testing.T) {
+	ca, cakey := newTestCA(t)
+
+	isCA, pathLen, err := ca.BasicConstraints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isCA || pathLen != -1 {
+		t.Fatalf("unexpected basic constraints: %v %d", isCA, pathLen)
+	}
+	usage, err := ca.KeyUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != KeyUsageKeyCertSign|KeyUsageCRLSign {
+		t.Fatalf("unexpected key usage %#x", usage)
+	}
+	caKeyId, err := ca.SubjectKeyId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(caKeyId) != 20 {
+		t.Fatalf("unexpected subject key id %x", caKeyId)
+	}
+
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := NewCertificate(&CertificateInfo{
+		Serial:     big.NewInt(2),
+		Expires:    time.Hour,
+		CommonName: "leaf",
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetVersion(X509_V3); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetIssuer(ca); err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range []struct {
+		nid   NID
+		value string
+	}{
+		{NID_basic_constraints, "CA:FALSE"},
+		{NID_key_usage, "critical,digitalSignature,keyEncipherment"},
+		{NID_ext_key_usage, "serverAuth,clientAuth"},
+		{NID_subject_alt_name, "DNS:leaf.example.com"},
+		{NID_subject_key_identifier, "hash"},
+		{NID_authority_key_identifier, "keyid:always"},
+	} {
+		if err := cert.AddExtension(ext.nid, ext.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cert.Sign(cakey, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+
+	isCA, pathLen, err = cert.BasicConstraints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isCA || pathLen != -1 {
+		t.Fatalf("unexpected basic constraints: %v %d", isCA, pathLen)
+	}
+	usage, err = cert.KeyUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != KeyUsageDigitalSignature|KeyUsageKeyEncipherment {
+		t.Fatalf("unexpected key usage %#x", usage)
+	}
+	eku, err := cert.ExtendedKeyUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eku) != 2 || eku[0] != NID_server_auth || eku[1] != NID_client_auth {
+		t.Fatalf("unexpected extended key usage %v", eku)
+	}
+	akid, err := cert.AuthorityKeyId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(akid, caKeyId) {
+		t.Fatal("authority key id does not match the issuer's subject key id")
+	}
+	skid, err := cert.SubjectKeyId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skid) != 20 || bytes.Equal(skid, caKeyId) {
+		t.Fatalf("unexpected subject key id %x", skid)
+	}
+
+	san, critical, err := cert.GetExtension("2.5.29.17")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if critical || !bytes.Equal(san, cert.GetExtensionValue(NID_subject_alt_name)) {
+		t.Fatal("unexpected subject alternative name extension")
+	}
+	if _, critical, _ := cert.GetExtension("2.5.29.15"); !critical {
+		t.Fatal("key usage should be critical")
+	}
+	if val, _, err := cert.GetExtension("2.5.29.31"); err != nil || val != nil {
+		t.Fatal("unexpected crl distribution points extension")
+	}
+	if cert.GetExtensionValue(NID_crl_distribution_points) != nil {
+		t.Fatal("unexpected crl distribution points extension")
+	}
+	if _, _, err := cert.GetExtension("not an oid"); err == nil {
+		t.Fatal("expected an error for an invalid oid")
+	}
+}
+
+func TestCertSubjectAltNames(t *testing.T) {
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := NewCertificate(&CertificateInfo{
+		Serial:     big.NewInt(1),
+		Expires:    time.Hour,
+		CommonName: "device-0001",
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetVersion(X509_V3); err != nil {
+		t.Fatal(err)
+	}
+
+	dns, err := cert.DNSNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dns) != 0 {
+		t.Fatal("unexpected dns names without a subject alternative name")
+	}
+
+	if err := cert.AddExtension(NID_subject_alt_name,
+		"DNS:device-0001.example.com,DNS:*.example.com,IP:192.0.2.1,"+
+			"IP:2001:db8::1,email:ops@example.com,"+
+			"URI:spiffe://example.com/device/0001,"+
+			"otherName:1.2.3.4;UTF8:ignored"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Sign(key, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+
+	dns, err = cert.DNSNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dns) != 2 || dns[0] != "device-0001.example.com" ||
+		dns[1] != "*.example.com" {
+		t.Fatalf("unexpected dns names %v", dns)
+	}
+	ips, err := cert.IPAddresses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 || !ips[0].Equal(net.ParseIP("192.0.2.1")) ||
+		!ips[1].Equal(net.ParseIP("2001:db8::1")) {
+		t.Fatalf("unexpected ip addresses %v", ips)
+	}
+	emails, err := cert.EmailAddresses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 1 || emails[0] != "ops@example.com" {
+		t.Fatalf("unexpected email addresses %v", emails)
+	}
+	uris, err := cert.URIs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uris) != 1 || uris[0].Scheme != "spiffe" ||
+		uris[0].Host != "example.com" || uris[0].Path != "/device/0001" {
+		t.Fatalf("unexpected uris %v", uris)
+	}
+}
+
+func TestCertStdlibConversion(t *testing.T) {
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	std, err := cert.ToX509()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certBytes)
+	if !bytes.Equal(std.Raw, block.Bytes) {
+		t.Fatal("stdlib certificate does not match the original encoding")
+	}
+
+	back, err := FromX509(std)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.GetSerialNumberHex() != cert.GetSerialNumberHex() {
+		t.Fatal("serial number changed in conversion")
+	}
+	der, err := back.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(der, block.Bytes) {
+		t.Fatal("converted certificate does not match the original encoding")
+	}
+	if _, err := FromX509(&x509.Certificate{}); err == nil {
+		t.Fatal("expected an error for a certificate without raw encoding")
+	}
+}
+
+func TestCertMetadata(t *testing.T) {
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, ok := new(big.Int).SetString("0123456789abcdef0123456789abcdef", 16)
+	if !ok {
+		t.Fatal("invalid serial")
+	}
+	cert, err := NewCertificate(&CertificateInfo{
+		Serial:     serial,
+		Expires:    time.Hour,
+		CommonName: "device-0001",
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Now().Add(10 * 24 * time.Hour).Truncate(time.Second)
+	if err := cert.SetNotBefore(notBefore); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetNotAfter(notAfter); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Sign(key, EVP_SHA384); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := cert.SerialNumber()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Cmp(serial) != 0 {
+		t.Fatalf("expected serial %x, got %x", serial, got)
+	}
+	start, err := cert.NotBefore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !start.Equal(notBefore) {
+		t.Fatalf("expected not before %v, got %v", notBefore, start)
+	}
+	end, err := cert.NotAfter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !end.Equal(notAfter) {
+		t.Fatalf("expected not after %v, got %v", notAfter, end)
+	}
+	if alg := cert.SignatureAlgorithm(); alg != NID_ecdsa_with_SHA384 {
+		t.Fatalf("unexpected signature algorithm %d", alg)
+	}
+
+	if cert.ExpiresWithin(9 * 24 * time.Hour) {
+		t.Fatal("expiring too early")
+	}
+	if !cert.ExpiresWithin(11 * 24 * time.Hour) {
+		t.Fatal("not expiring within 11 days")
+	}
+	if err := cert.SetNotAfter(time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if !cert.ExpiresWithin(0) {
+		t.Fatal("expired certificate not reported")
+	}
+
+	rsakey, err := GenerateRSAKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetPubKey(rsakey); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Sign(rsakey, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	if alg := cert.SignatureAlgorithm(); alg != NID_sha256WithRSAEncryption {
+		t.Fatalf("unexpected signature algorithm %d", alg)
+	}
+}
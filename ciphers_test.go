@@ -16,6 +16,9 @@ package openssl
 
 import (
 	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rc4"
 	"fmt"
 	"strings"
 	"testing"
@@ -300,3 +303,81 @@ func TestNonAuthenticatedEncryption(t *testing.T) {
 
 	checkEqual(t, []byte(plainOutput), plaintext1+plaintext2)
 }
+
+func TestLegacyCiphers(t *testing.T) {
+	if err := LoadLegacyProvider(); err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("0123456789abcdef01234567")
+	plaintext := []byte("legacy devices never die, they just fade away")
+
+	for _, tc := range []struct {
+		name   string
+		expect func() []byte
+	}{
+		{"des-ede3-cbc", func() []byte {
+			block, err := des.NewTripleDESCipher(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			padded := append([]byte{}, plaintext...)
+			pad := block.BlockSize() - len(padded)%block.BlockSize()
+			padded = append(padded, bytes.Repeat([]byte{byte(pad)}, pad)...)
+			out := make([]byte, len(padded))
+			cipher.NewCBCEncrypter(block, make([]byte, 8)).CryptBlocks(out,
+				padded)
+			return out
+		}},
+		{"rc4", func() []byte {
+			c, err := rc4.NewCipher(key[:16])
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := make([]byte, len(plaintext))
+			c.XORKeyStream(out, plaintext)
+			return out
+		}},
+		{"bf-cbc", nil},
+	} {
+		c, err := GetCipherByName(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var iv []byte
+		if c.IVSize() > 0 {
+			iv = make([]byte, c.IVSize())
+		}
+		ectx, err := NewEncryptionCipherCtx(c, nil, key[:c.KeySize()], iv)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		ciphertext, err := ectx.EncryptUpdate(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		final, err := ectx.EncryptFinal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ciphertext = append(ciphertext, final...)
+		if tc.expect != nil && !bytes.Equal(ciphertext, tc.expect()) {
+			t.Fatalf("%s: ciphertext differs from the standard library", tc.name)
+		}
+
+		dctx, err := NewDecryptionCipherCtx(c, nil, key[:c.KeySize()], iv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := dctx.DecryptUpdate(ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		final, err = dctx.DecryptFinal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(append(decrypted, final...), plaintext) {
+			t.Fatalf("%s: round trip failed", tc.name)
+		}
+	}
+}
@@ -0,0 +1,550 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"io/ioutil"
+	"runtime"
+	"unsafe"
+)
+
+// CMSFlags are CMS_* options for signing and verifying CMS (PKCS#7)
+// SignedData.
+type CMSFlags int
+
+const (
+	// CMSDetached leaves the content out of the signature.
+	CMSDetached CMSFlags = C.CMS_DETACHED
+	// CMSNoCerts leaves the signer certificates out of the signature.
+	CMSNoCerts CMSFlags = C.CMS_NOCERTS
+	// CMSNoAttributes signs the content without any signed attributes.
+	CMSNoAttributes CMSFlags = C.CMS_NOATTR
+	// CMSNoSMIMECaps leaves out the S/MIME capabilities signed attribute.
+	CMSNoSMIMECaps CMSFlags = C.CMS_NOSMIMECAP
+	// CMSUseKeyID identifies signers by subject key identifier instead of
+	// issuer and serial number.
+	CMSUseKeyID CMSFlags = C.CMS_USE_KEYID
+
+	// CMSNoIntern only looks for signer certificates in the certificates
+	// passed to Verify, not in the signature itself.
+	CMSNoIntern CMSFlags = C.CMS_NOINTERN
+	// CMSNoSignerCertVerify skips verifying the signer certificates'
+	// chains; only the signatures are checked.
+	CMSNoSignerCertVerify CMSFlags = C.CMS_NO_SIGNER_CERT_VERIFY
+)
+
+// CMSAttribute is a signed attribute with a single DER-encoded value.
+type CMSAttribute struct {
+	// OID is the attribute type as a dotted object identifier.
+	OID string
+	// Value is the DER encoding of the attribute value.
+	Value []byte
+}
+
+// CMSSigner is a certificate and key signing CMS content.
+type CMSSigner struct {
+	Certificate *Certificate
+	Key         PrivateKey
+	// Digest is one of EVP_SHA256, EVP_SHA384 or EVP_SHA512. Ed25519
+	// signers always use SHA-512 as required by RFC 8419 and may leave it as
+	// EVP_NULL; they need an OpenSSL version supporting EdDSA in CMS.
+	Digest EVP_MD
+	// Attributes are added to the signed attributes in addition to the
+	// content type, message digest and signing time.
+	Attributes []CMSAttribute
+}
+
+// CMS is a CMS ContentInfo, e.g. SignedData, which is compatible with
+// PKCS#7.
+type CMS struct {
+	cms *C.CMS_ContentInfo
+}
+
+func newCMS(cms *C.CMS_ContentInfo) *CMS {
+	c := &CMS{cms: cms}
+	runtime.SetFinalizer(c, func(c *CMS) {
+		C.CMS_ContentInfo_free(c.cms)
+	})
+	return c
+}
+
+// cmsContentBIO returns a memory BIO reading data, which must stay
+// referenced while the BIO is in use.
+func cmsContentBIO(data []byte) *C.BIO {
+	if len(data) == 0 {
+		return C.BIO_new(C.BIO_s_mem())
+	}
+	return C.BIO_new_mem_buf(unsafe.Pointer(&data[0]), C.int(len(data)))
+}
+
+// certificateStack returns a new stack holding certs, to be freed with
+// X_sk_X509_free. The certificates must stay referenced while it is in use.
+func certificateStack(certs []*Certificate) (*C.struct_stack_st_X509,
+	error) {
+	sk := C.X_sk_X509_new_null()
+	if sk == nil {
+		return nil, errors.New("failed to allocate certificate stack")
+	}
+	for _, cert := range certs {
+		if C.X_sk_X509_push(sk, cert.x) <= 0 {
+			C.X_sk_X509_free(sk)
+			return nil, errors.New("failed to add certificate")
+		}
+	}
+	return sk, nil
+}
+
+// cmsDigest returns the message digest used for signing with key. Unlike
+// certificates, CMS signatures with Ed25519 keys need SHA-512 to digest the
+// signed attributes.
+func cmsDigest(key PrivateKey, digest EVP_MD) (*C.EVP_MD, error) {
+	if key.KeyType() == KeyTypeED25519 {
+		if digest != EVP_NULL && digest != EVP_SHA512 {
+			return nil, errors.New("ed25519 cms signers require EVP_SHA512")
+		}
+		return getDigestFunction(EVP_SHA512), nil
+	}
+	return signingDigest(key, digest)
+}
+
+func addCMSAttribute(si *C.CMS_SignerInfo, attr CMSAttribute) error {
+	if len(attr.Value) == 0 {
+		return errors.New("empty cms attribute value")
+	}
+	coid := C.CString(attr.OID)
+	defer C.free(unsafe.Pointer(coid))
+	obj := C.OBJ_txt2obj(coid, 1)
+	if obj == nil {
+		return errors.New("invalid object identifier")
+	}
+	defer C.ASN1_OBJECT_free(obj)
+	if C.X_CMS_signed_add1_attr_der(si, obj,
+		(*C.uchar)(unsafe.Pointer(&attr.Value[0])),
+		C.int(len(attr.Value))) != 1 {
+		return errors.New("failed to add cms attribute")
+	}
+	return nil
+}
+
+// CMSSign signs data as CMS SignedData by every signer. certs are extra
+// certificates, such as intermediates, to include in the signature. The data
+// is signed as is, without any MIME canonicalization.
+func CMSSign(data []byte, signers []CMSSigner, certs []*Certificate,
+	flags CMSFlags) (*CMS, error) {
+	if len(signers) == 0 {
+		return nil, errors.New("no cms signers")
+	}
+	sk, err := certificateStack(certs)
+	if err != nil {
+		return nil, err
+	}
+	defer C.X_sk_X509_free(sk)
+	cflags := C.uint(flags | C.CMS_BINARY)
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	cms := C.CMS_sign(nil, nil, sk, nil, cflags|C.CMS_PARTIAL)
+	if cms == nil {
+		return nil, errorFromErrorQueue()
+	}
+	c := newCMS(cms)
+	for _, signer := range signers {
+		md, err := cmsDigest(signer.Key, signer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		si := C.CMS_add1_signer(c.cms, signer.Certificate.x,
+			signer.Key.evpPKey(), md, cflags)
+		if si == nil {
+			return nil, errorFromErrorQueue()
+		}
+		for _, attr := range signer.Attributes {
+			if err := addCMSAttribute(si, attr); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	bio := cmsContentBIO(data)
+	if bio == nil {
+		return nil, errors.New("failed creating bio")
+	}
+	defer C.BIO_free(bio)
+	if C.CMS_final(c.cms, bio, nil, cflags) != 1 {
+		return nil, errorFromErrorQueue()
+	}
+	runtime.KeepAlive(signers)
+	runtime.KeepAlive(certs)
+	return c, nil
+}
+
+// CMSCertificatesOnly returns a degenerate SignedData structure without
+// signers or content that carries certs, as returned by e.g. EST servers.
+func CMSCertificatesOnly(certs []*Certificate) (*CMS, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates")
+	}
+	sk, err := certificateStack(certs)
+	if err != nil {
+		return nil, err
+	}
+	defer C.X_sk_X509_free(sk)
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	// without signers there is nothing to finalize, and CMS_final fails
+	cms := C.CMS_sign(nil, nil, sk, nil,
+		C.CMS_BINARY|C.CMS_DETACHED|C.CMS_PARTIAL)
+	if cms == nil {
+		return nil, errorFromErrorQueue()
+	}
+	runtime.KeepAlive(certs)
+	return newCMS(cms), nil
+}
+
+// Verify checks the signatures and, unless CMSNoSignerCertVerify is set, the
+// signer certificate chains against store, using certs as extra untrusted
+// certificates. For a detached signature the signed data must be passed as
+// content. On success the signed content is returned.
+func (c *CMS) Verify(store *CertificateStore, certs []*Certificate,
+	content []byte, flags CMSFlags) ([]byte, error) {
+	sk, err := certificateStack(certs)
+	if err != nil {
+		return nil, err
+	}
+	defer C.X_sk_X509_free(sk)
+	var dcont *C.BIO
+	if content != nil {
+		dcont = cmsContentBIO(content)
+		if dcont == nil {
+			return nil, errors.New("failed creating bio")
+		}
+		defer C.BIO_free(dcont)
+	}
+	out := C.BIO_new(C.BIO_s_mem())
+	if out == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(out)
+	var x509store *C.X509_STORE
+	if store != nil {
+		x509store = store.store
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.CMS_verify(c.cms, sk, x509store, dcont, out,
+		C.uint(flags|C.CMS_BINARY)) != 1 {
+		return nil, errorFromErrorQueue()
+	}
+	runtime.KeepAlive(certs)
+	return ioutil.ReadAll(asAnyBio(out))
+}
+
+// Signers returns the signer certificates, in signer order. It is only valid
+// after a successful Verify.
+func (c *CMS) Signers() ([]*Certificate, error) {
+	sk := C.CMS_get0_signers(c.cms)
+	if sk == nil {
+		return nil, errors.New("no cms signers found")
+	}
+	defer C.X_sk_X509_free(sk)
+	n := int(C.X_sk_X509_num(sk))
+	signers := make([]*Certificate, 0, n)
+	for i := 0; i < n; i++ {
+		x := C.X_sk_X509_value(sk, C.int(i))
+		if C.X_X509_add_ref(x) != 1 {
+			return nil, errors.New("failed to reference signer certificate")
+		}
+		cert := &Certificate{x: x}
+		runtime.SetFinalizer(cert, func(cert *Certificate) {
+			C.X509_free(cert.x)
+		})
+		signers = append(signers, cert)
+	}
+	return signers, nil
+}
+
+// Certificates returns the certificates included in the structure, such as
+// those of a certificates-only response.
+func (c *CMS) Certificates() ([]*Certificate, error) {
+	sk := C.CMS_get1_certs(c.cms)
+	if sk == nil {
+		return nil, nil
+	}
+	defer C.X_sk_X509_free(sk)
+	n := int(C.X_sk_X509_num(sk))
+	certs := make([]*Certificate, 0, n)
+	for i := 0; i < n; i++ {
+		cert := &Certificate{x: C.X_sk_X509_value(sk, C.int(i))}
+		runtime.SetFinalizer(cert, func(cert *Certificate) {
+			C.X509_free(cert.x)
+		})
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// SignerCount returns the number of signatures.
+func (c *CMS) SignerCount() int {
+	infos := C.CMS_get0_SignerInfos(c.cms)
+	if infos == nil {
+		return 0
+	}
+	return int(C.X_sk_CMS_SignerInfo_num(infos))
+}
+
+// SignedAttribute returns the DER-encoded value of the signed attribute
+// identified by the dotted object identifier oid of the signer at index, or
+// nil if the signer has no such attribute.
+func (c *CMS) SignedAttribute(index int, oid string) ([]byte, error) {
+	infos := C.CMS_get0_SignerInfos(c.cms)
+	if infos == nil || index < 0 ||
+		index >= int(C.X_sk_CMS_SignerInfo_num(infos)) {
+		return nil, errors.New("no such cms signer")
+	}
+	si := C.X_sk_CMS_SignerInfo_value(infos, C.int(index))
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	obj := C.OBJ_txt2obj(coid, 1)
+	if obj == nil {
+		return nil, errors.New("invalid object identifier")
+	}
+	defer C.ASN1_OBJECT_free(obj)
+	var length C.int
+	der := C.X_CMS_signed_get_attr_der(si, obj, &length)
+	if der == nil {
+		return nil, nil
+	}
+	defer C.X_OPENSSL_free(unsafe.Pointer(der))
+	return C.GoBytes(unsafe.Pointer(der), length), nil
+}
+
+// CMSEncrypt encrypts data as CMS EnvelopedData for every recipient
+// certificate. RSA recipients use key transport and EC recipients ECDH key
+// agreement. cipher is the content encryption cipher and defaults to
+// aes-256-gcm; AEAD ciphers produce AuthEnvelopedData (RFC 5083).
+func CMSEncrypt(data []byte, recipients []*Certificate, cipher *Cipher,
+	flags CMSFlags) (*CMS, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no cms recipients")
+	}
+	if cipher == nil {
+		var err error
+		cipher, err = GetCipherByName("aes-256-gcm")
+		if err != nil {
+			return nil, err
+		}
+	}
+	sk, err := certificateStack(recipients)
+	if err != nil {
+		return nil, err
+	}
+	defer C.X_sk_X509_free(sk)
+	bio := cmsContentBIO(data)
+	if bio == nil {
+		return nil, errors.New("failed creating bio")
+	}
+	defer C.BIO_free(bio)
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	cms := C.CMS_encrypt(sk, bio, cipher.ptr, C.uint(flags|C.CMS_BINARY))
+	if cms == nil {
+		return nil, errorFromErrorQueue()
+	}
+	runtime.KeepAlive(recipients)
+	return newCMS(cms), nil
+}
+
+// Decrypt decrypts CMS EnvelopedData or AuthEnvelopedData with the private
+// key of a recipient. cert selects the matching recipient; if it is nil,
+// every recipient is tried, which for RSA key transport cannot tell a wrong
+// key from corrupted content. For detached content the ciphertext must be
+// passed as content.
+func (c *CMS) Decrypt(key PrivateKey, cert *Certificate, content []byte,
+	flags CMSFlags) ([]byte, error) {
+	if key == nil {
+		return nil, errors.New("no decryption key")
+	}
+	var x *C.X509
+	if cert != nil {
+		x = cert.x
+	}
+	var dcont *C.BIO
+	if content != nil {
+		dcont = cmsContentBIO(content)
+		if dcont == nil {
+			return nil, errors.New("failed creating bio")
+		}
+		defer C.BIO_free(dcont)
+	}
+	out := C.BIO_new(C.BIO_s_mem())
+	if out == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(out)
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.CMS_decrypt(c.cms, key.evpPKey(), x, dcont, out,
+		C.uint(flags|C.CMS_BINARY)) != 1 {
+		return nil, errorFromErrorQueue()
+	}
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(cert)
+	return ioutil.ReadAll(asAnyBio(out))
+}
+
+// RecipientCount returns the number of recipients of enveloped data.
+func (c *CMS) RecipientCount() int {
+	infos := C.CMS_get0_RecipientInfos(c.cms)
+	if infos == nil {
+		return 0
+	}
+	return int(C.X_sk_CMS_RecipientInfo_num(infos))
+}
+
+// MarshalDER converts the CMS structure to a DER-encoded block.
+func (c *CMS) MarshalDER() (der_block []byte, err error) {
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+	if int(C.i2d_CMS_bio(bio, c.cms)) != 1 {
+		return nil, errors.New("failed dumping cms der")
+	}
+	return ioutil.ReadAll(asAnyBio(bio))
+}
+
+// MarshalPEM converts the CMS structure to a PEM-encoded block.
+func (c *CMS) MarshalPEM() (pem_block []byte, err error) {
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+	if int(C.PEM_write_bio_CMS(bio, c.cms)) != 1 {
+		return nil, errors.New("failed dumping cms")
+	}
+	return ioutil.ReadAll(asAnyBio(bio))
+}
+
+// MarshalSMIME converts the CMS structure to an S/MIME message. A detached
+// signature is written as multipart/signed with content as the first part;
+// content must be nil for anything else, which is written as
+// application/pkcs7-mime.
+func (c *CMS) MarshalSMIME(content []byte) (smime []byte, err error) {
+	flags := C.int(C.CMS_BINARY)
+	var data *C.BIO
+	if content != nil {
+		if C.CMS_is_detached(c.cms) != 1 {
+			return nil, errors.New("content given for attached cms")
+		}
+		// the signature is complete, so the content is only copied
+		// rather than signed again
+		flags |= C.CMS_DETACHED | C.CMS_REUSE_DIGEST
+		data = cmsContentBIO(content)
+		if data == nil {
+			return nil, errors.New("failed creating bio")
+		}
+		defer C.BIO_free(data)
+	}
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.SMIME_write_CMS(bio, c.cms, data, flags) != 1 {
+		return nil, errorFromErrorQueue()
+	}
+	return ioutil.ReadAll(asAnyBio(bio))
+}
+
+// LoadCMSFromSMIME loads a CMS structure from an S/MIME message. For a
+// multipart/signed message the signed content is returned as well, to be
+// passed to Verify; otherwise content is nil.
+func LoadCMSFromSMIME(smime []byte) (cms *CMS, content []byte, err error) {
+	if len(smime) == 0 {
+		return nil, nil, errors.New("empty smime message")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	bio := C.BIO_new_mem_buf(unsafe.Pointer(&smime[0]), C.int(len(smime)))
+	if bio == nil {
+		return nil, nil, errors.New("failed creating bio")
+	}
+	defer C.BIO_free(bio)
+	var bcont *C.BIO
+	ci := C.SMIME_read_CMS(bio, &bcont)
+	if ci == nil {
+		return nil, nil, errorFromErrorQueue()
+	}
+	cms = newCMS(ci)
+	if bcont != nil {
+		defer C.BIO_free(bcont)
+		content, err = ioutil.ReadAll(asAnyBio(bcont))
+		if err != nil {
+			return nil, nil, err
+		}
+		if content == nil {
+			content = []byte{}
+		}
+	}
+	return cms, content, nil
+}
+
+// LoadCMSFromDER loads a CMS structure, such as a PKCS#7 signature, from a
+// DER-encoded block.
+func LoadCMSFromDER(der_block []byte) (*CMS, error) {
+	if len(der_block) == 0 {
+		return nil, errors.New("empty der block")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	bio := C.BIO_new_mem_buf(unsafe.Pointer(&der_block[0]),
+		C.int(len(der_block)))
+	cms := C.d2i_CMS_bio(bio, nil)
+	C.BIO_free(bio)
+	if cms == nil {
+		return nil, errorFromErrorQueue()
+	}
+	return newCMS(cms), nil
+}
+
+// LoadCMSFromPEM loads a CMS structure from a PEM-encoded block.
+func LoadCMSFromPEM(pem_block []byte) (*CMS, error) {
+	if len(pem_block) == 0 {
+		return nil, errors.New("empty pem block")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	bio := C.BIO_new_mem_buf(unsafe.Pointer(&pem_block[0]),
+		C.int(len(pem_block)))
+	cms := C.PEM_read_bio_CMS(bio, nil, nil, nil)
+	C.BIO_free(bio)
+	if cms == nil {
+		return nil, errorFromErrorQueue()
+	}
+	return newCMS(cms), nil
+}
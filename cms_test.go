@@ -0,0 +1,374 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"testing"
+)
+
+func newTestCMSSigner(t *testing.T, ca *Certificate, cakey PrivateKey,
+	key PrivateKey, digest EVP_MD) CMSSigner {
+	req, err := NewCertificateRequest(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Sign(key, digest); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := IssueCertificate(req, ca, cakey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return CMSSigner{Certificate: cert, Key: key, Digest: digest}
+}
+
+func TestCMSSignVerify(t *testing.T) {
+	ca, cakey := newTestCA(t)
+	store, err := NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddCertificate(ca); err != nil {
+		t.Fatal(err)
+	}
+
+	eckey, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsakey, err := GenerateRSAKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := asn1.MarshalWithParams("1.2.3", "utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const versionOID = "1.3.6.1.4.1.55555.2.1"
+	ecSigner := newTestCMSSigner(t, ca, cakey, eckey, EVP_SHA256)
+	ecSigner.Attributes = []CMSAttribute{{OID: versionOID, Value: version}}
+	rsaSigner := newTestCMSSigner(t, ca, cakey, rsakey, EVP_SHA384)
+
+	firmware := []byte("firmware image\r\nwith\nmixed line endings\x00\xff")
+
+	// attached signature by two signers
+	signed, err := CMSSign(firmware, []CMSSigner{ecSigner, rsaSigner}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := signed.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCMSFromDER(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.SignerCount() != 2 {
+		t.Fatalf("expected 2 signers, got %d", loaded.SignerCount())
+	}
+	content, err := loaded.Verify(store, nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, firmware) {
+		t.Fatal("signed content changed")
+	}
+	signers, err := loaded.Signers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 2 ||
+		signers[0].GetSerialNumberHex() !=
+			ecSigner.Certificate.GetSerialNumberHex() ||
+		signers[1].GetSerialNumberHex() !=
+			rsaSigner.Certificate.GetSerialNumberHex() {
+		t.Fatal("unexpected signers")
+	}
+	attr, err := loaded.SignedAttribute(0, versionOID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(attr, version) {
+		t.Fatalf("unexpected signed attribute %x", attr)
+	}
+	if attr, err := loaded.SignedAttribute(1, versionOID); err != nil ||
+		attr != nil {
+		t.Fatal("unexpected signed attribute on the second signer")
+	}
+	// signing time
+	if attr, err := loaded.SignedAttribute(0,
+		"1.2.840.113549.1.9.5"); err != nil || attr == nil {
+		t.Fatal("missing signing time")
+	}
+	if _, err := loaded.SignedAttribute(2, versionOID); err == nil {
+		t.Fatal("expected an error for a missing signer")
+	}
+
+	// an untrusted signer is rejected unless chains are not verified
+	empty, err := NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.Verify(empty, nil, nil, 0); err == nil {
+		t.Fatal("expected an error for an untrusted signer")
+	}
+	if _, err := loaded.Verify(empty, nil, nil,
+		CMSNoSignerCertVerify); err != nil {
+		t.Fatal(err)
+	}
+
+	// detached signature without certificates
+	detached, err := CMSSign(firmware, []CMSSigner{ecSigner},
+		nil, CMSDetached|CMSNoCerts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBlock, err := detached.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = LoadCMSFromPEM(pemBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := []*Certificate{ecSigner.Certificate}
+	if _, err := loaded.Verify(store, nil, firmware, 0); err == nil {
+		t.Fatal("expected an error without the signer certificate")
+	}
+	if _, err := loaded.Verify(store, certs, firmware, 0); err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte(nil), firmware...)
+	tampered[0] ^= 1
+	if _, err := loaded.Verify(store, certs, tampered, 0); err == nil {
+		t.Fatal("expected an error for tampered content")
+	}
+}
+
+func TestCMSSignED25519(t *testing.T) {
+	if !ed25519_support {
+		t.Skip("ED25519 not supported on this version of OpenSSL")
+	}
+	ca, cakey := newTestCA(t)
+	key, err := GenerateED25519Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := newTestCMSSigner(t, ca, cakey, key, EVP_NULL)
+	signed, err := CMSSign([]byte("manifest"), []CMSSigner{signer}, nil, 0)
+	if err != nil {
+		t.Skipf("EdDSA CMS signatures not supported by this OpenSSL: %v", err)
+	}
+	store, err := NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddCertificate(ca); err != nil {
+		t.Fatal(err)
+	}
+	content, err := signed.Verify(store, nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "manifest" {
+		t.Fatal("signed content changed")
+	}
+}
+
+func TestCMSEncryptDecrypt(t *testing.T) {
+	ca, cakey := newTestCA(t)
+	rsakey, err := GenerateRSAKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eckey, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaDevice := newTestCMSSigner(t, ca, cakey, rsakey, EVP_SHA256)
+	ecDevice := newTestCMSSigner(t, ca, cakey, eckey, EVP_SHA256)
+	recipients := []*Certificate{rsaDevice.Certificate, ecDevice.Certificate}
+	bundle := []byte("config bundle\x00\x01\x02")
+
+	cbc, err := GetCipherByName("aes-128-cbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cipher := range []*Cipher{nil, cbc} {
+		enveloped, err := CMSEncrypt(bundle, recipients, cipher, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := enveloped.MarshalDER()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(der, bundle) {
+			t.Fatal("content not encrypted")
+		}
+		loaded, err := LoadCMSFromDER(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := loaded.RecipientCount(); n != 2 {
+			t.Fatalf("expected 2 recipients, got %d", n)
+		}
+		for _, device := range []CMSSigner{rsaDevice, ecDevice} {
+			plain, err := loaded.Decrypt(device.Key, device.Certificate, nil, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(plain, bundle) {
+				t.Fatal("decrypted content changed")
+			}
+		}
+		plain, err := loaded.Decrypt(ecDevice.Key, nil, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plain, bundle) {
+			t.Fatal("decrypted content changed")
+		}
+	}
+
+	other, err := GenerateRSAKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outsider := newTestCMSSigner(t, ca, cakey, other, EVP_SHA256)
+	enveloped, err := CMSEncrypt(bundle, recipients[:1], nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enveloped.Decrypt(outsider.Key, outsider.Certificate, nil,
+		0); err == nil {
+		t.Fatal("decrypted for a certificate that is not a recipient")
+	}
+	if _, err := enveloped.Decrypt(outsider.Key, rsaDevice.Certificate, nil,
+		0); err == nil {
+		t.Fatal("decrypted with the wrong key")
+	}
+	if _, err := CMSEncrypt(bundle, nil, nil, 0); err == nil {
+		t.Fatal("encrypted without recipients")
+	}
+}
+
+func TestCMSSMIME(t *testing.T) {
+	ca, cakey := newTestCA(t)
+	store, err := NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddCertificate(ca); err != nil {
+		t.Fatal(err)
+	}
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := newTestCMSSigner(t, ca, cakey, key, EVP_SHA256)
+	message := []byte("Content-Type: text/plain\r\n\r\nupdate available\r\n")
+
+	// detached signature as multipart/signed
+	signed, err := CMSSign(message, []CMSSigner{signer}, nil, CMSDetached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smime, err := signed.MarshalSMIME(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(smime, []byte("multipart/signed")) {
+		t.Fatalf("expected multipart/signed message:\n%s", smime)
+	}
+	loaded, content, err := LoadCMSFromSMIME(smime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, message) {
+		t.Fatalf("signed content changed: %q", content)
+	}
+	if _, err := loaded.Verify(store, nil, content, 0); err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(smime, []byte("update available"),
+		[]byte("update availablE"), 1)
+	loaded, content, err = LoadCMSFromSMIME(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.Verify(store, nil, content, 0); err == nil {
+		t.Fatal("verified tampered message")
+	}
+
+	// attached signature as application/pkcs7-mime
+	signed, err = CMSSign(message, []CMSSigner{signer}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signed.MarshalSMIME(message); err == nil {
+		t.Fatal("wrote attached signature with separate content")
+	}
+	smime, err = signed.MarshalSMIME(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(smime, []byte("application/pkcs7-mime")) {
+		t.Fatalf("expected application/pkcs7-mime message:\n%s", smime)
+	}
+	loaded, content, err = LoadCMSFromSMIME(smime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != nil {
+		t.Fatal("unexpected detached content")
+	}
+	verified, err := loaded.Verify(store, nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(verified, message) {
+		t.Fatal("signed content changed")
+	}
+
+	// enveloped data
+	enveloped, err := CMSEncrypt(message, []*Certificate{signer.Certificate},
+		nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smime, err = enveloped.MarshalSMIME(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, _, err = LoadCMSFromSMIME(smime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := loaded.Decrypt(signer.Key, signer.Certificate, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, message) {
+		t.Fatal("decrypted content changed")
+	}
+
+	if _, _, err := LoadCMSFromSMIME([]byte("not a mime message")); err == nil {
+		t.Fatal("loaded invalid message")
+	}
+}
@@ -18,12 +18,14 @@ package openssl
 import "C"
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"runtime"
 	"sync"
+	"syscall"
 	"time"
 	"unsafe"
 
@@ -37,8 +39,19 @@ var (
 	errWantRead   = errors.New("want read")
 	errWantWrite  = errors.New("want write")
 	errTryAgain   = errors.New("try again")
+
+	errAsyncUnsupported = errors.New("async mode is not supported")
 )
 
+// closeNotifyTimeout bounds how long Conn.Close waits to send close_notify.
+const closeNotifyTimeout = time.Second
+
+// Conn is a TLS connection. One goroutine may Read while another Writes;
+// concurrent Reads, or concurrent Writes, are safe but interleave arbitrarily.
+// The TLS state itself is guarded by a mutex held only while OpenSSL encrypts
+// or decrypts, never while waiting on the underlying connection, so neither
+// direction blocks the other. Close may be called from any goroutine and
+// unblocks pending Reads and Writes.
 type Conn struct {
 	*SSL
 
@@ -49,6 +62,28 @@ type Conn struct {
 	is_shutdown      bool
 	mtx              sync.Mutex
 	want_read_future *utils.Future
+
+	// guarded by mtx
+	msg_state          *C.x_ssl_msg_state
+	trace_writer       io.Writer
+	bytes_read         uint64
+	bytes_written      uint64
+	handshake_start    time.Time
+	handshake_duration time.Duration
+	metrics            Metrics
+	handshake_failed   bool
+
+	// guarded by mtx
+	tracer              Tracer
+	trace_ctx           context.Context
+	handshake_span      Span
+	handshake_span_done bool
+
+	// set for connections of listeners limiting handshakes
+	handshake_limit *handshakeLimiter
+	limit_wait      *handshakeWait
+	limit_once      sync.Once
+	limit_err       error
 }
 
 type VerifyResult int
@@ -98,6 +133,12 @@ const (
 	InvalidPolicyExtension        VerifyResult = C.X509_V_ERR_INVALID_POLICY_EXTENSION
 	NoExplicitPolicy              VerifyResult = C.X509_V_ERR_NO_EXPLICIT_POLICY
 	UnnestedResource              VerifyResult = C.X509_V_ERR_UNNESTED_RESOURCE
+	PermittedViolation            VerifyResult = C.X509_V_ERR_PERMITTED_VIOLATION
+	ExcludedViolation             VerifyResult = C.X509_V_ERR_EXCLUDED_VIOLATION
+	SubtreeMinmax                 VerifyResult = C.X509_V_ERR_SUBTREE_MINMAX
+	UnsupportedConstraintType     VerifyResult = C.X509_V_ERR_UNSUPPORTED_CONSTRAINT_TYPE
+	UnsupportedConstraintSyntax   VerifyResult = C.X509_V_ERR_UNSUPPORTED_CONSTRAINT_SYNTAX
+	UnsupportedNameSyntax         VerifyResult = C.X509_V_ERR_UNSUPPORTED_NAME_SYNTAX
 	ApplicationVerification       VerifyResult = C.X509_V_ERR_APPLICATION_VERIFICATION
 )
 
@@ -111,6 +152,18 @@ func newSSL(ctx *C.SSL_CTX) (*C.SSL, error) {
 	return ssl, nil
 }
 
+// isUnixPacketConn reports whether conn is a SOCK_SEQPACKET unix socket. It
+// only looks at *net.UnixConn, whose address methods do no I/O, unlike
+// those of wrappers such as proxyConn.
+func isUnixPacketConn(conn net.Conn) bool {
+	unix_conn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return false
+	}
+	addr, ok := unix_conn.LocalAddr().(*net.UnixAddr)
+	return ok && addr.Net == "unixpacket"
+}
+
 func newConn(conn net.Conn, ctx *Ctx) (*Conn, error) {
 	ssl, err := newSSL(ctx.ctx)
 	if err != nil {
@@ -124,6 +177,12 @@ func newConn(conn net.Conn, ctx *Ctx) (*Conn, error) {
 		into_ssl.release_buffers = true
 		from_ssl.release_buffers = true
 	}
+	into_ssl.record_size = ctx.RecordSize()
+	if isUnixPacketConn(conn) {
+		// every packet has to fit the buffer the peer reads it into
+		from_ssl.max_write = SSLRecordSize
+		into_ssl.record_size = SSLRecordSize
+	}
 
 	into_ssl_cbio := into_ssl.MakeCBIO()
 	from_ssl_cbio := from_ssl.MakeCBIO()
@@ -135,8 +194,33 @@ func newConn(conn net.Conn, ctx *Ctx) (*Conn, error) {
 		return nil, errors.New("failed to allocate memory BIO")
 	}
 
+	// records are gathered in C and handed to from_ssl in batches, unless
+	// memory is short
+	wbio := from_ssl_cbio
+	if ctx.low_memory {
+		from_ssl.small_segments = true
+	} else {
+		wbio = C.X_BIO_push_coalesce(from_ssl_cbio)
+	}
+	if wbio == nil {
+		C.BIO_free(into_ssl_cbio)
+		C.BIO_free(from_ssl_cbio)
+		C.SSL_free(ssl)
+		return nil, errors.New("failed to allocate coalescing BIO")
+	}
+	// lets OpenSSL take in several records per read BIO callback
+	C.SSL_set_read_ahead(ssl, 1)
+
 	// the ssl object takes ownership of these objects now
-	C.SSL_set_bio(ssl, into_ssl_cbio, from_ssl_cbio)
+	C.SSL_set_bio(ssl, into_ssl_cbio, wbio)
+
+	msg_state := (*C.x_ssl_msg_state)(C.calloc(1,
+		C.size_t(unsafe.Sizeof(C.x_ssl_msg_state{}))))
+	if msg_state == nil {
+		C.SSL_free(ssl)
+		return nil, errors.New("failed to allocate connection stats")
+	}
+	C.X_SSL_set_stats(ssl, msg_state)
 
 	s := &SSL{ssl: ssl}
 	C.SSL_set_ex_data(s.ssl, get_ssl_idx(), pointer.Save(s))
@@ -144,15 +228,25 @@ func newConn(conn net.Conn, ctx *Ctx) (*Conn, error) {
 	c := &Conn{
 		SSL: s,
 
-		conn:     conn,
-		ctx:      ctx,
-		into_ssl: into_ssl,
-		from_ssl: from_ssl}
+		conn:      conn,
+		ctx:       ctx,
+		into_ssl:  into_ssl,
+		from_ssl:  from_ssl,
+		msg_state: msg_state,
+		metrics:   ctx.metrics,
+		tracer:    ctx.tracer}
 	runtime.SetFinalizer(c, func(c *Conn) {
 		c.into_ssl.Disconnect(into_ssl_cbio)
 		c.from_ssl.Disconnect(from_ssl_cbio)
 		C.SSL_free(c.ssl)
+		if c.msg_state.trace != nil {
+			C.BIO_free(c.msg_state.trace)
+		}
+		C.free(unsafe.Pointer(c.msg_state))
 	})
+	if c.metrics != nil {
+		c.metrics.ConnOpened()
+	}
 	return c, nil
 }
 
@@ -225,7 +319,7 @@ func (c *Conn) getErrorHandler(rv C.int, errno error) func() error {
 	case C.SSL_ERROR_ZERO_RETURN:
 		return func() error {
 			c.Close()
-			return io.ErrUnexpectedEOF
+			return io.EOF
 		}
 	case C.SSL_ERROR_WANT_READ:
 		go c.flushOutputBuffer()
@@ -259,27 +353,50 @@ func (c *Conn) getErrorHandler(rv C.int, errno error) func() error {
 			}
 			return errTryAgain
 		}
+	case C.SSL_ERROR_WANT_ASYNC, C.SSL_ERROR_WANT_ASYNC_JOB:
+		// only reachable if SSL_MODE_ASYNC was set behind our back
+		return func() error { return errAsyncUnsupported }
 	case C.SSL_ERROR_SYSCALL:
-		var err error
-		if C.ERR_peek_error() == 0 {
+		err := c.drainErrorQueue(errcode)
+		if len(err.Queue) == 0 {
 			switch rv {
 			case 0:
-				err = errors.New("protocol-violating EOF")
+				err.Err = io.ErrUnexpectedEOF
 			case -1:
-				err = errno
-			default:
-				err = errorFromErrorQueue()
+				err.Err = errno
 			}
-		} else {
-			err = errorFromErrorQueue()
 		}
 		return func() error { return err }
 	default:
-		err := errorFromErrorQueue()
+		err := c.drainErrorQueue(errcode)
 		return func() error { return err }
 	}
 }
 
+// drainErrorQueue is like the package-level drainErrorQueue, additionally
+// recording the class of the failed operation, the verification result and
+// the last alerts.
+func (c *Conn) drainErrorQueue(errcode C.int) *Error {
+	err := drainErrorQueue()
+	err.Class = ErrorClass(errcode)
+	err.VerifyResult = VerifyResult(C.SSL_get_verify_result(c.ssl))
+	err.Handshake = C.SSL_is_init_finished(c.ssl) == 0
+	err.AlertSent, err.AlertReceived = c.lastAlerts()
+	// closing a connection that never started its handshake fails too
+	if err.Handshake && !c.handshake_start.IsZero() {
+		c.endHandshakeSpan(err)
+		if c.metrics != nil && !c.handshake_failed {
+			c.handshake_failed = true
+			alert := err.AlertSent
+			if alert == nil {
+				alert = err.AlertReceived
+			}
+			c.metrics.HandshakeFailed(alert)
+		}
+	}
+	return err
+}
+
 func (c *Conn) handleError(errcb func() error) error {
 	if errcb != nil {
 		return errcb()
@@ -291,28 +408,61 @@ func (c *Conn) handshake() func() error {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 	if c.is_shutdown {
-		return func() error { return io.ErrUnexpectedEOF }
+		return func() error { return net.ErrClosed }
 	}
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
+	c.startHandshakeTimer()
 	rv, errno := C.SSL_do_handshake(c.ssl)
+	c.stopHandshakeTimer()
+	c.flushDebugTrace()
 	if rv > 0 {
 		return nil
 	}
+	if err := c.verify_peer_err; err != nil {
+		C.ERR_clear_error()
+		return func() error { return err }
+	}
 	return c.getErrorHandler(rv, errno)
 }
 
 // Handshake performs an SSL handshake. If a handshake is not manually
 // triggered, it will run before the first I/O on the encrypted stream.
+// Handshakes only lock their own connection, so handshakes on different
+// connections run concurrently.
 func (c *Conn) Handshake() error {
+	if c.handshake_limit != nil {
+		return c.limitedHandshake()
+	}
+	return c.doHandshake()
+}
+
+func (c *Conn) doHandshake() error {
 	err := errTryAgain
 	for err == errTryAgain {
 		err = c.handleError(c.handshake())
 	}
+	if err != nil {
+		c.mtx.Lock()
+		c.endHandshakeSpan(err)
+		c.mtx.Unlock()
+	}
 	go c.flushOutputBuffer()
 	return err
 }
 
+// HandshakeContext is like Handshake but aborts the handshake, returning
+// ctx.Err(), once ctx is done.
+func (c *Conn) HandshakeContext(ctx context.Context) error {
+	c.setTraceContext(ctx)
+	stop := watchContext(ctx, c.conn)
+	err := c.Handshake()
+	if stop() {
+		return ctx.Err()
+	}
+	return err
+}
+
 // PeerCertificate returns the Certificate of the peer with which you're
 // communicating. Only valid after a handshake.
 func (c *Conn) PeerCertificate() (*Certificate, error) {
@@ -371,21 +521,43 @@ type ConnectionState struct {
 	CertificateChain      []*Certificate
 	CertificateChainError error
 	SessionReused         bool
+	NegotiatedProtocol    string
 }
 
 func (c *Conn) ConnectionState() (rv ConnectionState) {
 	rv.Certificate, rv.CertificateError = c.PeerCertificate()
 	rv.CertificateChain, rv.CertificateChainError = c.PeerCertificateChain()
 	rv.SessionReused = c.SessionReused()
+	rv.NegotiatedProtocol = c.NegotiatedProtocol()
 	return
 }
 
+// NegotiatedProtocol returns the application protocol selected through ALPN,
+// such as "h2", or "" if none was. See Ctx.SetNextProtos.
+func (c *Conn) NegotiatedProtocol() string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.negotiatedProtocol()
+}
+
+// negotiatedProtocol is NegotiatedProtocol with c.mtx held.
+func (c *Conn) negotiatedProtocol() string {
+	var data *C.uchar
+	var length C.uint
+	C.SSL_get0_alpn_selected(c.ssl, &data, &length)
+	if data == nil || length == 0 {
+		return ""
+	}
+	return string(C.GoBytes(unsafe.Pointer(data), C.int(length)))
+}
+
 func (c *Conn) shutdown() func() error {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 	rv, errno := C.SSL_shutdown(c.ssl)
+	c.flushDebugTrace()
 	if rv > 0 {
 		return nil
 	}
@@ -400,9 +572,13 @@ func (c *Conn) shutdown() func() error {
 		// without tickling them to close by sending a TCP_FIN packet, or
 		// shutting down the write-side of the connection.
 		return nil
-	} else {
-		return c.getErrorHandler(rv, errno)
 	}
+	if C.SSL_get_error(c.ssl, rv) == C.SSL_ERROR_WANT_READ {
+		// we don't wait for the peer's close_notify, and a pending Read
+		// may be blocked on the input, which would block Close with it
+		return nil
+	}
+	return c.getErrorHandler(rv, errno)
 }
 
 func (c *Conn) shutdownLoop() error {
@@ -418,14 +594,16 @@ func (c *Conn) shutdownLoop() error {
 			return errors.New("shutdown requested a third time?")
 		}
 	}
-	if err == io.ErrUnexpectedEOF {
+	if err == io.EOF {
 		err = nil
 	}
 	return err
 }
 
 // Close shuts down the SSL connection and closes the underlying wrapped
-// connection.
+// connection. It may be called while other goroutines Read or Write, which
+// then return an error. Sending close_notify waits at most a second for a peer
+// that does not read.
 func (c *Conn) Close() error {
 	c.mtx.Lock()
 	if c.is_shutdown {
@@ -433,13 +611,79 @@ func (c *Conn) Close() error {
 		return nil
 	}
 	c.is_shutdown = true
+	if c.metrics != nil {
+		c.metrics.ConnClosed()
+	}
+	c.endHandshakeSpan(net.ErrClosed)
+	var span Span
+	if c.tracer != nil {
+		trace_ctx := c.trace_ctx
+		if trace_ctx == nil {
+			trace_ctx = context.Background()
+		}
+		_, span = c.tracer.StartSpan(trace_ctx, SpanShutdown)
+	}
 	c.mtx.Unlock()
+	if c.limit_wait != nil {
+		// a handshake queued by a limit gives up
+		c.limit_wait.close()
+	}
+	// also unblocks a Write stuck on the peer, which holds up the flush
+	c.conn.SetWriteDeadline(time.Now().Add(closeNotifyTimeout))
 	var errs utils.ErrorGroup
-	errs.Add(c.shutdownLoop())
+	err := c.shutdownLoop()
+	if span != nil {
+		span.End(err)
+	}
+	errs.Add(err)
 	errs.Add(c.conn.Close())
 	return errs.Finalize()
 }
 
+// SetRecordSize changes the connection's record size, as Ctx.SetRecordSize
+// does for new connections.
+func (c *Conn) SetRecordSize(size int) error {
+	if size < MinRecordSize || size > SSLRecordSize {
+		return fmt.Errorf("record size must be between %d and %d",
+			MinRecordSize, SSLRecordSize)
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if C.X_SSL_set_max_send_fragment(c.ssl, C.long(size)) != 1 {
+		return errors.New("failed to set record size")
+	}
+	if c.from_ssl.max_write == 0 {
+		c.into_ssl.data_mtx.Lock()
+		c.into_ssl.record_size = size
+		c.into_ssl.data_mtx.Unlock()
+	}
+	return nil
+}
+
+// SetReleaseBuffers sets or clears ReleaseBuffers on the connection. See
+// Ctx.SetReleaseBuffers. Buffers that are empty are freed right away.
+func (c *Conn) SetReleaseBuffers(release bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if release {
+		C.X_SSL_set_mode(c.ssl, C.SSL_MODE_RELEASE_BUFFERS)
+	} else {
+		C.X_SSL_clear_mode(c.ssl, C.SSL_MODE_RELEASE_BUFFERS)
+	}
+	c.into_ssl.SetReleaseBuffers(release)
+	c.from_ssl.SetReleaseBuffers(release)
+	if release {
+		C.X_SSL_release_write_buffer(c.ssl)
+	}
+}
+
+func (c *Conn) releaseBuffers() C.int {
+	if c.from_ssl.release_buffers {
+		return 1
+	}
+	return 0
+}
+
 func (c *Conn) read(b []byte) (int, func() error) {
 	if len(b) == 0 {
 		return 0, nil
@@ -451,8 +695,16 @@ func (c *Conn) read(b []byte) (int, func() error) {
 	}
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
-	rv, errno := C.SSL_read(c.ssl, unsafe.Pointer(&b[0]), C.int(len(b)))
+	c.startHandshakeTimer()
+	rv, errno := C.X_SSL_read_batch(c.ssl, unsafe.Pointer(&b[0]),
+		C.int(len(b)), c.releaseBuffers())
+	c.stopHandshakeTimer()
+	c.flushDebugTrace()
 	if rv > 0 {
+		c.bytes_read += uint64(rv)
+		if c.metrics != nil {
+			c.metrics.BytesRead(int(rv))
+		}
 		return int(rv), nil
 	}
 	return 0, c.getErrorHandler(rv, errno)
@@ -460,22 +712,30 @@ func (c *Conn) read(b []byte) (int, func() error) {
 
 // Read reads up to len(b) bytes into b. It returns the number of bytes read
 // and an error if applicable. io.EOF is returned when the caller can expect
-// to see no more data.
+// to see no more data, i.e. after the peer's close_notify or Close. A stream
+// that ends without close_notify fails with an error matching
+// io.ErrUnexpectedEOF.
 func (c *Conn) Read(b []byte) (n int, err error) {
 	if len(b) == 0 {
 		return 0, nil
 	}
+	if c.handshake_limit != nil {
+		if err := c.limitedHandshake(); err != nil {
+			return 0, err
+		}
+	}
 	err = errTryAgain
 	for err == errTryAgain {
 		n, errcb := c.read(b)
 		err = c.handleError(errcb)
 		if err == nil {
-			go c.flushOutputBuffer()
+			// reads only produce output for post-handshake messages, so
+			// skip the goroutine in the common case
+			if c.from_ssl.Buffered() > 0 {
+				go c.flushOutputBuffer()
+			}
 			return n, nil
 		}
-		if err == io.ErrUnexpectedEOF {
-			err = io.EOF
-		}
 	}
 	return 0, err
 }
@@ -487,25 +747,37 @@ func (c *Conn) write(b []byte) (int, func() error) {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 	if c.is_shutdown {
-		err := errors.New("connection closed")
-		return 0, func() error { return err }
+		return 0, func() error { return net.ErrClosed }
 	}
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
-	rv, errno := C.SSL_write(c.ssl, unsafe.Pointer(&b[0]), C.int(len(b)))
+	c.startHandshakeTimer()
+	rv, errno := C.X_SSL_write_batch(c.ssl, unsafe.Pointer(&b[0]),
+		C.int(len(b)), c.releaseBuffers())
+	c.stopHandshakeTimer()
+	c.flushDebugTrace()
 	if rv > 0 {
+		c.bytes_written += uint64(rv)
+		if c.metrics != nil {
+			c.metrics.BytesWritten(int(rv))
+		}
 		return int(rv), nil
 	}
 	return 0, c.getErrorHandler(rv, errno)
 }
 
 // Write will encrypt the contents of b and write it to the underlying stream.
-// Performance will be vastly improved if the size of b is a multiple of
-// SSLRecordSize.
+// Performance will be vastly improved if the size of b is a multiple of the
+// record size, SSLRecordSize unless changed with SetRecordSize.
 func (c *Conn) Write(b []byte) (written int, err error) {
 	if len(b) == 0 {
 		return 0, nil
 	}
+	if c.handshake_limit != nil {
+		if err := c.limitedHandshake(); err != nil {
+			return 0, err
+		}
+	}
 	err = errTryAgain
 	for err == errTryAgain {
 		n, errcb := c.write(b)
@@ -517,6 +789,68 @@ func (c *Conn) Write(b []byte) (written int, err error) {
 	return 0, err
 }
 
+// copyBufferSize is the size of the buffers ReadFrom and WriteTo copy
+// through, enough for several full size records per call into OpenSSL.
+const copyBufferSize = 8 * SSLRecordSize
+
+var copyBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	},
+}
+
+// ReadFrom writes the data read from r to the connection until r returns
+// io.EOF, implementing io.ReaderFrom so that io.Copy to the connection goes
+// through large pooled buffers, which are encrypted in batches of records.
+func (c *Conn) ReadFrom(r io.Reader) (n int64, err error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	for {
+		nr, er := r.Read(*buf)
+		if nr > 0 {
+			nw, ew := c.Write((*buf)[:nr])
+			n += int64(nw)
+			if ew != nil {
+				return n, ew
+			}
+		}
+		if er == io.EOF {
+			return n, nil
+		}
+		if er != nil {
+			return n, er
+		}
+	}
+}
+
+// WriteTo writes the data read from the connection to w until the peer
+// closes it, implementing io.WriterTo like ReadFrom implements io.ReaderFrom.
+// A stream ending without close_notify fails as with Read.
+func (c *Conn) WriteTo(w io.Writer) (n int64, err error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	for {
+		nr, er := c.Read(*buf)
+		if nr > 0 {
+			nw, ew := w.Write((*buf)[:nr])
+			n += int64(nw)
+			if ew != nil {
+				return n, ew
+			}
+			if nw != nr {
+				return n, io.ErrShortWrite
+			}
+		}
+		if er == io.EOF {
+			return n, nil
+		}
+		if er != nil {
+			return n, er
+		}
+	}
+}
+
 // VerifyHostname pulls the PeerCertificate and calls VerifyHostname on the
 // certificate.
 func (c *Conn) VerifyHostname(host string) error {
@@ -539,16 +873,26 @@ func (c *Conn) RemoteAddr() net.Addr {
 
 // SetDeadline calls SetDeadline on the underlying connection.
 func (c *Conn) SetDeadline(t time.Time) error {
+	if c.limit_wait != nil {
+		c.limit_wait.setReadDeadline(t)
+		c.limit_wait.setWriteDeadline(t)
+	}
 	return c.conn.SetDeadline(t)
 }
 
 // SetReadDeadline calls SetReadDeadline on the underlying connection.
 func (c *Conn) SetReadDeadline(t time.Time) error {
+	if c.limit_wait != nil {
+		c.limit_wait.setReadDeadline(t)
+	}
 	return c.conn.SetReadDeadline(t)
 }
 
 // SetWriteDeadline calls SetWriteDeadline on the underlying connection.
 func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if c.limit_wait != nil {
+		c.limit_wait.setWriteDeadline(t)
+	}
 	return c.conn.SetWriteDeadline(t)
 }
 
@@ -556,6 +900,20 @@ func (c *Conn) UnderlyingConn() net.Conn {
 	return c.conn
 }
 
+// SyscallConn returns a raw network connection to the underlying connection,
+// to set socket options or query the socket otherwise, and implements
+// syscall.Conn. Reading or writing through it bypasses TLS and breaks the
+// connection, and readiness of the socket does not account for data already
+// buffered by the connection.
+func (c *Conn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.conn.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("openssl: %T does not implement syscall.Conn",
+			c.conn)
+	}
+	return sc.SyscallConn()
+}
+
 func (c *Conn) SetTlsExtHostName(name string) error {
 	cname := C.CString(name)
 	defer C.free(unsafe.Pointer(cname))
@@ -585,7 +943,11 @@ func (c *Conn) GetSession() ([]byte, error) {
 		return nil, errors.New("failed to get session")
 	}
 	defer C.SSL_SESSION_free(session)
+	return marshalSession(session)
+}
 
+// marshalSession returns the DER encoding of session.
+func marshalSession(session *C.SSL_SESSION) ([]byte, error) {
 	// get the size of the encoding
 	slen := C.i2d_SSL_SESSION(session, nil)
 
@@ -607,7 +969,11 @@ func (c *Conn) setSession(session []byte) error {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
-	ptr := (*C.uchar)(&session[0])
+	// d2i advances the pointer it is given, which cgo only allows for a
+	// pointer into C memory
+	buf := C.CBytes(session)
+	defer C.free(buf)
+	ptr := (*C.uchar)(buf)
 	s := C.d2i_SSL_SESSION(nil, &ptr, C.long(len(session)))
 	if s == nil {
 		return fmt.Errorf("unable to load session: %s", errorFromErrorQueue())
@@ -0,0 +1,83 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// CPUCapabilities describes the CPU capability vector libcrypto selects its
+// assembly code paths from.
+type CPUCapabilities struct {
+	// Variable is the environment variable the vector is overridden with on
+	// this architecture, such as OPENSSL_ia32cap or OPENSSL_armcap.
+	Variable string
+	// Value is the vector in effect, formatted the way Variable accepts it.
+	Value string
+	// Override is the value of Variable libcrypto was initialized with, if
+	// any.
+	Override string
+}
+
+// GetCPUCapabilities returns the CPU capabilities libcrypto detected at
+// initialization, with any override applied. Requires OpenSSL 3.0 or later.
+func GetCPUCapabilities() (*CPUCapabilities, error) {
+	settings := C.X_OPENSSL_cpu_settings()
+	if settings == nil {
+		return nil, errors.New("CPU capabilities require OpenSSL 3.0")
+	}
+	// e.g. "OPENSSL_ia32cap=0xfffa32034f8bffff:0x0 env:~0x200000000000000"
+	s := C.GoString(settings)
+	eq := strings.IndexByte(s, '=')
+	if eq < 0 {
+		return nil, errors.New("no CPU capability vector on this platform")
+	}
+	caps := &CPUCapabilities{Variable: s[:eq], Value: s[eq+1:]}
+	if i := strings.Index(caps.Value, " env:"); i >= 0 {
+		caps.Override = caps.Value[i+len(" env:"):]
+		caps.Value = caps.Value[:i]
+	}
+	return caps, nil
+}
+
+// CPUCapabilitiesEnv returns the "VARIABLE=value" environment entry that
+// overrides the CPU capability vector with value, for instance
+// "~0x200000000000000" to mask AES-NI on x86. libcrypto reads the vector
+// once while it is loaded, before any Go code runs, so an override only
+// takes effect in a process started with the entry in its environment, such
+// as an exec.Cmd re-running the current binary.
+func CPUCapabilitiesEnv(value string) (string, error) {
+	caps, err := GetCPUCapabilities()
+	if err != nil {
+		return "", err
+	}
+	return caps.Variable + "=" + value, nil
+}
+
+// CPUCapabilitiesOverridden reports whether the current process was started
+// with a CPU capability override.
+func CPUCapabilitiesOverridden() bool {
+	caps, err := GetCPUCapabilities()
+	if err != nil {
+		return false
+	}
+	_, ok := os.LookupEnv(caps.Variable)
+	return ok
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCPUCapabilities(t *testing.T) {
+	if os.Getenv("GO_OPENSSL_CPUCAP_CHILD") != "" {
+		caps, err := GetCPUCapabilities()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Printf("caps=%s override=%s overridden=%v\n", caps.Value,
+			caps.Override, CPUCapabilitiesOverridden())
+		return
+	}
+	caps, err := GetCPUCapabilities()
+	if err != nil {
+		t.Skip(err)
+	}
+	if caps.Variable == "" || caps.Value == "" {
+		t.Fatalf("incomplete capabilities %+v", caps)
+	}
+	if runtime.GOARCH != "amd64" || caps.Override != "" {
+		return
+	}
+	if caps.Variable != "OPENSSL_ia32cap" {
+		t.Fatalf("unexpected variable %q", caps.Variable)
+	}
+
+	// mask AES-NI in a child process
+	env, err := CPUCapabilitiesEnv("~0x200000000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCPUCapabilities$")
+	cmd.Env = append(os.Environ(), env, "GO_OPENSSL_CPUCAP_CHILD=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if !strings.Contains(string(out),
+		"override=~0x200000000000000 overridden=true") {
+		t.Fatalf("override not applied: %s", out)
+	}
+	if strings.Contains(string(out), "caps="+caps.Value+" ") {
+		t.Fatalf("capabilities unchanged: %s", out)
+	}
+}
@@ -0,0 +1,417 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"runtime"
+	"time"
+	"unsafe"
+)
+
+// RevocationReason is the RFC 5280 CRLReason recorded for a revoked
+// certificate.
+type RevocationReason int
+
+const (
+	ReasonUnspecified          RevocationReason = 0
+	ReasonKeyCompromise        RevocationReason = 1
+	ReasonCACompromise         RevocationReason = 2
+	ReasonAffiliationChanged   RevocationReason = 3
+	ReasonSuperseded           RevocationReason = 4
+	ReasonCessationOfOperation RevocationReason = 5
+	ReasonCertificateHold      RevocationReason = 6
+	ReasonRemoveFromCRL        RevocationReason = 8
+	ReasonPrivilegeWithdrawn   RevocationReason = 9
+	ReasonAACompromise         RevocationReason = 10
+)
+
+// CRL is an X509 certificate revocation list.
+type CRL struct {
+	crl *C.X509_CRL
+	// Issuer is the CA certificate the list is issued by. It is used when
+	// adding extensions such as the authority key identifier.
+	Issuer *Certificate
+}
+
+func newCRL(crl *C.X509_CRL) *CRL {
+	c := &CRL{crl: crl}
+	runtime.SetFinalizer(c, func(c *CRL) {
+		C.X509_CRL_free(c.crl)
+	})
+	return c
+}
+
+// asn1Integer converts i to a newly allocated ASN1_INTEGER, which the caller
+// must free.
+func asn1Integer(i *big.Int) (*C.ASN1_INTEGER, error) {
+	b := i.Bytes()
+	if len(b) == 0 {
+		// BN_bin2bn treats a zero length input as zero
+		b = []byte{0}
+	}
+	bn := C.BN_bin2bn((*C.uchar)(unsafe.Pointer(&b[0])), C.int(len(b)), nil)
+	if bn == nil {
+		return nil, errors.New("failed to convert integer")
+	}
+	defer C.BN_free(bn)
+	ai := C.BN_to_ASN1_INTEGER(bn, nil)
+	if ai == nil {
+		return nil, errors.New("failed to convert integer")
+	}
+	return ai, nil
+}
+
+// bigInt converts an ASN1_INTEGER to a big.Int.
+func bigInt(ai *C.ASN1_INTEGER) (*big.Int, error) {
+	bn := C.ASN1_INTEGER_to_BN(ai, nil)
+	if bn == nil {
+		return nil, errors.New("failed to convert integer")
+	}
+	defer C.BN_free(bn)
+	b := make([]byte, (C.BN_num_bits(bn)+7)/8)
+	if len(b) > 0 {
+		C.BN_bn2bin(bn, (*C.uchar)(unsafe.Pointer(&b[0])))
+	}
+	i := new(big.Int).SetBytes(b)
+	if C.BN_is_negative(bn) != 0 {
+		i.Neg(i)
+	}
+	return i, nil
+}
+
+// goTime converts an ASN1_TIME to a UTC time.Time.
+func goTime(tm *C.ASN1_TIME) (time.Time, error) {
+	var t C.struct_tm
+	if tm == nil || C.ASN1_TIME_to_tm(tm, &t) != 1 {
+		return time.Time{}, errors.New("invalid time")
+	}
+	return time.Date(int(t.tm_year)+1900, time.Month(t.tm_mon+1),
+		int(t.tm_mday), int(t.tm_hour), int(t.tm_min), int(t.tm_sec), 0,
+		time.UTC), nil
+}
+
+// NewCRL creates an empty version 2 revocation list issued by issuer, with
+// its last update time set to now.
+func NewCRL(issuer *Certificate) (*CRL, error) {
+	crl := C.X509_CRL_new()
+	if crl == nil {
+		return nil, errors.New("failed to allocate crl")
+	}
+	c := newCRL(crl)
+	c.Issuer = issuer
+	if C.X509_CRL_set_version(c.crl, 1) != 1 {
+		return nil, errors.New("failed to set crl version")
+	}
+	if C.X509_CRL_set_issuer_name(c.crl,
+		C.X509_get_subject_name(issuer.x)) != 1 {
+		return nil, errors.New("failed to set crl issuer name")
+	}
+	if err := c.SetLastUpdate(time.Now()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetLastUpdate sets the issue date of the revocation list.
+func (c *CRL) SetLastUpdate(t time.Time) error {
+	tm := C.ASN1_TIME_set(nil, C.time_t(t.Unix()))
+	if tm == nil {
+		return errors.New("failed to set last update")
+	}
+	defer C.ASN1_TIME_free(tm)
+	if C.X509_CRL_set1_lastUpdate(c.crl, tm) != 1 {
+		return errors.New("failed to set last update")
+	}
+	return nil
+}
+
+// SetNextUpdate sets the date by which the next revocation list will be
+// issued.
+func (c *CRL) SetNextUpdate(t time.Time) error {
+	tm := C.ASN1_TIME_set(nil, C.time_t(t.Unix()))
+	if tm == nil {
+		return errors.New("failed to set next update")
+	}
+	defer C.ASN1_TIME_free(tm)
+	if C.X509_CRL_set1_nextUpdate(c.crl, tm) != 1 {
+		return errors.New("failed to set next update")
+	}
+	return nil
+}
+
+// SetNumber sets the CRL number extension, which must increase with every
+// list issued by the same CA.
+func (c *CRL) SetNumber(number *big.Int) error {
+	ai, err := asn1Integer(number)
+	if err != nil {
+		return err
+	}
+	defer C.ASN1_INTEGER_free(ai)
+	if C.X509_CRL_add1_ext_i2d(c.crl, C.NID_crl_number, unsafe.Pointer(ai),
+		0, C.X509V3_ADD_REPLACE) != 1 {
+		return errors.New("failed to set crl number")
+	}
+	return nil
+}
+
+// AddRevoked adds the certificate with the given serial number to the list.
+// A reason other than ReasonUnspecified is recorded in a reason code entry
+// extension.
+func (c *CRL) AddRevoked(serial *big.Int, revoked time.Time,
+	reason RevocationReason) error {
+	rev := C.X509_REVOKED_new()
+	if rev == nil {
+		return errors.New("failed to allocate revoked entry")
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			C.X509_REVOKED_free(rev)
+		}
+	}()
+
+	ai, err := asn1Integer(serial)
+	if err != nil {
+		return err
+	}
+	defer C.ASN1_INTEGER_free(ai)
+	if C.X509_REVOKED_set_serialNumber(rev, ai) != 1 {
+		return errors.New("failed to set revoked serial")
+	}
+	tm := C.ASN1_TIME_set(nil, C.time_t(revoked.Unix()))
+	if tm == nil {
+		return errors.New("failed to set revocation date")
+	}
+	defer C.ASN1_TIME_free(tm)
+	if C.X509_REVOKED_set_revocationDate(rev, tm) != 1 {
+		return errors.New("failed to set revocation date")
+	}
+	if reason != ReasonUnspecified {
+		code := C.ASN1_ENUMERATED_new()
+		if code == nil {
+			return errors.New("failed to allocate reason code")
+		}
+		defer C.ASN1_ENUMERATED_free(code)
+		if C.ASN1_ENUMERATED_set(code, C.long(reason)) != 1 {
+			return errors.New("failed to set reason code")
+		}
+		if C.X509_REVOKED_add1_ext_i2d(rev, C.NID_crl_reason,
+			unsafe.Pointer(code), 0, 0) != 1 {
+			return errors.New("failed to add reason code")
+		}
+	}
+	if C.X509_CRL_add0_revoked(c.crl, rev) != 1 {
+		return errors.New("failed to add revoked entry")
+	}
+	ok = true
+	return nil
+}
+
+// AddExtension adds an X509v3 extension to the revocation list, e.g.
+// NID_authority_key_identifier with "keyid:always".
+func (c *CRL) AddExtension(nid NID, value string) error {
+	var ctx C.X509V3_CTX
+	var issuer *C.X509
+	if c.Issuer != nil {
+		issuer = c.Issuer.x
+	}
+	C.X509V3_set_ctx(&ctx, issuer, nil, nil, c.crl, 0)
+	cvalue := C.CString(value)
+	defer C.free(unsafe.Pointer(cvalue))
+	ex := C.X509V3_EXT_conf_nid(nil, &ctx, C.int(nid), cvalue)
+	if ex == nil {
+		return errors.New("failed to create x509v3 extension")
+	}
+	defer C.X509_EXTENSION_free(ex)
+	if C.X509_CRL_add_ext(c.crl, ex, -1) != 1 {
+		return errors.New("failed to add x509v3 extension")
+	}
+	return nil
+}
+
+// Sign sorts the revoked entries and signs the list with the issuer's
+// private key. Ed25519 keys require EVP_NULL as digest; otherwise one of
+// EVP_SHA256, EVP_SHA384 or EVP_SHA512 must be used.
+func (c *CRL) Sign(privKey PrivateKey, digest EVP_MD) error {
+	md, err := signingDigest(privKey, digest)
+	if err != nil {
+		return err
+	}
+	if C.X509_CRL_sort(c.crl) != 1 {
+		return errors.New("failed to sort crl")
+	}
+	if C.X509_CRL_sign(c.crl, privKey.evpPKey(), md) <= 0 {
+		return errors.New("failed to sign crl")
+	}
+	return nil
+}
+
+// MarshalPEM converts the revocation list to a PEM-encoded block.
+func (c *CRL) MarshalPEM() (pem_block []byte, err error) {
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+	if int(C.PEM_write_bio_X509_CRL(bio, c.crl)) != 1 {
+		return nil, errors.New("failed dumping crl")
+	}
+	return ioutil.ReadAll(asAnyBio(bio))
+}
+
+// MarshalDER converts the revocation list to a DER-encoded block.
+func (c *CRL) MarshalDER() (der_block []byte, err error) {
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+	if int(C.i2d_X509_CRL_bio(bio, c.crl)) != 1 {
+		return nil, errors.New("failed dumping crl der")
+	}
+	return ioutil.ReadAll(asAnyBio(bio))
+}
+
+// LoadCRLFromPEM loads a revocation list from a PEM-encoded block. The
+// signature is not checked; call Verify.
+func LoadCRLFromPEM(pem_block []byte) (*CRL, error) {
+	if len(pem_block) == 0 {
+		return nil, errors.New("empty pem block")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	bio := C.BIO_new_mem_buf(unsafe.Pointer(&pem_block[0]),
+		C.int(len(pem_block)))
+	crl := C.PEM_read_bio_X509_CRL(bio, nil, nil, nil)
+	C.BIO_free(bio)
+	if crl == nil {
+		return nil, errorFromErrorQueue()
+	}
+	return newCRL(crl), nil
+}
+
+// LoadCRLFromDER loads a revocation list from a DER-encoded block. The
+// signature is not checked; call Verify.
+func LoadCRLFromDER(der_block []byte) (*CRL, error) {
+	if len(der_block) == 0 {
+		return nil, errors.New("empty der block")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	bio := C.BIO_new_mem_buf(unsafe.Pointer(&der_block[0]),
+		C.int(len(der_block)))
+	crl := C.d2i_X509_CRL_bio(bio, nil)
+	C.BIO_free(bio)
+	if crl == nil {
+		return nil, errorFromErrorQueue()
+	}
+	return newCRL(crl), nil
+}
+
+// Verify checks the signature of the revocation list against the issuer's
+// public key.
+func (c *CRL) Verify(issuerKey PublicKey) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.X509_CRL_verify(c.crl, issuerKey.evpPKey()) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
+// GetIssuerName returns the name of the CA that issued the list.
+func (c *CRL) GetIssuerName() (*Name, error) {
+	n := C.X509_CRL_get_issuer(c.crl)
+	if n == nil {
+		return nil, errors.New("failed to get issuer name")
+	}
+	return &Name{name: n}, nil
+}
+
+// LastUpdate returns the issue date of the revocation list.
+func (c *CRL) LastUpdate() (time.Time, error) {
+	return goTime(C.X509_CRL_get0_lastUpdate(c.crl))
+}
+
+// NextUpdate returns the date by which the next list will be issued, or the
+// zero time if the list does not specify one.
+func (c *CRL) NextUpdate() (time.Time, error) {
+	tm := C.X509_CRL_get0_nextUpdate(c.crl)
+	if tm == nil {
+		return time.Time{}, nil
+	}
+	return goTime(tm)
+}
+
+// RevokedEntry describes a certificate listed in a revocation list.
+type RevokedEntry struct {
+	SerialNumber   *big.Int
+	RevocationTime time.Time
+	// Reason is ReasonUnspecified if the entry has no reason code.
+	Reason RevocationReason
+}
+
+func revokedEntry(rev *C.X509_REVOKED) (*RevokedEntry, error) {
+	serial, err := bigInt(C.X509_REVOKED_get0_serialNumber(rev))
+	if err != nil {
+		return nil, err
+	}
+	when, err := goTime(C.X509_REVOKED_get0_revocationDate(rev))
+	if err != nil {
+		return nil, err
+	}
+	entry := &RevokedEntry{
+		SerialNumber:   serial,
+		RevocationTime: when,
+	}
+	code := (*C.ASN1_ENUMERATED)(C.X509_REVOKED_get_ext_d2i(rev,
+		C.NID_crl_reason, nil, nil))
+	if code != nil {
+		entry.Reason = RevocationReason(C.ASN1_ENUMERATED_get(code))
+		C.ASN1_ENUMERATED_free(code)
+	}
+	return entry, nil
+}
+
+// RevokedEntries returns the certificates listed as revoked.
+func (c *CRL) RevokedEntries() ([]RevokedEntry, error) {
+	revoked := C.X509_CRL_get_REVOKED(c.crl)
+	n := int(C.X_sk_X509_REVOKED_num(revoked))
+	entries := make([]RevokedEntry, 0, n)
+	for i := 0; i < n; i++ {
+		entry, err := revokedEntry(C.X_sk_X509_REVOKED_value(revoked,
+			C.int(i)))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+// IsRevokedBy reports whether the certificate is listed in the revocation
+// list. The list must be issued by the certificate's issuer for a match; its
+// signature and validity period are not checked, so call CRL.Verify first.
+// Entries with reason ReasonRemoveFromCRL do not count as revoked.
+func (c *Certificate) IsRevokedBy(crl *CRL) bool {
+	var rev *C.X509_REVOKED
+	return C.X509_CRL_get0_by_cert(crl.crl, &rev, c.x) == 1
+}
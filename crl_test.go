@@ -0,0 +1,249 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestCRLGenerate(t *testing.T) {
+	ca, cakey := newTestCA(t)
+
+	now := time.Now().Truncate(time.Second)
+	crl, err := NewCRL(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.SetLastUpdate(now); err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.SetNextUpdate(now.Add(24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.SetNumber(big.NewInt(42)); err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.AddExtension(NID_authority_key_identifier,
+		"keyid:always"); err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.AddRevoked(big.NewInt(1001), now.Add(-time.Hour),
+		ReasonKeyCompromise); err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.AddRevoked(big.NewInt(7), now.Add(-2*time.Hour),
+		ReasonUnspecified); err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.Sign(cakey, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := crl.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBlock, err := crl.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pemBlock)
+	if block == nil || block.Type != "X509 CRL" ||
+		!bytes.Equal(block.Bytes, der) {
+		t.Fatal("pem and der encodings differ")
+	}
+
+	list, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caDER, err := ca.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdCA, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := list.CheckSignatureFrom(stdCA); err != nil {
+		t.Fatal(err)
+	}
+	if list.Number.Int64() != 42 {
+		t.Fatalf("unexpected crl number %v", list.Number)
+	}
+	if !list.ThisUpdate.Equal(now) || !list.NextUpdate.Equal(now.Add(24*time.Hour)) {
+		t.Fatal("unexpected update times")
+	}
+	if !bytes.Equal(list.AuthorityKeyId, stdCA.SubjectKeyId) {
+		t.Fatal("authority key id does not match the issuer")
+	}
+	entries := list.RevokedCertificateEntries
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 revoked entries, got %d", len(entries))
+	}
+	// entries are sorted by serial number when signing
+	if entries[0].SerialNumber.Int64() != 7 || entries[0].ReasonCode != 0 {
+		t.Fatal("unexpected first revoked entry")
+	}
+	if entries[1].SerialNumber.Int64() != 1001 ||
+		entries[1].ReasonCode != int(ReasonKeyCompromise) ||
+		!entries[1].RevocationTime.Equal(now.Add(-time.Hour)) {
+		t.Fatal("unexpected second revoked entry")
+	}
+}
+
+func TestCRLParse(t *testing.T) {
+	ca, cakey := newTestCA(t)
+
+	issue := func(serial int64) *Certificate {
+		key, err := GenerateECKey(Prime256v1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := NewCertificateRequest(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := req.Sign(key, EVP_SHA256); err != nil {
+			t.Fatal(err)
+		}
+		cert, err := IssueCertificate(req, ca, cakey, &IssuanceProfile{
+			Serial: func() (*big.Int, error) {
+				return big.NewInt(serial), nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	revokedCert := issue(1001)
+	validCert := issue(1002)
+
+	now := time.Now().Truncate(time.Second)
+	crl, err := NewCRL(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.SetLastUpdate(now); err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.AddRevoked(big.NewInt(1001), now.Add(-time.Hour),
+		ReasonSuperseded); err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.Sign(cakey, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	pemBlock, err := crl.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := crl.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromDER, err := LoadCRLFromDER(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCRLFromPEM(pemBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reencoded, err := fromDER.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reencoded, der) {
+		t.Fatal("der round trip changed the crl")
+	}
+
+	if err := loaded.Verify(cakey); err != nil {
+		t.Fatal(err)
+	}
+	other, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Verify(other); err == nil {
+		t.Fatal("expected verification with the wrong key to fail")
+	}
+
+	issuer, err := loaded.GetIssuerName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn, ok := issuer.GetEntry(NID_commonName); !ok || cn != "Test CA" {
+		t.Fatalf("unexpected issuer %q", cn)
+	}
+	last, err := loaded.LastUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last.Equal(now) {
+		t.Fatalf("unexpected last update %v", last)
+	}
+	next, err := loaded.NextUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !next.IsZero() {
+		t.Fatalf("unexpected next update %v", next)
+	}
+
+	entries, err := loaded.RevokedEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].SerialNumber.Int64() != 1001 ||
+		entries[0].Reason != ReasonSuperseded ||
+		!entries[0].RevocationTime.Equal(now.Add(-time.Hour)) {
+		t.Fatalf("unexpected revoked entries %+v", entries)
+	}
+
+	if !revokedCert.IsRevokedBy(loaded) {
+		t.Fatal("expected certificate to be revoked")
+	}
+	if validCert.IsRevokedBy(loaded) {
+		t.Fatal("unexpected revocation")
+	}
+	// a list from another CA does not revoke the certificate
+	otherCA, err := NewCertificate(&CertificateInfo{
+		Serial:     big.NewInt(1),
+		Expires:    time.Hour,
+		CommonName: "Other CA",
+	}, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCRL, err := NewCRL(otherCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := otherCRL.AddRevoked(big.NewInt(1001), now,
+		ReasonUnspecified); err != nil {
+		t.Fatal(err)
+	}
+	if revokedCert.IsRevokedBy(otherCRL) {
+		t.Fatal("certificate revoked by a list from another issuer")
+	}
+}
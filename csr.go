@@ -0,0 +1,242 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"io/ioutil"
+	"runtime"
+	"unsafe"
+)
+
+// CertificateRequest is a PKCS#10 certificate signing request (X509_REQ).
+type CertificateRequest struct {
+	req *C.X509_REQ
+	// requested extensions, added to the request when it is signed
+	exts *C.struct_stack_st_X509_EXTENSION
+}
+
+func newCertificateRequest(req *C.X509_REQ) *CertificateRequest {
+	r := &CertificateRequest{req: req}
+	runtime.SetFinalizer(r, func(r *CertificateRequest) {
+		if r.exts != nil {
+			C.X_sk_X509_EXTENSION_pop_free(r.exts)
+		}
+		C.X509_REQ_free(r.req)
+	})
+	return r
+}
+
+// NewCertificateRequest creates an unsigned certificate signing request for
+// key. Set the subject and requested extensions, then call Sign.
+func NewCertificateRequest(key PublicKey) (*CertificateRequest, error) {
+	req := C.X509_REQ_new()
+	if req == nil {
+		return nil, errors.New("failed to allocate certificate request")
+	}
+	r := newCertificateRequest(req)
+	if C.X509_REQ_set_version(r.req, 0) != 1 {
+		return nil, errors.New("failed to set certificate request version")
+	}
+	if C.X509_REQ_set_pubkey(r.req, key.evpPKey()) != 1 {
+		return nil, errors.New("failed to set public key")
+	}
+	return r, nil
+}
+
+// GetSubjectName returns the subject name of the request. Entries added to
+// the returned Name modify the request.
+func (r *CertificateRequest) GetSubjectName() (*Name, error) {
+	n := C.X509_REQ_get_subject_name(r.req)
+	if n == nil {
+		return nil, errors.New("failed to get subject name")
+	}
+	return &Name{name: n}, nil
+}
+
+// SetSubjectName sets the subject name of the request.
+func (r *CertificateRequest) SetSubjectName(name *Name) error {
+	if C.X509_REQ_set_subject_name(r.req, name.name) != 1 {
+		return errors.New("failed to set subject name")
+	}
+	return nil
+}
+
+// AddExtension adds an extension to the set of extensions requested from
+// the CA, using the same textual values as Certificate.AddExtension.
+// Extensions must be added before the request is signed.
+func (r *CertificateRequest) AddExtension(nid NID, value string) error {
+	var ctx C.X509V3_CTX
+	C.X509V3_set_ctx(&ctx, nil, nil, r.req, nil, 0)
+	cvalue := C.CString(value)
+	defer C.free(unsafe.Pointer(cvalue))
+	ex := C.X509V3_EXT_conf_nid(nil, &ctx, C.int(nid), cvalue)
+	if ex == nil {
+		return errors.New("failed to create x509v3 extension")
+	}
+	defer C.X509_EXTENSION_free(ex)
+	if C.X509v3_add_ext(&r.exts, ex, -1) == nil {
+		return errors.New("failed to add x509v3 extension")
+	}
+	return nil
+}
+
+// AddExtensions wraps AddExtension using a map of NID to text extension.
+func (r *CertificateRequest) AddExtensions(extensions map[NID]string) error {
+	for nid, value := range extensions {
+		if err := r.AddExtension(nid, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sign signs the request with the private key matching its public key.
+// Accepted digests are the same as for Certificate.Sign.
+func (r *CertificateRequest) Sign(privKey PrivateKey, digest EVP_MD) error {
+	md, err := signingDigest(privKey, digest)
+	if err != nil {
+		return err
+	}
+	if r.exts != nil {
+		if C.X509_REQ_add_extensions(r.req, r.exts) != 1 {
+			return errors.New("failed to add requested extensions")
+		}
+		C.X_sk_X509_EXTENSION_pop_free(r.exts)
+		r.exts = nil
+	}
+	if C.X509_REQ_sign(r.req, privKey.evpPKey(), md) <= 0 {
+		return errors.New("failed to sign certificate request")
+	}
+	return nil
+}
+
+// Verify checks the request's self-signature, proving that the requester
+// holds the private key for the enclosed public key.
+func (r *CertificateRequest) Verify() error {
+	pkey := C.X509_REQ_get0_pubkey(r.req)
+	if pkey == nil {
+		return errors.New("no public key found")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.X509_REQ_verify(r.req, pkey) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
+// PublicKey returns the public key embedded in the request.
+func (r *CertificateRequest) PublicKey() (PublicKey, error) {
+	pkey := C.X509_REQ_get_pubkey(r.req)
+	if pkey == nil {
+		return nil, errors.New("no public key found")
+	}
+	key := &pKey{key: pkey}
+	runtime.SetFinalizer(key, func(key *pKey) {
+		C.EVP_PKEY_free(key.key)
+	})
+	return key, nil
+}
+
+// requestedExtensions returns a copy of the extensions requested in a signed
+// request. The caller must free the stack, which is nil if there are none.
+func (r *CertificateRequest) requestedExtensions() *C.struct_stack_st_X509_EXTENSION {
+	return C.X509_REQ_get_extensions(r.req)
+}
+
+// GetExtensionValue returns the DER-encoded value of the requested extension
+// with the given NID, or nil if it was not requested.
+func (r *CertificateRequest) GetExtensionValue(nid NID) []byte {
+	exts := r.requestedExtensions()
+	if exts == nil {
+		return nil
+	}
+	defer C.X_sk_X509_EXTENSION_pop_free(exts)
+	loc := C.X509v3_get_ext_by_NID(exts, C.int(nid), -1)
+	if loc < 0 {
+		return nil
+	}
+	data := C.X509_EXTENSION_get_data(C.X509v3_get_ext(exts, loc))
+	return C.GoBytes(unsafe.Pointer(C.ASN1_STRING_get0_data(data)),
+		C.ASN1_STRING_length(data))
+}
+
+// LoadCertificateRequestFromPEM loads a certificate signing request from a
+// PEM-encoded block. The signature is not checked; call Verify.
+func LoadCertificateRequestFromPEM(pem_block []byte) (*CertificateRequest,
+	error) {
+	if len(pem_block) == 0 {
+		return nil, errors.New("empty pem block")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	bio := C.BIO_new_mem_buf(unsafe.Pointer(&pem_block[0]),
+		C.int(len(pem_block)))
+	req := C.PEM_read_bio_X509_REQ(bio, nil, nil, nil)
+	C.BIO_free(bio)
+	if req == nil {
+		return nil, errorFromErrorQueue()
+	}
+	return newCertificateRequest(req), nil
+}
+
+// LoadCertificateRequestFromDER loads a certificate signing request from a
+// DER-encoded block. The signature is not checked; call Verify.
+func LoadCertificateRequestFromDER(der_block []byte) (*CertificateRequest,
+	error) {
+	if len(der_block) == 0 {
+		return nil, errors.New("empty der block")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	bio := C.BIO_new_mem_buf(unsafe.Pointer(&der_block[0]),
+		C.int(len(der_block)))
+	req := C.d2i_X509_REQ_bio(bio, nil)
+	C.BIO_free(bio)
+	if req == nil {
+		return nil, errorFromErrorQueue()
+	}
+	return newCertificateRequest(req), nil
+}
+
+// MarshalPEM converts the request to PEM-encoded format.
+func (r *CertificateRequest) MarshalPEM() (pem_block []byte, err error) {
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+	if int(C.PEM_write_bio_X509_REQ(bio, r.req)) != 1 {
+		return nil, errors.New("failed dumping certificate request")
+	}
+	return ioutil.ReadAll(asAnyBio(bio))
+}
+
+// MarshalDER converts the request to DER-encoded format.
+func (r *CertificateRequest) MarshalDER() (der_block []byte, err error) {
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+	if int(C.i2d_X509_REQ_bio(bio, r.req)) != 1 {
+		return nil, errors.New("failed dumping certificate request der")
+	}
+	return ioutil.ReadAll(asAnyBio(bio))
+}
@@ -0,0 +1,136 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestCertificateRequest(t *testing.T) {
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := NewCertificateRequest(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := req.GetSubjectName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := name.AddTextEntry("CN", "device-0001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := req.AddExtension(NID_subject_alt_name,
+		"DNS:device-0001.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := req.AddExtension(NID_key_usage,
+		"critical,digitalSignature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Sign(key, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+
+	pem, err := req.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCertificateRequestFromPEM(pem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := loaded.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.Equal(key) {
+		t.Fatal("request public key differs from signing key")
+	}
+	subject, err := loaded.GetSubjectName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn, ok := subject.GetEntry(NID_commonName); !ok || cn != "device-0001" {
+		t.Fatalf("unexpected common name %q", cn)
+	}
+	if loaded.GetExtensionValue(NID_subject_alt_name) == nil {
+		t.Fatal("missing requested subject alternative name")
+	}
+	if loaded.GetExtensionValue(NID_ext_key_usage) != nil {
+		t.Fatal("unexpected extended key usage")
+	}
+
+	der, err := loaded.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	std, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := std.CheckSignature(); err != nil {
+		t.Fatal(err)
+	}
+	if len(std.DNSNames) != 1 || std.DNSNames[0] != "device-0001.example.com" {
+		t.Fatalf("unexpected dns names %v", std.DNSNames)
+	}
+
+	// change the subject to invalidate the signature
+	tampered := bytes.Replace(der, []byte("device-0001"), []byte("device-0002"), 1)
+	bad, err := LoadCertificateRequestFromDER(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bad.Verify(); err == nil {
+		t.Fatal("expected verification of a tampered request to fail")
+	}
+}
+
+func TestCertificateRequestFromStdlib(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader,
+		&x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: "stdlib"},
+			DNSNames: []string{"stdlib.example.com"},
+		}, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := LoadCertificateRequestFromDER(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if req.GetExtensionValue(NID_subject_alt_name) == nil {
+		t.Fatal("missing subject alternative name")
+	}
+}
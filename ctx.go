@@ -23,19 +23,17 @@ import (
 	"io/ioutil"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 	"unsafe"
 
 	"github.com/mattn/go-pointer"
-	"github.com/spacemonkeygo/spacelog"
 
 )
 
 var (
 	ssl_ctx_idx = C.X_SSL_CTX_new_index()
-
-	logger = spacelog.GetLogger()
 )
 
 type Ctx struct {
@@ -45,9 +43,29 @@ type Ctx struct {
 	key       PrivateKey
 	verify_cb VerifyCallback
 	sni_cb    TLSExtServernameCallback
+	lib       *LibraryContext
+
+	// guards watcher and watched_creds, which handshakes read
+	watcher_mu sync.Mutex
+	watcher    *CredentialWatcher
+	// the last credentials of a closed watcher, still in use
+	watched_creds *credentials
+
+	next_protos []string
+	record_size int
+	low_memory  bool
+	metrics     Metrics
+	tracer      Tracer
+
+	verify_peer_cb VerifyPeerCertificateCallback
+
+	psk_client_cb PSKClientCallback
+	psk_server_cb PSKServerCallback
 
 	ticket_store_mu sync.Mutex
 	ticket_store    *TicketStore
+
+	client_sessions *ClientSessionCache
 }
 
 //export get_ssl_ctx_idx
@@ -80,6 +98,34 @@ func NewCtx() (*Ctx, error) {
 	return c, nil
 }
 
+// NewCtxWithLibraryContext creates a context like NewCtx whose algorithms
+// are fetched from lib's providers, filtered by the optional property query,
+// such as "fips=yes". Requires OpenSSL 3.0 or later.
+func NewCtxWithLibraryContext(lib *LibraryContext, properties string) (*Ctx,
+	error) {
+	if C.OPENSSL_VERSION_NUMBER < 0x30000000 {
+		return nil, errors.New("library contexts require OpenSSL 3.0")
+	}
+	var cprops *C.char
+	if properties != "" {
+		cprops = C.CString(properties)
+		defer C.free(unsafe.Pointer(cprops))
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	ctx := C.X_SSL_CTX_new_ex(lib.ctx, cprops)
+	if ctx == nil {
+		return nil, errorFromErrorQueue()
+	}
+	c := &Ctx{ctx: ctx, lib: lib}
+	C.SSL_CTX_set_ex_data(ctx, get_ssl_ctx_idx(), pointer.Save(c))
+	runtime.SetFinalizer(c, func(c *Ctx) {
+		C.SSL_CTX_free(c.ctx)
+	})
+	c.SetOptions(NoSSLv2 | NoSSLv3)
+	return c, nil
+}
+
 // NewCtxFromFiles calls NewCtx, loads the provided files, and configures the
 // context to use them.
 func NewCtxFromFiles(cert_file string, key_file string) (*Ctx, error) {
@@ -88,37 +134,11 @@ func NewCtxFromFiles(cert_file string, key_file string) (*Ctx, error) {
 		return nil, err
 	}
 
-	cert_bytes, err := ioutil.ReadFile(cert_file)
-	if err != nil {
-		return nil, err
-	}
-
-	certs := SplitPEM(cert_bytes)
-	if len(certs) == 0 {
-		return nil, fmt.Errorf("no PEM certificate found in '%s'", cert_file)
-	}
-	first, certs := certs[0], certs[1:]
-	cert, err := LoadCertificateFromPEM(first)
+	err = ctx.UseCertificateChainFile(cert_file)
 	if err != nil {
 		return nil, err
 	}
 
-	err = ctx.UseCertificate(cert)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, pem := range certs {
-		cert, err := LoadCertificateFromPEM(pem)
-		if err != nil {
-			return nil, err
-		}
-		err = ctx.AddChainCertificate(cert)
-		if err != nil {
-			return nil, err
-		}
-	}
-
 	key_bytes, err := ioutil.ReadFile(key_file)
 	if err != nil {
 		return nil, err
@@ -181,6 +201,51 @@ func (c *Ctx) UseCertificate(cert *Certificate) error {
 	return nil
 }
 
+// UseCertificateChainPEM configures the context to present the certificates
+// in pem_block, such as a "fullchain.pem" file: the first is used as with
+// UseCertificate, the others are added to the chain in order as with
+// AddChainCertificate.
+func (c *Ctx) UseCertificateChainPEM(pem_block []byte) error {
+	certs := SplitPEM(pem_block)
+	if len(certs) == 0 {
+		return errors.New("no PEM certificate found")
+	}
+	cert, err := LoadCertificateFromPEM(certs[0])
+	if err != nil {
+		return err
+	}
+	chain := make([]*Certificate, 0, len(certs)-1)
+	for _, pem := range certs[1:] {
+		cert, err := LoadCertificateFromPEM(pem)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, cert)
+	}
+	if err := c.UseCertificate(cert); err != nil {
+		return err
+	}
+	for _, cert := range chain {
+		if err := c.AddChainCertificate(cert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseCertificateChainFile is like UseCertificateChainPEM, reading the
+// certificates from the file at path.
+func (c *Ctx) UseCertificateChainFile(path string) error {
+	pem_block, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := c.UseCertificateChainPEM(pem_block); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
 // AddChainCertificate adds a certificate to the chain presented in the
 // handshake.
 func (c *Ctx) AddChainCertificate(cert *Certificate) error {
@@ -195,6 +260,30 @@ func (c *Ctx) AddChainCertificate(cert *Certificate) error {
 	return nil
 }
 
+// CheckPrivateKey checks that the private key set with UsePrivateKey belongs
+// to the certificate set with UseCertificate, catching configuration errors
+// at startup instead of as handshake failures.
+func (c *Ctx) CheckPrivateKey() error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if int(C.SSL_CTX_check_private_key(c.ctx)) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
+// AddClientCA adds the subject of cert to the list of CA names servers send
+// when requesting client certificates, which clients use to pick one. It
+// does not make cert trusted; add it to the certificate store for that.
+func (c *Ctx) AddClientCA(cert *Certificate) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if int(C.SSL_CTX_add_client_CA(c.ctx, cert.x)) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
 // UsePrivateKey configures the context to use the given private key for SSL
 // handshakes.
 func (c *Ctx) UsePrivateKey(key PrivateKey) error {
@@ -268,6 +357,46 @@ func (s *CertificateStore) AddCertificate(cert *Certificate) error {
 	return nil
 }
 
+// LoadLocations trusts all certificate authorities in either the ca_file or
+// the ca_path directory. Certificates in ca_path must be named after their
+// subject name hash, as created by c_rehash, and are only read when needed
+// during verification.
+func (s *CertificateStore) LoadLocations(ca_file string, ca_path string) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var c_ca_file, c_ca_path *C.char
+	if ca_file != "" {
+		c_ca_file = C.CString(ca_file)
+		defer C.free(unsafe.Pointer(c_ca_file))
+	}
+	if ca_path != "" {
+		c_ca_path = C.CString(ca_path)
+		defer C.free(unsafe.Pointer(c_ca_path))
+	}
+	if C.X509_STORE_load_locations(s.store, c_ca_file, c_ca_path) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
+// LoadLocationsFromDir trusts the certificate authorities in a hashed
+// directory. See LoadLocations.
+func (s *CertificateStore) LoadLocationsFromDir(ca_path string) error {
+	return s.LoadLocations("", ca_path)
+}
+
+// SetDefaultVerifyPaths trusts the certificate authorities in OpenSSL's
+// default CA file and directory, usually the system CA bundle. The
+// SSL_CERT_FILE and SSL_CERT_DIR environment variables override them.
+func (s *CertificateStore) SetDefaultVerifyPaths() error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.X509_STORE_set_default_paths(s.store) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
 type CertificateStoreCtx struct {
 	ctx     *C.X509_STORE_CTX
 	ssl_ctx *Ctx
@@ -286,6 +415,17 @@ func (csc *CertificateStoreCtx) Err() error {
 		C.GoString(C.X509_verify_cert_error_string(C.long(code))))
 }
 
+// SSL returns the connection whose peer certificates are being verified, or
+// nil when verifying outside of a connection made by this package.
+func (csc *CertificateStoreCtx) SSL() *SSL {
+	p := C.X_X509_STORE_CTX_get_ssl_data(csc.ctx)
+	if p == nil {
+		return nil
+	}
+	s, _ := pointer.Restore(p).(*SSL)
+	return s
+}
+
 func (csc *CertificateStoreCtx) Depth() int {
 	return int(C.X509_STORE_CTX_get_error_depth(csc.ctx))
 }
@@ -363,19 +503,72 @@ func (c *Ctx) GetOptions() Options {
 	return Options(C.X_SSL_CTX_get_options(c.ctx))
 }
 
+// SetRecordSize sets the largest record plaintext sent on connections made
+// from the context, and the space each read from the underlying connection
+// is given, from MinRecordSize up to the default SSLRecordSize. Smaller
+// sizes save memory on constrained devices at the cost of more records and
+// reads; peers may still send full size records, which are then read in
+// several pieces. Existing connections keep their size.
+func (c *Ctx) SetRecordSize(size int) error {
+	if size < MinRecordSize || size > SSLRecordSize {
+		return fmt.Errorf("record size must be between %d and %d",
+			MinRecordSize, SSLRecordSize)
+	}
+	if C.X_SSL_CTX_set_max_send_fragment(c.ctx, C.long(size)) != 1 {
+		return errors.New("failed to set record size")
+	}
+	c.record_size = size
+	return nil
+}
+
+// RecordSize returns the record size set by SetRecordSize.
+func (c *Ctx) RecordSize() int {
+	if c.record_size == 0 {
+		return SSLRecordSize
+	}
+	return c.record_size
+}
+
 type Modes int
 
 const (
-	// ReleaseBuffers is only valid if you are using OpenSSL 1.0.1 or newer
+	// ReleaseBuffers frees a connection's read and write buffers, in
+	// OpenSSL and in this package, whenever they run empty, trading
+	// allocations for a smaller footprint of idle connections. See
+	// SetReleaseBuffers. Only valid with OpenSSL 1.0.1 or newer.
 	ReleaseBuffers Modes = C.SSL_MODE_RELEASE_BUFFERS
 )
 
 // SetMode sets context modes. See
 // http://www.openssl.org/docs/ssl/SSL_CTX_set_mode.html
+//
+// SetMode panics if modes include SSL_MODE_ASYNC, which is not supported:
+// OpenSSL runs async jobs on stacks of its own, and the Go BIO and verify
+// callbacks invoked during a handshake cannot be entered from a foreign
+// stack.
 func (c *Ctx) SetMode(modes Modes) Modes {
+	if modes&C.SSL_MODE_ASYNC != 0 {
+		panic("openssl: " + errAsyncUnsupported.Error())
+	}
 	return Modes(C.X_SSL_CTX_set_mode(c.ctx, C.long(modes)))
 }
 
+// ClearMode clears context modes and returns the modes left set.
+func (c *Ctx) ClearMode(modes Modes) Modes {
+	return Modes(C.X_SSL_CTX_clear_mode(c.ctx, C.long(modes)))
+}
+
+// SetReleaseBuffers sets or clears ReleaseBuffers for connections made from
+// the context afterwards. Servers holding many mostly idle connections save
+// up to tens of kilobytes per connection with it.
+func (c *Ctx) SetReleaseBuffers(release bool) {
+	if release {
+		c.SetMode(ReleaseBuffers)
+	} else {
+		c.ClearMode(ReleaseBuffers)
+	}
+}
+
 // GetMode returns context modes. See
 // http://www.openssl.org/docs/ssl/SSL_CTX_set_mode.html
 func (c *Ctx) GetMode() Modes {
@@ -397,7 +590,7 @@ type VerifyCallback func(ok bool, store *CertificateStoreCtx) bool
 func go_ssl_ctx_verify_cb_thunk(p unsafe.Pointer, ok C.int, ctx *C.X509_STORE_CTX) C.int {
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Critf("openssl: verify callback panic'd: %v", err)
+			logCallbackPanic("verify callback", err)
 			os.Exit(1)
 		}
 	}()
@@ -493,7 +686,72 @@ func (c *Ctx) SetCipherList(list string) error {
 	return nil
 }
 
-// SetNextProtos sets Negotiation protocol to the ctx.
+// SetCiphersuites sets the TLS 1.3 cipher suites, a colon separated list
+// such as "TLS_AES_128_GCM_SHA256:TLS_CHACHA20_POLY1305_SHA256". It does
+// nothing with OpenSSL versions before 1.1.1, which lack TLS 1.3.
+func (c *Ctx) SetCiphersuites(suites string) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	csuites := C.CString(suites)
+	defer C.free(unsafe.Pointer(csuites))
+	if C.X_SSL_CTX_set_ciphersuites(c.ctx, csuites) == 0 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
+// SetSignatureAlgorithms sets the signature algorithms offered or accepted
+// for handshake signatures, in order of preference, e.g. "ed25519",
+// "ecdsa_secp256r1_sha256" or "rsa_pss_rsae_sha256".
+func (c *Ctx) SetSignatureAlgorithms(algs ...string) error {
+	if len(algs) == 0 {
+		return errors.New("no signature algorithms given")
+	}
+	calgs := C.CString(strings.Join(algs, ":"))
+	defer C.free(unsafe.Pointer(calgs))
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.X_SSL_CTX_set1_sigalgs_list(c.ctx, calgs) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
+// TLSVersion is a protocol version for SetMinProtoVersion and
+// SetMaxProtoVersion.
+type TLSVersion int
+
+const (
+	TLSv1   TLSVersion = C.TLS1_VERSION
+	TLSv1_1 TLSVersion = C.TLS1_1_VERSION
+	TLSv1_2 TLSVersion = C.TLS1_2_VERSION
+	// the wire value, as TLS1_3_VERSION is missing before OpenSSL 1.1.1
+	TLSv1_3 TLSVersion = 0x0304
+	// for contexts made by NewDTLSCtx
+	DTLSv1   TLSVersion = C.DTLS1_VERSION
+	DTLSv1_2 TLSVersion = C.DTLS1_2_VERSION
+)
+
+// SetMinProtoVersion sets the lowest protocol version the context
+// negotiates. Zero enables the lowest version OpenSSL supports.
+func (c *Ctx) SetMinProtoVersion(version TLSVersion) error {
+	if C.X_SSL_CTX_set_min_proto_version(c.ctx, C.int(version)) != 1 {
+		return fmt.Errorf("unsupported protocol version %#x", int(version))
+	}
+	return nil
+}
+
+// SetMaxProtoVersion sets the highest protocol version the context
+// negotiates. Zero enables the highest version OpenSSL supports.
+func (c *Ctx) SetMaxProtoVersion(version TLSVersion) error {
+	if C.X_SSL_CTX_set_max_proto_version(c.ctx, C.int(version)) != 1 {
+		return fmt.Errorf("unsupported protocol version %#x", int(version))
+	}
+	return nil
+}
+
+// SetNextProtos sets the application protocols offered through ALPN, in
+// order of preference. Servers select the first of them the client offers.
 func (c *Ctx) SetNextProtos(protos []string) error {
 	if len(protos) == 0 {
 		return nil
@@ -513,9 +771,33 @@ func (c *Ctx) SetNextProtos(protos []string) error {
 	if ret != 0 {
 		return errors.New("error while setting protos to ctx")
 	}
+	c.next_protos = append([]string(nil), protos...)
+	C.SSL_CTX_set_alpn_select_cb(c.ctx,
+		(*[0]byte)(C.X_SSL_CTX_alpn_select_cb), nil)
 	return nil
 }
 
+//export go_ssl_ctx_alpn_select_thunk
+func go_ssl_ctx_alpn_select_thunk(p unsafe.Pointer, in *C.uchar,
+	inlen C.uint) C.int {
+	defer func() {
+		if err := recover(); err != nil {
+			logCallbackPanic("alpn select callback", err)
+			os.Exit(1)
+		}
+	}()
+	offered := C.GoBytes(unsafe.Pointer(in), C.int(inlen))
+	for _, proto := range pointer.Restore(p).(*Ctx).next_protos {
+		for i := 0; i < len(offered); i += int(offered[i]) + 1 {
+			end := i + 1 + int(offered[i])
+			if end <= len(offered) && string(offered[i+1:end]) == proto {
+				return C.int(i)
+			}
+		}
+	}
+	return -1
+}
+
 type SessionCacheModes int
 
 const (
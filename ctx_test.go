@@ -15,6 +15,10 @@
 package openssl
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -46,3 +50,146 @@ func TestCtxSessCacheSizeOption(t *testing.T) {
 		t.Error("SessSetCacheSize() does not save anything to ctx")
 	}
 }
+
+func TestCtxRecordSize(t *testing.T) {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.RecordSize() != SSLRecordSize {
+		t.Fatalf("unexpected default record size %d", ctx.RecordSize())
+	}
+	if err := ctx.SetRecordSize(MinRecordSize - 1); err == nil {
+		t.Fatal("expected error for a record size below the minimum")
+	}
+	if err := ctx.SetRecordSize(2048); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.RecordSize() != 2048 {
+		t.Fatalf("record size not saved, got %d", ctx.RecordSize())
+	}
+}
+
+func TestCtxCheckPrivateKey(t *testing.T) {
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.CheckPrivateKey(); err == nil {
+		t.Fatal("check passed without certificate and key")
+	}
+	if err := ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.CheckPrivateKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := GenerateRSAKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err = NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// OpenSSL drops the mismatching key, or refuses it
+	if ctx.UsePrivateKey(other) == nil && ctx.UseCertificate(cert) == nil {
+		if err := ctx.CheckPrivateKey(); err == nil {
+			t.Fatal("check passed with mismatching key")
+		}
+	}
+}
+
+func TestCtxUseCertificateChainPEM(t *testing.T) {
+	ca, cakey := newTestCA(t)
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := newTestCMSSigner(t, ca, cakey, key, EVP_SHA256).Certificate
+	var fullchain []byte
+	for _, cert := range []*Certificate{leaf, ca} {
+		pem, err := cert.MarshalPEM()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fullchain = append(fullchain, pem...)
+	}
+	dir, err := ioutil.TempDir("", "chain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fullchain.pem")
+	if err := ioutil.WriteFile(path, fullchain, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, use := range []func(*Ctx) error{
+		func(ctx *Ctx) error { return ctx.UseCertificateChainPEM(fullchain) },
+		func(ctx *Ctx) error { return ctx.UseCertificateChainFile(path) },
+	} {
+		ctx, err := NewCtx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := use(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := ctx.UsePrivateKey(key); err != nil {
+			t.Fatal(err)
+		}
+		if err := ctx.CheckPrivateKey(); err != nil {
+			t.Fatal(err)
+		}
+		if len(ctx.chain) != 1 {
+			t.Fatalf("unexpected chain length %d", len(ctx.chain))
+		}
+		got, _ := ctx.chain[0].MarshalDER()
+		want, _ := ca.MarshalDER()
+		if !bytes.Equal(got, want) {
+			t.Fatal("unexpected chain certificate")
+		}
+	}
+
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UseCertificateChainPEM(keyBytes); err == nil {
+		t.Fatal("used chain without certificates")
+	}
+	if err := ctx.UseCertificateChainFile(filepath.Join(dir,
+		"missing.pem")); err == nil {
+		t.Fatal("used missing chain file")
+	}
+}
+
+func TestCtxSetModeRefusesAsync(t *testing.T) {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected SetMode to refuse SSL_MODE_ASYNC")
+		}
+		if ctx.SetMode(0)&ReleaseBuffers != 0 {
+			t.Fatal("refused modes were partially applied")
+		}
+	}()
+	// SSL_MODE_ASYNC
+	ctx.SetMode(ReleaseBuffers | 0x100)
+}
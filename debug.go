@@ -0,0 +1,70 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"io"
+	"unsafe"
+)
+
+// SetDebugTrace writes a decoded transcript of the messages the connection
+// sends and receives from now on to w, as printed by SSL_trace. OpenSSL
+// builds without SSL_trace only note each message's content type and length.
+// w is written to with the connection locked, and write errors are ignored.
+// A nil w stops the transcript. Meant for debugging, e.g. of interop
+// problems, as it slows the connection down considerably.
+func (c *Conn) SetDebugTrace(w io.Writer) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if w == nil {
+		if c.msg_state.trace != nil {
+			c.flushDebugTrace()
+			C.BIO_free(c.msg_state.trace)
+			c.msg_state.trace = nil
+		}
+		c.trace_writer = nil
+		return nil
+	}
+	if c.msg_state.trace == nil {
+		bio := C.BIO_new(C.BIO_s_mem())
+		if bio == nil {
+			return errors.New("failed to allocate debug trace buffer")
+		}
+		c.msg_state.trace = bio
+	}
+	c.trace_writer = w
+	return nil
+}
+
+// flushDebugTrace moves the transcript buffered since the last call to the
+// writer set by SetDebugTrace. c.mtx must be held.
+func (c *Conn) flushDebugTrace() {
+	if c.msg_state.trace == nil {
+		return
+	}
+	var buf [4096]byte
+	for {
+		n := C.BIO_read(c.msg_state.trace, unsafe.Pointer(&buf[0]),
+			C.int(len(buf)))
+		if n <= 0 {
+			return
+		}
+		c.trace_writer.Write(buf[:n])
+	}
+}
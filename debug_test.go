@@ -0,0 +1,59 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestDebugTrace(t *testing.T) {
+	server := newTestTLSServer(t, "tcp", "localhost:0")
+	defer server.Close()
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := Client(c, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var transcript bytes.Buffer
+	if err := conn.SetDebugTrace(&transcript); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if transcript.Len() == 0 {
+		t.Fatal("expected a handshake transcript")
+	}
+	if err := conn.SetDebugTrace(nil); err != nil {
+		t.Fatal(err)
+	}
+	n := transcript.Len()
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	if transcript.Len() != n {
+		t.Fatal("expected the transcript to stop")
+	}
+}
@@ -62,3 +62,28 @@ func (c *Ctx) SetDHParameters(dh *DH) error {
 	}
 	return nil
 }
+
+// SetDHParametersFromPEM loads the DH parameters from a PEM-encoded block and
+// sets them as with SetDHParameters.
+func (c *Ctx) SetDHParametersFromPEM(pem_block []byte) error {
+	dh, err := LoadDHParametersFromPEM(pem_block)
+	if err != nil {
+		return err
+	}
+	return c.SetDHParameters(dh)
+}
+
+// SetDHAuto lets OpenSSL pick the DH group used for ephemeral DH key
+// exchange, matching its strength to that of the certificate. With OpenSSL 3
+// the groups are the standard ones of RFC 7919, so that DHE cipher suites can
+// be enabled without generating custom parameters. Requires OpenSSL 1.1.0 or
+// newer.
+func (c *Ctx) SetDHAuto() error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if int(C.X_SSL_CTX_set_dh_auto(c.ctx, 1)) != 1 {
+		return errors.New("failed to enable automatic dh parameters")
+	}
+	return nil
+}
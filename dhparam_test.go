@@ -0,0 +1,113 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"strings"
+	"testing"
+)
+
+// RFC 7919 ffdhe2048
+var dhParamsBytes = []byte(`-----BEGIN DH PARAMETERS-----
+MIIBCAKCAQEA//////////+t+FRYortKmq/cViAnPTzx2LnFg84tNpWp4TZBFGQz
++8yTnc4kmz75fS/jY2MMddj2gbICrsRhetPfHtXV/WVhJDP1H18GbtCFY2VVPe0a
+87VXE15/V8k1mE8McODmi3fipona8+/och3xWKE2rec1MKzKT0g6eXq8CrGCsyT7
+YdEIqUuyyOP7uWrat2DX9GgdT0Kj3jlN9K5W7edjcrsZCwenyO4KbXCeAvzhzffi
+7MA0BM0oNC9hkXL+nOmFg/+OTxIy7vKBg8P+OxtMb61zO7X8vC7CIAXFjvGDfRaD
+ssbzSibBsu/6iGtCOGEoXJf//////////wIBAg==
+-----END DH PARAMETERS-----
+`)
+
+// dheHandshake runs a TLS 1.2 handshake restricted to a DHE cipher suite
+// against a server configured by setup, returning the negotiated cipher.
+func dheHandshake(t *testing.T, setup func(*Ctx) error) (string, error) {
+	server_ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server_ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := server_ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := setup(server_ctx); err != nil {
+		t.Fatal(err)
+	}
+	client_ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ctx := range []*Ctx{server_ctx, client_ctx} {
+		if err := ctx.SetMaxProtoVersion(TLSv1_2); err != nil {
+			t.Fatal(err)
+		}
+		if err := ctx.SetCipherList("DHE-RSA-AES128-GCM-SHA256"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server_conn, client_conn := NetPipe(t)
+	server, err := Server(server_conn, server_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Client(client_conn, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close_both(server, client)
+	go server.Handshake()
+	if err := client.Handshake(); err != nil {
+		return "", err
+	}
+	return client.CurrentCipher()
+}
+
+func TestCtxSetDHParameters(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		setup func(*Ctx) error
+	}{
+		{"auto", func(ctx *Ctx) error { return ctx.SetDHAuto() }},
+		{"pem", func(ctx *Ctx) error {
+			return ctx.SetDHParametersFromPEM(dhParamsBytes)
+		}},
+	} {
+		cipher, err := dheHandshake(t, test.setup)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !strings.HasPrefix(cipher, "DHE-") {
+			t.Fatalf("%s: unexpected cipher %s", test.name, cipher)
+		}
+	}
+
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.SetDHParametersFromPEM(certBytes); err == nil {
+		t.Fatal("expected an error for a certificate")
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Dialer dials OpenSSL client connections, optionally traversing an HTTP
+// CONNECT or SOCKS5 proxy before the TLS handshake with the server. The
+// socket options mirror those of net.Dialer.
+type Dialer struct {
+	// Timeout bounds the whole dial, including the proxy tunnel and the
+	// handshake. Zero means no timeout beyond the operating system's
+	// connect timeout.
+	Timeout time.Duration
+	// LocalAddr is the local address to dial from, e.g. to pick an
+	// interface. If nil, one is chosen automatically.
+	LocalAddr net.Addr
+	// KeepAlive is the TCP keep-alive period, as with net.Dialer: zero
+	// enables the default period and a negative value disables keep-alives.
+	KeepAlive time.Duration
+	// Resolver looks up host names. If nil, net.DefaultResolver is used.
+	Resolver *net.Resolver
+	// Control is called after creating each socket and before connecting
+	// it, to set socket options.
+	Control func(network, address string, c syscall.RawConn) error
+	// ReusePort sets SO_REUSEPORT on each socket before Control is called,
+	// allowing several connections to bind the same LocalAddr.
+	ReusePort bool
+	// DisableNoDelay clears TCP_NODELAY, which Go sets by default, on
+	// connections to servers dialed directly, enabling Nagle's algorithm.
+	DisableNoDelay bool
+
+	// NetDialer dials the connection to the server or proxy. If set, it is
+	// used as is and the socket options above except Timeout are ignored.
+	NetDialer *net.Dialer
+	// Ctx configures the connections. If nil, a default context is created
+	// per dial, as with Dial.
+	Ctx *Ctx
+	// Flags are the DialFlags applied to every connection.
+	Flags DialFlags
+	// Proxy is the URL of the proxy to tunnel through: "http://host:port"
+	// for HTTP CONNECT, or "socks5://host:port" for SOCKS5. Credentials in
+	// the URL's user info are sent as Basic proxy authorization or SOCKS5
+	// username/password authentication. If nil, servers are dialed
+	// directly.
+	Proxy *url.URL
+}
+
+// Dial connects to addr, through the proxy if one is set, and performs the
+// client handshake.
+func (d *Dialer) Dial(network, addr string) (*Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext is like Dial but aborts connecting, tunneling and the
+// handshake once ctx is done.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (
+	*Conn, error) {
+	return d.dialSession(ctx, network, addr, nil)
+}
+
+// dialSession dials like DialContext, resuming session if it is not nil.
+func (d *Dialer) dialSession(ctx context.Context, network, addr string,
+	session []byte) (*Conn, error) {
+	dial, err := d.dialFunc()
+	if err != nil {
+		return nil, err
+	}
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	return dialSession(ctx, dial, network, addr, d.Ctx, d.Flags, session)
+}
+
+func (d *Dialer) dialFunc() (func(context.Context, string, string) (
+	net.Conn, error), error) {
+	dialer := d.NetDialer
+	sockopts := &socketOptions{
+		reuse_port:   d.ReusePort,
+		control:      d.Control,
+		set_no_delay: d.DisableNoDelay,
+	}
+	if dialer == nil {
+		dialer = &net.Dialer{
+			LocalAddr: d.LocalAddr,
+			KeepAlive: d.KeepAlive,
+			Resolver:  d.Resolver,
+			Control:   sockopts.controlFunc(),
+		}
+	}
+	if d.Proxy == nil {
+		if d.NetDialer != nil || !d.DisableNoDelay {
+			return dialer.DialContext, nil
+		}
+		return func(ctx context.Context, network, addr string) (net.Conn,
+			error) {
+			c, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			if err := sockopts.apply(c); err != nil {
+				c.Close()
+				return nil, err
+			}
+			return c, nil
+		}, nil
+	}
+	switch d.Proxy.Scheme {
+	case "http":
+		return func(ctx context.Context, network, addr string) (net.Conn,
+			error) {
+			return dialHTTPConnect(ctx, dialer, d.Proxy, addr)
+		}, nil
+	case "socks5", "socks5h":
+		socks, err := proxy.FromURL(d.Proxy, dialer)
+		if err != nil {
+			return nil, err
+		}
+		return socks.(proxy.ContextDialer).DialContext, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", d.Proxy.Scheme)
+	}
+}
+
+// dialHTTPConnect opens a tunnel to addr through the HTTP proxy at
+// proxy_url.
+func dialHTTPConnect(ctx context.Context, dialer *net.Dialer,
+	proxy_url *url.URL, addr string) (net.Conn, error) {
+	proxy_addr := proxy_url.Host
+	if proxy_url.Port() == "" {
+		proxy_addr = net.JoinHostPort(proxy_url.Hostname(), "80")
+	}
+	c, err := dialer.DialContext(ctx, "tcp", proxy_addr)
+	if err != nil {
+		return nil, err
+	}
+	stop := watchContext(ctx, c)
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := proxy_url.User; user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+
+			base64.StdEncoding.EncodeToString(
+				[]byte(user.Username()+":"+password)))
+	}
+	err = req.Write(c)
+	var resp *http.Response
+	br := bufio.NewReader(c)
+	if err == nil {
+		resp, err = http.ReadResponse(br, req)
+	}
+	if stop() {
+		c.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.Close()
+		return nil, fmt.Errorf("proxy refused tunnel: %s", resp.Status)
+	}
+	// the server speaks only after the ClientHello, so anything buffered
+	// past the response came from the proxy
+	if br.Buffered() > 0 {
+		c.Close()
+		return nil, errors.New("proxy sent data past its response")
+	}
+	return c, nil
+}
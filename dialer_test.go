@@ -0,0 +1,281 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newTestTLSServer serves one-line echo replies over OpenSSL.
+func newTestTLSServer(t *testing.T, network, addr string) net.Listener {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen(network, addr, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				line, err := bufio.NewReader(c).ReadBytes('\n')
+				if err == nil {
+					c.Write(line)
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+// serveProxy accepts connections on l, opening a tunnel to the address
+// handshake returns for each.
+func serveProxy(l net.Listener, handshake func(net.Conn) (string, error)) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			addr, err := handshake(c)
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", addr)
+			if err != nil {
+				return
+			}
+			defer upstream.Close()
+			go io.Copy(upstream, c)
+			io.Copy(c, upstream)
+		}()
+	}
+}
+
+func httpConnectHandshake(c net.Conn) (string, error) {
+	req, err := http.ReadRequest(bufio.NewReader(c))
+	if err != nil {
+		return "", err
+	}
+	// "user:secret"
+	if req.Method != http.MethodConnect || req.Header.Get(
+		"Proxy-Authorization") != "Basic dXNlcjpzZWNyZXQ=" {
+		io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return "", io.EOF
+	}
+	_, err = io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+	return req.Host, err
+}
+
+func socks5Handshake(c net.Conn) (string, error) {
+	r := bufio.NewReader(c)
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return "", err
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return "", err
+	}
+	// no authentication
+	if _, err := c.Write([]byte{5, 0}); err != nil {
+		return "", err
+	}
+	var req [4]byte
+	if _, err := io.ReadFull(r, req[:]); err != nil {
+		return "", err
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case 3:
+		n, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		name := make([]byte, n)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", io.EOF
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	_, err := c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return net.JoinHostPort(host,
+		strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), err
+}
+
+func testDialerProxy(t *testing.T, scheme string,
+	handshake func(net.Conn) (string, error), user *url.Userinfo) {
+	server := newTestTLSServer(t, "tcp", "localhost:0")
+	defer server.Close()
+	pl, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+	go serveProxy(pl, handshake)
+
+	dialer := &Dialer{
+		Flags: InsecureSkipHostVerification,
+		Proxy: &url.URL{Scheme: scheme, Host: pl.Addr().String(), User: user},
+	}
+	conn, err := dialer.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "hello\n" {
+		t.Fatalf("unexpected reply %q", line)
+	}
+}
+
+func TestDialerHTTPConnect(t *testing.T) {
+	testDialerProxy(t, "http", httpConnectHandshake,
+		url.UserPassword("user", "secret"))
+}
+
+func TestDialerHTTPConnectRefused(t *testing.T) {
+	pl, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+	go serveProxy(pl, httpConnectHandshake)
+	dialer := &Dialer{
+		Proxy: &url.URL{Scheme: "http", Host: pl.Addr().String()},
+	}
+	_, err = dialer.Dial("tcp", "localhost:443")
+	if err == nil {
+		t.Fatal("expected error without proxy credentials")
+	}
+}
+
+func TestDialerSOCKS5(t *testing.T) {
+	testDialerProxy(t, "socks5", socks5Handshake, nil)
+}
+
+func TestDialerUnsupportedProxy(t *testing.T) {
+	dialer := &Dialer{Proxy: &url.URL{Scheme: "ftp", Host: "localhost:21"}}
+	if _, err := dialer.Dial("tcp", "localhost:443"); err == nil {
+		t.Fatal("expected error for unsupported proxy scheme")
+	}
+}
+
+func TestDialerSocketOptions(t *testing.T) {
+	server := newTestTLSServer(t, "tcp", "127.0.0.1:0")
+	defer server.Close()
+	var controlled string
+	dialer := &Dialer{
+		Flags:     InsecureSkipHostVerification,
+		LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		KeepAlive: -1,
+		Control: func(network, address string, c syscall.RawConn) error {
+			controlled = address
+			return nil
+		},
+	}
+	conn, err := dialer.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if controlled != server.Addr().String() {
+		t.Fatalf("control hook saw %q", controlled)
+	}
+	local := conn.LocalAddr().(*net.TCPAddr)
+	if !local.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("unexpected local address %v", local)
+	}
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDialerTimeout(t *testing.T) {
+	// the server accepts but never answers the ClientHello
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(ioutil.Discard, c)
+	}()
+	dialer := &Dialer{Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	if _, err := dialer.Dial("tcp", l.Addr().String()); err == nil {
+		t.Fatal("expected handshake to time out")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("timeout not honored")
+	}
+}
@@ -40,6 +40,12 @@ func GetDigestByName(name string) (*Digest, error) {
 	return &Digest{ptr: p}, nil
 }
 
+// Method returns the digest for use with the signing and verification
+// functions, e.g. for algorithms without a corresponding EVP_MD constant.
+func (d *Digest) Method() Method {
+	return d.ptr
+}
+
 // GetDigestByName returns the Digest with the NID or nil and an error if the
 // digest was not found.
 func GetDigestByNid(nid NID) (*Digest, error) {
@@ -0,0 +1,878 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/mattn/go-pointer"
+)
+
+const (
+	// DefaultDTLSPathMTU is the path MTU DTLS connections assume until told
+	// otherwise with SetMTU: the minimum MTU of IPv6 links, which CoAP
+	// recommends when the path MTU is unknown.
+	DefaultDTLSPathMTU = 1280
+
+	// dtlsRecordHeaderLen is the size of the header of each DTLS record,
+	// whose last two bytes are the length of the record body.
+	dtlsRecordHeaderLen = 13
+
+	// maxDatagramSize bounds the datagrams read from the network.
+	maxDatagramSize = 65535
+
+	// dtlsAcceptBacklog is the number of new peers waiting for Accept and
+	// dtlsPeerBacklog the number of datagrams waiting for each peer. Past
+	// them datagrams are dropped, as the network would.
+	dtlsAcceptBacklog = 64
+	dtlsPeerBacklog   = 32
+)
+
+// NewDTLSCtx creates a context for DTLS connections made with DialDTLS,
+// DTLSClient and DTLS listeners. It supports DTLS 1.0 and 1.2; restrict it
+// with SetMinProtoVersion(DTLSv1_2) where possible.
+func NewDTLSCtx() (*Ctx, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	ctx := C.SSL_CTX_new(C.X_DTLS_method())
+	if ctx == nil {
+		return nil, errorFromErrorQueue()
+	}
+	c := &Ctx{ctx: ctx}
+	C.SSL_CTX_set_ex_data(ctx, get_ssl_ctx_idx(), pointer.Save(c))
+	runtime.SetFinalizer(c, func(c *Ctx) {
+		C.SSL_CTX_free(c.ctx)
+	})
+	return c, nil
+}
+
+// dtlsTransport moves the datagrams of a single DTLS association.
+type dtlsTransport interface {
+	// recv returns the next datagram from the peer, valid until the next
+	// call, failing with a timeout error once deadline passes.
+	recv(deadline time.Time) ([]byte, error)
+	send(datagram []byte) error
+	// close unblocks recv for good.
+	close() error
+	localAddr() net.Addr
+	remoteAddr() net.Addr
+}
+
+// DTLSConn is a DTLS connection, implementing net.Conn with the message
+// semantics of datagrams: each Write is sent as one record in one datagram,
+// and each Read returns the data of one record. The handshake runs before
+// the first Read or Write unless Handshake is called first, retransmitting
+// lost flights with OpenSSL's timers.
+type DTLSConn struct {
+	*SSL
+	ctx       *Ctx // for gc
+	transport dtlsTransport
+
+	// guards the SSL object and its BIOs
+	mtx  sync.Mutex
+	rbio *C.BIO
+	wbio *C.BIO
+	// datagram size, the path MTU minus IP and UDP headers
+	mtu    int
+	closed bool
+
+	handshake_mtx  sync.Mutex
+	handshake_done bool
+	handshake_err  error
+
+	// lets only one Read receive datagrams at a time
+	read_mtx sync.Mutex
+
+	deadline_mtx   sync.Mutex
+	read_deadline  time.Time
+	write_deadline time.Time
+}
+
+func newDTLSConn(ctx *Ctx, transport dtlsTransport, path_mtu int) (*DTLSConn,
+	error) {
+	ssl, err := newSSL(ctx.ctx)
+	if err != nil {
+		return nil, err
+	}
+	rbio := C.BIO_new(C.BIO_s_mem())
+	wbio := C.BIO_new(C.BIO_s_mem())
+	if rbio == nil || wbio == nil {
+		// these frees are null safe
+		C.BIO_free(rbio)
+		C.BIO_free(wbio)
+		C.SSL_free(ssl)
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	// an empty read BIO means "wait for the next datagram", not EOF
+	C.BIO_ctrl(rbio, C.BIO_C_SET_BUF_MEM_EOF_RETURN, -1, nil)
+	// the ssl object takes ownership of these objects now
+	C.SSL_set_bio(ssl, rbio, wbio)
+	// memory BIOs cannot report the MTU, which is set explicitly instead
+	C.X_SSL_set_options(ssl, C.SSL_OP_NO_QUERY_MTU)
+
+	s := &SSL{ssl: ssl}
+	C.SSL_set_ex_data(s.ssl, get_ssl_idx(), pointer.Save(s))
+	c := &DTLSConn{
+		SSL:       s,
+		ctx:       ctx,
+		transport: transport,
+		rbio:      rbio,
+		wbio:      wbio,
+	}
+	runtime.SetFinalizer(c, func(c *DTLSConn) {
+		C.SSL_free(c.ssl)
+	})
+	if err := c.SetMTU(path_mtu); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetMTU sets the path MTU of the connection, the largest IP packet that
+// reaches the peer unfragmented, e.g. after path MTU discovery. Records and
+// handshake messages are sized so that each datagram fits it, including
+// IP and UDP headers.
+func (c *DTLSConn) SetMTU(path_mtu int) error {
+	header := 20 + 8
+	if addr, ok := c.transport.remoteAddr().(*net.UDPAddr); ok &&
+		addr.IP.To4() == nil {
+		header = 40 + 8
+	}
+	mtu := path_mtu - header
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if mtu <= 0 || C.X_SSL_set_mtu(c.ssl, C.long(mtu)) <= 0 {
+		return fmt.Errorf("openssl: path MTU %d too small for DTLS", path_mtu)
+	}
+	c.mtu = mtu
+	return nil
+}
+
+// MaxPayload returns the largest Write that fits a single datagram with the
+// current cipher and MTU, or 0 before the handshake. Requires OpenSSL 1.1.1
+// or later; older versions always return 0.
+func (c *DTLSConn) MaxPayload() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if C.SSL_is_init_finished(c.ssl) == 0 {
+		return 0
+	}
+	return int(C.X_DTLS_get_data_mtu(c.ssl))
+}
+
+// flush sends the records OpenSSL wrote, packing as many as fit the MTU
+// into each datagram. c.mtx must be held.
+func (c *DTLSConn) flush() error {
+	pending := int(C.BIO_ctrl_pending(c.wbio))
+	if pending == 0 {
+		return nil
+	}
+	buf := make([]byte, pending)
+	n := C.BIO_read(c.wbio, unsafe.Pointer(&buf[0]), C.int(pending))
+	if n <= 0 {
+		return errors.New("failed to read DTLS records")
+	}
+	buf = buf[:n]
+	start := 0
+	for off := 0; off < len(buf); {
+		if len(buf)-off < dtlsRecordHeaderLen {
+			return errors.New("truncated DTLS record")
+		}
+		end := off + dtlsRecordHeaderLen +
+			(int(buf[off+11])<<8 | int(buf[off+12]))
+		if end > len(buf) {
+			return errors.New("truncated DTLS record")
+		}
+		if end-start > c.mtu && off > start {
+			if err := c.transport.send(buf[start:off]); err != nil {
+				return err
+			}
+			start = off
+		}
+		off = end
+	}
+	return c.transport.send(buf[start:])
+}
+
+// timer returns how long until OpenSSL wants to retransmit, if it does.
+// c.mtx must be held.
+func (c *DTLSConn) timer() (time.Duration, bool) {
+	var usec C.longlong
+	if C.X_DTLSv1_get_timeout(c.ssl, &usec) == 0 {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// sslError turns the result of a failed SSL call into an error. It must run
+// on the thread of the call; want_read reports whether more datagrams are
+// needed instead.
+func (c *DTLSConn) sslError(rv C.int) (want_read bool, err error) {
+	errcode := C.SSL_get_error(c.ssl, rv)
+	switch errcode {
+	case C.SSL_ERROR_WANT_READ:
+		C.ERR_clear_error()
+		return true, nil
+	case C.SSL_ERROR_ZERO_RETURN:
+		C.ERR_clear_error()
+		return false, io.EOF
+	}
+	e := drainErrorQueue()
+	e.Class = ErrorClass(errcode)
+	e.VerifyResult = VerifyResult(C.SSL_get_verify_result(c.ssl))
+	e.Handshake = C.SSL_is_init_finished(c.ssl) == 0
+	return false, e
+}
+
+// do runs op on the SSL object and sends what it wrote. It returns how long
+// until the next retransmission if op needs more datagrams.
+func (c *DTLSConn) do(op func() C.int) (rv C.int, want_read bool,
+	timer time.Duration, has_timer bool, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.closed {
+		return 0, false, 0, false, net.ErrClosed
+	}
+	runtime.LockOSThread()
+	rv = op()
+	if rv <= 0 {
+		want_read, err = c.sslError(rv)
+	}
+	runtime.UnlockOSThread()
+	if ferr := c.flush(); ferr != nil && err == nil {
+		err = ferr
+	}
+	if want_read {
+		timer, has_timer = c.timer()
+	}
+	return rv, want_read, timer, has_timer, err
+}
+
+// receive waits for the next datagram and hands it to OpenSSL, or lets
+// OpenSSL retransmit once its timer expires first.
+func (c *DTLSConn) receive(timer time.Duration, has_timer bool,
+	deadline time.Time) error {
+	wait := deadline
+	if has_timer {
+		retransmit := time.Now().Add(timer)
+		if wait.IsZero() || retransmit.Before(wait) {
+			wait = retransmit
+		}
+	}
+	datagram, err := c.transport.recv(wait)
+	if err != nil {
+		if !isTimeout(err) {
+			return err
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return os.ErrDeadlineExceeded
+		}
+		_, _, _, _, err := c.do(func() C.int {
+			if C.X_DTLSv1_handle_timeout(c.ssl) < 0 {
+				return -1
+			}
+			return 1
+		})
+		return err
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	if C.BIO_write(c.rbio, unsafe.Pointer(&datagram[0]),
+		C.int(len(datagram))) != C.int(len(datagram)) {
+		return errors.New("failed to buffer datagram")
+	}
+	return nil
+}
+
+// Handshake performs the DTLS handshake if it has not run yet.
+func (c *DTLSConn) Handshake() error {
+	c.handshake_mtx.Lock()
+	defer c.handshake_mtx.Unlock()
+	if c.handshake_done {
+		return c.handshake_err
+	}
+	for {
+		rv, want_read, timer, has_timer, err := c.do(func() C.int {
+			return C.SSL_do_handshake(c.ssl)
+		})
+		if rv == 1 || (err != nil && !want_read) {
+			c.handshake_done = true
+			c.handshake_err = err
+			return err
+		}
+		c.deadline_mtx.Lock()
+		deadline := c.read_deadline
+		if deadline.IsZero() || (!c.write_deadline.IsZero() &&
+			c.write_deadline.Before(deadline)) {
+			deadline = c.write_deadline
+		}
+		c.deadline_mtx.Unlock()
+		if err := c.receive(timer, has_timer, deadline); err != nil {
+			if err == os.ErrDeadlineExceeded {
+				// the handshake may resume with a later deadline
+				return err
+			}
+			c.handshake_done = true
+			c.handshake_err = err
+			return err
+		}
+	}
+}
+
+// Read reads the data of the next record. b should have room for
+// MaxPayload bytes; the rest of a longer record is returned by the next
+// Read. Read returns io.EOF once the peer closed the connection.
+func (c *DTLSConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.read_mtx.Lock()
+	defer c.read_mtx.Unlock()
+	for {
+		rv, want_read, timer, has_timer, err := c.do(func() C.int {
+			return C.SSL_read(c.ssl, unsafe.Pointer(&b[0]), C.int(len(b)))
+		})
+		if rv > 0 {
+			return int(rv), nil
+		}
+		if !want_read {
+			return 0, err
+		}
+		c.deadline_mtx.Lock()
+		deadline := c.read_deadline
+		c.deadline_mtx.Unlock()
+		if err := c.receive(timer, has_timer, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write sends b as one record in one datagram. b must not be longer than
+// MaxPayload, as the datagram would have to be fragmented otherwise.
+func (c *DTLSConn) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if max := c.MaxPayload(); max > 0 && len(b) > max {
+		return 0, fmt.Errorf("openssl: message of %d bytes exceeds the "+
+			"DTLS payload limit of %d", len(b), max)
+	}
+	c.deadline_mtx.Lock()
+	deadline := c.write_deadline
+	c.deadline_mtx.Unlock()
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	rv, _, _, _, err := c.do(func() C.int {
+		return C.SSL_write(c.ssl, unsafe.Pointer(&b[0]), C.int(len(b)))
+	})
+	if rv <= 0 {
+		if err == nil {
+			err = errors.New("openssl: DTLS write failed")
+		}
+		return 0, err
+	}
+	return int(rv), err
+}
+
+// Close sends close_notify to the peer, without waiting for its reply, and
+// releases the transport. It unblocks pending reads.
+func (c *DTLSConn) Close() error {
+	c.mtx.Lock()
+	if c.closed {
+		c.mtx.Unlock()
+		return nil
+	}
+	if C.SSL_is_init_finished(c.ssl) != 0 {
+		runtime.LockOSThread()
+		C.SSL_shutdown(c.ssl)
+		C.ERR_clear_error()
+		runtime.UnlockOSThread()
+		c.flush()
+	}
+	c.closed = true
+	c.mtx.Unlock()
+	return c.transport.close()
+}
+
+// PeerCertificate returns the certificate the peer presented.
+func (c *DTLSConn) PeerCertificate() (*Certificate, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	x := C.SSL_get_peer_certificate(c.ssl)
+	if x == nil {
+		return nil, errors.New("no peer certificate found")
+	}
+	cert := &Certificate{x: x}
+	runtime.SetFinalizer(cert, func(cert *Certificate) {
+		C.X509_free(cert.x)
+	})
+	return cert, nil
+}
+
+// LocalAddr returns the local address of the underlying packet connection.
+func (c *DTLSConn) LocalAddr() net.Addr {
+	return c.transport.localAddr()
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *DTLSConn) RemoteAddr() net.Addr {
+	return c.transport.remoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines. Both bound the handshake.
+func (c *DTLSConn) SetDeadline(t time.Time) error {
+	c.deadline_mtx.Lock()
+	defer c.deadline_mtx.Unlock()
+	c.read_deadline = t
+	c.write_deadline = t
+	return nil
+}
+
+// SetReadDeadline sets the deadline of Read and the handshake.
+func (c *DTLSConn) SetReadDeadline(t time.Time) error {
+	c.deadline_mtx.Lock()
+	defer c.deadline_mtx.Unlock()
+	c.read_deadline = t
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of Write and the handshake. Sending a
+// datagram does not block, so it only fails writes started too late.
+func (c *DTLSConn) SetWriteDeadline(t time.Time) error {
+	c.deadline_mtx.Lock()
+	defer c.deadline_mtx.Unlock()
+	c.write_deadline = t
+	return nil
+}
+
+// dtlsClientTransport exchanges datagrams with a single server over a
+// packet connection of its own, ignoring datagrams from other addresses.
+type dtlsClientTransport struct {
+	pc    net.PacketConn
+	raddr net.Addr
+	owned bool
+	buf   []byte
+}
+
+func (t *dtlsClientTransport) recv(deadline time.Time) ([]byte, error) {
+	if err := t.pc.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	for {
+		n, addr, err := t.pc.ReadFrom(t.buf)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 && addr.String() == t.raddr.String() {
+			return t.buf[:n], nil
+		}
+	}
+}
+
+func (t *dtlsClientTransport) send(datagram []byte) error {
+	_, err := t.pc.WriteTo(datagram, t.raddr)
+	return err
+}
+
+func (t *dtlsClientTransport) close() error {
+	if t.owned {
+		return t.pc.Close()
+	}
+	return t.pc.SetReadDeadline(aLongTimeAgo)
+}
+
+func (t *dtlsClientTransport) localAddr() net.Addr  { return t.pc.LocalAddr() }
+func (t *dtlsClientTransport) remoteAddr() net.Addr { return t.raddr }
+
+// DTLSClient makes a DTLS client connection to raddr over pc, which may be
+// shared with nothing else reading from it, and puts it in the connect
+// state. As with Client, verifying the server's name is up to the caller.
+// The connection does not close pc.
+func DTLSClient(pc net.PacketConn, raddr net.Addr, ctx *Ctx) (*DTLSConn,
+	error) {
+	return newDTLSClient(&dtlsClientTransport{pc: pc, raddr: raddr,
+		buf: make([]byte, maxDatagramSize)}, ctx)
+}
+
+func newDTLSClient(t *dtlsClientTransport, ctx *Ctx) (*DTLSConn, error) {
+	c, err := newDTLSConn(ctx, t, DefaultDTLSPathMTU)
+	if err != nil {
+		return nil, err
+	}
+	C.SSL_set_connect_state(c.ssl)
+	return c, nil
+}
+
+// DialDTLS connects to the host:port addr on the "udp", "udp4" or "udp6"
+// network using a context made by NewDTLSCtx, and performs the handshake.
+// Host names are sent as SNI and, when the context verifies peers, checked
+// against the server certificate.
+func DialDTLS(network, addr string, ctx *Ctx) (*DTLSConn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	raddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newDTLSClient(&dtlsClientTransport{pc: pc, raddr: raddr,
+		owned: true, buf: make([]byte, maxDatagramSize)}, ctx)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	if net.ParseIP(host) == nil {
+		chost := C.CString(host)
+		defer C.free(unsafe.Pointer(chost))
+		if C.X_SSL_set_tlsext_host_name(c.ssl, chost) == 0 ||
+			C.SSL_set1_host(c.ssl, chost) != 1 {
+			c.Close()
+			return nil, errors.New("failed to set server name")
+		}
+	}
+	if err := c.Handshake(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// DTLSListener accepts DTLS connections from the peers sending to a single
+// packet connection, implementing net.Listener. Datagrams are dispatched to
+// connections by source address. New peers first have to echo a cookie in a
+// HelloVerifyRequest exchange, so that ClientHellos from spoofed addresses
+// cost neither state nor more than a small reply.
+type DTLSListener struct {
+	pc  net.PacketConn
+	ctx *Ctx
+
+	mtx      sync.Mutex
+	path_mtu int
+	peers    map[string]*dtlsServerTransport
+	err      error
+
+	// the key of the cookies, and the connection listening for the next
+	// peer, only used by serve
+	secret  []byte
+	pending *DTLSConn
+
+	accept chan *DTLSConn
+	done   chan struct{}
+	close  sync.Once
+}
+
+// ListenDTLS listens for DTLS connections on the local address laddr of the
+// "udp", "udp4" or "udp6" network, using a context made by NewDTLSCtx that
+// has a certificate and key.
+func ListenDTLS(network, laddr string, ctx *Ctx) (*DTLSListener, error) {
+	if ctx == nil {
+		return nil, errors.New("no ssl context provided")
+	}
+	pc, err := net.ListenPacket(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	l, err := NewDTLSListener(pc, ctx)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// NewDTLSListener accepts DTLS connections on pc, which it reads from from
+// now on and closes with the listener. It installs the cookie callbacks of
+// ctx and requires OpenSSL 1.1.1 or later.
+func NewDTLSListener(pc net.PacketConn, ctx *Ctx) (*DTLSListener, error) {
+	if C.OPENSSL_VERSION_NUMBER < 0x1010100f {
+		return nil, errors.New("DTLS listeners require OpenSSL 1.1.1")
+	}
+	secret := make([]byte, sha256.Size)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	C.SSL_CTX_set_cookie_generate_cb(ctx.ctx,
+		(*[0]byte)(C.X_DTLS_cookie_generate_cb))
+	C.SSL_CTX_set_cookie_verify_cb(ctx.ctx,
+		(*[0]byte)(C.X_DTLS_cookie_verify_cb))
+	l := &DTLSListener{
+		pc:       pc,
+		ctx:      ctx,
+		path_mtu: DefaultDTLSPathMTU,
+		peers:    make(map[string]*dtlsServerTransport),
+		secret:   secret,
+		accept:   make(chan *DTLSConn, dtlsAcceptBacklog),
+		done:     make(chan struct{}),
+	}
+	go l.serve()
+	return l, nil
+}
+
+// SetMTU sets the path MTU of connections accepted from now on, see
+// DTLSConn.SetMTU.
+func (l *DTLSListener) SetMTU(path_mtu int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.path_mtu = path_mtu
+}
+
+// Accept waits for the next peer. The handshake runs on the first Read or
+// Write of the returned *DTLSConn, or when calling its Handshake method.
+func (l *DTLSListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		return nil, l.err
+	}
+}
+
+// Close stops the listener and closes the packet connection, ending the
+// connections accepted from it.
+func (l *DTLSListener) Close() error {
+	err := net.ErrClosed
+	l.close.Do(func() {
+		err = l.pc.Close()
+	})
+	return err
+}
+
+// Addr returns the local address of the packet connection.
+func (l *DTLSListener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+func (l *DTLSListener) serve() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			var net_err net.Error
+			if errors.As(err, &net_err) && net_err.Timeout() {
+				continue
+			}
+			l.mtx.Lock()
+			l.err = err
+			if errors.Is(err, net.ErrClosed) {
+				l.err = net.ErrClosed
+			}
+			l.mtx.Unlock()
+			close(l.done)
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		l.mtx.Lock()
+		t := l.peers[addr.String()]
+		l.mtx.Unlock()
+		if t == nil {
+			// 22 is the handshake content type
+			if buf[0] == 22 {
+				l.listen(addr, buf[:n])
+			}
+			continue
+		}
+		datagram := append([]byte(nil), buf[:n]...)
+		select {
+		case t.in <- datagram:
+		default:
+		}
+	}
+}
+
+// cookie returns the cookie of the peer at addr.
+func (l *DTLSListener) cookie(addr net.Addr) []byte {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(addr.String()))
+	return mac.Sum(nil)
+}
+
+// listen answers a ClientHello from the new peer at addr with a
+// HelloVerifyRequest, unless it carries the peer's cookie, in which case the
+// pending connection is bound to the peer and queued for Accept.
+func (l *DTLSListener) listen(addr net.Addr, datagram []byte) {
+	if len(l.accept) == cap(l.accept) {
+		// the peer retries once Accept caught up
+		return
+	}
+	if l.pending == nil {
+		t := &dtlsServerTransport{
+			l:    l,
+			in:   make(chan []byte, dtlsPeerBacklog),
+			done: make(chan struct{}),
+		}
+		c, err := newDTLSConn(l.ctx, t, DefaultDTLSPathMTU)
+		if err != nil {
+			return
+		}
+		C.SSL_set_accept_state(c.ssl)
+		l.pending = c
+	}
+	c := l.pending
+	t := c.transport.(*dtlsServerTransport)
+	t.key = addr.String()
+	t.raddr = addr
+	c.dtls_cookie = l.cookie(addr)
+
+	c.mtx.Lock()
+	rv := C.int(-1)
+	if C.BIO_write(c.rbio, unsafe.Pointer(&datagram[0]),
+		C.int(len(datagram))) == C.int(len(datagram)) {
+		runtime.LockOSThread()
+		rv = C.X_DTLSv1_listen(c.ssl)
+		C.ERR_clear_error()
+		runtime.UnlockOSThread()
+	}
+	// sends the HelloVerifyRequest, if any
+	c.flush()
+	C.BIO_ctrl(c.rbio, C.BIO_CTRL_RESET, 0, nil)
+	c.mtx.Unlock()
+	if rv < 0 {
+		// start over with a fresh connection
+		l.pending = nil
+	}
+	if rv != 1 {
+		return
+	}
+
+	l.pending = nil
+	l.mtx.Lock()
+	path_mtu := l.path_mtu
+	l.peers[t.key] = t
+	l.mtx.Unlock()
+	if c.SetMTU(path_mtu) != nil {
+		t.close()
+		return
+	}
+	l.accept <- c
+}
+
+//export go_dtls_cookie_generate_thunk
+func go_dtls_cookie_generate_thunk(p unsafe.Pointer, cookie *C.uchar,
+	cookie_len *C.uint) C.int {
+	defer func() {
+		if err := recover(); err != nil {
+			logCallbackPanic("DTLS cookie callback", err)
+			os.Exit(1)
+		}
+	}()
+	s := pointer.Restore(p).(*SSL)
+	if len(s.dtls_cookie) == 0 {
+		return 0
+	}
+	C.memcpy(unsafe.Pointer(cookie), unsafe.Pointer(&s.dtls_cookie[0]),
+		C.size_t(len(s.dtls_cookie)))
+	*cookie_len = C.uint(len(s.dtls_cookie))
+	return 1
+}
+
+//export go_dtls_cookie_verify_thunk
+func go_dtls_cookie_verify_thunk(p unsafe.Pointer, cookie *C.uchar,
+	cookie_len C.uint) C.int {
+	defer func() {
+		if err := recover(); err != nil {
+			logCallbackPanic("DTLS cookie callback", err)
+			os.Exit(1)
+		}
+	}()
+	s := pointer.Restore(p).(*SSL)
+	if len(s.dtls_cookie) == 0 || !hmac.Equal(s.dtls_cookie,
+		C.GoBytes(unsafe.Pointer(cookie), C.int(cookie_len))) {
+		return 0
+	}
+	return 1
+}
+
+// dtlsServerTransport receives the datagrams a listener dispatches to one
+// peer and sends through the listener's packet connection.
+type dtlsServerTransport struct {
+	l     *DTLSListener
+	key   string
+	raddr net.Addr
+	in    chan []byte
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (t *dtlsServerTransport) recv(deadline time.Time) ([]byte, error) {
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case datagram := <-t.in:
+		return datagram, nil
+	case <-expired:
+		return nil, os.ErrDeadlineExceeded
+	case <-t.done:
+		return nil, net.ErrClosed
+	case <-t.l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (t *dtlsServerTransport) send(datagram []byte) error {
+	_, err := t.l.pc.WriteTo(datagram, t.raddr)
+	return err
+}
+
+func (t *dtlsServerTransport) close() error {
+	t.once.Do(func() {
+		t.l.mtx.Lock()
+		if t.l.peers[t.key] == t {
+			delete(t.l.peers, t.key)
+		}
+		t.l.mtx.Unlock()
+		close(t.done)
+	})
+	return nil
+}
+
+func (t *dtlsServerTransport) localAddr() net.Addr  { return t.l.pc.LocalAddr() }
+func (t *dtlsServerTransport) remoteAddr() net.Addr { return t.raddr }
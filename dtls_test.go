@@ -0,0 +1,368 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newDTLSTestCtxs(t testing.TB) (server, client *Ctx) {
+	server, err := NewDTLSCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	client, err = NewDTLSCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetVerify(VerifyNone, nil)
+	return server, client
+}
+
+// sizePacketConn records the size of the datagrams written to it and drops
+// the first drop of them.
+type sizePacketConn struct {
+	net.PacketConn
+
+	mtx   sync.Mutex
+	drop  int
+	sizes []int
+}
+
+func (c *sizePacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.sizes = append(c.sizes, len(b))
+	if c.drop > 0 {
+		c.drop--
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func (c *sizePacketConn) maxSize() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	max := 0
+	for _, size := range c.sizes {
+		if size > max {
+			max = size
+		}
+	}
+	return max
+}
+
+func listenDTLSTest(t testing.TB, ctx *Ctx, drop int) (*DTLSListener,
+	*sizePacketConn) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spc := &sizePacketConn{PacketConn: pc, drop: drop}
+	l, err := NewDTLSListener(spc, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, spc
+}
+
+func dialDTLSTest(t testing.TB, l *DTLSListener, ctx *Ctx, drop int) (
+	*DTLSConn, *sizePacketConn) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	spc := &sizePacketConn{PacketConn: pc, drop: drop}
+	c, err := DTLSClient(spc, l.Addr(), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, spc
+}
+
+// echoDTLS echoes the messages of the first connection of l until it ends,
+// then sends its final error.
+func echoDTLS(l *DTLSListener) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, err := c.Read(buf)
+			if err != nil {
+				done <- err
+				return
+			}
+			if _, err := c.Write(buf[:n]); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	return done
+}
+
+func TestDTLSEcho(t *testing.T) {
+	server_ctx, client_ctx := newDTLSTestCtxs(t)
+	l, err := ListenDTLS("udp", "127.0.0.1:0", server_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	done := echoDTLS(l)
+
+	c, err := DialDTLS("udp", l.Addr().String(), client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 1024)
+	for _, msg := range []string{"first", "second message", "third"} {
+		if _, err := c.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != msg {
+			t.Fatalf("got %q, expected %q", buf[:n], msg)
+		}
+	}
+	if c.MaxPayload() <= 0 {
+		t.Fatalf("expected a payload limit, got %d", c.MaxPayload())
+	}
+	if _, err := c.PeerCertificate(); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Fatalf("expected io.EOF on the server, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not see the connection close")
+	}
+	if _, err := c.Write([]byte("late")); err == nil {
+		t.Fatal("expected writes after Close to fail")
+	}
+}
+
+func TestDTLSRetransmitsLostFlights(t *testing.T) {
+	server_ctx, client_ctx := newDTLSTestCtxs(t)
+	// lose the first server flight, which the client has to wait out
+	l, _ := listenDTLSTest(t, server_ctx, 1)
+	defer l.Close()
+	done := echoDTLS(l)
+	c, _ := dialDTLSTest(t, l, client_ctx, 0)
+	defer c.Close()
+
+	c.SetDeadline(time.Now().Add(20 * time.Second))
+	if err := c.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Fatalf("got %q", buf[:n])
+	}
+	c.Close()
+	<-done
+}
+
+func TestDTLSMTU(t *testing.T) {
+	server_ctx, client_ctx := newDTLSTestCtxs(t)
+	const path_mtu = 576
+	l, server_pc := listenDTLSTest(t, server_ctx, 0)
+	defer l.Close()
+	l.SetMTU(path_mtu)
+	done := echoDTLS(l)
+	c, client_pc := dialDTLSTest(t, l, client_ctx, 0)
+	defer c.Close()
+	if err := c.SetMTU(path_mtu); err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := c.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	max := c.MaxPayload()
+	if max <= 0 || max > path_mtu-28 {
+		t.Fatalf("unexpected payload limit %d", max)
+	}
+	msg := bytes.Repeat([]byte("x"), max)
+	if _, err := c.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, maxDatagramSize)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], msg) {
+		t.Fatal("echo mismatch")
+	}
+	if _, err := c.Write(append(msg, 'x')); err == nil {
+		t.Fatal("expected a message over the payload limit to fail")
+	}
+	for name, pc := range map[string]*sizePacketConn{
+		"server": server_pc, "client": client_pc} {
+		if size := pc.maxSize(); size > path_mtu-28 {
+			t.Fatalf("%s sent a %d byte datagram", name, size)
+		}
+	}
+	if err := c.SetMTU(20); err == nil {
+		t.Fatal("expected a path MTU below the headers to fail")
+	}
+	c.Close()
+	<-done
+}
+
+func TestDTLSReadDeadline(t *testing.T) {
+	server_ctx, client_ctx := newDTLSTestCtxs(t)
+	l, _ := listenDTLSTest(t, server_ctx, 0)
+	defer l.Close()
+	done := echoDTLS(l)
+	c, _ := dialDTLSTest(t, l, client_ctx, 0)
+	defer c.Close()
+
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := c.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := c.Read(make([]byte, 16)); err != os.ErrDeadlineExceeded {
+		t.Fatalf("expected os.ErrDeadlineExceeded, got %v", err)
+	}
+	// the connection survives the timeout
+	c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := c.Read(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("got %q, %v", buf[:n], err)
+	}
+	c.Close()
+	<-done
+}
+
+func TestDTLSListenerCookieExchange(t *testing.T) {
+	server_ctx, client_ctx := newDTLSTestCtxs(t)
+	l, server_pc := listenDTLSTest(t, server_ctx, 0)
+	defer l.Close()
+
+	// capture a ClientHello without a cookie
+	sink, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	capture, err := DTLSClient(sink, sink.LocalAddr(), client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go capture.Handshake()
+	defer capture.Close()
+	sink.SetReadDeadline(time.Now().Add(5 * time.Second))
+	hello := make([]byte, maxDatagramSize)
+	n, _, err := sink.ReadFrom(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello = hello[:n]
+
+	// replayed from another address, as by an attacker spoofing its victim,
+	// it only earns a HelloVerifyRequest smaller than itself
+	victim, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer victim.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := victim.WriteTo(hello, l.Addr()); err != nil {
+			t.Fatal(err)
+		}
+		victim.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reply := make([]byte, maxDatagramSize)
+		n, _, err := victim.ReadFrom(reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 3 is the HelloVerifyRequest message type
+		if n >= len(hello) || reply[0] != 22 || reply[13] != 3 {
+			t.Fatalf("expected a small HelloVerifyRequest, got %d bytes", n)
+		}
+	}
+	l.mtx.Lock()
+	peers := len(l.peers)
+	l.mtx.Unlock()
+	if peers != 0 || len(l.accept) != 0 {
+		t.Fatalf("unverified ClientHellos made %d peers", peers)
+	}
+	if size := server_pc.maxSize(); size >= len(hello) {
+		t.Fatalf("server sent a %d byte datagram", size)
+	}
+
+	// real clients echo the cookie and connect
+	done := echoDTLS(l)
+	c, _ := dialDTLSTest(t, l, client_ctx, 0)
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	if n, err := c.Read(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("got %q, %v", buf[:n], err)
+	}
+	c.Close()
+	<-done
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+)
+
+// EarlyDataStatus reports what became of TLS 1.3 early (0-RTT) data.
+type EarlyDataStatus int
+
+const (
+	// EarlyDataNotSent means the client sent no early data.
+	EarlyDataNotSent EarlyDataStatus = C.SSL_EARLY_DATA_NOT_SENT
+	// EarlyDataRejected means the client sent early data that the server
+	// skipped, and that has to be sent again after the handshake.
+	EarlyDataRejected EarlyDataStatus = C.SSL_EARLY_DATA_REJECTED
+	// EarlyDataAccepted means the server processed the client's early data.
+	EarlyDataAccepted EarlyDataStatus = C.SSL_EARLY_DATA_ACCEPTED
+)
+
+func (s EarlyDataStatus) String() string {
+	switch s {
+	case EarlyDataNotSent:
+		return "not sent"
+	case EarlyDataRejected:
+		return "rejected"
+	case EarlyDataAccepted:
+		return "accepted"
+	}
+	return fmt.Sprintf("EarlyDataStatus(%d)", int(s))
+}
+
+// SetMaxEarlyData sets the most TLS 1.3 early data, in bytes, that servers
+// made from the context accept per connection, and advertise in the session
+// tickets they issue. Clients sending more than that fail the handshake. Zero,
+// the default, disables early data. Requires OpenSSL 1.1.1 or newer.
+func (c *Ctx) SetMaxEarlyData(max uint32) error {
+	if C.X_SSL_CTX_set_max_early_data(c.ctx, C.uint32_t(max)) != 1 {
+		return errors.New("failed to set maximum early data")
+	}
+	return nil
+}
+
+// MaxEarlyData returns the limit set with SetMaxEarlyData.
+func (c *Ctx) MaxEarlyData() uint32 {
+	return uint32(C.X_SSL_CTX_get_max_early_data(c.ctx))
+}
+
+// EarlyDataStatus reports whether early data was sent on the connection and,
+// if so, whether the server accepted it. It is meaningful once the handshake
+// completed.
+func (c *Conn) EarlyDataStatus() EarlyDataStatus {
+	return EarlyDataStatus(C.X_SSL_get_early_data_status(c.ssl))
+}
+
+// PeerMaxEarlyData returns how much early data, in bytes, the server accepts
+// when resuming the connection's current session, as advertised in its
+// session ticket. It is zero if the server accepts no early data, or before
+// a TLS 1.3 session ticket arrived.
+func (c *Conn) PeerMaxEarlyData() uint32 {
+	return uint32(C.X_SSL_get_session_max_early_data(c.ssl))
+}
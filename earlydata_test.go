@@ -0,0 +1,83 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"io"
+	"testing"
+)
+
+func TestCtxSetMaxEarlyData(t *testing.T) {
+	server_ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server_ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := server_ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	if server_ctx.MaxEarlyData() != 0 {
+		t.Fatal("expected early data to be disabled by default")
+	}
+	if err := server_ctx.SetMaxEarlyData(16384); err != nil {
+		t.Fatal(err)
+	}
+	if max := server_ctx.MaxEarlyData(); max != 16384 {
+		t.Fatalf("unexpected maximum early data %d", max)
+	}
+	client_ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server_conn, client_conn := NetPipe(t)
+	server, err := Server(server_conn, server_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Client(client_conn, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close_both(server, client)
+	handshakeBoth(t, server, client)
+
+	// the session ticket advertising the limit follows the handshake
+	go io.Copy(server, server)
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if max := client.PeerMaxEarlyData(); max != 16384 {
+		t.Fatalf("unexpected peer maximum early data %d", max)
+	}
+	for _, conn := range []*Conn{server, client} {
+		if status := conn.EarlyDataStatus(); status != EarlyDataNotSent {
+			t.Fatalf("unexpected early data status %v", status)
+		}
+	}
+}
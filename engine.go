@@ -15,7 +15,9 @@
 package openssl
 
 /*
+#include "shim.h"
 #include "openssl/engine.h"
+#include "openssl/conf.h"
 */
 import "C"
 
@@ -25,6 +27,32 @@ import (
 	"unsafe"
 )
 
+// EngineMethod selects the algorithm classes an engine provides by default.
+type EngineMethod uint
+
+const (
+	EngineMethodRSA           EngineMethod = C.ENGINE_METHOD_RSA
+	EngineMethodDSA           EngineMethod = C.ENGINE_METHOD_DSA
+	EngineMethodDH            EngineMethod = C.ENGINE_METHOD_DH
+	EngineMethodRAND          EngineMethod = C.ENGINE_METHOD_RAND
+	EngineMethodCiphers       EngineMethod = C.ENGINE_METHOD_CIPHERS
+	EngineMethodDigests       EngineMethod = C.ENGINE_METHOD_DIGESTS
+	EngineMethodPKeyMeths     EngineMethod = C.ENGINE_METHOD_PKEY_METHS
+	EngineMethodPKeyASN1Meths EngineMethod = C.ENGINE_METHOD_PKEY_ASN1_METHS
+	EngineMethodEC            EngineMethod = C.ENGINE_METHOD_EC
+	EngineMethodAll           EngineMethod = C.ENGINE_METHOD_ALL
+)
+
+// EngineCommand is a control command as accepted by ENGINE_ctrl_cmd_string,
+// e.g. {"SO_PATH", "/usr/lib/engines/pkcs11.so"}. Commands without an
+// argument leave Value empty.
+type EngineCommand struct {
+	Name  string
+	Value string
+	// Optional commands are skipped if the engine does not support them.
+	Optional bool
+}
+
 type Engine struct {
 	e *C.ENGINE
 }
@@ -48,3 +76,151 @@ func EngineById(name string) (*Engine, error) {
 	})
 	return e, nil
 }
+
+// EngineLoadByID loads the engine id, which may be built in or, through the
+// "dynamic" engine and pre commands such as SO_PATH, ID and LOAD, a shared
+// library. pre commands run before the engine is initialized and post
+// commands after, e.g. to set a PIN.
+func EngineLoadByID(id string, pre, post []EngineCommand) (*Engine, error) {
+	cid := C.CString(id)
+	defer C.free(unsafe.Pointer(cid))
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	C.ENGINE_load_builtin_engines()
+	e := C.ENGINE_by_id(cid)
+	if e == nil {
+		C.ERR_clear_error()
+		return nil, fmt.Errorf("engine %s missing", id)
+	}
+	for _, cmd := range pre {
+		if err := engineCtrl(e, cmd); err != nil {
+			C.ENGINE_free(e)
+			return nil, err
+		}
+	}
+	if C.ENGINE_init(e) != 1 {
+		C.ENGINE_free(e)
+		C.ERR_clear_error()
+		return nil, fmt.Errorf("engine %s not initialized", id)
+	}
+	engine := &Engine{e: e}
+	runtime.SetFinalizer(engine, func(e *Engine) {
+		C.ENGINE_finish(e.e)
+		C.ENGINE_free(e.e)
+	})
+	for _, cmd := range post {
+		if err := engineCtrl(engine.e, cmd); err != nil {
+			return nil, err
+		}
+	}
+	return engine, nil
+}
+
+// LoadConfigFile loads the OpenSSL configuration file name, running its
+// modules such as engines sections, e.g. to load and configure an engine
+// before EngineLoadByID looks it up.
+func LoadConfigFile(name string) error {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.CONF_modules_load_file(cname, nil, 0) <= 0 {
+		return fmt.Errorf("failed to load config %s: %v", name,
+			errorFromErrorQueue())
+	}
+	return nil
+}
+
+// engineCtrl runs cmd on e. The caller locks the OS thread.
+func engineCtrl(e *C.ENGINE, cmd EngineCommand) error {
+	cname := C.CString(cmd.Name)
+	defer C.free(unsafe.Pointer(cname))
+	var cvalue *C.char
+	if cmd.Value != "" {
+		cvalue = C.CString(cmd.Value)
+		defer C.free(unsafe.Pointer(cvalue))
+	}
+	var optional C.int
+	if cmd.Optional {
+		optional = 1
+	}
+	if C.ENGINE_ctrl_cmd_string(e, cname, cvalue, optional) != 1 {
+		return fmt.Errorf("engine command %s failed: %v", cmd.Name,
+			errorFromErrorQueue())
+	}
+	return nil
+}
+
+// ID returns the engine's identifier.
+func (e *Engine) ID() string {
+	return C.GoString(C.ENGINE_get_id(e.e))
+}
+
+// Ctrl runs a control command on the initialized engine.
+func (e *Engine) Ctrl(cmd EngineCommand) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	return engineCtrl(e.e, cmd)
+}
+
+// SetDefault registers the engine as the default implementation of methods
+// for all of OpenSSL, including keys and connections that do not refer to
+// the engine.
+func (e *Engine) SetDefault(methods EngineMethod) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.ENGINE_set_default(e.e, C.uint(methods)) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
+// LoadPrivateKey loads the private key key_id from the engine, e.g. a
+// PKCS#11 URI. The key material usually stays in the hardware; the returned
+// key signs and decrypts through the engine.
+func (e *Engine) LoadPrivateKey(key_id string) (PrivateKey, error) {
+	return e.loadPrivateKey(key_id, "")
+}
+
+// loadPrivateKey loads key_id, answering the engine's password prompts with
+// pin.
+func (e *Engine) loadPrivateKey(key_id, pin string) (PrivateKey, error) {
+	cid := C.CString(key_id)
+	defer C.free(unsafe.Pointer(cid))
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var key *C.EVP_PKEY
+	if pin == "" {
+		key = C.ENGINE_load_private_key(e.e, cid, nil, nil)
+	} else {
+		cpin := C.CString(pin)
+		defer C.free(unsafe.Pointer(cpin))
+		key = C.X_ENGINE_load_private_key(e.e, cid, cpin)
+	}
+	if key == nil {
+		return nil, errorFromErrorQueue()
+	}
+	p := &pKey{key: key}
+	runtime.SetFinalizer(p, func(p *pKey) {
+		C.X_EVP_PKEY_free(p.key)
+	})
+	return p, nil
+}
+
+// LoadPublicKey loads the public key key_id from the engine.
+func (e *Engine) LoadPublicKey(key_id string) (PublicKey, error) {
+	cid := C.CString(key_id)
+	defer C.free(unsafe.Pointer(cid))
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	key := C.ENGINE_load_public_key(e.e, cid, nil, nil)
+	if key == nil {
+		return nil, errorFromErrorQueue()
+	}
+	p := &pKey{key: key}
+	runtime.SetFinalizer(p, func(p *pKey) {
+		C.X_EVP_PKEY_free(p.key)
+	})
+	return p, nil
+}
@@ -0,0 +1,49 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"testing"
+)
+
+func TestEngineLoadByID(t *testing.T) {
+	if _, err := EngineLoadByID("no-such-engine", nil, nil); err == nil {
+		t.Fatal("loaded missing engine")
+	}
+	if _, err := EngineLoadByID("dynamic", []EngineCommand{
+		{Name: "SO_PATH", Value: "/nonexistent/engine.so"},
+		{Name: "LOAD"},
+	}, nil); err == nil {
+		t.Fatal("loaded missing shared library")
+	}
+
+	e, err := EngineLoadByID("rdrand", nil, nil)
+	if err != nil {
+		t.Skip("rdrand engine not available:", err)
+	}
+	if id := e.ID(); id != "rdrand" {
+		t.Fatalf("unexpected engine id %s", id)
+	}
+	if err := e.Ctrl(EngineCommand{Name: "NO_SUCH_COMMAND"}); err == nil {
+		t.Fatal("ran unsupported command")
+	}
+	if err := e.Ctrl(EngineCommand{Name: "NO_SUCH_COMMAND",
+		Optional: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.LoadPrivateKey("key"); err == nil {
+		t.Fatal("loaded key from engine without key storage")
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var (
+	// ErrHandshakeFailed matches, with errors.Is, errors that ended a TLS
+	// handshake.
+	ErrHandshakeFailed = errors.New("openssl: handshake failed")
+	// ErrCertVerification matches, with errors.Is, errors caused by a
+	// certificate failing verification.
+	ErrCertVerification = errors.New("openssl: certificate verification failed")
+)
+
+// ErrorClass is the result of SSL_get_error for a failed TLS operation.
+type ErrorClass int
+
+const (
+	ErrorClassNone       ErrorClass = C.SSL_ERROR_NONE
+	ErrorClassSSL        ErrorClass = C.SSL_ERROR_SSL
+	ErrorClassSyscall    ErrorClass = C.SSL_ERROR_SYSCALL
+	ErrorClassZeroReturn ErrorClass = C.SSL_ERROR_ZERO_RETURN
+)
+
+// ErrorLib identifies the OpenSSL library that queued an error.
+type ErrorLib int
+
+const (
+	ErrorLibSSL  ErrorLib = C.ERR_LIB_SSL
+	ErrorLibX509 ErrorLib = C.ERR_LIB_X509
+	ErrorLibEVP  ErrorLib = C.ERR_LIB_EVP
+	ErrorLibPEM  ErrorLib = C.ERR_LIB_PEM
+	ErrorLibASN1 ErrorLib = C.ERR_LIB_ASN1
+)
+
+// Reason codes of ErrorLibSSL that callers commonly need to tell apart.
+const (
+	ReasonCertificateVerifyFailed = C.SSL_R_CERTIFICATE_VERIFY_FAILED
+	ReasonBadRecordMac            = C.SSL_R_DECRYPTION_FAILED_OR_BAD_RECORD_MAC
+	ReasonAlertBadRecordMac       = C.SSL_R_SSLV3_ALERT_BAD_RECORD_MAC
+	ReasonAlertCertificateExpired = C.SSL_R_SSLV3_ALERT_CERTIFICATE_EXPIRED
+	ReasonAlertUnknownCA          = C.SSL_R_TLSV1_ALERT_UNKNOWN_CA
+	ReasonNoSharedCipher          = C.SSL_R_NO_SHARED_CIPHER
+	ReasonWrongVersionNumber      = C.SSL_R_WRONG_VERSION_NUMBER
+)
+
+// ErrorEntry is one error from the OpenSSL error queue.
+type ErrorEntry struct {
+	// Code is the packed error code as returned by ERR_get_error.
+	Code     uint64
+	Lib      ErrorLib
+	Reason   int
+	LibName  string
+	FuncName string
+	// ReasonString is OpenSSL's description of Reason.
+	ReasonString string
+}
+
+func (e ErrorEntry) String() string {
+	return fmt.Sprintf("%s:%s:%s", e.LibName, e.FuncName, e.ReasonString)
+}
+
+// Error is returned for failures reported by OpenSSL. It carries the drained
+// error queue and, for failed TLS operations, the SSL_get_error class and the
+// peer verification result, so that e.g. an expired certificate can be told
+// apart from a bad record MAC with errors.As.
+type Error struct {
+	// Class is ErrorClassNone unless the error comes from a TLS operation.
+	Class ErrorClass
+	// Queue is the OpenSSL error queue, oldest first. It may be empty.
+	Queue []ErrorEntry
+	// VerifyResult is the peer certificate verification result at the time
+	// of a failed TLS operation, Ok otherwise.
+	VerifyResult VerifyResult
+	// AlertSent and AlertReceived are the last alerts of a failed TLS
+	// operation's connection, nil if there were none.
+	AlertSent     *Alert
+	AlertReceived *Alert
+	// Handshake is set if the error ended a TLS handshake.
+	Handshake bool
+	// Err is the underlying error, if any, e.g. of a failed system call.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if len(e.Queue) == 0 && e.Err != nil {
+		return e.Err.Error()
+	}
+	errs := make([]string, 0, len(e.Queue))
+	for _, entry := range e.Queue {
+		errs = append(errs, entry.String())
+	}
+	return fmt.Sprintf("SSL errors: %s", strings.Join(errs, "\n"))
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches ErrHandshakeFailed and ErrCertVerification.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrHandshakeFailed:
+		return e.Handshake
+	case ErrCertVerification:
+		return e.HasReason(ErrorLibSSL, ReasonCertificateVerifyFailed)
+	}
+	return false
+}
+
+// Timeout reports whether the underlying error is a timeout, as for
+// net.Error.
+func (e *Error) Timeout() bool {
+	var net_err net.Error
+	return errors.As(e.Err, &net_err) && net_err.Timeout()
+}
+
+// Temporary reports whether the underlying error is temporary, as for
+// net.Error.
+func (e *Error) Temporary() bool {
+	var net_err net.Error
+	return errors.As(e.Err, &net_err) && net_err.Temporary()
+}
+
+// HasReason reports whether the error queue contains an error of lib with
+// the given reason.
+func (e *Error) HasReason(lib ErrorLib, reason int) bool {
+	for _, entry := range e.Queue {
+		if entry.Lib == lib && entry.Reason == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// drainErrorQueue needs to run in the same OS thread as the operation that
+// caused the possible error
+func drainErrorQueue() *Error {
+	e := &Error{}
+	for {
+		code := C.ERR_get_error()
+		if code == 0 {
+			break
+		}
+		e.Queue = append(e.Queue, ErrorEntry{
+			Code:         uint64(code),
+			Lib:          ErrorLib(C.X_ERR_GET_LIB(code)),
+			Reason:       int(C.X_ERR_GET_REASON(code)),
+			LibName:      C.GoString(C.ERR_lib_error_string(code)),
+			FuncName:     C.GoString(C.ERR_func_error_string(code)),
+			ReasonString: C.GoString(C.ERR_reason_error_string(code)),
+		})
+	}
+	return e
+}
+
+// errorFromErrorQueue needs to run in the same OS thread as the operation
+// that caused the possible error
+func errorFromErrorQueue() error {
+	return drainErrorQueue()
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestErrorQueue(t *testing.T) {
+	_, err := LoadCertificateFromPEM([]byte("-----BEGIN CERTIFICATE-----\n" +
+		"bm90IGEgY2VydGlmaWNhdGU=\n-----END CERTIFICATE-----\n"))
+	var ssl_err *Error
+	if !errors.As(err, &ssl_err) {
+		t.Fatalf("expected *Error, got %T: %v", err, err)
+	}
+	if len(ssl_err.Queue) == 0 {
+		t.Fatal("expected a non-empty error queue")
+	}
+	if ssl_err.Class != ErrorClassNone || ssl_err.VerifyResult != Ok {
+		t.Fatalf("unexpected class %d or verify result %d", ssl_err.Class,
+			ssl_err.VerifyResult)
+	}
+}
+
+func TestErrorVerifyFailed(t *testing.T) {
+	server := newTestTLSServer(t, "tcp", "localhost:0")
+	defer server.Close()
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.SetVerifyMode(VerifyPeer)
+	dialer := &Dialer{Ctx: ctx, Flags: InsecureSkipHostVerification}
+	conn, err := dialer.Dial("tcp", server.Addr().String())
+	if err == nil {
+		conn.Close()
+		t.Fatal("expected verification of an untrusted server to fail")
+	}
+	var ssl_err *Error
+	if !errors.As(err, &ssl_err) {
+		t.Fatalf("expected *Error, got %T: %v", err, err)
+	}
+	if ssl_err.Class != ErrorClassSSL {
+		t.Fatalf("expected class %d, got %d", ErrorClassSSL, ssl_err.Class)
+	}
+	if ssl_err.VerifyResult == Ok {
+		t.Fatal("expected a failed verify result")
+	}
+	if !errors.Is(err, ErrCertVerification) ||
+		!errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("expected a failed verification, got %v", err)
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	err := &Error{Handshake: true}
+	if !errors.Is(err, ErrHandshakeFailed) {
+		t.Fatal("expected handshake error to match ErrHandshakeFailed")
+	}
+	if errors.Is(err, ErrCertVerification) {
+		t.Fatal("expected handshake error not to match ErrCertVerification")
+	}
+	if !errors.Is(&VerifyError{Result: CertHasExpired}, ErrCertVerification) {
+		t.Fatal("expected VerifyError to match ErrCertVerification")
+	}
+}
+
+func TestConnNetErrors(t *testing.T) {
+	server := newTestTLSServer(t, "tcp", "localhost:0")
+	defer server.Close()
+	dialer := &Dialer{Flags: InsecureSkipHostVerification}
+	conn, err := dialer.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	var b [1]byte
+	_, err = conn.Read(b[:])
+	if net_err, ok := err.(net.Error); !ok || !net_err.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	// the server closes with close_notify after echoing one line
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(conn); err != nil {
+		t.Fatalf("expected a clean EOF, got %v", err)
+	}
+	conn.Close()
+	_, err = conn.Write([]byte("ping\n"))
+	if _, ok := err.(net.Error); !ok || !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+}
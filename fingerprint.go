@@ -0,0 +1,49 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"encoding/base64"
+)
+
+// SPKIFingerprint returns the SHA-256 hash of the DER-encoded
+// SubjectPublicKeyInfo of the key. Hex-encode it for display or
+// base64-encode it for an HPKP-style pin-sha256 value.
+func (key *pKey) SPKIFingerprint() (fingerprint []byte, err error) {
+	der, err := key.MarshalPKIXPublicKeyDER()
+	if err != nil {
+		return nil, err
+	}
+	sum, err := SHA256(der)
+	if err != nil {
+		return nil, err
+	}
+	return sum[:], nil
+}
+
+// PublicKeyPinSHA256 returns the base64-encoded SHA-256 hash of the
+// certificate's SubjectPublicKeyInfo, as used by pin-sha256 directives
+// (RFC 7469) and HPKP violation reports.
+func (c *Certificate) PublicKeyPinSHA256() (string, error) {
+	pub, err := c.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	fingerprint, err := pub.SPKIFingerprint()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(fingerprint), nil
+}
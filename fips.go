@@ -14,26 +14,68 @@
 
 package openssl
 
-/*
-#include <openssl/ssl.h>
-*/
+// #include "shim.h"
 import "C"
-import "runtime"
+
+import (
+	"fmt"
+	"runtime"
+)
 
 // FIPSModeSet enables a FIPS 140-2 validated mode of operation.
 // https://wiki.openssl.org/index.php/FIPS_mode_set()
+//
+// On OpenSSL 3.x enabling loads the "fips" provider, as EnableFIPS does, and
+// disabling lifts the fips=yes default property and reloads the "default"
+// provider.
 func FIPSModeSet(mode bool) error {
+	if mode {
+		return EnableFIPS()
+	}
+	if C.OPENSSL_VERSION_NUMBER >= 0x30000000 {
+		if err := loadProvider("default"); err != nil {
+			return err
+		}
+	}
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
+	if C.X_FIPS_mode_set(0) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
 
-	var r C.int
-	if mode {
-		r = C.FIPS_mode_set(1)
-	} else {
-		r = C.FIPS_mode_set(0)
+// EnableFIPS switches the process into FIPS mode. With OpenSSL 1.x this calls
+// FIPS_mode_set, which requires a FIPS capable libcrypto. With OpenSSL 3.x it
+// loads the "fips" and "base" providers into the default library context and
+// sets the fips=yes default property, so every algorithm fetched afterwards
+// comes from the FIPS module. The module must have been installed and its
+// configuration (fipsmodule.cnf) included from the OpenSSL configuration.
+func EnableFIPS() error {
+	if C.OPENSSL_VERSION_NUMBER >= 0x30000000 {
+		if err := loadProvider("fips"); err != nil {
+			return fmt.Errorf("fips provider not available: %v", err)
+		}
+		// the fips provider has no encoders or decoders for PEM and DER
+		if err := loadProvider("base"); err != nil {
+			return err
+		}
 	}
-	if r != 1 {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.X_FIPS_mode_set(1) != 1 {
+		if C.OPENSSL_VERSION_NUMBER < 0x30000000 {
+			return fmt.Errorf("fips module not available: %v",
+				errorFromErrorQueue())
+		}
 		return errorFromErrorQueue()
 	}
 	return nil
 }
+
+// IsFIPSEnabled reports whether the process is running in FIPS mode. With
+// OpenSSL 3.x that requires both the fips=yes default property and an
+// available "fips" provider.
+func IsFIPSEnabled() bool {
+	return C.X_FIPS_mode() == 1
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnableFIPS(t *testing.T) {
+	if IsFIPSEnabled() {
+		t.Fatal("FIPS mode enabled by default")
+	}
+	err := EnableFIPS()
+	if err != nil {
+		if !strings.Contains(err.Error(), "fips") {
+			t.Fatalf("unclear error without a FIPS module: %v", err)
+		}
+		if IsFIPSEnabled() {
+			t.Fatal("FIPS mode enabled after a failed EnableFIPS")
+		}
+		t.Skip(err)
+	}
+	defer func() {
+		if err := FIPSModeSet(false); err != nil {
+			t.Fatal(err)
+		}
+		if IsFIPSEnabled() {
+			t.Fatal("FIPS mode still enabled")
+		}
+	}()
+	if !IsFIPSEnabled() {
+		t.Fatal("FIPS mode not enabled")
+	}
+	if _, err := SHA256([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"unsafe"
+)
+
+// firmwareChunkSize is the amount of the image digested at a time.
+const firmwareChunkSize = 32 * 1024
+
+// VerifyFirmware verifies signature over the firmware image read from image,
+// digesting the image as it is read so that images of any size are verified
+// in constant memory. The signature is either a detached CMS (PKCS#7)
+// SignedData, or a raw ECDSA (DER) or RSA PKCS#1 v1.5 signature over the
+// SHA-256 digest of the image made by the key of signerChain[0]. Either way
+// the signer certificate must chain to roots, with signerChain and, for CMS,
+// the certificates embedded in the signature as untrusted intermediates. No
+// certificate purpose is checked.
+func VerifyFirmware(image io.Reader, signature []byte,
+	signerChain []*Certificate, roots *CertificateStore) error {
+	if len(signature) == 0 {
+		return errors.New("empty firmware signature")
+	}
+	if roots == nil {
+		return errors.New("no firmware roots")
+	}
+	if cms, err := LoadCMSFromDER(signature); err == nil {
+		return verifyFirmwareCMS(image, cms, signerChain, roots)
+	}
+	if len(signerChain) == 0 {
+		return errors.New("raw firmware signature without signer certificate")
+	}
+	if _, err := roots.Verify(signerChain[0], signerChain[1:], nil); err != nil {
+		return err
+	}
+	key, err := signerChain[0].PublicKey()
+	if err != nil {
+		return err
+	}
+	return verifyFirmwareRaw(image, signature, key)
+}
+
+func verifyFirmwareCMS(image io.Reader, cms *CMS, signerChain []*Certificate,
+	roots *CertificateStore) error {
+	if cms.SignerCount() == 0 {
+		return errors.New("firmware signature has no signers")
+	}
+	sk, err := certificateStack(signerChain)
+	if err != nil {
+		return err
+	}
+	defer C.X_sk_X509_free(sk)
+	bio, rb, free := newReaderBio(image)
+	if bio == nil {
+		return errors.New("failed creating bio")
+	}
+	defer free()
+
+	// signer chains are verified below, without the S/MIME purpose check
+	// of CMS_verify
+	runtime.LockOSThread()
+	rc := C.CMS_verify(cms.cms, sk, nil, bio, nil,
+		C.CMS_BINARY|C.CMS_NO_SIGNER_CERT_VERIFY)
+	var cms_err error
+	if rc != 1 {
+		cms_err = errorFromErrorQueue()
+	}
+	runtime.UnlockOSThread()
+	if rb.err != nil {
+		return rb.err
+	}
+	if cms_err != nil {
+		return cms_err
+	}
+
+	signers, err := cms.Signers()
+	if err != nil {
+		return err
+	}
+	embedded, err := cms.Certificates()
+	if err != nil {
+		return err
+	}
+	untrusted := append(append([]*Certificate{}, signerChain...), embedded...)
+	for _, signer := range signers {
+		if _, err := roots.Verify(signer, untrusted, nil); err != nil {
+			return err
+		}
+	}
+	runtime.KeepAlive(signerChain)
+	return nil
+}
+
+func verifyFirmwareRaw(image io.Reader, signature []byte, key PublicKey) error {
+	switch key.KeyType() {
+	case KeyTypeRSA, KeyTypeEC:
+	default:
+		return errors.New("raw firmware signatures require an rsa or ec key")
+	}
+	ctx := C.X_EVP_MD_CTX_new()
+	if ctx == nil {
+		return errors.New("failed to allocate digest context")
+	}
+	defer C.X_EVP_MD_CTX_free(ctx)
+	if C.X_EVP_VerifyInit(ctx, C.X_EVP_sha256()) != 1 {
+		return errors.New("failed to init verify")
+	}
+	buf := make([]byte, firmwareChunkSize)
+	for {
+		n, err := image.Read(buf)
+		if n > 0 {
+			if C.X_EVP_VerifyUpdate(ctx, unsafe.Pointer(&buf[0]),
+				C.uint(n)) != 1 {
+				return errors.New("failed to update verify")
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.X_EVP_VerifyFinal(ctx, (*C.uchar)(unsafe.Pointer(&signature[0])),
+		C.uint(len(signature)), key.evpPKey()) != 1 {
+		return errors.New("firmware signature verification failed")
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, f.err
+	}
+	return n, err
+}
+
+func TestVerifyFirmware(t *testing.T) {
+	ca, cakey := newTestCA(t)
+	roots, err := NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := roots.AddCertificate(ca); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := newTestCA(t)
+	otherRoots, err := NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := otherRoots.AddCertificate(other); err != nil {
+		t.Fatal(err)
+	}
+
+	eckey, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsakey, err := GenerateRSAKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSigner := newTestCMSSigner(t, ca, cakey, eckey, EVP_SHA256)
+	rsaSigner := newTestCMSSigner(t, ca, cakey, rsakey, EVP_SHA256)
+
+	image := bytes.Repeat([]byte("firmware image\x00\xff"), 100000)
+	tampered := append([]byte{}, image...)
+	tampered[len(tampered)/2] ^= 1
+
+	cms, err := CMSSign(image, []CMSSigner{ecSigner}, nil, CMSDetached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmsSig, err := cms.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSig, err := eckey.SignPKCS1v15(SHA256_Method, image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSig, err := rsakey.SignPKCS1v15(SHA256_Method, image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name  string
+		sig   []byte
+		chain []*Certificate
+	}{
+		{"cms", cmsSig, nil},
+		{"ecdsa", ecSig, []*Certificate{ecSigner.Certificate}},
+		{"rsa", rsaSig, []*Certificate{rsaSigner.Certificate}},
+	} {
+		err := VerifyFirmware(bytes.NewReader(image), test.sig, test.chain,
+			roots)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if err := VerifyFirmware(bytes.NewReader(tampered), test.sig,
+			test.chain, roots); err == nil {
+			t.Fatalf("%s: verified tampered image", test.name)
+		}
+		if err := VerifyFirmware(bytes.NewReader(image), test.sig,
+			test.chain, otherRoots); err == nil {
+			t.Fatalf("%s: verified with untrusted signer", test.name)
+		}
+		read_err := errors.New("read failed")
+		err = VerifyFirmware(&failingReader{bytes.NewReader(image),
+			read_err}, test.sig, test.chain, roots)
+		if err != read_err {
+			t.Fatalf("%s: expected read error, got %v", test.name, err)
+		}
+	}
+
+	if err := VerifyFirmware(bytes.NewReader(image), ecSig, nil,
+		roots); err == nil {
+		t.Fatal("verified raw signature without signer")
+	}
+}
@@ -3,7 +3,10 @@ module github.com/fotahub/go-openssl
 require (
 	github.com/mattn/go-pointer v0.0.1
 	github.com/spacemonkeygo/spacelog v0.0.0-20180420211403-2296661a0572
-	golang.org/x/sys v0.0.0-20190626221950-04f50cda93cb // indirect
+	golang.org/x/net v0.35.0
+	golang.org/x/sys v0.30.0
 )
 
-go 1.12
+require golang.org/x/text v0.22.0 // indirect
+
+go 1.17
@@ -0,0 +1,76 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+/*
+#include "openssl/engine.h"
+*/
+import "C"
+
+import (
+	"fmt"
+	"runtime"
+)
+
+const (
+	gostEngineID     = "gost"
+	gostProviderName = "gostprov"
+)
+
+// LoadGOST makes the GOST algorithms (GOST R 34.10-2012 signatures,
+// GOST R 34.11-2012 "Streebog" digests, Kuznyechik and Magma ciphers and the
+// matching TLS ciphersuites) available. It requires the third-party gost
+// engine, or on OpenSSL 3 the gost provider, to be installed.
+//
+// On OpenSSL 3 the gost provider is preferred. Otherwise the gost engine is
+// loaded and registered as the default implementation of everything it
+// supports, so that GetCipherByName, GetDigestByName, key loading and
+// Ctx.SetCipherList pick up GOST without further configuration. The returned
+// Engine is nil when the provider was used. Errors carry the OpenSSL errors
+// of the failed provider and engine loads as an *Error.
+func LoadGOST() (*Engine, error) {
+	var provider_err error
+	if C.OPENSSL_VERSION_NUMBER >= 0x30000000 {
+		provider_err = loadProvider(gostProviderName)
+		if provider_err == nil {
+			// explicitly loading a provider disables the implicit default
+			return nil, loadProvider("default")
+		}
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	e, err := EngineById(gostEngineID)
+	if err != nil {
+		return nil, gostError(err, provider_err)
+	}
+	if C.ENGINE_set_default(e.e, C.ENGINE_METHOD_ALL) != 1 {
+		return nil, gostError(fmt.Errorf("engine %s could not be set as "+
+			"default", gostEngineID), provider_err)
+	}
+	return e, nil
+}
+
+// gostError drains the error queue, along with the errors of the failed
+// provider load, into err. It must run in the thread of the failed call.
+func gostError(err, provider_err error) error {
+	e := drainErrorQueue()
+	if p, ok := provider_err.(*Error); ok {
+		e.Queue = append(p.Queue, e.Queue...)
+	}
+	if len(e.Queue) == 0 {
+		return err
+	}
+	return fmt.Errorf("%v: %w", err, e)
+}
@@ -0,0 +1,49 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGOST(t *testing.T) {
+	if _, err := LoadGOST(); err != nil {
+		t.Skipf("gost engine or provider not available: %s", err)
+	}
+	for _, name := range []string{"md_gost12_256", "md_gost12_512"} {
+		d, err := GetDigestByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Method() == nil {
+			t.Fatalf("%s: nil method", name)
+		}
+	}
+	if _, err := GetCipherByName("kuznyechik-cbc"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadGOSTError(t *testing.T) {
+	_, err := LoadGOST()
+	if err == nil {
+		t.Skip("gost engine or provider available")
+	}
+	var ssl_err *Error
+	if !errors.As(err, &ssl_err) || len(ssl_err.Queue) == 0 {
+		t.Fatalf("expected the OpenSSL errors in %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"unsafe"
+)
+
+// Key exchange groups, as named in group lists. The hybrid post-quantum
+// groups combine a classical curve with ML-KEM and are only available once a
+// provider implementing them, such as the oqs-provider, has been loaded.
+const (
+	GroupX25519             = "X25519"
+	GroupX448               = "X448"
+	GroupP256               = "P-256"
+	GroupP384               = "P-384"
+	GroupP521               = "P-521"
+	GroupX25519MLKEM768     = "X25519MLKEM768"
+	GroupSecP256r1MLKEM768  = "SecP256r1MLKEM768"
+	GroupSecP384r1MLKEM1024 = "SecP384r1MLKEM1024"
+)
+
+// LoadOQSProvider loads the Open Quantum Safe provider ("oqsprovider") into
+// the default library context, alongside the "default" provider, making its
+// post-quantum and hybrid groups available to SetGroups. The provider module
+// is looked up in the OpenSSL modules directory, or OPENSSL_MODULES if set.
+// Calling LoadOQSProvider again is a no-op.
+func LoadOQSProvider() error {
+	if err := loadProviderOnce("oqsprovider"); err != nil {
+		return fmt.Errorf("oqs provider not available: %v", err)
+	}
+	return nil
+}
+
+// SetGroups sets the key exchange groups offered or accepted, in order of
+// preference. A client sends a key share for the first group only, so put
+// the hybrid group to pilot first and a classical fallback after it, e.g.
+// SetGroups(GroupX25519MLKEM768, GroupX25519).
+func (c *Ctx) SetGroups(groups ...string) error {
+	if len(groups) == 0 {
+		return errors.New("no groups given")
+	}
+	cgroups := C.CString(strings.Join(groups, ":"))
+	defer C.free(unsafe.Pointer(cgroups))
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.X_SSL_CTX_set1_groups_list(c.ctx, cgroups) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
+// SetGroups overrides the context's key exchange groups for this
+// connection. See Ctx.SetGroups.
+func (s *SSL) SetGroups(groups ...string) error {
+	if len(groups) == 0 {
+		return errors.New("no groups given")
+	}
+	cgroups := C.CString(strings.Join(groups, ":"))
+	defer C.free(unsafe.Pointer(cgroups))
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.X_SSL_set1_groups_list(s.ssl, cgroups) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
+// GroupAvailable reports whether the named key exchange group is known to
+// the loaded providers and can be configured with SetGroups.
+func GroupAvailable(group string) bool {
+	ctx, err := NewCtx()
+	if err != nil {
+		return false
+	}
+	err = ctx.SetGroups(group)
+	return err == nil
+}
+
+// NegotiatedGroup returns the name of the key exchange group agreed on in
+// the handshake, e.g. "x25519" or "X25519MLKEM768". Only valid after a
+// handshake.
+func (c *Conn) NegotiatedGroup() (string, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.is_shutdown {
+		return "", errors.New("connection closed")
+	}
+	name := C.X_SSL_get_negotiated_group_name(c.ssl)
+	if name == nil {
+		return "", errors.New("no group negotiated")
+	}
+	return C.GoString(name), nil
+}
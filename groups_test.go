@@ -0,0 +1,96 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func groupsHandshake(t *testing.T, server_groups, client_groups []string) (
+	string, string) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+	server, client := OpenSSLConstructor(t, server_conn, client_conn)
+	defer close_both(server, client)
+	if err := server.(*Conn).SetGroups(server_groups...); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.(*Conn).SetGroups(client_groups...); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, conn := range []HandshakingConn{server, client} {
+		go func(conn HandshakingConn) {
+			defer wg.Done()
+			if err := conn.Handshake(); err != nil {
+				t.Error(err)
+			}
+		}(conn)
+	}
+	wg.Wait()
+	server_group, err := server.(*Conn).NegotiatedGroup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client_group, err := client.(*Conn).NegotiatedGroup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server_group, client_group
+}
+
+func TestNegotiatedGroup(t *testing.T) {
+	server_group, client_group := groupsHandshake(t,
+		[]string{GroupP256, GroupX25519}, []string{GroupP384, GroupP256})
+	if !strings.EqualFold(server_group, "prime256v1") &&
+		!strings.EqualFold(server_group, "secp256r1") {
+		t.Fatalf("unexpected server group %q", server_group)
+	}
+	if client_group != server_group {
+		t.Fatalf("client negotiated %q, server %q", client_group,
+			server_group)
+	}
+
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.SetGroups("no-such-group"); err == nil {
+		t.Fatal("expected error for unknown group")
+	}
+	if err := ctx.SetGroups(); err == nil {
+		t.Fatal("expected error without groups")
+	}
+}
+
+func TestHybridGroup(t *testing.T) {
+	if err := LoadOQSProvider(); err != nil {
+		t.Skip(err)
+	}
+	if !GroupAvailable(GroupX25519MLKEM768) {
+		t.Skip("oqs-provider without X25519MLKEM768")
+	}
+	server_group, client_group := groupsHandshake(t,
+		[]string{GroupX25519MLKEM768, GroupX25519},
+		[]string{GroupX25519MLKEM768, GroupX25519})
+	if server_group != GroupX25519MLKEM768 ||
+		client_group != GroupX25519MLKEM768 {
+		t.Fatalf("negotiated %q and %q", server_group, client_group)
+	}
+}
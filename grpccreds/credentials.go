@@ -0,0 +1,156 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package grpccreds provides gRPC transport credentials that secure
+// connections with OpenSSL instead of crypto/tls.
+package grpccreds
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/fotahub/go-openssl"
+	"google.golang.org/grpc/credentials"
+)
+
+// AuthInfo is the credentials.AuthInfo of connections secured by this
+// package.
+type AuthInfo struct {
+	credentials.CommonAuthInfo
+	// State is the state of the connection after the handshake.
+	State openssl.ConnectionState
+	// PeerCertificates is the peer's certificate followed by the chain it
+	// presented, empty if it presented none.
+	PeerCertificates []*openssl.Certificate
+}
+
+// AuthType implements credentials.AuthInfo.
+func (AuthInfo) AuthType() string {
+	return "openssl"
+}
+
+type transportCredentials struct {
+	ctx         *openssl.Ctx
+	flags       openssl.DialFlags
+	server_name string
+}
+
+// NewClientCredentials returns transport credentials performing client
+// handshakes with ctx, which is configured to offer "h2" through ALPN. The
+// server's hostname is verified against the dialed authority unless flags
+// includes openssl.InsecureSkipHostVerification; as with openssl.Dial, ctx
+// must be set up to verify the server's certificate chain.
+func NewClientCredentials(ctx *openssl.Ctx,
+	flags openssl.DialFlags) (credentials.TransportCredentials, error) {
+	if ctx == nil {
+		return nil, errors.New("no ssl context provided")
+	}
+	if err := ctx.SetNextProtos([]string{"h2"}); err != nil {
+		return nil, err
+	}
+	return &transportCredentials{ctx: ctx, flags: flags}, nil
+}
+
+// NewServerCredentials returns transport credentials performing server
+// handshakes with ctx, which is configured to select "h2" through ALPN.
+func NewServerCredentials(
+	ctx *openssl.Ctx) (credentials.TransportCredentials, error) {
+	if ctx == nil {
+		return nil, errors.New("no ssl context provided")
+	}
+	if err := ctx.SetNextProtos([]string{"h2"}); err != nil {
+		return nil, err
+	}
+	return &transportCredentials{ctx: ctx}, nil
+}
+
+func (c *transportCredentials) ClientHandshake(ctx context.Context,
+	authority string, raw net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	host := c.server_name
+	if host == "" {
+		host = authority
+		if h, _, err := net.SplitHostPort(authority); err == nil {
+			host = h
+		}
+	}
+	conn, err := openssl.Client(raw, c.ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.flags&openssl.DisableSNI == 0 {
+		if err := conn.SetTlsExtHostName(host); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	if err := conn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if c.flags&openssl.InsecureSkipHostVerification == 0 {
+		if err := conn.VerifyHostname(host); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	return conn, newAuthInfo(conn, true), nil
+}
+
+func (c *transportCredentials) ServerHandshake(
+	raw net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, err := openssl.Server(raw, c.ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, newAuthInfo(conn, false), nil
+}
+
+func newAuthInfo(conn *openssl.Conn, client bool) AuthInfo {
+	info := AuthInfo{
+		CommonAuthInfo: credentials.CommonAuthInfo{
+			SecurityLevel: credentials.PrivacyAndIntegrity,
+		},
+		State: conn.ConnectionState(),
+	}
+	// only a client's view of the chain starts with the peer's certificate
+	if !client && info.State.Certificate != nil {
+		info.PeerCertificates = append(info.PeerCertificates,
+			info.State.Certificate)
+	}
+	info.PeerCertificates = append(info.PeerCertificates,
+		info.State.CertificateChain...)
+	return info
+}
+
+func (c *transportCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{
+		SecurityProtocol: "tls",
+		ServerName:       c.server_name,
+	}
+}
+
+func (c *transportCredentials) Clone() credentials.TransportCredentials {
+	clone := *c
+	return &clone
+}
+
+func (c *transportCredentials) OverrideServerName(server_name string) error {
+	c.server_name = server_name
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpccreds
+
+import (
+	"context"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fotahub/go-openssl"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+)
+
+func newTestCertificate(t *testing.T) (*openssl.Certificate,
+	openssl.PrivateKey) {
+	key, err := openssl.GenerateECKey(openssl.Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := openssl.NewCertificate(&openssl.CertificateInfo{
+		Serial:     big.NewInt(1),
+		Expires:    24 * time.Hour,
+		CommonName: "localhost",
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetVersion(openssl.X509_V3); err != nil {
+		t.Fatal(err)
+	}
+	err = cert.AddExtension(openssl.NID_subject_alt_name, "DNS:localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Sign(key, openssl.EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestCredentials(t *testing.T) {
+	cert, key := newTestCertificate(t)
+
+	server_ctx, err := openssl.NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server_ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := server_ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	server_creds, err := NewServerCredentials(server_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.Creds(server_creds))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	defer server.Stop()
+
+	client_ctx, err := openssl.NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client_ctx.GetCertificateStore().AddCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	client_ctx.SetVerifyMode(openssl.VerifyPeer)
+	client_creds, err := NewClientCredentials(client_ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	cc, err := grpc.Dial("localhost:"+port,
+		grpc.WithTransportCredentials(client_creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var p peer.Peer
+	resp, err := healthpb.NewHealthClient(cc).Check(ctx,
+		&healthpb.HealthCheckRequest{}, grpc.Peer(&p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("unexpected status %v", resp.Status)
+	}
+	info, ok := p.AuthInfo.(AuthInfo)
+	if !ok {
+		t.Fatalf("unexpected auth info %T", p.AuthInfo)
+	}
+	if info.State.NegotiatedProtocol != "h2" {
+		t.Fatalf("negotiated %q", info.State.NegotiatedProtocol)
+	}
+	if len(info.PeerCertificates) != 1 {
+		t.Fatalf("got %d peer certificates", len(info.PeerCertificates))
+	}
+	name, err := info.PeerCertificates[0].GetSubjectName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn, _ := name.GetEntry(openssl.NID_commonName); cn != "localhost" {
+		t.Fatalf("unexpected peer %q", cn)
+	}
+}
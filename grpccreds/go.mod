@@ -0,0 +1,18 @@
+module github.com/fotahub/go-openssl/grpccreds
+
+go 1.17
+
+require (
+	github.com/fotahub/go-openssl v0.0.0-20261017033506-cde7149a6fa4
+	google.golang.org/grpc v1.64.1
+)
+
+require (
+	github.com/mattn/go-pointer v0.0.1 // indirect
+	github.com/spacemonkeygo/spacelog v0.0.0-20180420211403-2296661a0572 // indirect
+	golang.org/x/net v0.35.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
@@ -0,0 +1,262 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrHandshakeLimited is returned for connections closed without a handshake
+// because a listener's handshake limit was reached, see HandshakeReset.
+var ErrHandshakeLimited = errors.New("openssl: handshake limit exceeded")
+
+// HandshakeOverflow selects what listeners do with connections whose
+// handshake would exceed a limit.
+type HandshakeOverflow int
+
+const (
+	// HandshakeQueue delays the handshake until it fits the limit. The
+	// connection's deadline and Close, as well as the handshake timeout of
+	// WithEagerHandshake, end the wait, failing the handshake with a
+	// timeout or net.ErrClosed.
+	HandshakeQueue HandshakeOverflow = iota
+	// HandshakeReset resets the connection without handshake, failing it
+	// with ErrHandshakeLimited.
+	HandshakeReset
+)
+
+// WithMaxConcurrentHandshakes limits the number of handshakes of accepted
+// connections that run at the same time to max, protecting the CPU from
+// floods of handshakes. Handshakes past the limit wait or fail according to
+// overflow. max must be at least 1, otherwise Listen fails, as does Accept
+// of listeners made with NewListener.
+func WithMaxConcurrentHandshakes(max int,
+	overflow HandshakeOverflow) ListenerOption {
+	return func(l *listener) {
+		if max < 1 {
+			l.err = fmt.Errorf("openssl: at least one concurrent "+
+				"handshake must be allowed, got %d", max)
+			return
+		}
+		limiter := l.handshakeLimiter()
+		limiter.slots = make(chan struct{}, max)
+		limiter.slots_overflow = overflow
+	}
+}
+
+// WithHandshakeRateLimit limits the handshakes of accepted connections to
+// per_second on average, allowing bursts of up to burst handshakes, with a
+// token bucket. Handshakes past the limit wait or fail according to
+// overflow.
+func WithHandshakeRateLimit(per_second float64, burst int,
+	overflow HandshakeOverflow) ListenerOption {
+	return func(l *listener) {
+		if burst < 1 {
+			burst = 1
+		}
+		limiter := l.handshakeLimiter()
+		limiter.rate = per_second
+		limiter.burst = float64(burst)
+		limiter.tokens = float64(burst)
+		limiter.last = time.Now()
+		limiter.rate_overflow = overflow
+	}
+}
+
+func (l *listener) handshakeLimiter() *handshakeLimiter {
+	if l.limiter == nil {
+		l.limiter = &handshakeLimiter{}
+	}
+	return l.limiter
+}
+
+// handshakeLimiter holds the handshake limits shared by the connections of a
+// listener.
+type handshakeLimiter struct {
+	slots          chan struct{}
+	slots_overflow HandshakeOverflow
+
+	rate          float64
+	rate_overflow HandshakeOverflow
+	mtx           sync.Mutex
+	burst         float64
+	tokens        float64
+	last          time.Time
+}
+
+// acquire waits for the handshake to fit the limits and returns a function
+// to call once it is over. Waits end early as w says.
+func (h *handshakeLimiter) acquire(w *handshakeWait) (release func(),
+	err error) {
+	if h.rate > 0 {
+		if err := h.take(w); err != nil {
+			return nil, err
+		}
+	}
+	if h.slots == nil {
+		return func() {}, nil
+	}
+	if h.slots_overflow == HandshakeReset {
+		select {
+		case h.slots <- struct{}{}:
+		default:
+			return nil, ErrHandshakeLimited
+		}
+	} else if err := w.wait(h.slots, time.Time{}); err != nil {
+		return nil, err
+	}
+	return func() { <-h.slots }, nil
+}
+
+// take takes a token from the bucket, waiting for one to be added if the
+// bucket is empty and handshakes are queued.
+func (h *handshakeLimiter) take(w *handshakeWait) error {
+	h.mtx.Lock()
+	now := time.Now()
+	h.tokens += now.Sub(h.last).Seconds() * h.rate
+	if h.tokens > h.burst {
+		h.tokens = h.burst
+	}
+	h.last = now
+	if h.tokens < 1 && h.rate_overflow == HandshakeReset {
+		h.mtx.Unlock()
+		return ErrHandshakeLimited
+	}
+	// queued handshakes take their token in advance, in order
+	h.tokens--
+	wait := time.Duration(0)
+	if h.tokens < 0 {
+		wait = time.Duration(-h.tokens / h.rate * float64(time.Second))
+	}
+	h.mtx.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	if err := w.wait(nil, now.Add(wait)); err != nil {
+		// hand the token on to the handshakes queued behind
+		h.mtx.Lock()
+		h.tokens++
+		h.mtx.Unlock()
+		return err
+	}
+	return nil
+}
+
+// handshakeWait lets the deadlines and Close of a connection interrupt its
+// handshake while a limit keeps it waiting.
+type handshakeWait struct {
+	mtx    sync.Mutex
+	read   time.Time
+	write  time.Time
+	closed bool
+	// changed is closed and replaced whenever the fields above change
+	changed chan struct{}
+}
+
+func newHandshakeWait() *handshakeWait {
+	return &handshakeWait{changed: make(chan struct{})}
+}
+
+func (w *handshakeWait) update(change func()) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	change()
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+func (w *handshakeWait) setReadDeadline(t time.Time) {
+	w.update(func() { w.read = t })
+}
+
+func (w *handshakeWait) setWriteDeadline(t time.Time) {
+	w.update(func() { w.write = t })
+}
+
+func (w *handshakeWait) close() {
+	w.update(func() { w.closed = true })
+}
+
+// wait waits until it can send to slots, if not nil, or until the time
+// until, if not zero. It fails with os.ErrDeadlineExceeded once the earlier
+// of the deadlines passes and with net.ErrClosed once the connection is
+// closed.
+func (w *handshakeWait) wait(slots chan struct{}, until time.Time) error {
+	var done <-chan time.Time
+	if !until.IsZero() {
+		timer := time.NewTimer(time.Until(until))
+		defer timer.Stop()
+		done = timer.C
+	}
+	for {
+		w.mtx.Lock()
+		closed, changed := w.closed, w.changed
+		deadline := w.read
+		if deadline.IsZero() || (!w.write.IsZero() &&
+			w.write.Before(deadline)) {
+			deadline = w.write
+		}
+		w.mtx.Unlock()
+		if closed {
+			return net.ErrClosed
+		}
+		var expired <-chan time.Time
+		var deadline_timer *time.Timer
+		if !deadline.IsZero() {
+			deadline_timer = time.NewTimer(time.Until(deadline))
+			expired = deadline_timer.C
+		}
+		var err error
+		select {
+		case slots <- struct{}{}:
+		case <-done:
+		case <-expired:
+			err = os.ErrDeadlineExceeded
+		case <-changed:
+			// look at the new deadlines
+			err = errTryAgain
+		}
+		if deadline_timer != nil {
+			deadline_timer.Stop()
+		}
+		if err != errTryAgain {
+			return err
+		}
+	}
+}
+
+// limitedHandshake runs the first handshake of c within the limits of its
+// listener. Later calls return the result of the first.
+func (c *Conn) limitedHandshake() error {
+	c.limit_once.Do(func() {
+		release, err := c.handshake_limit.acquire(c.limit_wait)
+		if err != nil {
+			if tcp, ok := c.conn.(*net.TCPConn); ok {
+				tcp.SetLinger(0)
+			}
+			c.conn.Close()
+			c.limit_err = err
+			return
+		}
+		c.limit_err = c.doHandshake()
+		release()
+	})
+	return c.limit_err
+}
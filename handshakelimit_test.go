@@ -0,0 +1,227 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// listenLimited serves connections of a listener with opts, reporting the
+// result of each handshake.
+func listenLimited(t *testing.T, opts ...ListenerOption) (net.Listener,
+	chan error) {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen("tcp", "localhost:0", ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := make(chan error, 16)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				err := c.(*Conn).Handshake()
+				results <- err
+				if err == nil {
+					c.Read(make([]byte, 1))
+				}
+			}()
+		}
+	}()
+	return l, results
+}
+
+func TestListenerMaxConcurrentHandshakes(t *testing.T) {
+	l, results := listenLimited(t,
+		WithMaxConcurrentHandshakes(1, HandshakeReset))
+	defer l.Close()
+	addr := l.Addr().String()
+
+	// holds the only handshake slot
+	stalled, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := Dial("tcp", addr, nil, InsecureSkipVerify); err == nil {
+		t.Fatal("handshake exceeded the limit")
+	}
+	if err := <-results; err != ErrHandshakeLimited {
+		t.Fatalf("expected ErrHandshakeLimited, got %v", err)
+	}
+	stalled.Close()
+	<-results
+
+	conn, err := Dial("tcp", addr, nil, InsecureSkipVerify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-results
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListenerHandshakeRateLimit(t *testing.T) {
+	l, results := listenLimited(t,
+		WithHandshakeRateLimit(20, 1, HandshakeQueue))
+	defer l.Close()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		conn, err := Dial("tcp", l.Addr().String(), nil, InsecureSkipVerify)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = <-results
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the burst covers the first handshake, the others wait 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("handshakes were not delayed, took %v", elapsed)
+	}
+
+	l, results = listenLimited(t,
+		WithHandshakeRateLimit(0.01, 1, HandshakeReset))
+	defer l.Close()
+	conn, err := Dial("tcp", l.Addr().String(), nil, InsecureSkipVerify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-results
+	conn.Close()
+	if _, err := Dial("tcp", l.Addr().String(), nil,
+		InsecureSkipVerify); err == nil {
+		t.Fatal("handshake exceeded the rate")
+	}
+	if err := <-results; err != ErrHandshakeLimited {
+		t.Fatalf("expected ErrHandshakeLimited, got %v", err)
+	}
+}
+
+func TestListenerQueuedHandshakeInterrupted(t *testing.T) {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range []ListenerOption{
+		WithMaxConcurrentHandshakes(1, HandshakeQueue),
+		WithHandshakeRateLimit(0.01, 1, HandshakeQueue),
+	} {
+		inner, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := NewListener(inner, ctx, opt)
+		var clients []net.Conn
+		accept := func() *Conn {
+			client, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			clients = append(clients, client)
+			c, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return c.(*Conn)
+		}
+
+		// a silent client takes the only slot or token
+		stalled := accept()
+		go stalled.Handshake()
+		time.Sleep(50 * time.Millisecond)
+
+		timed_out := accept()
+		timed_out.SetDeadline(time.Now().Add(100 * time.Millisecond))
+		start := time.Now()
+		err = timed_out.Handshake()
+		if net_err, ok := err.(net.Error); !ok || !net_err.Timeout() {
+			t.Fatalf("expected a timeout, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("deadline ignored, waited %v", elapsed)
+		}
+		timed_out.Close()
+
+		closed := accept()
+		result := make(chan error, 1)
+		go func() { result <- closed.Handshake() }()
+		time.Sleep(50 * time.Millisecond)
+		closed.Close()
+		select {
+		case err := <-result:
+			if err == nil {
+				t.Fatal("handshake of a closed connection succeeded")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Close did not end the wait")
+		}
+
+		stalled.Close()
+		for _, client := range clients {
+			client.Close()
+		}
+		l.Close()
+	}
+}
+
+func TestListenerMaxConcurrentHandshakesInvalid(t *testing.T) {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, err := Listen("tcp", "localhost:0", ctx,
+		WithMaxConcurrentHandshakes(0, HandshakeQueue)); err == nil {
+		l.Close()
+		t.Fatal("a limit of zero handshakes was accepted")
+	}
+	inner, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(inner, ctx, WithMaxConcurrentHandshakes(-1,
+		HandshakeQueue))
+	defer l.Close()
+	if _, err := l.Accept(); err == nil {
+		t.Fatal("a negative handshake limit was accepted")
+	}
+}
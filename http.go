@@ -15,7 +15,16 @@
 package openssl
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
 	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
 )
 
 // ListenAndServeTLS will take an http.Handler and serve it using OpenSSL over
@@ -30,32 +39,281 @@ func ListenAndServeTLS(addr string, cert_file string, key_file string,
 // configured to use the provided cert and key files.
 func ServerListenAndServeTLS(srv *http.Server,
 	cert_file, key_file string) error {
+	ctx, err := NewCtxFromFiles(cert_file, key_file)
+	if err != nil {
+		return err
+	}
+	return ServerListenAndServeTLSCtx(srv, ctx)
+}
+
+// ServerListenAndServeTLSCtx will take an http.Server and serve it using
+// OpenSSL configured by ctx. See ServerServeTLS.
+func ServerListenAndServeTLSCtx(srv *http.Server, ctx *Ctx) error {
 	addr := srv.Addr
 	if addr == "" {
 		addr = ":https"
 	}
-
-	ctx, err := NewCtxFromFiles(cert_file, key_file)
+	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
+	return ServerServeTLS(srv, l, ctx)
+}
 
-	l, err := Listen("tcp", addr, ctx)
-	if err != nil {
+// ServerServeTLS serves srv on connections accepted from the plain listener
+// l and wrapped with OpenSSL server connections using ctx. Clients that
+// select "h2" through ALPN are served HTTP/2, the rest HTTP/1.1; setting
+// srv.TLSNextProto to an empty map disables HTTP/2, as with crypto/tls.
+// The handshake runs before a connection is handed to srv, limited by the
+// shortest of srv's read header, read and write timeouts. Like srv.Serve,
+// ServerServeTLS returns http.ErrServerClosed after srv.Shutdown or
+// srv.Close.
+func ServerServeTLS(srv *http.Server, l net.Listener, ctx *Ctx) error {
+	if ctx == nil {
+		return errors.New("no ssl context provided")
+	}
+	var h2 *http2.Server
+	protos := []string{"http/1.1"}
+	if srv.TLSNextProto == nil || len(srv.TLSNextProto) > 0 {
+		h2 = &http2.Server{}
+		if err := http2.ConfigureServer(srv, h2); err != nil {
+			return err
+		}
+		protos = []string{http2.NextProtoTLS, "http/1.1"}
+	}
+	if err := ctx.SetNextProtos(protos); err != nil {
 		return err
 	}
+	hl := &httpListener{
+		Listener: l,
+		ctx:      ctx,
+		srv:      srv,
+		h2:       h2,
+		conns:    make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+	go hl.acceptLoop()
+	return srv.Serve(hl)
+}
+
+// httpListener performs handshakes as connections are accepted, serving
+// HTTP/2 connections itself and handing the others to the http.Server
+// through Accept.
+type httpListener struct {
+	net.Listener
+	ctx *Ctx
+	srv *http.Server
+	h2  *http2.Server
+
+	conns     chan net.Conn
+	closed    chan struct{}
+	close_err error
+	once      sync.Once
+	err       error
+}
+
+func (l *httpListener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			l.closeWith(err)
+			return
+		}
+		go l.handshake(c)
+	}
+}
+
+func (l *httpListener) handshake(c net.Conn) {
+	conn, err := Server(c, l.ctx)
+	if err != nil {
+		c.Close()
+		return
+	}
+	if timeout := l.handshakeTimeout(); timeout > 0 {
+		c.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return
+	}
+	c.SetDeadline(time.Time{})
+	if l.h2 != nil && conn.NegotiatedProtocol() == http2.NextProtoTLS {
+		l.h2.ServeConn(conn, &http2.ServeConnOpts{
+			BaseConfig: l.srv,
+			Handler:    l.srv.Handler,
+		})
+		return
+	}
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *httpListener) handshakeTimeout() time.Duration {
+	var timeout time.Duration
+	for _, t := range []time.Duration{l.srv.ReadHeaderTimeout,
+		l.srv.ReadTimeout, l.srv.WriteTimeout} {
+		if t > 0 && (timeout == 0 || t < timeout) {
+			timeout = t
+		}
+	}
+	return timeout
+}
+
+func (l *httpListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		if l.err != nil {
+			return nil, l.err
+		}
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *httpListener) Close() error {
+	return l.closeWith(nil)
+}
+
+// closeWith closes the listener, making Accept return err, or a generic
+// error if err is nil, from then on.
+func (l *httpListener) closeWith(err error) error {
+	l.once.Do(func() {
+		l.err = err
+		close(l.closed)
+		l.close_err = l.Listener.Close()
+	})
+	return l.close_err
+}
+
+// Transport is an http.RoundTripper making HTTPS requests over connections
+// dialed with this package. It offers "h2" and "http/1.1" through ALPN and
+// routes each host to HTTP2 or HTTP1 depending on the protocol its server
+// selected on the first connection. Both transports may be tuned before use,
+// except for their dial functions. Proxies are not supported, since
+// http.Transport would establish TLS through a proxy with crypto/tls.
+type Transport struct {
+	HTTP1 *http.Transport
+	HTTP2 *http2.Transport
+
+	ctx    *Ctx
+	flags  DialFlags
+	dialer net.Dialer
+
+	mtx    sync.Mutex
+	protos map[string]string
+	probes map[string]*Conn
+}
+
+// NewTransport creates a Transport dialing with ctx, which is configured to
+// offer "h2" and "http/1.1" through ALPN. flags are passed to DialContext for
+// every connection.
+func NewTransport(ctx *Ctx, flags DialFlags) (*Transport, error) {
+	if ctx == nil {
+		return nil, errors.New("no ssl context provided")
+	}
+	err := ctx.SetNextProtos([]string{http2.NextProtoTLS, "http/1.1"})
+	if err != nil {
+		return nil, err
+	}
+	t := &Transport{
+		ctx:    ctx,
+		flags:  flags,
+		dialer: net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		protos: make(map[string]string),
+		probes: make(map[string]*Conn),
+	}
+	t.HTTP1 = &http.Transport{
+		DialContext:           t.dialer.DialContext,
+		DialTLSContext:        t.dialTLS,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	t.HTTP2 = &http2.Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string,
+			_ *tls.Config) (net.Conn, error) {
+			return t.dialTLS(ctx, network, addr)
+		},
+	}
+	return t, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return t.HTTP1.RoundTrip(req)
+	}
+	addr := canonicalAddr(req.URL)
+	t.mtx.Lock()
+	proto, known := t.protos[addr]
+	t.mtx.Unlock()
+	if !known {
+		// dial once to learn the server's protocol, and leave the connection
+		// for the transport picked to dial next
+		conn, err := DialContext(req.Context(), &t.dialer, "tcp", addr, t.ctx,
+			t.flags)
+		if err != nil {
+			return nil, err
+		}
+		proto = conn.NegotiatedProtocol()
+		t.mtx.Lock()
+		t.protos[addr] = proto
+		if t.probes[addr] == nil {
+			t.probes[addr] = conn
+			conn = nil
+		}
+		t.mtx.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
+	}
+	if proto == http2.NextProtoTLS {
+		return t.HTTP2.RoundTrip(req)
+	}
+	return t.HTTP1.RoundTrip(req)
+}
 
-	return srv.Serve(l)
+// CloseIdleConnections closes the idle connections of both transports.
+func (t *Transport) CloseIdleConnections() {
+	t.mtx.Lock()
+	probes := t.probes
+	t.probes = make(map[string]*Conn)
+	t.mtx.Unlock()
+	for _, conn := range probes {
+		conn.Close()
+	}
+	t.HTTP1.CloseIdleConnections()
+	t.HTTP2.CloseIdleConnections()
+}
+
+func (t *Transport) dialTLS(ctx context.Context, network, addr string) (
+	net.Conn, error) {
+	t.mtx.Lock()
+	conn := t.probes[addr]
+	delete(t.probes, addr)
+	t.mtx.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+	conn, err := DialContext(ctx, &t.dialer, network, addr, t.ctx, t.flags)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
 }
 
-// TODO: http client integration
-// holy crap, getting this integrated nicely with the Go stdlib HTTP client
-// stack so that it does proxying, connection pooling, and most importantly
-// hostname verification is really hard. So much stuff is hardcoded to just use
-// the built-in TLS lib. I think to get this to work either some crazy
-// hacktackery beyond me, an almost straight up fork of the HTTP client, or
-// serious stdlib internal refactoring is necessary.
-// even more so, good luck getting openssl to use the operating system default
-// root certificates if the user doesn't provide any. sadlol
-// NOTE: if you're going to try and write your own round tripper, at least use
-//  openssl.Dial, or equivalent logic
+func canonicalAddr(url *url.URL) string {
+	port := url.Port()
+	if port == "" {
+		port = "443"
+	}
+	return net.JoinHostPort(url.Hostname(), port)
+}
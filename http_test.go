@@ -0,0 +1,136 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testTransport(t *testing.T, enable_http2 bool, proto_major int) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}))
+	srv.EnableHTTP2 = enable_http2
+	srv.StartTLS()
+	defer srv.Close()
+
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport, err := NewTransport(ctx, InsecureSkipHostVerification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.ProtoMajor != proto_major || string(body) != resp.Proto {
+			t.Fatalf("got %s, server saw %s", resp.Proto, body)
+		}
+	}
+}
+
+func TestTransportHTTP2(t *testing.T) {
+	testTransport(t, true, 2)
+}
+
+func TestTransportHTTP1(t *testing.T) {
+	testTransport(t, false, 1)
+}
+
+func testServeTLS(t *testing.T, srv *http.Server, proto_major int) {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		})
+	served := make(chan error, 1)
+	go func() { served <- ServerServeTLS(srv, l, ctx) }()
+
+	// the stdlib client negotiates h2 whenever the server offers it
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get("https://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtoMajor != proto_major || string(body) != resp.Proto {
+		t.Fatalf("got %s, server saw %s", resp.Proto, body)
+	}
+	client.CloseIdleConnections()
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != http.ErrServerClosed {
+		t.Fatalf("unexpected serve error %v", err)
+	}
+}
+
+func TestServerServeTLSHTTP2(t *testing.T) {
+	testServeTLS(t, &http.Server{ReadHeaderTimeout: 10 * time.Second}, 2)
+}
+
+func TestServerServeTLSHTTP1(t *testing.T) {
+	srv := &http.Server{TLSNextProto: map[string]func(*http.Server,
+		*tls.Conn, http.Handler){}}
+	testServeTLS(t, srv, 1)
+}
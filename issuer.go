@@ -0,0 +1,97 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrIssuerNotFound is returned when none of the candidates issued a
+// certificate.
+var ErrIssuerNotFound = errors.New("openssl: issuer not found")
+
+// IsIssuedBy reports whether issuer signed c: the issuer's subject must match
+// the issuer name of c, its subject key identifier the authority key
+// identifier of c where both are present, its key usage must allow signing
+// certificates, and the signature of c must verify with its key.
+func (c *Certificate) IsIssuedBy(issuer *Certificate) bool {
+	if C.X509_check_issued(issuer.x, c.x) != C.X509_V_OK {
+		return false
+	}
+	pub := C.X509_get0_pubkey(issuer.x)
+	return pub != nil && C.X509_verify(c.x, pub) == 1
+}
+
+// FindIssuer returns the certificate among candidates that issued c, for
+// assembling chains from certificates supplied in no particular order.
+// Candidates whose subject key identifier matches the authority key
+// identifier of c are tried first. c itself is skipped, unless it is the
+// only match, so that self-signed roots are found in bundles as well.
+// ErrIssuerNotFound is returned if no candidate issued c.
+func (c *Certificate) FindIssuer(candidates []*Certificate) (*Certificate,
+	error) {
+	aki, err := c.AuthorityKeyId()
+	if err != nil {
+		return nil, err
+	}
+	var fallback, self *Certificate
+	for _, candidate := range candidates {
+		if !c.IsIssuedBy(candidate) {
+			continue
+		}
+		if C.X509_cmp(c.x, candidate.x) == 0 {
+			self = candidate
+			continue
+		}
+		if aki == nil {
+			return candidate, nil
+		}
+		ski, err := candidate.SubjectKeyId()
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(aki, ski) {
+			return candidate, nil
+		}
+		if fallback == nil {
+			fallback = candidate
+		}
+	}
+	if fallback != nil {
+		return fallback, nil
+	}
+	if self != nil {
+		return self, nil
+	}
+	return nil, ErrIssuerNotFound
+}
+
+// FindIssuerInPEM is like FindIssuer for the certificates in a PEM bundle.
+// Blocks other than certificates are skipped.
+func (c *Certificate) FindIssuerInPEM(bundle []byte) (*Certificate, error) {
+	var candidates []*Certificate
+	for _, block := range SplitPEM(bundle) {
+		cert, err := LoadCertificateFromPEM(block)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, cert)
+	}
+	return c.FindIssuer(candidates)
+}
@@ -0,0 +1,121 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func newTestLeaf(t *testing.T, ca *Certificate, cakey PrivateKey) *Certificate {
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := NewCertificateRequest(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := req.GetSubjectName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := name.AddTextEntry("CN", "leaf"); err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Sign(key, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := IssueCertificate(req, ca, cakey, &IssuanceProfile{
+		Validity: time.Hour,
+		Extensions: map[NID]string{
+			NID_authority_key_identifier: "keyid:always,issuer:always",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
+
+func TestAuthorityKeyIdentifier(t *testing.T) {
+	ca, cakey := newTestCA(t)
+	leaf := newTestLeaf(t, ca, cakey)
+	aki, err := leaf.AuthorityKeyIdentifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ski, err := ca.SubjectKeyId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ski) == 0 || !bytes.Equal(aki.KeyId, ski) {
+		t.Fatalf("key identifiers differ: %x, %x", aki.KeyId, ski)
+	}
+	if aki.SerialNumber == nil || aki.SerialNumber.Int64() != 1 {
+		t.Fatalf("unexpected serial number %v", aki.SerialNumber)
+	}
+	if len(aki.IssuerNames) != 1 ||
+		aki.IssuerNames[0].String() != "CN=Test CA" {
+		t.Fatalf("unexpected issuer names %v", aki.IssuerNames)
+	}
+	if aki, err := ca.AuthorityKeyIdentifier(); err != nil || aki != nil {
+		t.Fatalf("unexpected authority key identifier %+v, %v", aki, err)
+	}
+}
+
+func TestFindIssuerInPEM(t *testing.T) {
+	// both CAs share their subject name and differ in their keys
+	ca, cakey := newTestCA(t)
+	other, otherkey := newTestCA(t)
+	leaf := newTestLeaf(t, ca, cakey)
+	other_leaf := newTestLeaf(t, other, otherkey)
+
+	var bundle []byte
+	for _, cert := range []*Certificate{other, leaf, other_leaf, ca} {
+		pem, err := cert.MarshalPEM()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle = append(bundle, pem...)
+	}
+	bundle = append(bundle, keyBytes...)
+
+	for _, test := range []struct {
+		cert, issuer *Certificate
+	}{
+		{leaf, ca},
+		{other_leaf, other},
+		{ca, ca},
+	} {
+		issuer, err := test.cert.FindIssuerInPEM(bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := test.issuer.MarshalDER()
+		got, _ := issuer.MarshalDER()
+		if !bytes.Equal(got, want) {
+			t.Fatal("found the wrong issuer")
+		}
+	}
+	if !leaf.IsIssuedBy(ca) || leaf.IsIssuedBy(other) {
+		t.Fatal("unexpected issuer match")
+	}
+	if _, err := leaf.FindIssuer([]*Certificate{other,
+		other_leaf}); err != ErrIssuerNotFound {
+		t.Fatalf("expected ErrIssuerNotFound, got %v", err)
+	}
+}
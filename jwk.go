@@ -0,0 +1,283 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// jwk is the JSON representation of an RFC 7517 JSON Web Key. Only the
+// members describing the key material are handled; any others (kid, use,
+// alg, ...) are ignored on import.
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	D   string `json:"d,omitempty"`
+	P   string `json:"p,omitempty"`
+	Q   string `json:"q,omitempty"`
+	Dp  string `json:"dp,omitempty"`
+	Dq  string `json:"dq,omitempty"`
+	Qi  string `json:"qi,omitempty"`
+}
+
+func jwkEncode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func jwkEncodeInt(i *big.Int) string {
+	return jwkEncode(i.Bytes())
+}
+
+// jwkEncodeFixed encodes i big-endian, left-padded to size bytes as required
+// for EC coordinates and private scalars (RFC 7518 section 6.2).
+func jwkEncodeFixed(i *big.Int, size int) string {
+	b := make([]byte, size)
+	return jwkEncode(i.FillBytes(b))
+}
+
+func jwkDecode(field, s string) ([]byte, error) {
+	if s == "" {
+		return nil, fmt.Errorf("jwk: missing %q member", field)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("jwk: invalid %q member: %v", field, err)
+	}
+	return b, nil
+}
+
+func jwkDecodeInt(field, s string) (*big.Int, error) {
+	b, err := jwkDecode(field, s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func jwkCurve(crv string) (elliptic.Curve, error) {
+	switch crv {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("jwk: unsupported curve %q", crv)
+}
+
+func (j *jwk) setPublic(pub interface{}) error {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		j.Kty = "RSA"
+		j.N = jwkEncodeInt(pub.N)
+		j.E = jwkEncodeInt(big.NewInt(int64(pub.E)))
+	case *ecdsa.PublicKey:
+		params := pub.Curve.Params()
+		if _, err := jwkCurve(params.Name); err != nil {
+			return err
+		}
+		size := (params.BitSize + 7) / 8
+		j.Kty = "EC"
+		j.Crv = params.Name
+		j.X = jwkEncodeFixed(pub.X, size)
+		j.Y = jwkEncodeFixed(pub.Y, size)
+	case ed25519.PublicKey:
+		j.Kty = "OKP"
+		j.Crv = "Ed25519"
+		j.X = jwkEncode(pub)
+	default:
+		return fmt.Errorf("jwk: unsupported key type %T", pub)
+	}
+	return nil
+}
+
+func (j *jwk) publicKey() (interface{}, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := jwkDecodeInt("n", j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := jwkDecodeInt("e", j.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("jwk: rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, err := jwkCurve(j.Crv)
+		if err != nil {
+			return nil, err
+		}
+		x, err := jwkDecodeInt("x", j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := jwkDecodeInt("y", j.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("jwk: unsupported curve %q", j.Crv)
+		}
+		x, err := jwkDecode("x", j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("jwk: invalid ed25519 public key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("jwk: unsupported key type %q", j.Kty)
+}
+
+// MarshalPublicKeyJWK converts an RSA, EC or Ed25519 public key to a JSON
+// Web Key (RFC 7517).
+func MarshalPublicKeyJWK(key PublicKey) ([]byte, error) {
+	pub, err := key.ToStdlibPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	var j jwk
+	if err := j.setPublic(pub); err != nil {
+		return nil, err
+	}
+	return json.Marshal(&j)
+}
+
+// MarshalPrivateKeyJWK converts an RSA, EC or Ed25519 private key to a JSON
+// Web Key (RFC 7517) including the private key members.
+func MarshalPrivateKeyJWK(key PrivateKey) ([]byte, error) {
+	priv, err := key.ToStdlibKey()
+	if err != nil {
+		return nil, err
+	}
+	var j jwk
+	switch priv := priv.(type) {
+	case *rsa.PrivateKey:
+		if len(priv.Primes) != 2 {
+			return nil, errors.New("jwk: multi-prime rsa keys are not supported")
+		}
+		if err := j.setPublic(&priv.PublicKey); err != nil {
+			return nil, err
+		}
+		priv.Precompute()
+		j.D = jwkEncodeInt(priv.D)
+		j.P = jwkEncodeInt(priv.Primes[0])
+		j.Q = jwkEncodeInt(priv.Primes[1])
+		j.Dp = jwkEncodeInt(priv.Precomputed.Dp)
+		j.Dq = jwkEncodeInt(priv.Precomputed.Dq)
+		j.Qi = jwkEncodeInt(priv.Precomputed.Qinv)
+	case *ecdsa.PrivateKey:
+		if err := j.setPublic(&priv.PublicKey); err != nil {
+			return nil, err
+		}
+		j.D = jwkEncodeFixed(priv.D, (priv.Curve.Params().BitSize+7)/8)
+	case ed25519.PrivateKey:
+		if err := j.setPublic(priv.Public()); err != nil {
+			return nil, err
+		}
+		j.D = jwkEncode(priv.Seed())
+	default:
+		return nil, fmt.Errorf("jwk: unsupported key type %T", priv)
+	}
+	return json.Marshal(&j)
+}
+
+// LoadPublicKeyFromJWK loads a public key from a JSON Web Key. Private key
+// members, if present, are ignored.
+func LoadPublicKeyFromJWK(jwk_block []byte) (PublicKey, error) {
+	var j jwk
+	if err := json.Unmarshal(jwk_block, &j); err != nil {
+		return nil, err
+	}
+	pub, err := j.publicKey()
+	if err != nil {
+		return nil, err
+	}
+	return FromStdlibPublicKey(pub)
+}
+
+// LoadPrivateKeyFromJWK loads a private key from a JSON Web Key.
+func LoadPrivateKeyFromJWK(jwk_block []byte) (PrivateKey, error) {
+	var j jwk
+	if err := json.Unmarshal(jwk_block, &j); err != nil {
+		return nil, err
+	}
+	pub, err := j.publicKey()
+	if err != nil {
+		return nil, err
+	}
+	d, err := jwkDecode("d", j.D)
+	if err != nil {
+		return nil, err
+	}
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		p, err := jwkDecodeInt("p", j.P)
+		if err != nil {
+			return nil, err
+		}
+		q, err := jwkDecodeInt("q", j.Q)
+		if err != nil {
+			return nil, err
+		}
+		priv := &rsa.PrivateKey{
+			PublicKey: *pub,
+			D:         new(big.Int).SetBytes(d),
+			Primes:    []*big.Int{p, q},
+		}
+		if err := priv.Validate(); err != nil {
+			return nil, err
+		}
+		priv.Precompute()
+		return FromStdlibKey(priv)
+	case *ecdsa.PublicKey:
+		priv := &ecdsa.PrivateKey{
+			PublicKey: *pub,
+			D:         new(big.Int).SetBytes(d),
+		}
+		return FromStdlibKey(priv)
+	case ed25519.PublicKey:
+		if len(d) != ed25519.SeedSize {
+			return nil, errors.New("jwk: invalid ed25519 private key size")
+		}
+		priv := ed25519.NewKeyFromSeed(d)
+		if !pub.Equal(priv.Public()) {
+			return nil, errors.New("jwk: ed25519 public key does not match")
+		}
+		return FromStdlibKey(priv)
+	}
+	return nil, errors.New("jwk: unsupported key type")
+}
@@ -18,11 +18,15 @@ package openssl
 import "C"
 
 import (
+	"crypto"
 	"errors"
+	"io"
 	"io/ioutil"
+	"os"
 	"runtime"
 	"unsafe"
 
+	"github.com/mattn/go-pointer"
 )
 
 var ( // some (effectively) constants for tests to refer to
@@ -92,6 +96,14 @@ type PublicKey interface {
 	// Size returns the size (in bytes) of signatures created with this key.
 	Size() int
 
+	// ToStdlibPublicKey converts the key to the matching crypto/rsa,
+	// crypto/ecdsa or crypto/ed25519 public key type.
+	ToStdlibPublicKey() (crypto.PublicKey, error)
+
+	// SPKIFingerprint returns the SHA-256 hash of the DER-encoded
+	// SubjectPublicKeyInfo.
+	SPKIFingerprint() (fingerprint []byte, err error)
+
 	evpPKey() *C.EVP_PKEY
 }
 
@@ -108,6 +120,37 @@ type PrivateKey interface {
 	// MarshalPKCS1PrivateKeyDER converts the private key to DER-encoded PKCS1
 	// format
 	MarshalPKCS1PrivateKeyDER() (der_block []byte, err error)
+
+	// MarshalPKCS8PrivateKeyPEM converts the private key to PEM-encoded
+	// PKCS8 format
+	MarshalPKCS8PrivateKeyPEM() (pem_block []byte, err error)
+
+	// MarshalPKCS8PrivateKeyDER converts the private key to DER-encoded
+	// PKCS8 format
+	MarshalPKCS8PrivateKeyDER() (der_block []byte, err error)
+
+	// MarshalEncryptedPKCS8PrivateKeyPEM converts the private key to a
+	// password-protected PEM-encoded PKCS8 block. opts may be nil.
+	MarshalEncryptedPKCS8PrivateKeyPEM(password []byte, opts *PKCS8Options) (
+		pem_block []byte, err error)
+
+	// MarshalEncryptedPKCS8PrivateKeyDER converts the private key to a
+	// password-protected DER-encoded PKCS8 EncryptedPrivateKeyInfo.
+	MarshalEncryptedPKCS8PrivateKeyDER(password []byte, opts *PKCS8Options) (
+		der_block []byte, err error)
+
+	// Public returns the public half of the key as a standard library type,
+	// for use as a crypto.Decrypter.
+	Public() crypto.PublicKey
+
+	// Decrypt decrypts msg with an RSA key using PKCS1 v1.5 (nil or
+	// *rsa.PKCS1v15DecryptOptions) or OAEP (*rsa.OAEPOptions) padding.
+	Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) (
+		plaintext []byte, err error)
+
+	// ToStdlibKey converts the key to the matching crypto/rsa, crypto/ecdsa
+	// or crypto/ed25519 private key type.
+	ToStdlibKey() (crypto.PrivateKey, error)
 }
 
 type pKey struct {
@@ -117,7 +160,10 @@ type pKey struct {
 func (key *pKey) evpPKey() *C.EVP_PKEY { return key.key }
 
 func (key *pKey) Equal(other PublicKey) bool {
-	return C.EVP_PKEY_cmp(key.key, other.evpPKey()) == 1
+	if other == nil {
+		return false
+	}
+	return C.X_EVP_PKEY_eq(key.key, other.evpPKey()) == 1
 }
 
 func (key *pKey) KeyType() NID {
@@ -315,7 +361,9 @@ func LoadPrivateKeyFromPEM(pem_block []byte) (PrivateKey, error) {
 	return p, nil
 }
 
-// LoadPrivateKeyFromPEMWithPassword loads a private key from a PEM-encoded block.
+// LoadPrivateKeyFromPEMWithPassword loads a private key from a PEM-encoded
+// block, decrypting it with password if it is encrypted, either in the
+// traditional format ("Proc-Type: 4,ENCRYPTED") or as encrypted PKCS#8.
 func LoadPrivateKeyFromPEMWithPassword(pem_block []byte, password string) (
 	PrivateKey, error) {
 	if len(pem_block) == 0 {
@@ -328,7 +376,10 @@ func LoadPrivateKeyFromPEMWithPassword(pem_block []byte, password string) (
 	}
 	defer C.BIO_free(bio)
 	cs := C.CString(password)
-	defer C.free(unsafe.Pointer(cs))
+	defer func() {
+		C.OPENSSL_cleanse(unsafe.Pointer(cs), C.size_t(len(password)))
+		C.free(unsafe.Pointer(cs))
+	}()
 	key := C.PEM_read_bio_PrivateKey(bio, nil, nil, unsafe.Pointer(cs))
 	if key == nil {
 		return nil, errors.New("failed reading private key")
@@ -341,6 +392,21 @@ func LoadPrivateKeyFromPEMWithPassword(pem_block []byte, password string) (
 	return p, nil
 }
 
+// LoadPrivateKeyFromPEMWithPasswordBytes is like
+// LoadPrivateKeyFromPEMWithPassword but zeroes password before returning, so
+// that callers holding the password in a byte slice can keep it from
+// lingering in memory. OpenSSL's copies are cleansed as well.
+func LoadPrivateKeyFromPEMWithPasswordBytes(pem_block []byte,
+	password []byte) (PrivateKey, error) {
+	defer func() {
+		for i := range password {
+			password[i] = 0
+		}
+	}()
+	return LoadPrivateKeyFromPEMWithCallback(pem_block,
+		func(bool) ([]byte, error) { return password, nil })
+}
+
 // LoadPrivateKeyFromDER loads a private key from a DER-encoded block.
 func LoadPrivateKeyFromDER(der_block []byte) (PrivateKey, error) {
 	if len(der_block) == 0 {
@@ -372,6 +438,81 @@ func LoadPrivateKeyFromPEMWidthPassword(pem_block []byte, password string) (
 	return LoadPrivateKeyFromPEMWithPassword(pem_block, password)
 }
 
+// PasswordCallback supplies the passphrase for an encrypted key. rwflag is
+// true when the passphrase is used for encryption, in which case the caller
+// may want to ask for it twice. Returning an error aborts the operation.
+type PasswordCallback func(rwflag bool) (password []byte, err error)
+
+type passwordCallbackState struct {
+	cb  PasswordCallback
+	err error
+}
+
+//export go_pem_password_cb_thunk
+func go_pem_password_cb_thunk(p unsafe.Pointer, buf *C.char, size C.int,
+	rwflag C.int) C.int {
+	defer func() {
+		if err := recover(); err != nil {
+			logCallbackPanic("pem password callback", err)
+			os.Exit(1)
+		}
+	}()
+
+	state := pointer.Restore(p).(*passwordCallbackState)
+	password, err := state.cb(rwflag != 0)
+	if err != nil {
+		state.err = err
+		return -1
+	}
+	if len(password) > int(size) {
+		state.err = errors.New("password too long")
+		return -1
+	}
+	if len(password) > 0 {
+		C.memcpy(unsafe.Pointer(buf), unsafe.Pointer(&password[0]),
+			C.size_t(len(password)))
+	}
+	return C.int(len(password))
+}
+
+// LoadPrivateKeyFromPEMWithCallback loads a private key from a PEM-encoded
+// block, asking cb for the passphrase if the key is encrypted. cb is not
+// called for unencrypted keys.
+func LoadPrivateKeyFromPEMWithCallback(pem_block []byte, cb PasswordCallback) (
+	PrivateKey, error) {
+	if len(pem_block) == 0 {
+		return nil, errors.New("empty pem block")
+	}
+	if cb == nil {
+		return nil, errors.New("nil password callback")
+	}
+	bio := C.BIO_new_mem_buf(unsafe.Pointer(&pem_block[0]),
+		C.int(len(pem_block)))
+	if bio == nil {
+		return nil, errors.New("failed creating bio")
+	}
+	defer C.BIO_free(bio)
+
+	state := &passwordCallbackState{cb: cb}
+	p := pointer.Save(state)
+	defer pointer.Unref(p)
+
+	key := C.PEM_read_bio_PrivateKey(bio, nil,
+		(*C.pem_password_cb)(C.X_pem_password_cb), p)
+	if key == nil {
+		if state.err != nil {
+			return nil, state.err
+		}
+		return nil, errors.New("failed reading private key")
+	}
+
+	pk := &pKey{key: key}
+	runtime.SetFinalizer(pk, func(p *pKey) {
+		C.X_EVP_PKEY_free(p.key)
+	})
+	return pk, nil
+}
+
 // LoadPublicKeyFromPEM loads a public key from a PEM-encoded block.
 func LoadPublicKeyFromPEM(pem_block []byte) (PublicKey, error) {
 	if len(pem_block) == 0 {
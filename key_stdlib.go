@@ -0,0 +1,101 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// FromStdlibKey converts a standard library private key (*rsa.PrivateKey,
+// *ecdsa.PrivateKey or ed25519.PrivateKey) into a PrivateKey backed by
+// OpenSSL.
+func FromStdlibKey(key crypto.PrivateKey) (PrivateKey, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+	case *ed25519.PrivateKey:
+		key = *k
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return LoadPrivateKeyFromDER(der)
+}
+
+// FromStdlibPublicKey converts a standard library public key
+// (*rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey) into a PublicKey
+// backed by OpenSSL.
+func FromStdlibPublicKey(key crypto.PublicKey) (PublicKey, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+	case *ed25519.PublicKey:
+		key = *k
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return LoadPublicKeyFromDER(der)
+}
+
+// ToStdlibPublicKey converts the key into the matching standard library
+// public key type (*rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey).
+func (key *pKey) ToStdlibPublicKey() (crypto.PublicKey, error) {
+	der, err := key.MarshalPKIXPublicKeyDER()
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKIXPublicKey(der)
+}
+
+// ToStdlibKey converts the key into the matching standard library private
+// key type (*rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey). This
+// copies the private key material out of OpenSSL.
+func (key *pKey) ToStdlibKey() (crypto.PrivateKey, error) {
+	der, err := key.marshalPKCS8PrivateKeyDER()
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKCS8PrivateKey(der)
+}
+
+func (key *pKey) marshalPKCS8PrivateKeyDER() ([]byte, error) {
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+
+	if int(C.i2d_PKCS8PrivateKey_bio(bio, key.key, nil, nil, 0, nil,
+		nil)) != 1 {
+		return nil, errors.New("failed dumping pkcs8 private key der")
+	}
+
+	return ioutil.ReadAll(asAnyBio(bio))
+}
@@ -0,0 +1,115 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/spacemonkeygo/spacelog"
+)
+
+// LogLevel is the severity of a message passed to a Logger.
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+	LogCrit
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	case LogError:
+		return "error"
+	case LogCrit:
+		return "crit"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// LogField is a key/value pair attached to a log message.
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger receives the messages of the package, such as panics recovered in
+// callbacks from OpenSSL. It must be safe for concurrent use.
+type Logger interface {
+	Log(level LogLevel, msg string, fields ...LogField)
+}
+
+var (
+	logger_mtx sync.RWMutex
+	logger     Logger = spacelogLogger{spacelog.GetLogger()}
+)
+
+// SetLogger makes the package log to l. A nil l restores the default, which
+// logs with spacelog.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = spacelogLogger{spacelog.GetLogger()}
+	}
+	logger_mtx.Lock()
+	logger = l
+	logger_mtx.Unlock()
+}
+
+func getLogger() Logger {
+	logger_mtx.RLock()
+	defer logger_mtx.RUnlock()
+	return logger
+}
+
+// logCallbackPanic logs a panic recovered in a callback from OpenSSL.
+func logCallbackPanic(callback string, err interface{}) {
+	getLogger().Log(LogCrit, fmt.Sprintf("openssl: %s panic'd", callback),
+		LogField{Key: "panic", Value: err})
+}
+
+type spacelogLogger struct {
+	logger *spacelog.Logger
+}
+
+func (l spacelogLogger) Log(level LogLevel, msg string, fields ...LogField) {
+	parts := make([]string, 0, len(fields)+1)
+	parts = append(parts, msg)
+	for _, field := range fields {
+		parts = append(parts, fmt.Sprintf("%s=%v", field.Key, field.Value))
+	}
+	line := strings.Join(parts, " ")
+	switch level {
+	case LogDebug:
+		l.logger.Debug(line)
+	case LogInfo:
+		l.logger.Info(line)
+	case LogWarn:
+		l.logger.Warn(line)
+	case LogError:
+		l.logger.Error(line)
+	default:
+		l.logger.Crit(line)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"sync"
+	"testing"
+)
+
+type recordingLogger struct {
+	mtx    sync.Mutex
+	levels []LogLevel
+	msgs   []string
+	fields [][]LogField
+}
+
+func (l *recordingLogger) Log(level LogLevel, msg string,
+	fields ...LogField) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.levels = append(l.levels, level)
+	l.msgs = append(l.msgs, msg)
+	l.fields = append(l.fields, fields)
+}
+
+func TestSetLogger(t *testing.T) {
+	l := &recordingLogger{}
+	SetLogger(l)
+	defer SetLogger(nil)
+
+	logCallbackPanic("test callback", "boom")
+	if len(l.msgs) != 1 {
+		t.Fatalf("expected one message, got %d", len(l.msgs))
+	}
+	if l.levels[0] != LogCrit || l.msgs[0] != "openssl: test callback panic'd" {
+		t.Fatalf("unexpected message %v %q", l.levels[0], l.msgs[0])
+	}
+	if len(l.fields[0]) != 1 || l.fields[0][0] != (LogField{"panic", "boom"}) {
+		t.Fatalf("unexpected fields %v", l.fields[0])
+	}
+
+	SetLogger(nil)
+	if _, ok := getLogger().(spacelogLogger); !ok {
+		t.Fatal("expected the default logger to be restored")
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+)
+
+// LowMemoryRecordSize is the record size and maximum fragment length of
+// contexts in low memory mode.
+const LowMemoryRecordSize = 2048
+
+// SetLowMemory puts the context into low memory mode for constrained
+// devices. Connections made from it afterwards
+//
+//   - release their buffers whenever they run empty, see SetReleaseBuffers
+//   - send records of at most LowMemoryRecordSize bytes and, as clients, ask
+//     servers to do the same through the maximum fragment length extension
+//     (RFC 6066), with OpenSSL 1.1.1 or newer
+//   - read from the underlying connection LowMemoryRecordSize bytes at a time
+//   - pass each record on to the underlying connection as it is written,
+//     without gathering records in batches, and allocate write buffers only
+//     as large as the records they hold
+//
+// and the session cache is disabled.
+//
+// Idle connections then hold no I/O buffers, and busy connections with peers
+// honoring the fragment length target about 12 KiB of buffers, OpenSSL's
+// included, where the defaults allow for well over 100 KiB. Larger records
+// from servers ignoring the extension are still read, in several pieces,
+// but grow OpenSSL's read buffer to full size while the connection is busy.
+//
+// The settings may be changed individually afterwards.
+func (c *Ctx) SetLowMemory() error {
+	if err := c.SetRecordSize(LowMemoryRecordSize); err != nil {
+		return err
+	}
+	if C.X_SSL_CTX_set_max_fragment_length(c.ctx,
+		LowMemoryRecordSize) != 1 {
+		return errors.New("failed to set maximum fragment length")
+	}
+	c.SetReleaseBuffers(true)
+	c.SetSessionCacheMode(SessionCacheOff)
+	c.low_memory = true
+	return nil
+}
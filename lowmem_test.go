@@ -0,0 +1,129 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// recordSizeConn tracks the largest TLS record read from the connection.
+type recordSizeConn struct {
+	net.Conn
+	mtx     sync.Mutex
+	pending []byte
+	largest int
+}
+
+func (c *recordSizeConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.pending = append(c.pending, b[:n]...)
+	for len(c.pending) >= 5 {
+		size := int(c.pending[3])<<8 | int(c.pending[4])
+		if len(c.pending) < 5+size {
+			break
+		}
+		if size > c.largest {
+			c.largest = size
+		}
+		c.pending = c.pending[5+size:]
+	}
+	return n, err
+}
+
+func (c *recordSizeConn) Largest() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.largest
+}
+
+func TestCtxSetLowMemory(t *testing.T) {
+	server_ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server_ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := server_ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	client_ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client_ctx.SetLowMemory(); err != nil {
+		t.Fatal(err)
+	}
+	if client_ctx.GetMode()&ReleaseBuffers == 0 {
+		t.Fatal("expected buffers to be released")
+	}
+	if client_ctx.RecordSize() != LowMemoryRecordSize {
+		t.Fatalf("unexpected record size %d", client_ctx.RecordSize())
+	}
+
+	server_conn, client_conn := NetPipe(t)
+	server_sizes := &recordSizeConn{Conn: server_conn}
+	client_sizes := &recordSizeConn{Conn: client_conn}
+	server, err := Server(server_sizes, server_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Client(client_sizes, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close_both(server, client)
+	handshakeBoth(t, server, client)
+
+	go io.Copy(server, server)
+	data := bytes.Repeat([]byte("low memory "), 4096)
+	go client.Write(data)
+	echo := make([]byte, len(data))
+	if _, err := io.ReadFull(client, echo); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(echo, data) {
+		t.Fatal("echoed data differs")
+	}
+
+	// the ciphertext of a record adds at most a few hundred bytes
+	const limit = LowMemoryRecordSize + 256
+	if largest := server_sizes.Largest(); largest > limit {
+		t.Fatalf("client sent a %d byte record", largest)
+	}
+	if largest := client_sizes.Largest(); largest > limit {
+		t.Fatalf("server sent a %d byte record", largest)
+	}
+	client.into_ssl.data_mtx.Lock()
+	read_cap := cap(client.into_ssl.buf)
+	client.into_ssl.data_mtx.Unlock()
+	if read_cap > LowMemoryRecordSize {
+		t.Fatalf("unexpected read buffer of %d bytes", read_cap)
+	}
+}
@@ -22,41 +22,61 @@ import (
 // #include <stdlib.h>
 import "C"
 
-type mapping struct {
-	lock   sync.Mutex
+// mappingShards spreads tokens over independently locked maps so that
+// connections on different cores rarely contend. Must be a power of two.
+const mappingShards = 64
+
+type mappingShard struct {
+	lock   sync.RWMutex
 	values map[token]unsafe.Pointer
+	// keep shards on separate cache lines
+	_ [64]byte
+}
+
+type mapping struct {
+	shards [mappingShards]mappingShard
 }
 
 func newMapping() *mapping {
-	return &mapping{
-		values: make(map[token]unsafe.Pointer),
+	m := &mapping{}
+	for i := range m.shards {
+		m.shards[i].values = make(map[token]unsafe.Pointer)
 	}
+	return m
 }
 
 type token unsafe.Pointer
 
+func (m *mapping) shard(x token) *mappingShard {
+	// tokens are malloc'd, so the low bits carry no information
+	return &m.shards[(uintptr(x)>>4)&(mappingShards-1)]
+}
+
 func (m *mapping) Add(x unsafe.Pointer) token {
 	res := token(C.malloc(1))
 
-	m.lock.Lock()
-	m.values[res] = x
-	m.lock.Unlock()
+	s := m.shard(res)
+	s.lock.Lock()
+	s.values[res] = x
+	s.lock.Unlock()
 
 	return res
 }
 
 func (m *mapping) Get(x token) unsafe.Pointer {
-	m.lock.Lock()
-	res := m.values[x]
-	m.lock.Unlock()
+	s := m.shard(x)
+	s.lock.RLock()
+	res := s.values[x]
+	s.lock.RUnlock()
 
 	return res
 }
 
 func (m *mapping) Del(x token) {
-	m.lock.Lock()
-	delete(m.values, x)
-	m.lock.Unlock()
+	s := m.shard(x)
+	s.lock.Lock()
+	delete(s.values, x)
+	s.lock.Unlock()
 
 	C.free(unsafe.Pointer(x))
 }
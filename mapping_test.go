@@ -0,0 +1,59 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func TestMappingConcurrent(t *testing.T) {
+	m := newMapping()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				v := new(int)
+				tok := m.Add(unsafe.Pointer(v))
+				if m.Get(tok) != unsafe.Pointer(v) {
+					t.Error("lookup returned a different value")
+					return
+				}
+				m.Del(tok)
+			}
+		}()
+	}
+	wg.Wait()
+	for i := range m.shards {
+		if n := len(m.shards[i].values); n != 0 {
+			t.Fatalf("shard %d still holds %d values", i, n)
+		}
+	}
+}
+
+func BenchmarkMappingGet(b *testing.B) {
+	m := newMapping()
+	b.RunParallel(func(pb *testing.PB) {
+		// one token per goroutine, like one per connection
+		tok := m.Add(unsafe.Pointer(new(int)))
+		defer m.Del(tok)
+		for pb.Next() {
+			m.Get(tok)
+		}
+	})
+}
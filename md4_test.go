@@ -57,6 +57,10 @@ var md4Examples = []struct{ out, in string }{
 }
 
 func TestMD4Examples(t *testing.T) {
+	// MD4 lives in the legacy provider on OpenSSL 3
+	if err := LoadLegacyProvider(); err != nil {
+		t.Fatal(err)
+	}
 	for _, ex := range md4Examples {
 		buf, err := hex.DecodeString(ex.in)
 		if err != nil {
@@ -75,6 +79,10 @@ func TestMD4Examples(t *testing.T) {
 }
 
 func TestMD4Writer(t *testing.T) {
+	// MD4 lives in the legacy provider on OpenSSL 3
+	if err := LoadLegacyProvider(); err != nil {
+		t.Fatal(err)
+	}
 	ohash, err := NewMD4Hash()
 	if err != nil {
 		t.Fatal(err)
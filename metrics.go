@@ -0,0 +1,141 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"expvar"
+	"fmt"
+	"time"
+)
+
+// Metrics receives events from the connections of a Ctx, whether they were
+// accepted by a Listener or made by Dial, for export to e.g. Prometheus or
+// expvar. Methods are called with the connection locked, so they must be
+// fast, safe for concurrent use and must not call back into the connection.
+type Metrics interface {
+	// ConnOpened and ConnClosed are called once per connection, and so
+	// track the active connections.
+	ConnOpened()
+	ConnClosed()
+	// HandshakeDone is called once the initial handshake has finished, with
+	// its latency and whether it resumed a session.
+	HandshakeDone(latency time.Duration, resumed bool)
+	// HandshakeFailed is called when the initial handshake fails, with the
+	// alert sent or else received that ended it, which may be nil.
+	HandshakeFailed(alert *Alert)
+	// BytesRead and BytesWritten count application data.
+	BytesRead(n int)
+	BytesWritten(n int)
+}
+
+// SetMetrics makes connections created from the context afterwards report to
+// m. A nil m disables reporting.
+func (c *Ctx) SetMetrics(m Metrics) {
+	c.metrics = m
+}
+
+// handshakeLatencyBuckets are the upper bounds, in seconds, of the handshake
+// latency histogram of ExpvarMetrics.
+var handshakeLatencyBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// ExpvarMetrics implements Metrics with expvar variables:
+//
+//	conns_active        connections currently open
+//	handshakes          completed handshakes
+//	handshakes_resumed  completed handshakes that resumed a session
+//	handshake_failures  failed handshakes by alert, or "none"
+//	handshake_latency   cumulative histogram of handshake latency, keyed by
+//	                    upper bound in seconds as "le_0.005" ... "le_+Inf"
+//	bytes_read          application data read
+//	bytes_written       application data written
+type ExpvarMetrics struct {
+	vars *expvar.Map
+
+	conns_active       expvar.Int
+	handshakes         expvar.Int
+	handshakes_resumed expvar.Int
+	handshake_failures expvar.Map
+	handshake_latency  expvar.Map
+	bytes_read         expvar.Int
+	bytes_written      expvar.Int
+}
+
+// NewExpvarMetrics publishes the variables of a new ExpvarMetrics under
+// name. Like expvar.Publish, it panics if name is already in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{vars: expvar.NewMap(name)}
+	m.handshake_failures.Init()
+	m.handshake_latency.Init()
+	for _, bound := range handshakeLatencyBuckets {
+		m.handshake_latency.Add(latencyBucketKey(bound), 0)
+	}
+	m.handshake_latency.Add("le_+Inf", 0)
+	m.vars.Set("conns_active", &m.conns_active)
+	m.vars.Set("handshakes", &m.handshakes)
+	m.vars.Set("handshakes_resumed", &m.handshakes_resumed)
+	m.vars.Set("handshake_failures", &m.handshake_failures)
+	m.vars.Set("handshake_latency", &m.handshake_latency)
+	m.vars.Set("bytes_read", &m.bytes_read)
+	m.vars.Set("bytes_written", &m.bytes_written)
+	return m
+}
+
+// Vars returns the map holding the variables.
+func (m *ExpvarMetrics) Vars() *expvar.Map {
+	return m.vars
+}
+
+func latencyBucketKey(bound float64) string {
+	return fmt.Sprintf("le_%g", bound)
+}
+
+func (m *ExpvarMetrics) ConnOpened() {
+	m.conns_active.Add(1)
+}
+
+func (m *ExpvarMetrics) ConnClosed() {
+	m.conns_active.Add(-1)
+}
+
+func (m *ExpvarMetrics) HandshakeDone(latency time.Duration, resumed bool) {
+	m.handshakes.Add(1)
+	if resumed {
+		m.handshakes_resumed.Add(1)
+	}
+	seconds := latency.Seconds()
+	for _, bound := range handshakeLatencyBuckets {
+		if seconds <= bound {
+			m.handshake_latency.Add(latencyBucketKey(bound), 1)
+		}
+	}
+	m.handshake_latency.Add("le_+Inf", 1)
+}
+
+func (m *ExpvarMetrics) HandshakeFailed(alert *Alert) {
+	if alert == nil {
+		m.handshake_failures.Add("none", 1)
+		return
+	}
+	m.handshake_failures.Add(alert.String(), 1)
+}
+
+func (m *ExpvarMetrics) BytesRead(n int) {
+	m.bytes_read.Add(int64(n))
+}
+
+func (m *ExpvarMetrics) BytesWritten(n int) {
+	m.bytes_written.Add(int64(n))
+}
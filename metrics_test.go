@@ -0,0 +1,83 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bufio"
+	"expvar"
+	"testing"
+)
+
+func expvarInt(t *testing.T, m *expvar.Map, name string) int64 {
+	v, ok := m.Get(name).(*expvar.Int)
+	if !ok {
+		t.Fatalf("missing variable %s", name)
+	}
+	return v.Value()
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	server := newTestTLSServer(t, "tcp", "localhost:0")
+	defer server.Close()
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewExpvarMetrics("openssl_test_metrics")
+	ctx.SetMetrics(m)
+	dialer := &Dialer{Ctx: ctx, Flags: InsecureSkipHostVerification}
+	conn, err := dialer.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := expvarInt(t, m.Vars(), "conns_active"); n != 1 {
+		t.Fatalf("expected one active connection, got %d", n)
+	}
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	for name, expected := range map[string]int64{
+		"conns_active":  0,
+		"handshakes":    1,
+		"bytes_read":    5,
+		"bytes_written": 5,
+	} {
+		if n := expvarInt(t, m.Vars(), name); n != expected {
+			t.Fatalf("expected %s to be %d, got %d", name, expected, n)
+		}
+	}
+	latency := m.Vars().Get("handshake_latency").(*expvar.Map)
+	if n := expvarInt(t, latency, "le_+Inf"); n != 1 {
+		t.Fatalf("expected one handshake latency, got %d", n)
+	}
+
+	ctx.SetVerifyMode(VerifyPeer)
+	if _, err := dialer.Dial("tcp", server.Addr().String()); err == nil {
+		t.Fatal("expected verification of an untrusted server to fail")
+	}
+	failures := m.Vars().Get("handshake_failures").(*expvar.Map)
+	var failed int64
+	failures.Do(func(kv expvar.KeyValue) {
+		failed += kv.Value.(*expvar.Int).Value()
+	})
+	if failed != 1 {
+		t.Fatalf("expected one failed handshake, got %d", failed)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"errors"
+)
+
+// NewMutualTLSServerCtx creates a server context for mutual TLS from PEM
+// data: the server certificate followed by its chain, its private key, and
+// the CA certificates client certificates must chain to. Clients are
+// required to present a certificate, the CAs are advertised to them, and the
+// context uses ProfileIntermediate. Sessions are only resumed with contexts
+// trusting the same CAs.
+func NewMutualTLSServerCtx(certChainPEM, keyPEM,
+	clientCAPEM []byte) (*Ctx, error) {
+	client_cas := SplitPEM(clientCAPEM)
+	if len(client_cas) == 0 {
+		return nil, errors.New("no PEM certificate found in client CAs")
+	}
+	ctx, err := NewCtxWithProfile(ProfileIntermediate)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.UseCertificateChainPEM(certChainPEM); err != nil {
+		return nil, err
+	}
+	key, err := LoadPrivateKeyFromPEM(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.UsePrivateKey(key); err != nil {
+		return nil, err
+	}
+	if err := ctx.CheckPrivateKey(); err != nil {
+		return nil, err
+	}
+
+	store := ctx.GetCertificateStore()
+	for _, pem := range client_cas {
+		ca, err := LoadCertificateFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.AddCertificate(ca); err != nil {
+			return nil, err
+		}
+		if err := ctx.AddClientCA(ca); err != nil {
+			return nil, err
+		}
+	}
+	ctx.SetVerifyMode(VerifyPeer | VerifyFailIfNoPeerCert)
+
+	// resumed sessions skip client verification, so tie them to the CAs
+	sid_ctx, err := SHA256(clientCAPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.SetSessionId(sid_ctx[:]); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
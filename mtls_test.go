@@ -0,0 +1,114 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestNewMutualTLSServerCtx(t *testing.T) {
+	ca, cakey := newTestCA(t)
+	ca_pem, err := ca.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server_key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server_cert, err := GenerateSelfSigned([]string{"localhost"}, server_key,
+		time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert_pem, err := server_cert.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key_pem, err := server_key.MarshalPKCS1PrivateKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server_ctx, err := NewMutualTLSServerCtx(cert_pem, key_pem, ca_pem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server_ctx.VerifyMode() != VerifyPeer|VerifyFailIfNoPeerCert {
+		t.Fatalf("unexpected verify mode %v", server_ctx.VerifyMode())
+	}
+
+	client_key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := newTestCMSSigner(t, ca, cakey, client_key, EVP_SHA256)
+
+	for _, with_cert := range []bool{true, false} {
+		client_ctx, err := NewCtx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if with_cert {
+			if err := client_ctx.UseCertificate(signer.Certificate); err != nil {
+				t.Fatal(err)
+			}
+			if err := client_ctx.UsePrivateKey(client_key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		server_conn, client_conn := NetPipe(t)
+		server, err := Server(server_conn, server_ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := Client(client_conn, client_ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errs := make(chan error, 1)
+		go func() { errs <- server.Handshake() }()
+		// TLS 1.3 clients learn about rejected certificates on reading
+		if client.Handshake() == nil {
+			go client.Read(make([]byte, 1))
+		}
+		err = <-errs
+		if !with_cert {
+			if err == nil {
+				t.Fatal("accepted client without certificate")
+			}
+			close_both(server, client)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer, err := server.PeerCertificate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := peer.MarshalDER()
+		want, _ := signer.Certificate.MarshalDER()
+		if !bytes.Equal(got, want) {
+			t.Fatal("unexpected client certificate")
+		}
+		close_both(server, client)
+	}
+
+	if _, err := NewMutualTLSServerCtx(cert_pem, key_pem, nil); err == nil {
+		t.Fatal("created context without client CAs")
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"io/ioutil"
+	"unsafe"
+)
+
+// NameEntry is a single attribute of an X509 name.
+type NameEntry struct {
+	// OID is the attribute type as a dotted object identifier.
+	OID string
+	// NID is the attribute type's NID, or NID_undef if OpenSSL does not
+	// know it.
+	NID NID
+	// Value is the attribute value converted to UTF-8.
+	Value string
+	// RDN is the index of the relative distinguished name the entry belongs
+	// to. Entries of a multi-valued RDN share the same index.
+	RDN int
+}
+
+// nameEntryValue returns the value of a name entry converted to UTF-8.
+func nameEntryValue(ne *C.X509_NAME_ENTRY) (string, bool) {
+	var out *C.uchar
+	n := C.ASN1_STRING_to_UTF8(&out, C.X509_NAME_ENTRY_get_data(ne))
+	if n < 0 {
+		return "", false
+	}
+	defer C.X_OPENSSL_free(unsafe.Pointer(out))
+	return C.GoStringN((*C.char)(unsafe.Pointer(out)), n), true
+}
+
+// objectOID returns the dotted form of an object identifier.
+func objectOID(obj *C.ASN1_OBJECT) string {
+	var buf [128]C.char
+	n := C.OBJ_obj2txt(&buf[0], C.int(len(buf)), obj, 1)
+	if n <= 0 {
+		return ""
+	}
+	if int(n) >= len(buf) {
+		long := make([]C.char, n+1)
+		n = C.OBJ_obj2txt(&long[0], C.int(len(long)), obj, 1)
+		return C.GoStringN(&long[0], n)
+	}
+	return C.GoStringN(&buf[0], n)
+}
+
+// EntryCount returns the number of entries in the name.
+func (n *Name) EntryCount() int {
+	return int(C.X509_NAME_entry_count(n.name))
+}
+
+// Entries returns all entries of the name in order, from the most
+// significant RDN (usually the country) to the least.
+func (n *Name) Entries() ([]NameEntry, error) {
+	count := n.EntryCount()
+	entries := make([]NameEntry, 0, count)
+	for i := 0; i < count; i++ {
+		ne := C.X509_NAME_get_entry(n.name, C.int(i))
+		if ne == nil {
+			return nil, errors.New("failed to get x509 name entry")
+		}
+		value, ok := nameEntryValue(ne)
+		if !ok {
+			return nil, errors.New("failed to convert x509 name entry")
+		}
+		obj := C.X509_NAME_ENTRY_get_object(ne)
+		entries = append(entries, NameEntry{
+			OID:   objectOID(obj),
+			NID:   NID(C.OBJ_obj2nid(obj)),
+			Value: value,
+			RDN:   int(C.X509_NAME_ENTRY_set(ne)),
+		})
+	}
+	return entries, nil
+}
+
+// AddEntryByNID appends a UTF-8 entry with the given attribute type as a new
+// RDN.
+func (n *Name) AddEntryByNID(nid NID, value string) error {
+	cvalue := (*C.uchar)(unsafe.Pointer(C.CString(value)))
+	defer C.free(unsafe.Pointer(cvalue))
+	if C.X509_NAME_add_entry_by_NID(n.name, C.int(nid), C.MBSTRING_UTF8,
+		cvalue, C.int(len(value)), -1, 0) != 1 {
+		return errors.New("failed to add x509 name entry")
+	}
+	return nil
+}
+
+// AddEntryByOID appends a UTF-8 entry with the attribute type given as a
+// dotted object identifier as a new RDN. The attribute does not need to be
+// known to OpenSSL.
+func (n *Name) AddEntryByOID(oid string, value string) error {
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	obj := C.OBJ_txt2obj(coid, 1)
+	if obj == nil {
+		return errors.New("invalid object identifier")
+	}
+	defer C.ASN1_OBJECT_free(obj)
+	cvalue := (*C.uchar)(unsafe.Pointer(C.CString(value)))
+	defer C.free(unsafe.Pointer(cvalue))
+	if C.X509_NAME_add_entry_by_OBJ(n.name, obj, C.MBSTRING_UTF8, cvalue,
+		C.int(len(value)), -1, 0) != 1 {
+		return errors.New("failed to add x509 name entry")
+	}
+	return nil
+}
+
+// GetEntryByOID returns the first entry with the attribute type given as a
+// dotted object identifier. If no entry, then ("", false) is returned.
+func (n *Name) GetEntryByOID(oid string) (entry string, ok bool) {
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	obj := C.OBJ_txt2obj(coid, 1)
+	if obj == nil {
+		return "", false
+	}
+	defer C.ASN1_OBJECT_free(obj)
+	loc := C.X509_NAME_get_index_by_OBJ(n.name, obj, -1)
+	if loc < 0 {
+		return "", false
+	}
+	return nameEntryValue(C.X509_NAME_get_entry(n.name, loc))
+}
+
+// String returns the name in RFC 2253 form, e.g. "CN=device,O=Example,C=DE",
+// with non-ASCII characters kept as UTF-8.
+func (n *Name) String() string {
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return ""
+	}
+	defer C.BIO_free(bio)
+	flags := C.ulong(C.XN_FLAG_RFC2253 &^ C.ASN1_STRFLGS_ESC_MSB)
+	if C.X509_NAME_print_ex(bio, n.name, 0, flags) < 0 {
+		return ""
+	}
+	out, err := ioutil.ReadAll(asAnyBio(bio))
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
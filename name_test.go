@@ -0,0 +1,128 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestNameEntries(t *testing.T) {
+	const hwRevision = "1.3.6.1.4.1.55555.1.1"
+
+	name, err := NewName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := name.AddTextEntry("C", "DE"); err != nil {
+		t.Fatal(err)
+	}
+	if err := name.AddEntryByNID(NID_organizationName,
+		"Müller Gerätebau GmbH"); err != nil {
+		t.Fatal(err)
+	}
+	if err := name.AddEntryByOID(hwRevision, "rev-3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := name.AddEntryByNID(NID_commonName, "device-0001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := name.AddEntryByOID("not an oid", "x"); err == nil {
+		t.Fatal("expected an error for an invalid oid")
+	}
+
+	if name.EntryCount() != 4 {
+		t.Fatalf("expected 4 entries, got %d", name.EntryCount())
+	}
+	entries, err := name.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []NameEntry{
+		{OID: "2.5.4.6", NID: NID_countryName, Value: "DE", RDN: 0},
+		{OID: "2.5.4.10", NID: NID_organizationName,
+			Value: "Müller Gerätebau GmbH", RDN: 1},
+		{OID: hwRevision, NID: NID_undef, Value: "rev-3", RDN: 2},
+		{OID: "2.5.4.3", NID: NID_commonName, Value: "device-0001", RDN: 3},
+	}
+	for i, entry := range entries {
+		if entry != expected[i] {
+			t.Fatalf("entry %d: expected %+v, got %+v", i, expected[i], entry)
+		}
+	}
+	if v, ok := name.GetEntry(NID_organizationName); !ok ||
+		v != "Müller Gerätebau GmbH" {
+		t.Fatalf("unexpected organization %q", v)
+	}
+	if v, ok := name.GetEntryByOID(hwRevision); !ok || v != "rev-3" {
+		t.Fatalf("unexpected hardware revision %q", v)
+	}
+	if _, ok := name.GetEntryByOID("2.5.4.11"); ok {
+		t.Fatal("unexpected organizational unit")
+	}
+	// attributes without a short name are hex encoded as per RFC 2253
+	want := "CN=device-0001,1.3.6.1.4.1.55555.1.1=#0C057265762D33," +
+		"O=Müller Gerätebau GmbH,C=DE"
+	if name.String() != want {
+		t.Fatalf("expected %q, got %q", want, name.String())
+	}
+
+	// the entries survive a round trip through crypto/x509
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := NewCertificate(&CertificateInfo{
+		Serial:  big.NewInt(1),
+		Expires: time.Hour,
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetSubjectName(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetIssuerName(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Sign(key, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	der, err := cert.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Subject.Organization) != 1 ||
+		parsed.Subject.Organization[0] != "Müller Gerätebau GmbH" {
+		t.Fatalf("unexpected organization %v", parsed.Subject.Organization)
+	}
+	oid := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 55555, 1, 1}
+	found := false
+	for _, atv := range parsed.Subject.Names {
+		if atv.Type.Equal(oid) && atv.Value == "rev-3" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("custom attribute missing from parsed subject")
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"encoding/asn1"
+	"errors"
+	"net"
+	"net/url"
+	"runtime"
+)
+
+// NameConstraints holds the subtrees of a CA certificate's name constraints
+// extension (RFC 5280, section 4.2.1.10). Names issued under the CA must lie
+// within one of the permitted subtrees of their type, if there are any, and
+// outside all excluded subtrees.
+type NameConstraints struct {
+	Critical bool
+
+	PermittedDNSDomains     []string
+	ExcludedDNSDomains      []string
+	PermittedEmailAddresses []string
+	ExcludedEmailAddresses  []string
+	PermittedIPRanges       []*net.IPNet
+	ExcludedIPRanges        []*net.IPNet
+	// URI constraints are host names, like DNS constraints.
+	PermittedURIDomains []string
+	ExcludedURIDomains  []string
+	PermittedDirNames   []*Name
+	ExcludedDirNames    []*Name
+}
+
+type generalSubtree struct {
+	Base    asn1.RawValue
+	Minimum int `asn1:"optional,tag:0,default:0"`
+	Maximum int `asn1:"optional,tag:1,default:-1"`
+}
+
+// NameConstraints returns the certificate's name constraints, or nil if it
+// has no name constraints extension.
+func (c *Certificate) NameConstraints() (*NameConstraints, error) {
+	loc := C.X509_get_ext_by_NID(c.x, C.int(NID_name_constraints), -1)
+	if loc < 0 {
+		return nil, nil
+	}
+	der, critical := c.extension(loc)
+	var constraints struct {
+		Permitted []generalSubtree `asn1:"optional,tag:0"`
+		Excluded  []generalSubtree `asn1:"optional,tag:1"`
+	}
+	if rest, err := asn1.Unmarshal(der, &constraints); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("trailing data after name constraints")
+	}
+	nc := &NameConstraints{Critical: critical}
+	err := nc.addSubtrees(constraints.Permitted, &nc.PermittedDNSDomains,
+		&nc.PermittedEmailAddresses, &nc.PermittedIPRanges,
+		&nc.PermittedURIDomains, &nc.PermittedDirNames)
+	if err != nil {
+		return nil, err
+	}
+	err = nc.addSubtrees(constraints.Excluded, &nc.ExcludedDNSDomains,
+		&nc.ExcludedEmailAddresses, &nc.ExcludedIPRanges,
+		&nc.ExcludedURIDomains, &nc.ExcludedDirNames)
+	if err != nil {
+		return nil, err
+	}
+	return nc, nil
+}
+
+func (nc *NameConstraints) addSubtrees(subtrees []generalSubtree,
+	dns, emails *[]string, ips *[]*net.IPNet, uris *[]string,
+	dir_names *[]*Name) error {
+	for _, subtree := range subtrees {
+		if subtree.Minimum != 0 || subtree.Maximum != -1 {
+			return errors.New("name constraints with minimum or maximum " +
+				"are not supported")
+		}
+		base := subtree.Base
+		if base.Class != asn1.ClassContextSpecific {
+			return errors.New("invalid name constraint")
+		}
+		switch base.Tag {
+		case 1:
+			*emails = append(*emails, string(base.Bytes))
+		case 2:
+			*dns = append(*dns, string(base.Bytes))
+		case 4:
+			name, err := loadNameFromDER(base.Bytes)
+			if err != nil {
+				return err
+			}
+			*dir_names = append(*dir_names, name)
+		case 6:
+			*uris = append(*uris, string(base.Bytes))
+		case 7:
+			n := len(base.Bytes) / 2
+			if n != net.IPv4len && n != net.IPv6len ||
+				len(base.Bytes) != 2*n {
+				return errors.New("invalid ip address constraint length")
+			}
+			*ips = append(*ips, &net.IPNet{
+				IP:   net.IP(append([]byte(nil), base.Bytes[:n]...)),
+				Mask: net.IPMask(append([]byte(nil), base.Bytes[n:]...)),
+			})
+		}
+	}
+	return nil
+}
+
+// loadNameFromDER decodes a DER encoded X509 name.
+func loadNameFromDER(der []byte) (*Name, error) {
+	buf := C.CBytes(der)
+	defer C.free(buf)
+	ptr := (*C.uchar)(buf)
+	n := C.d2i_X509_NAME(nil, &ptr, C.long(len(der)))
+	if n == nil {
+		return nil, errors.New("invalid x509 name")
+	}
+	name := &Name{name: n}
+	runtime.SetFinalizer(name, func(n *Name) {
+		C.X509_NAME_free(n.name)
+	})
+	return name, nil
+}
+
+// CheckNameConstraints checks the subject and subject alternative names of
+// candidate against the name constraints of the CA certificate c, the way
+// chain verification does, including common names that look like host
+// names with OpenSSL 1.1.1 or newer. candidate need not be signed, so that
+// certificates can be checked before they are issued. A violation is
+// returned as a *VerifyError. Without a name constraints extension every
+// name is permitted. OpenSSL caches the extensions of candidate when they
+// are first inspected, so all of them need to be added beforehand.
+func (c *Certificate) CheckNameConstraints(candidate *Certificate) error {
+	crit := C.int(-1)
+	ext := C.X509_get_ext_d2i(c.x, C.int(NID_name_constraints), &crit, nil)
+	if ext == nil {
+		if crit == -1 {
+			return nil
+		}
+		return errors.New("invalid name constraints")
+	}
+	nc := (*C.NAME_CONSTRAINTS)(ext)
+	defer C.NAME_CONSTRAINTS_free(nc)
+
+	// the check reads the alternative names OpenSSL caches on first use.
+	// Filling that cache on a certificate that is still to be signed would
+	// leave stale extension and signature data behind, so the names are
+	// checked on a scratch certificate instead.
+	x := C.X509_new()
+	if x == nil {
+		return errors.New("failed to allocate certificate")
+	}
+	defer C.X509_free(x)
+	if C.X509_set_subject_name(x, C.X509_get_subject_name(candidate.x)) != 1 {
+		return errors.New("failed to copy subject name")
+	}
+	san := C.int(NID_subject_alt_name)
+	loc := C.X509_get_ext_by_NID(candidate.x, san, -1)
+	for ; loc >= 0; loc = C.X509_get_ext_by_NID(candidate.x, san, loc) {
+		if C.X509_add_ext(x, C.X509_get_ext(candidate.x, loc), -1) != 1 {
+			return errors.New("failed to copy subject alternative names")
+		}
+	}
+	runtime.KeepAlive(candidate)
+	C.X509_check_purpose(x, -1, 0)
+	rc := C.NAME_CONSTRAINTS_check(x, nc)
+	if rc == C.X509_V_OK {
+		rc = C.X_NAME_CONSTRAINTS_check_CN(x, nc)
+	}
+	if rc != C.X509_V_OK {
+		return &VerifyError{Result: VerifyResult(rc)}
+	}
+	return nil
+}
+
+// Identity is a set of names to check against name constraints with
+// CheckNameConstraintsIdentity.
+type Identity struct {
+	Subject        *Name
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+}
+
+// CheckNameConstraintsIdentity is like CheckNameConstraints for a candidate
+// identity, e.g. one taken from a certificate signing request.
+func (c *Certificate) CheckNameConstraintsIdentity(id *Identity) error {
+	candidate := &Certificate{x: C.X509_new()}
+	if candidate.x == nil {
+		return errors.New("failed to allocate certificate")
+	}
+	defer C.X509_free(candidate.x)
+	if id.Subject != nil {
+		if err := candidate.SetSubjectName(id.Subject); err != nil {
+			return err
+		}
+	}
+	var names []asn1.RawValue
+	add := func(tag int, value []byte) {
+		names = append(names, asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: tag, Bytes: value})
+	}
+	for _, email := range id.EmailAddresses {
+		add(1, []byte(email))
+	}
+	for _, dns := range id.DNSNames {
+		add(2, []byte(dns))
+	}
+	for _, uri := range id.URIs {
+		add(6, []byte(uri.String()))
+	}
+	for _, ip := range id.IPAddresses {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		add(7, ip)
+	}
+	if len(names) > 0 {
+		der, err := asn1.Marshal(names)
+		if err != nil {
+			return err
+		}
+		if err := candidate.AddExtensionByOID("2.5.29.17", false,
+			der); err != nil {
+			return err
+		}
+	}
+	return c.CheckNameConstraints(candidate)
+}
@@ -0,0 +1,188 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestConstrainedCA(t *testing.T) (*Certificate, PrivateKey) {
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := NewCertificate(&CertificateInfo{
+		Serial:     big.NewInt(1),
+		Expires:    24 * time.Hour,
+		CommonName: "Constrained CA",
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.SetVersion(X509_V3); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.AddExtensions(map[NID]string{
+		NID_basic_constraints: "critical,CA:TRUE",
+		NID_key_usage:         "critical,keyCertSign,cRLSign",
+		NID_name_constraints: "critical,permitted;DNS:example.com," +
+			"permitted;IP:10.0.0.0/255.0.0.0,permitted;email:example.com," +
+			"excluded;DNS:internal.example.com",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.Sign(key, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	return ca, key
+}
+
+func TestNameConstraints(t *testing.T) {
+	ca, _ := newTestConstrainedCA(t)
+	nc, err := ca.NameConstraints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nc.Critical || len(nc.PermittedDNSDomains) != 1 ||
+		nc.PermittedDNSDomains[0] != "example.com" ||
+		len(nc.ExcludedDNSDomains) != 1 ||
+		nc.ExcludedDNSDomains[0] != "internal.example.com" ||
+		len(nc.PermittedEmailAddresses) != 1 {
+		t.Fatalf("unexpected name constraints %+v", nc)
+	}
+	if len(nc.PermittedIPRanges) != 1 ||
+		nc.PermittedIPRanges[0].String() != "10.0.0.0/8" {
+		t.Fatalf("unexpected ip ranges %v", nc.PermittedIPRanges)
+	}
+
+	unconstrained, _ := newTestCA(t)
+	if nc, err := unconstrained.NameConstraints(); err != nil || nc != nil {
+		t.Fatalf("unexpected name constraints %+v, %v", nc, err)
+	}
+	if err := unconstrained.CheckNameConstraintsIdentity(&Identity{
+		DNSNames: []string{"anything.test"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckNameConstraintsIdentity(t *testing.T) {
+	ca, _ := newTestConstrainedCA(t)
+	subject := func(cn string) *Name {
+		name, err := NewName()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := name.AddTextEntry("CN", cn); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+
+	for _, test := range []struct {
+		name     string
+		identity Identity
+		result   VerifyResult
+	}{
+		{"permitted", Identity{
+			Subject:        subject("device.example.com"),
+			DNSNames:       []string{"device.example.com", "example.com"},
+			IPAddresses:    []net.IP{net.ParseIP("10.1.2.3")},
+			EmailAddresses: []string{"ops@example.com"},
+		}, Ok},
+		{"dns outside", Identity{
+			DNSNames: []string{"device.example.org"},
+		}, PermittedViolation},
+		{"dns excluded", Identity{
+			DNSNames: []string{"db.internal.example.com"},
+		}, ExcludedViolation},
+		{"ip outside", Identity{
+			IPAddresses: []net.IP{net.ParseIP("192.168.1.1")},
+		}, PermittedViolation},
+		{"email outside", Identity{
+			EmailAddresses: []string{"ops@example.org"},
+		}, PermittedViolation},
+		{"common name outside", Identity{
+			Subject: subject("device.example.org"),
+		}, PermittedViolation},
+	} {
+		err := ca.CheckNameConstraintsIdentity(&test.identity)
+		if test.result == Ok {
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			continue
+		}
+		var verr *VerifyError
+		if !errors.As(err, &verr) || verr.Result != test.result {
+			t.Fatalf("%s: unexpected error %v", test.name, err)
+		}
+	}
+}
+
+func TestIssueCertificateNameConstraints(t *testing.T) {
+	ca, cakey := newTestConstrainedCA(t)
+	issue := func(san string) (*Certificate, error) {
+		key, err := GenerateECKey(Prime256v1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := NewCertificateRequest(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := req.AddExtensions(map[NID]string{
+			NID_subject_alt_name: san,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := req.Sign(key, EVP_SHA256); err != nil {
+			t.Fatal(err)
+		}
+		return IssueCertificate(req, ca, cakey, &IssuanceProfile{
+			AllowedExtensions: []NID{NID_subject_alt_name},
+		})
+	}
+	cert, err := issue("DNS:device.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the check must not leave the issued certificate unusable
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddCertificate(ca); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Verify(cert, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issue("DNS:device.example.org"); !errors.Is(err,
+		ErrCertVerification) {
+		t.Fatalf("expected a name constraints violation, got %v", err)
+	}
+}
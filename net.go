@@ -15,48 +15,272 @@
 package openssl
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net"
+	"sync"
+	"time"
 )
 
 type listener struct {
 	net.Listener
 	ctx *Ctx
+
+	proxy_protocol       bool
+	proxy_header_timeout time.Duration
+
+	eager_handshake   bool
+	handshake_timeout time.Duration
+	handshake_errors  bool
+
+	limiter *handshakeLimiter
+
+	sockopts socketOptions
+
+	// set by options given invalid arguments
+	err error
+
+	// eager handshakes run in goroutines of their own, started by the first
+	// Accept, which hand over their results until the listener closes
+	accept_once sync.Once
+	accepted    chan acceptResult
+	closing     context.Context
+	cancel      context.CancelFunc
+	close_once  sync.Once
+	close_err   error
+	accept_err  error
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	if !l.eager_handshake {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		return l.serverConn(c)
+	}
+	l.accept_once.Do(func() {
+		go l.acceptLoop()
+	})
+	select {
+	case r := <-l.accepted:
+		return r.conn, r.err
+	case <-l.closing.Done():
+		return nil, l.accept_err
+	}
+}
+
+// Close closes the listener, aborting pending eager handshakes.
+func (l *listener) Close() error {
+	if !l.eager_handshake {
+		return l.Listener.Close()
+	}
+	return l.closeWith(net.ErrClosed)
+}
+
+// closeWith closes the listener, making Accept return err from then on.
+func (l *listener) closeWith(err error) error {
+	l.close_once.Do(func() {
+		l.accept_err = err
+		l.cancel()
+		l.close_err = l.Listener.Close()
+	})
+	return l.close_err
+}
+
+func (l *listener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			var net_err net.Error
+			if errors.As(err, &net_err) && net_err.Temporary() {
+				// leave backing off to the caller, as without eager
+				// handshakes
+				if !l.deliver(acceptResult{err: err}) {
+					return
+				}
+				continue
+			}
+			l.closeWith(err)
+			return
+		}
+		go l.eagerHandshake(c)
+	}
 }
 
-func (l *listener) Accept() (c net.Conn, err error) {
-	c, err = l.Listener.Accept()
+// eagerHandshake hands c over to Accept once its handshake succeeded.
+func (l *listener) eagerHandshake(c net.Conn) {
+	ssl_c, err := l.serverConn(c)
 	if err != nil {
+		l.deliver(acceptResult{err: err})
+		return
+	}
+	if err := l.handshake(ssl_c); err != nil {
+		remote := ssl_c.RemoteAddr()
+		ssl_c.Close()
+		if err == net.ErrClosed {
+			return
+		}
+		if l.handshake_errors {
+			l.deliver(acceptResult{err: &AcceptError{RemoteAddr: remote,
+				Err: err}})
+			return
+		}
+		getLogger().Log(LogInfo, "openssl: handshake failed",
+			LogField{Key: "remote", Value: remote},
+			LogField{Key: "error", Value: err})
+		return
+	}
+	if !l.deliver(acceptResult{conn: ssl_c}) {
+		ssl_c.Close()
+	}
+}
+
+// deliver waits for Accept to take r, reporting false if the listener
+// closes first.
+func (l *listener) deliver(r acceptResult) bool {
+	select {
+	case l.accepted <- r:
+		return true
+	case <-l.closing.Done():
+		return false
+	}
+}
+
+// serverConn wraps the accepted connection c as configured.
+func (l *listener) serverConn(c net.Conn) (*Conn, error) {
+	if err := l.sockopts.apply(c); err != nil {
+		c.Close()
 		return nil, err
 	}
+	if l.proxy_protocol {
+		c = newProxyConn(c, l.proxy_header_timeout)
+	}
 	ssl_c, err := Server(c, l.ctx)
 	if err != nil {
 		c.Close()
 		return nil, err
 	}
+	if l.limiter != nil {
+		ssl_c.handshake_limit = l.limiter
+		ssl_c.limit_wait = newHandshakeWait()
+	}
 	return ssl_c, nil
 }
 
+// handshake runs the handshake of ssl_c within the handshake timeout, which
+// also bounds any wait for a handshake limit. Closing the listener aborts it
+// with net.ErrClosed.
+func (l *listener) handshake(ssl_c *Conn) error {
+	if l.handshake_timeout > 0 {
+		ssl_c.SetDeadline(time.Now().Add(l.handshake_timeout))
+	}
+	stop := watchContext(l.closing, ssl_c)
+	err := ssl_c.Handshake()
+	if stop() {
+		return net.ErrClosed
+	}
+	if err != nil {
+		return err
+	}
+	ssl_c.SetDeadline(time.Time{})
+	return nil
+}
+
+// WithEagerHandshake makes the listener complete the handshake of each
+// connection before Accept returns it, instead of leaving it to the first
+// Read or Write, so that servers reject failing clients before spending a
+// goroutine of their own on them. Handshakes run concurrently, subject to
+// WithMaxConcurrentHandshakes, and Accept returns connections as their
+// handshakes complete, so slow clients hold up nobody else. timeout bounds
+// each handshake; zero means no limit. Failed handshakes are logged at
+// LogInfo and skipped, unless WithHandshakeErrors is given too.
+func WithEagerHandshake(timeout time.Duration) ListenerOption {
+	return func(l *listener) {
+		l.eager_handshake = true
+		l.handshake_timeout = timeout
+	}
+}
+
+// WithHandshakeErrors makes Accept return the failed handshakes of
+// WithEagerHandshake as *AcceptError instead of logging them. Leave it out
+// for http.Server.Serve, which backs off for up to a second after every
+// error Accept returns, so that failing clients would slow down accepting
+// everyone else.
+func WithHandshakeErrors() ListenerOption {
+	return func(l *listener) {
+		l.handshake_errors = true
+	}
+}
+
+// AcceptError is returned by Accept of listeners with WithEagerHandshake
+// and WithHandshakeErrors when the handshake with a client fails. The
+// listener remains usable.
+type AcceptError struct {
+	RemoteAddr net.Addr
+	Err        error
+}
+
+func (e *AcceptError) Error() string {
+	return fmt.Sprintf("handshake with %v failed: %v", e.RemoteAddr, e.Err)
+}
+
+func (e *AcceptError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the handshake timed out.
+func (e *AcceptError) Timeout() bool {
+	var net_err net.Error
+	return errors.As(e.Err, &net_err) && net_err.Timeout()
+}
+
+// Temporary always reports true: the failure concerns one client and later
+// calls to Accept may succeed.
+func (e *AcceptError) Temporary() bool {
+	return true
+}
+
 // NewListener wraps an existing net.Listener such that all accepted
 // connections are wrapped as OpenSSL server connections using the provided
 // context ctx.
-func NewListener(inner net.Listener, ctx *Ctx) net.Listener {
-	return &listener{
+func NewListener(inner net.Listener, ctx *Ctx,
+	opts ...ListenerOption) net.Listener {
+	l := &listener{
 		Listener: inner,
 		ctx:      ctx}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.eager_handshake {
+		l.accepted = make(chan acceptResult)
+		l.closing, l.cancel = context.WithCancel(context.Background())
+	}
+	return l
 }
 
 // Listen is a wrapper around net.Listen that wraps incoming connections with
-// an OpenSSL server connection using the provided context ctx.
-func Listen(network, laddr string, ctx *Ctx) (net.Listener, error) {
+// an OpenSSL server connection using the provided context ctx. Options such as
+// WithReusePort and WithControl configure the listening socket.
+func Listen(network, laddr string, ctx *Ctx,
+	opts ...ListenerOption) (net.Listener, error) {
 	if ctx == nil {
 		return nil, errors.New("no ssl context provided")
 	}
-	l, err := net.Listen(network, laddr)
+	l, err := listen(network, laddr, opts)
 	if err != nil {
 		return nil, err
 	}
-	return NewListener(l, ctx), nil
+	return NewListener(l, ctx, opts...), nil
 }
 
 type DialFlags int
@@ -64,18 +288,32 @@ type DialFlags int
 const (
 	InsecureSkipHostVerification DialFlags = 1 << iota
 	DisableSNI
+	// InsecureSkipVerify accepts any server certificate chain and hostname,
+	// leaving authentication of the server to the VerifyPeerCertificate
+	// callback of the context, which should then be set. Without one the
+	// connection is open to man-in-the-middle attacks. Such connections
+	// bypass the client session cache of the context, so that later
+	// verified dials never resume an unverified session.
+	InsecureSkipVerify
 )
 
 // Dial will connect to network/address and then wrap the corresponding
 // underlying connection with an OpenSSL client connection using context ctx.
 // If flags includes InsecureSkipHostVerification, the server certificate's
-// hostname will not be checked to match the hostname in addr. Otherwise, flags
-// should be 0.
+// hostname will not be checked to match the hostname in addr. If it includes
+// InsecureSkipVerify, neither the hostname nor the certificate chain are
+// checked. Otherwise, flags should be 0.
 //
 // Dial probably won't work for you unless you set a verify location or add
 // some certs to the certificate store of the client context you're using.
 // This library is not nice enough to use the system certificate store by
 // default for you yet.
+//
+// For the "unix" and "unixpacket" networks addr is a socket path, or an
+// abstract socket name starting with "@" on Linux. Such addresses carry no
+// hostname, so neither SNI nor hostname verification are performed; rely on
+// the certificate chain verification configured on ctx to authenticate the
+// peer.
 func Dial(network, addr string, ctx *Ctx, flags DialFlags) (*Conn, error) {
 	return DialSession(network, addr, ctx, flags, nil)
 }
@@ -83,8 +321,9 @@ func Dial(network, addr string, ctx *Ctx, flags DialFlags) (*Conn, error) {
 // DialSession will connect to network/address and then wrap the corresponding
 // underlying connection with an OpenSSL client connection using context ctx.
 // If flags includes InsecureSkipHostVerification, the server certificate's
-// hostname will not be checked to match the hostname in addr. Otherwise, flags
-// should be 0.
+// hostname will not be checked to match the hostname in addr. If it includes
+// InsecureSkipVerify, neither the hostname nor the certificate chain are
+// checked. Otherwise, flags should be 0.
 //
 // Dial probably won't work for you unless you set a verify location or add
 // some certs to the certificate store of the client context you're using.
@@ -95,10 +334,36 @@ func Dial(network, addr string, ctx *Ctx, flags DialFlags) (*Conn, error) {
 // can be retrieved from the GetSession method on the Conn.
 func DialSession(network, addr string, ctx *Ctx, flags DialFlags,
 	session []byte) (*Conn, error) {
+	var dialer net.Dialer
+	return dialSession(context.Background(), dialer.DialContext, network,
+		addr, ctx, flags, session)
+}
 
-	host, _, err := net.SplitHostPort(addr)
-	if err != nil {
-		return nil, err
+// DialContext is like Dial but connects with dialer, which may be nil, and
+// aborts the connection and handshake once dial_ctx is done.
+func DialContext(dial_ctx context.Context, dialer *net.Dialer, network,
+	addr string, ctx *Ctx, flags DialFlags) (*Conn, error) {
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	return dialSession(dial_ctx, dialer.DialContext, network, addr, ctx,
+		flags, nil)
+}
+
+// dialSession connects to addr with dial and performs the client handshake
+// over the connection.
+func dialSession(dial_ctx context.Context, dial func(context.Context, string,
+	string) (net.Conn, error), network, addr string, ctx *Ctx,
+	flags DialFlags, session []byte) (*Conn, error) {
+
+	// unix socket addresses carry no hostname to verify or send as SNI
+	var host string
+	if !isUnixNetwork(network) {
+		var err error
+		host, _, err = net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
 	}
 	if ctx == nil {
 		var err error
@@ -108,35 +373,83 @@ func DialSession(network, addr string, ctx *Ctx, flags DialFlags,
 		}
 		// TODO: use operating system default certificate chain?
 	}
-	c, err := net.Dial(network, addr)
+	if ctx.tracer == nil {
+		return dialConn(dial_ctx, dial, network, addr, host, ctx, flags,
+			session)
+	}
+	dial_ctx, span := ctx.tracer.StartSpan(dial_ctx, SpanDial)
+	span.SetAttributes(TraceAttribute{AttrNetwork, network},
+		TraceAttribute{AttrPeerAddr, addr})
+	conn, err := dialConn(dial_ctx, dial, network, addr, host, ctx, flags,
+		session)
+	span.End(err)
+	return conn, err
+}
+
+// dialConn does the work of dialSession once host and ctx are known.
+func dialConn(dial_ctx context.Context, dial func(context.Context, string,
+	string) (net.Conn, error), network, addr, host string, ctx *Ctx,
+	flags DialFlags, session []byte) (*Conn, error) {
+	c, err := dial(dial_ctx, network, addr)
 	if err != nil {
 		return nil, err
 	}
+	stop := watchContext(dial_ctx, c)
 	conn, err := Client(c, ctx)
 	if err != nil {
+		stop()
 		c.Close()
 		return nil, err
 	}
+	conn.setTraceContext(dial_ctx)
+	// sessions of unverified connections are neither resumed nor stored, as
+	// resumption skips chain verification
+	if session == nil && ctx.client_sessions != nil &&
+		flags&InsecureSkipVerify == 0 {
+		servername := ""
+		if flags&DisableSNI == 0 {
+			servername = host
+		}
+		conn.session_key = clientSessionKey(addr, servername)
+		conn.session_cache = ctx.client_sessions
+		if cached, ok := conn.session_cache.Get(conn.session_key); ok {
+			if conn.setSession(cached) != nil {
+				conn.session_cache.Remove(conn.session_key)
+			}
+		}
+	}
 	if session != nil {
 		err := conn.setSession(session)
 		if err != nil {
+			stop()
 			c.Close()
 			return nil, err
 		}
 	}
-	if flags&DisableSNI == 0 {
+	if flags&InsecureSkipVerify != 0 {
+		conn.SetVerify(VerifyPeer, func(bool, *CertificateStoreCtx) bool {
+			return true
+		})
+	}
+	if flags&DisableSNI == 0 && host != "" {
 		err = conn.SetTlsExtHostName(host)
 		if err != nil {
+			stop()
 			conn.Close()
 			return nil, err
 		}
 	}
 	err = conn.Handshake()
+	if stop() {
+		conn.Close()
+		return nil, dial_ctx.Err()
+	}
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
-	if flags&InsecureSkipHostVerification == 0 {
+	if flags&(InsecureSkipHostVerification|InsecureSkipVerify) == 0 &&
+		host != "" {
 		err = conn.VerifyHostname(host)
 		if err != nil {
 			conn.Close()
@@ -145,3 +458,35 @@ func DialSession(network, addr string, ctx *Ctx, flags DialFlags,
 	}
 	return conn, nil
 }
+
+func isUnixNetwork(network string) bool {
+	switch network {
+	case "unix", "unixpacket":
+		return true
+	}
+	return false
+}
+
+// watchContext expires c's deadline once ctx is done, unblocking pending
+// I/O, until the returned stop function is called. stop reports whether the
+// deadline was expired.
+func watchContext(ctx context.Context, c net.Conn) (stop func() bool) {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+	done := make(chan struct{})
+	expired := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Unix(1, 0))
+			expired <- true
+		case <-done:
+			expired <- false
+		}
+	}()
+	return func() bool {
+		close(done)
+		return <-expired
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestDialContextHandshakeTimeout(t *testing.T) {
+	// accepts connections but never answers the handshake
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	dial_ctx, cancel := context.WithTimeout(context.Background(),
+		100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = DialContext(dial_ctx, nil, "tcp", l.Addr().String(), nil,
+		InsecureSkipHostVerification)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("handshake was not aborted")
+	}
+}
+
+func testDialUnix(t *testing.T, network, addr string, size int) {
+	server := newTestTLSServer(t, network, addr)
+	defer server.Close()
+	conn, err := Dial(network, addr, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	msg := append(bytes.Repeat([]byte("x"), size), '\n')
+	go conn.Write(msg)
+	reply, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply, msg) {
+		t.Fatalf("got %d bytes back, sent %d", len(reply), len(msg))
+	}
+}
+
+func TestDialUnix(t *testing.T) {
+	dir, err := os.MkdirTemp("", "openssl-unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testDialUnix(t, "unix", filepath.Join(dir, "tls.sock"), 64)
+}
+
+func TestDialUnixAbstract(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract sockets are linux only")
+	}
+	testDialUnix(t, "unix", fmt.Sprintf("@go-openssl-test-%d", os.Getpid()),
+		64)
+}
+
+func TestDialUnixPacket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("unixpacket is linux only")
+	}
+	dir, err := os.MkdirTemp("", "openssl-unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// spans several records, each of which must fit a single packet
+	testDialUnix(t, "unixpacket", filepath.Join(dir, "tls.sock"),
+		4*SSLRecordSize)
+}
+
+func newEagerTestCtx(t *testing.T) *Ctx {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+func TestListenerEagerHandshake(t *testing.T) {
+	l, err := Listen("tcp", "localhost:0", newEagerTestCtx(t),
+		WithEagerHandshake(200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	addr := l.Addr().String()
+
+	logs := &recordingLogger{}
+	SetLogger(logs)
+	defer SetLogger(nil)
+
+	// a client that is not speaking TLS and one that never starts the
+	// handshake are skipped by Accept
+	go func() {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		fmt.Fprint(c, "GET / HTTP/1.0\r\n\r\n")
+		c.Read(make([]byte, 1))
+	}()
+	go func() {
+		silent, err := net.Dial("tcp", addr)
+		if err != nil {
+			return
+		}
+		defer silent.Close()
+		silent.Read(make([]byte, 1))
+	}()
+	go func() {
+		// after the failing clients
+		time.Sleep(100 * time.Millisecond)
+		c, err := Dial("tcp", addr, nil, InsecureSkipVerify)
+		if err == nil {
+			defer c.Close()
+			c.Read(make([]byte, 1))
+		}
+	}()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.(*Conn).CurrentCipher(); err != nil {
+		t.Fatal("accepted connection without handshake")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logs.mtx.Lock()
+		msgs := append([]string(nil), logs.msgs...)
+		logs.mtx.Unlock()
+		if len(msgs) == 2 && msgs[0] == "openssl: handshake failed" {
+			break
+		}
+		if len(msgs) > 2 || time.Now().After(deadline) {
+			t.Fatalf("expected two failed handshakes logged, got %q", msgs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestListenerEagerHandshakeStalledClient(t *testing.T) {
+	// without a timeout, a silent client must only hold up itself
+	l, err := Listen("tcp", "localhost:0", newEagerTestCtx(t),
+		WithEagerHandshake(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	silent, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	clients := make(chan *Conn, 1)
+	go func() {
+		c, err := Dial("tcp", addr, nil, InsecureSkipVerify)
+		if err != nil {
+			clients <- nil
+			return
+		}
+		clients <- c
+	}()
+	accepted := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			c.Close()
+		}
+		accepted <- err
+	}()
+	select {
+	case err := <-accepted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a stalled client blocked Accept")
+	}
+	if c := <-clients; c != nil {
+		c.Close()
+	}
+
+	// closing the listener aborts the pending handshake
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	silent.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := silent.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("expected the pending handshake to be aborted, got %v", err)
+	}
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestListenerHandshakeErrors(t *testing.T) {
+	l, err := Listen("tcp", "localhost:0", newEagerTestCtx(t),
+		WithEagerHandshake(time.Second), WithHandshakeErrors())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fmt.Fprint(c, "GET / HTTP/1.0\r\n\r\n")
+
+	_, err = l.Accept()
+	var accept_err *AcceptError
+	if !errors.As(err, &accept_err) {
+		t.Fatalf("expected *AcceptError, got %v", err)
+	}
+	if accept_err.RemoteAddr.String() != c.LocalAddr().String() {
+		t.Fatalf("unexpected remote address %v", accept_err.RemoteAddr)
+	}
+	if !accept_err.Temporary() {
+		t.Fatal("expected a temporary error")
+	}
+}
@@ -198,9 +198,17 @@ const (
 	NID_ad_ca_issuers                      NID = 179
 	NID_OCSP_sign                          NID = 180
 	NID_X9_62_id_ecPublicKey               NID = 408
+	NID_name_constraints                   NID = 666
+	NID_sha256WithRSAEncryption            NID = 668
+	NID_sha384WithRSAEncryption            NID = 669
+	NID_sha512WithRSAEncryption            NID = 670
+	NID_ecdsa_with_SHA256                  NID = 794
+	NID_ecdsa_with_SHA384                  NID = 795
+	NID_ecdsa_with_SHA512                  NID = 796
 	NID_hmac                               NID = 855
 	NID_cmac                               NID = 894
 	NID_dhpublicnumber                     NID = 920
+	NID_tlsfeature                         NID = 1020
 	NID_tls1_prf                           NID = 1021
 	NID_hkdf                               NID = 1036
 	NID_X25519                             NID = 1034
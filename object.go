@@ -17,8 +17,70 @@ package openssl
 // #include "shim.h"
 import "C"
 
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
 // CreateObjectIdentifier creates ObjectIdentifier and returns NID for the created
-// ObjectIdentifier
+// ObjectIdentifier, or NID_undef if it cannot be created.
+//
+// Deprecated: use RegisterOID, which reports why registration failed.
 func CreateObjectIdentifier(oid string, shortName string, longName string) NID {
-	return NID(C.OBJ_create(C.CString(oid), C.CString(shortName), C.CString(longName)))
+	nid, _ := RegisterOID(oid, shortName, longName)
+	return nid
+}
+
+// oidRegistry serializes registrations, which are not atomic in OpenSSL.
+var oidRegistry sync.Mutex
+
+// RegisterOID registers a custom object identifier, given in dotted form,
+// with OpenSSL's object table under short_name and long_name, and returns
+// the NID assigned to it. Registered identifiers are shown by name in
+// certificate names, extensions and dumps, and can be used wherever NIDs
+// are taken. Registering the same identifier again with the same names
+// returns its NID, while names already taken by another identifier are an
+// error. Registrations last for the lifetime of the process.
+func RegisterOID(oid, short_name, long_name string) (NID, error) {
+	if short_name == "" || long_name == "" {
+		return NID_undef, errors.New("object identifier names are required")
+	}
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	csn := C.CString(short_name)
+	defer C.free(unsafe.Pointer(csn))
+	cln := C.CString(long_name)
+	defer C.free(unsafe.Pointer(cln))
+
+	obj := C.OBJ_txt2obj(coid, 1)
+	if obj == nil {
+		return NID_undef, fmt.Errorf("invalid object identifier %q", oid)
+	}
+	defer C.ASN1_OBJECT_free(obj)
+
+	oidRegistry.Lock()
+	defer oidRegistry.Unlock()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if nid := C.OBJ_obj2nid(obj); nid != C.NID_undef {
+		if C.GoString(C.OBJ_nid2sn(nid)) == short_name &&
+			C.GoString(C.OBJ_nid2ln(nid)) == long_name {
+			return NID(nid), nil
+		}
+		return NID_undef, fmt.Errorf("object identifier %s is already "+
+			"registered as %s", oid, C.GoString(C.OBJ_nid2sn(nid)))
+	}
+	if C.OBJ_sn2nid(csn) != C.NID_undef || C.OBJ_ln2nid(cln) != C.NID_undef {
+		return NID_undef, fmt.Errorf("object identifier name %s or %s is "+
+			"already taken", short_name, long_name)
+	}
+	nid := C.OBJ_create(coid, csn, cln)
+	if nid == C.NID_undef {
+		return NID_undef, errorFromErrorQueue()
+	}
+	return NID(nid), nil
 }
@@ -0,0 +1,86 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegisterOID(t *testing.T) {
+	const oid = "1.3.6.1.4.1.55555.1.1"
+	nid, err := RegisterOID(oid, "deviceClass", "Device Class")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nid == NID_undef {
+		t.Fatal("expected a nid")
+	}
+	again, err := RegisterOID(oid, "deviceClass", "Device Class")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != nid {
+		t.Fatalf("registered twice as %d and %d", nid, again)
+	}
+	if _, err := RegisterOID(oid, "otherClass", "Other Class"); err == nil {
+		t.Fatal("expected an error renaming a registered identifier")
+	}
+	if _, err := RegisterOID("1.3.6.1.4.1.55555.1.2", "deviceClass",
+		"Device Class"); err == nil {
+		t.Fatal("expected an error reusing registered names")
+	}
+	if _, err := RegisterOID("not an oid", "x", "y"); err == nil {
+		t.Fatal("expected an error for an invalid identifier")
+	}
+
+	name, err := NewName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := name.AddEntryByOID(oid, "gateway"); err != nil {
+		t.Fatal(err)
+	}
+	if s := name.String(); s != "deviceClass=gateway" {
+		t.Fatalf("unexpected name %s", s)
+	}
+	entries, err := name.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].NID != nid || entries[0].OID != oid {
+		t.Fatalf("unexpected entry %+v", entries[0])
+	}
+
+	elements, err := ParseASN1([]byte{0x06, 0x0a, 0x2b, 0x06, 0x01, 0x04,
+		0x01, 0x83, 0xb2, 0x03, 0x01, 0x01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	long_name, err := elements[0].OIDName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if long_name != "Device Class" {
+		t.Fatalf("unexpected long name %s", long_name)
+	}
+	dump, err := DumpASN1(elements[0].Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dump, ":Device Class") {
+		t.Fatalf("unexpected dump %s", dump)
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"runtime"
+	"time"
+	"unsafe"
+)
+
+// OCSPCertStatus is the revocation status reported for a certificate.
+type OCSPCertStatus int
+
+const (
+	OCSPGood    OCSPCertStatus = C.V_OCSP_CERTSTATUS_GOOD
+	OCSPRevoked OCSPCertStatus = C.V_OCSP_CERTSTATUS_REVOKED
+	OCSPUnknown OCSPCertStatus = C.V_OCSP_CERTSTATUS_UNKNOWN
+)
+
+// OCSPResponseStatus is the outcome of processing an OCSP request. Only
+// OCSPSuccessful responses carry certificate statuses.
+type OCSPResponseStatus int
+
+const (
+	OCSPSuccessful       OCSPResponseStatus = C.OCSP_RESPONSE_STATUS_SUCCESSFUL
+	OCSPMalformedRequest OCSPResponseStatus = C.OCSP_RESPONSE_STATUS_MALFORMEDREQUEST
+	OCSPInternalError    OCSPResponseStatus = C.OCSP_RESPONSE_STATUS_INTERNALERROR
+	OCSPTryLater         OCSPResponseStatus = C.OCSP_RESPONSE_STATUS_TRYLATER
+	OCSPSigRequired      OCSPResponseStatus = C.OCSP_RESPONSE_STATUS_SIGREQUIRED
+	OCSPUnauthorized     OCSPResponseStatus = C.OCSP_RESPONSE_STATUS_UNAUTHORIZED
+)
+
+// OCSPCertID identifies a certificate by its issuer's name and key hashes and
+// its serial number.
+type OCSPCertID struct {
+	id *C.OCSP_CERTID
+}
+
+func newOCSPCertID(id *C.OCSP_CERTID) *OCSPCertID {
+	c := &OCSPCertID{id: id}
+	runtime.SetFinalizer(c, func(c *OCSPCertID) {
+		C.OCSP_CERTID_free(c.id)
+	})
+	return c
+}
+
+// NewOCSPCertID creates the identifier of cert, which was issued by issuer.
+// The issuer hashes are computed with SHA-1 as required by RFC 5019.
+func NewOCSPCertID(cert, issuer *Certificate) (*OCSPCertID, error) {
+	id := C.OCSP_cert_to_id(C.X_EVP_sha1(), cert.x, issuer.x)
+	if id == nil {
+		return nil, errors.New("failed to create ocsp cert id")
+	}
+	return newOCSPCertID(id), nil
+}
+
+// SerialNumber returns the serial number of the identified certificate.
+func (c *OCSPCertID) SerialNumber() (*big.Int, error) {
+	var serial *C.ASN1_INTEGER
+	if C.OCSP_id_get0_info(nil, nil, nil, &serial, c.id) != 1 {
+		return nil, errors.New("failed to read ocsp cert id")
+	}
+	return bigInt(serial)
+}
+
+// IssuedBy reports whether the identified certificate was issued by issuer.
+func (c *OCSPCertID) IssuedBy(issuer *Certificate) bool {
+	return C.X_OCSP_id_issued_by(c.id, issuer.x) == 1
+}
+
+// OCSPRequest is an OCSP request for the status of one or more certificates.
+type OCSPRequest struct {
+	req *C.OCSP_REQUEST
+}
+
+func newOCSPRequest(req *C.OCSP_REQUEST) *OCSPRequest {
+	r := &OCSPRequest{req: req}
+	runtime.SetFinalizer(r, func(r *OCSPRequest) {
+		C.OCSP_REQUEST_free(r.req)
+	})
+	return r
+}
+
+// NewOCSPRequest creates an empty OCSP request.
+func NewOCSPRequest() (*OCSPRequest, error) {
+	req := C.OCSP_REQUEST_new()
+	if req == nil {
+		return nil, errors.New("failed to allocate ocsp request")
+	}
+	return newOCSPRequest(req), nil
+}
+
+// AddCertID asks for the status of the identified certificate.
+func (r *OCSPRequest) AddCertID(id *OCSPCertID) error {
+	dup := C.OCSP_CERTID_dup(id.id)
+	if dup == nil {
+		return errors.New("failed to copy ocsp cert id")
+	}
+	if C.OCSP_request_add0_id(r.req, dup) == nil {
+		C.OCSP_CERTID_free(dup)
+		return errors.New("failed to add ocsp cert id")
+	}
+	return nil
+}
+
+// AddNonce adds a random nonce extension, which a responder echoes to
+// prevent replay of old responses.
+func (r *OCSPRequest) AddNonce() error {
+	if C.OCSP_request_add1_nonce(r.req, nil, -1) != 1 {
+		return errors.New("failed to add ocsp nonce")
+	}
+	return nil
+}
+
+// CertIDs returns the identifiers of the certificates in the request.
+func (r *OCSPRequest) CertIDs() ([]*OCSPCertID, error) {
+	n := int(C.OCSP_request_onereq_count(r.req))
+	ids := make([]*OCSPCertID, 0, n)
+	for i := 0; i < n; i++ {
+		one := C.OCSP_request_onereq_get0(r.req, C.int(i))
+		id := C.OCSP_CERTID_dup(C.OCSP_onereq_get0_id(one))
+		if id == nil {
+			return nil, errors.New("failed to copy ocsp cert id")
+		}
+		ids = append(ids, newOCSPCertID(id))
+	}
+	return ids, nil
+}
+
+// MarshalDER converts the request to a DER-encoded block.
+func (r *OCSPRequest) MarshalDER() (der_block []byte, err error) {
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+	if int(C.X_i2d_OCSP_REQUEST_bio(bio, r.req)) != 1 {
+		return nil, errors.New("failed dumping ocsp request der")
+	}
+	return ioutil.ReadAll(asAnyBio(bio))
+}
+
+// LoadOCSPRequestFromDER loads an OCSP request from a DER-encoded block.
+func LoadOCSPRequestFromDER(der_block []byte) (*OCSPRequest, error) {
+	if len(der_block) == 0 {
+		return nil, errors.New("empty der block")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	bio := C.BIO_new_mem_buf(unsafe.Pointer(&der_block[0]),
+		C.int(len(der_block)))
+	req := C.X_d2i_OCSP_REQUEST_bio(bio)
+	C.BIO_free(bio)
+	if req == nil {
+		return nil, errorFromErrorQueue()
+	}
+	return newOCSPRequest(req), nil
+}
+
+// OCSPResponseBuilder assembles the certificate statuses of a successful OCSP
+// response on the responder side.
+type OCSPResponseBuilder struct {
+	basic *C.OCSP_BASICRESP
+}
+
+// NewOCSPResponseBuilder creates a builder without any statuses.
+func NewOCSPResponseBuilder() (*OCSPResponseBuilder, error) {
+	basic := C.OCSP_BASICRESP_new()
+	if basic == nil {
+		return nil, errors.New("failed to allocate ocsp response")
+	}
+	b := &OCSPResponseBuilder{basic: basic}
+	runtime.SetFinalizer(b, func(b *OCSPResponseBuilder) {
+		C.OCSP_BASICRESP_free(b.basic)
+	})
+	return b, nil
+}
+
+// asn1Time converts t to a newly allocated ASN1_TIME, which the caller must
+// free. The zero time converts to nil.
+func asn1Time(t time.Time) (*C.ASN1_TIME, error) {
+	if t.IsZero() {
+		return nil, nil
+	}
+	tm := C.ASN1_TIME_set(nil, C.time_t(t.Unix()))
+	if tm == nil {
+		return nil, errors.New("failed to convert time")
+	}
+	return tm, nil
+}
+
+// AddStatus reports the status of the identified certificate, valid from
+// thisUpdate until nextUpdate. A zero nextUpdate is left out of the
+// response. revokedAt and reason are only used for OCSPRevoked.
+func (b *OCSPResponseBuilder) AddStatus(id *OCSPCertID, status OCSPCertStatus,
+	revokedAt time.Time, reason RevocationReason, thisUpdate,
+	nextUpdate time.Time) error {
+	var revtime *C.ASN1_TIME
+	creason := C.int(C.OCSP_REVOKED_STATUS_NOSTATUS)
+	if status == OCSPRevoked {
+		if revokedAt.IsZero() {
+			return errors.New("revoked status requires a revocation time")
+		}
+		var err error
+		if revtime, err = asn1Time(revokedAt); err != nil {
+			return err
+		}
+		defer C.ASN1_TIME_free(revtime)
+		if reason != ReasonUnspecified {
+			creason = C.int(reason)
+		}
+	}
+	thisupd, err := asn1Time(thisUpdate)
+	if err != nil {
+		return err
+	}
+	if thisupd == nil {
+		return errors.New("status requires a this update time")
+	}
+	defer C.ASN1_TIME_free(thisupd)
+	nextupd, err := asn1Time(nextUpdate)
+	if err != nil {
+		return err
+	}
+	if nextupd != nil {
+		defer C.ASN1_TIME_free(nextupd)
+	}
+	if C.OCSP_basic_add1_status(b.basic, id.id, C.int(status), creason,
+		revtime, thisupd, nextupd) == nil {
+		return errors.New("failed to add ocsp status")
+	}
+	return nil
+}
+
+// CopyNonce echoes the nonce of req, if it has one, in the response.
+func (b *OCSPResponseBuilder) CopyNonce(req *OCSPRequest) error {
+	if C.OCSP_copy_nonce(b.basic, req.req) <= 0 {
+		return errors.New("failed to copy ocsp nonce")
+	}
+	return nil
+}
+
+// Sign signs the statuses with the responder's key and returns the
+// successful response. signer is either the CA that issued the certificates
+// or a responder certificate delegated by it; it and any chain certificates
+// are included in the response. Ed25519 keys require EVP_NULL as digest.
+func (b *OCSPResponseBuilder) Sign(signer *Certificate, key PrivateKey,
+	digest EVP_MD, chain ...*Certificate) (*OCSPResponse, error) {
+	md, err := signingDigest(key, digest)
+	if err != nil {
+		return nil, err
+	}
+	for _, cert := range chain {
+		if C.OCSP_basic_add1_cert(b.basic, cert.x) != 1 {
+			return nil, errors.New("failed to add ocsp certificate")
+		}
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.OCSP_basic_sign(b.basic, signer.x, key.evpPKey(), md, nil, 0) != 1 {
+		return nil, errorFromErrorQueue()
+	}
+	resp := C.OCSP_response_create(C.OCSP_RESPONSE_STATUS_SUCCESSFUL,
+		b.basic)
+	if resp == nil {
+		return nil, errors.New("failed to create ocsp response")
+	}
+	return newOCSPResponse(resp), nil
+}
+
+// OCSPResponse is an OCSP response.
+type OCSPResponse struct {
+	resp *C.OCSP_RESPONSE
+}
+
+func newOCSPResponse(resp *C.OCSP_RESPONSE) *OCSPResponse {
+	r := &OCSPResponse{resp: resp}
+	runtime.SetFinalizer(r, func(r *OCSPResponse) {
+		C.OCSP_RESPONSE_free(r.resp)
+	})
+	return r
+}
+
+// NewOCSPErrorResponse creates an unsigned response reporting that a request
+// could not be answered, e.g. OCSPMalformedRequest or OCSPUnauthorized.
+func NewOCSPErrorResponse(status OCSPResponseStatus) (*OCSPResponse, error) {
+	if status == OCSPSuccessful {
+		return nil, errors.New("successful responses must be signed")
+	}
+	resp := C.OCSP_response_create(C.int(status), nil)
+	if resp == nil {
+		return nil, errors.New("failed to create ocsp response")
+	}
+	return newOCSPResponse(resp), nil
+}
+
+// Status returns the response status.
+func (r *OCSPResponse) Status() OCSPResponseStatus {
+	return OCSPResponseStatus(C.OCSP_response_status(r.resp))
+}
+
+// MarshalDER converts the response to a DER-encoded block.
+func (r *OCSPResponse) MarshalDER() (der_block []byte, err error) {
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+	if int(C.X_i2d_OCSP_RESPONSE_bio(bio, r.resp)) != 1 {
+		return nil, errors.New("failed dumping ocsp response der")
+	}
+	return ioutil.ReadAll(asAnyBio(bio))
+}
+
+// LoadOCSPResponseFromDER loads an OCSP response from a DER-encoded block.
+// The signature is not checked.
+func LoadOCSPResponseFromDER(der_block []byte) (*OCSPResponse, error) {
+	if len(der_block) == 0 {
+		return nil, errors.New("empty der block")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	bio := C.BIO_new_mem_buf(unsafe.Pointer(&der_block[0]),
+		C.int(len(der_block)))
+	resp := C.X_d2i_OCSP_RESPONSE_bio(bio)
+	C.BIO_free(bio)
+	if resp == nil {
+		return nil, errorFromErrorQueue()
+	}
+	return newOCSPResponse(resp), nil
+}
@@ -0,0 +1,148 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestOCSPRequestResponse(t *testing.T) {
+	ca, cakey := newTestCA(t)
+
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := NewCertificateRequest(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := csr.Sign(key, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := IssueCertificate(csr, ca, cakey, &IssuanceProfile{
+		Serial: func() (*big.Int, error) { return big.NewInt(1001), nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// client side
+	id, err := NewOCSPCertID(cert, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := NewOCSPRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.AddCertID(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := req.AddNonce(); err != nil {
+		t.Fatal(err)
+	}
+	reqDER, err := req.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// responder side
+	received, err := LoadOCSPRequestFromDER(reqDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := received.CertIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("expected 1 cert id, got %d", len(ids))
+	}
+	serial, err := ids[0].SerialNumber()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial.Int64() != 1001 {
+		t.Fatalf("unexpected serial %v", serial)
+	}
+	if !ids[0].IssuedBy(ca) {
+		t.Fatal("cert id does not match its issuer")
+	}
+	other, err := NewCertificate(&CertificateInfo{
+		Serial:     big.NewInt(1),
+		Expires:    time.Hour,
+		CommonName: "Other CA",
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids[0].IssuedBy(other) {
+		t.Fatal("cert id matches the wrong issuer")
+	}
+
+	now := time.Now()
+	builder, err := NewOCSPResponseBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.AddStatus(ids[0], OCSPRevoked, now.Add(-time.Hour),
+		ReasonKeyCompromise, now, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.AddStatus(ids[0], OCSPRevoked, time.Time{},
+		ReasonUnspecified, now, time.Time{}); err == nil {
+		t.Fatal("expected an error for a revoked status without a time")
+	}
+	if err := builder.CopyNonce(received); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := builder.Sign(ca, cakey, EVP_SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status() != OCSPSuccessful {
+		t.Fatalf("unexpected response status %d", resp.Status())
+	}
+	respDER, err := resp.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadOCSPResponseFromDER(respDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadedDER, err := loaded.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loadedDER, respDER) {
+		t.Fatal("der round trip changed the response")
+	}
+
+	errResp, err := NewOCSPErrorResponse(OCSPUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errResp.Status() != OCSPUnauthorized {
+		t.Fatalf("unexpected response status %d", errResp.Status())
+	}
+	if _, err := NewOCSPErrorResponse(OCSPSuccessful); err == nil {
+		t.Fatal("expected an error for an unsigned successful response")
+	}
+}
@@ -0,0 +1,398 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	opensshPEMType = "OPENSSH PRIVATE KEY"
+	opensshMagic   = "openssh-key-v1\x00"
+)
+
+// sshWriter builds the RFC 4251 wire encoding used by OpenSSH.
+type sshWriter struct {
+	bytes.Buffer
+}
+
+func (w *sshWriter) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
+
+func (w *sshWriter) string(b []byte) {
+	w.uint32(uint32(len(b)))
+	w.Write(b)
+}
+
+func (w *sshWriter) mpint(i *big.Int) {
+	b := i.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	w.string(b)
+}
+
+// sshReader consumes the RFC 4251 wire encoding used by OpenSSH.
+type sshReader struct {
+	b   []byte
+	err error
+}
+
+var errSSHShort = errors.New("openssh: truncated key data")
+
+func (r *sshReader) uint32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.b) < 4 {
+		r.err = errSSHShort
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *sshReader) string() []byte {
+	n := r.uint32()
+	if r.err != nil {
+		return nil
+	}
+	if uint32(len(r.b)) < n {
+		r.err = errSSHShort
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *sshReader) mpint() *big.Int {
+	b := r.string()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		r.err = errors.New("openssh: negative mpint")
+	}
+	return new(big.Int).SetBytes(b)
+}
+
+func sshCurve(name string) (elliptic.Curve, string, error) {
+	switch name {
+	case "nistp256", "P-256":
+		return elliptic.P256(), "nistp256", nil
+	case "nistp384", "P-384":
+		return elliptic.P384(), "nistp384", nil
+	case "nistp521", "P-521":
+		return elliptic.P521(), "nistp521", nil
+	}
+	return nil, "", fmt.Errorf("openssh: unsupported curve %q", name)
+}
+
+// writeSSHPublicKey writes the key type and public key fields of pub.
+func writeSSHPublicKey(w *sshWriter, pub interface{}) (string, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		w.string([]byte("ssh-rsa"))
+		w.mpint(big.NewInt(int64(pub.E)))
+		w.mpint(pub.N)
+		return "ssh-rsa", nil
+	case *ecdsa.PublicKey:
+		_, curve, err := sshCurve(pub.Curve.Params().Name)
+		if err != nil {
+			return "", err
+		}
+		keyType := "ecdsa-sha2-" + curve
+		w.string([]byte(keyType))
+		w.string([]byte(curve))
+		w.string(elliptic.Marshal(pub.Curve, pub.X, pub.Y))
+		return keyType, nil
+	case ed25519.PublicKey:
+		w.string([]byte("ssh-ed25519"))
+		w.string(pub)
+		return "ssh-ed25519", nil
+	}
+	return "", fmt.Errorf("openssh: unsupported key type %T", pub)
+}
+
+// readSSHPublicKeyFields reads the public key fields of a key of the given
+// type.
+func readSSHPublicKeyFields(r *sshReader, keyType string) (interface{},
+	error) {
+	switch keyType {
+	case "ssh-rsa":
+		e := r.mpint()
+		n := r.mpint()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("openssh: rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521":
+		curve, name, err := sshCurve(string(r.string()))
+		if err != nil {
+			return nil, err
+		}
+		point := r.string()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if keyType != "ecdsa-sha2-"+name {
+			return nil, errors.New("openssh: curve does not match key type")
+		}
+		x, y := elliptic.Unmarshal(curve, point)
+		if x == nil {
+			return nil, errors.New("openssh: invalid ecdsa point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "ssh-ed25519":
+		pub := r.string()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if len(pub) != ed25519.PublicKeySize {
+			return nil, errors.New("openssh: invalid ed25519 public key size")
+		}
+		return ed25519.PublicKey(pub), nil
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return nil, fmt.Errorf("openssh: unsupported key type %q", keyType)
+}
+
+// MarshalOpenSSHPublicKey converts an RSA, EC or Ed25519 public key to a
+// single OpenSSH public key line ("ssh-ed25519 AAAA... comment"), as used in
+// authorized_keys files. The comment may be empty.
+func MarshalOpenSSHPublicKey(key PublicKey, comment string) ([]byte, error) {
+	pub, err := key.ToStdlibPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	var w sshWriter
+	keyType, err := writeSSHPublicKey(&w, pub)
+	if err != nil {
+		return nil, err
+	}
+	line := keyType + " " + base64.StdEncoding.EncodeToString(w.Bytes())
+	if comment != "" {
+		line += " " + comment
+	}
+	return []byte(line + "\n"), nil
+}
+
+// LoadPublicKeyFromOpenSSH loads a public key from a single OpenSSH public
+// key line and returns it together with its comment. Lines carrying
+// authorized_keys options are not supported.
+func LoadPublicKeyFromOpenSSH(line []byte) (key PublicKey, comment string,
+	err error) {
+	fields := bytes.Fields(line)
+	if len(fields) < 2 {
+		return nil, "", errors.New("openssh: malformed public key line")
+	}
+	blob, err := base64.StdEncoding.DecodeString(string(fields[1]))
+	if err != nil {
+		return nil, "", err
+	}
+	r := &sshReader{b: blob}
+	if string(r.string()) != string(fields[0]) {
+		return nil, "", errors.New("openssh: key type does not match key data")
+	}
+	pub, err := readSSHPublicKeyFields(r, string(fields[0]))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(r.b) != 0 {
+		return nil, "", errors.New("openssh: trailing public key data")
+	}
+	key, err = FromStdlibPublicKey(pub)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, string(bytes.Join(fields[2:], []byte(" "))), nil
+}
+
+// MarshalOpenSSHPrivateKeyPEM converts an RSA, EC or Ed25519 private key to
+// an unencrypted "OPENSSH PRIVATE KEY" PEM block as written by ssh-keygen.
+func MarshalOpenSSHPrivateKeyPEM(key PrivateKey, comment string) (
+	pem_block []byte, err error) {
+	priv, err := key.ToStdlibKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var pub, sec sshWriter
+	var check [4]byte
+	if _, err := rand.Read(check[:]); err != nil {
+		return nil, err
+	}
+	sec.Write(check[:])
+	sec.Write(check[:])
+
+	switch priv := priv.(type) {
+	case *rsa.PrivateKey:
+		if len(priv.Primes) != 2 {
+			return nil, errors.New(
+				"openssh: multi-prime rsa keys are not supported")
+		}
+		priv.Precompute()
+		if _, err := writeSSHPublicKey(&pub, &priv.PublicKey); err != nil {
+			return nil, err
+		}
+		sec.string([]byte("ssh-rsa"))
+		sec.mpint(priv.N)
+		sec.mpint(big.NewInt(int64(priv.E)))
+		sec.mpint(priv.D)
+		sec.mpint(priv.Precomputed.Qinv)
+		sec.mpint(priv.Primes[0])
+		sec.mpint(priv.Primes[1])
+	case *ecdsa.PrivateKey:
+		keyType, err := writeSSHPublicKey(&pub, &priv.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		_, curve, _ := sshCurve(priv.Curve.Params().Name)
+		sec.string([]byte(keyType))
+		sec.string([]byte(curve))
+		sec.string(elliptic.Marshal(priv.Curve, priv.X, priv.Y))
+		sec.mpint(priv.D)
+	case ed25519.PrivateKey:
+		if _, err := writeSSHPublicKey(&pub, priv.Public()); err != nil {
+			return nil, err
+		}
+		sec.string([]byte("ssh-ed25519"))
+		sec.string(priv.Public().(ed25519.PublicKey))
+		sec.string(priv)
+	default:
+		return nil, fmt.Errorf("openssh: unsupported key type %T", priv)
+	}
+	sec.string([]byte(comment))
+	for i := byte(1); sec.Len()%8 != 0; i++ {
+		sec.WriteByte(i)
+	}
+
+	var w sshWriter
+	w.WriteString(opensshMagic)
+	w.string([]byte("none")) // cipher
+	w.string([]byte("none")) // kdf
+	w.string(nil)            // kdf options
+	w.uint32(1)
+	w.string(pub.Bytes())
+	w.string(sec.Bytes())
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  opensshPEMType,
+		Bytes: w.Bytes(),
+	}), nil
+}
+
+// LoadPrivateKeyFromOpenSSHPEM loads a private key from an "OPENSSH PRIVATE
+// KEY" PEM block. Only unencrypted keys are supported.
+func LoadPrivateKeyFromOpenSSHPEM(pem_block []byte) (PrivateKey, error) {
+	block, _ := pem.Decode(pem_block)
+	if block == nil || block.Type != opensshPEMType {
+		return nil, errors.New("openssh: no private key block found")
+	}
+	if !bytes.HasPrefix(block.Bytes, []byte(opensshMagic)) {
+		return nil, errors.New("openssh: invalid private key magic")
+	}
+	r := &sshReader{b: block.Bytes[len(opensshMagic):]}
+	cipher := string(r.string())
+	kdf := string(r.string())
+	r.string() // kdf options
+	nkeys := r.uint32()
+	r.string() // public key, repeated in the private section
+	sec := &sshReader{b: r.string()}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if cipher != "none" || kdf != "none" {
+		return nil, errors.New("openssh: encrypted keys are not supported")
+	}
+	if nkeys != 1 {
+		return nil, errors.New("openssh: only single-key files are supported")
+	}
+
+	if sec.uint32() != sec.uint32() {
+		return nil, errors.New("openssh: corrupt private section")
+	}
+	keyType := string(sec.string())
+	if keyType == "ssh-rsa" {
+		// unlike the public key, the private section stores n before e
+		n := sec.mpint()
+		e := sec.mpint()
+		d := sec.mpint()
+		sec.mpint() // iqmp
+		p := sec.mpint()
+		q := sec.mpint()
+		if sec.err != nil {
+			return nil, sec.err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("openssh: rsa exponent too large")
+		}
+		priv := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: n, E: int(e.Int64())},
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		if err := priv.Validate(); err != nil {
+			return nil, err
+		}
+		priv.Precompute()
+		return FromStdlibKey(priv)
+	}
+	pub, err := readSSHPublicKeyFields(sec, keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		d := sec.mpint()
+		if sec.err != nil {
+			return nil, sec.err
+		}
+		return FromStdlibKey(&ecdsa.PrivateKey{PublicKey: *pub, D: d})
+	case ed25519.PublicKey:
+		sk := sec.string()
+		if sec.err != nil {
+			return nil, sec.err
+		}
+		if len(sk) != ed25519.PrivateKeySize {
+			return nil, errors.New("openssh: invalid ed25519 private key size")
+		}
+		priv := ed25519.NewKeyFromSeed(sk[:ed25519.SeedSize])
+		if !pub.Equal(priv.Public()) {
+			return nil, errors.New("openssh: ed25519 public key does not match")
+		}
+		return FromStdlibKey(priv)
+	}
+	return nil, errors.New("openssh: unsupported key type")
+}
@@ -14,8 +14,16 @@
 
 package openssl
 
+// #include "shim.h"
+import "C"
+
 import (
+	"errors"
+	"fmt"
 	"regexp"
+	"runtime"
+	"strings"
+	"unsafe"
 )
 
 var pemSplit *regexp.Regexp = regexp.MustCompile(`(?sm)` +
@@ -23,6 +31,195 @@ var pemSplit *regexp.Regexp = regexp.MustCompile(`(?sm)` +
 	`.*?` +
 	`^-----[\s-]*?END.*?-----[\s-]*?$)`)
 
+// SplitPEM returns the PEM blocks found in data. ParsePEM classifies them as
+// well.
 func SplitPEM(data []byte) [][]byte {
 	return pemSplit.FindAll(data, -1)
 }
+
+// PEMKind classifies the contents of a PEM block.
+type PEMKind int
+
+const (
+	PEMUnknown PEMKind = iota
+	PEMCertificate
+	PEMPrivateKey
+	PEMPublicKey
+	PEMCertificateRequest
+	PEMCRL
+	PEMDHParameters
+	PEMECParameters
+	PEMCMS
+)
+
+func (k PEMKind) String() string {
+	switch k {
+	case PEMUnknown:
+		return "unknown"
+	case PEMCertificate:
+		return "certificate"
+	case PEMPrivateKey:
+		return "private key"
+	case PEMPublicKey:
+		return "public key"
+	case PEMCertificateRequest:
+		return "certificate request"
+	case PEMCRL:
+		return "crl"
+	case PEMDHParameters:
+		return "dh parameters"
+	case PEMECParameters:
+		return "ec parameters"
+	case PEMCMS:
+		return "cms"
+	}
+	return fmt.Sprintf("PEMKind(%d)", int(k))
+}
+
+// PEMKeyFormat is the encoding of a private key in a PEM block.
+type PEMKeyFormat int
+
+const (
+	// PEMKeyNone is the format of blocks other than private keys.
+	PEMKeyNone PEMKeyFormat = iota
+	// PEMKeyPKCS8 is an unencrypted PKCS#8 key ("PRIVATE KEY").
+	PEMKeyPKCS8
+	// PEMKeyEncryptedPKCS8 is an encrypted PKCS#8 key
+	// ("ENCRYPTED PRIVATE KEY").
+	PEMKeyEncryptedPKCS8
+	// PEMKeyRSA is a PKCS#1 RSA key ("RSA PRIVATE KEY").
+	PEMKeyRSA
+	// PEMKeyEC is a SEC 1 EC key ("EC PRIVATE KEY").
+	PEMKeyEC
+	// PEMKeyDSA is a traditional DSA key ("DSA PRIVATE KEY").
+	PEMKeyDSA
+	// PEMKeyOpenSSH is an OpenSSH key ("OPENSSH PRIVATE KEY").
+	PEMKeyOpenSSH
+)
+
+var pemTypes = map[string]struct {
+	kind   PEMKind
+	format PEMKeyFormat
+}{
+	"CERTIFICATE":             {PEMCertificate, PEMKeyNone},
+	"X509 CERTIFICATE":        {PEMCertificate, PEMKeyNone},
+	"TRUSTED CERTIFICATE":     {PEMCertificate, PEMKeyNone},
+	"PRIVATE KEY":             {PEMPrivateKey, PEMKeyPKCS8},
+	"ENCRYPTED PRIVATE KEY":   {PEMPrivateKey, PEMKeyEncryptedPKCS8},
+	"RSA PRIVATE KEY":         {PEMPrivateKey, PEMKeyRSA},
+	"EC PRIVATE KEY":          {PEMPrivateKey, PEMKeyEC},
+	"DSA PRIVATE KEY":         {PEMPrivateKey, PEMKeyDSA},
+	"OPENSSH PRIVATE KEY":     {PEMPrivateKey, PEMKeyOpenSSH},
+	"PUBLIC KEY":              {PEMPublicKey, PEMKeyNone},
+	"RSA PUBLIC KEY":          {PEMPublicKey, PEMKeyNone},
+	"CERTIFICATE REQUEST":     {PEMCertificateRequest, PEMKeyNone},
+	"NEW CERTIFICATE REQUEST": {PEMCertificateRequest, PEMKeyNone},
+	"X509 CRL":                {PEMCRL, PEMKeyNone},
+	"DH PARAMETERS":           {PEMDHParameters, PEMKeyNone},
+	"X9.42 DH PARAMETERS":     {PEMDHParameters, PEMKeyNone},
+	"EC PARAMETERS":           {PEMECParameters, PEMKeyNone},
+	"CMS":                     {PEMCMS, PEMKeyNone},
+	"PKCS7":                   {PEMCMS, PEMKeyNone},
+}
+
+// PEMBlock is a block of a PEM bundle, as returned by ParsePEM.
+type PEMBlock struct {
+	// Type is the label of the block, e.g. "RSA PRIVATE KEY".
+	Type      string
+	Kind      PEMKind
+	KeyFormat PEMKeyFormat
+	// Headers holds the RFC 1421 headers of the block, such as the
+	// Proc-Type and DEK-Info headers of traditionally encrypted keys.
+	Headers map[string]string
+	// Encrypted reports whether the block is a password protected key.
+	Encrypted bool
+	// Bytes is the decoded content of the block.
+	Bytes []byte
+	// PEM is the block's original text, as taken by the PEM loaders.
+	PEM []byte
+}
+
+// ParsePEM splits data into its PEM blocks like SplitPEM and classifies
+// them, so that the blocks of mixed bundles can be passed to the matching
+// loaders, or to Load.
+func ParsePEM(data []byte) ([]*PEMBlock, error) {
+	var blocks []*PEMBlock
+	for _, text := range SplitPEM(data) {
+		block, err := parsePEMBlock(text)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+func parsePEMBlock(text []byte) (*PEMBlock, error) {
+	bio := C.BIO_new_mem_buf(unsafe.Pointer(&text[0]), C.int(len(text)))
+	if bio == nil {
+		return nil, errors.New("failed creating bio")
+	}
+	defer C.BIO_free(bio)
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var name, header *C.char
+	var data *C.uchar
+	var length C.long
+	if C.PEM_read_bio(bio, &name, &header, &data, &length) != 1 {
+		return nil, errorFromErrorQueue()
+	}
+	defer C.X_OPENSSL_free(unsafe.Pointer(name))
+	defer C.X_OPENSSL_free(unsafe.Pointer(header))
+	defer C.X_OPENSSL_free(unsafe.Pointer(data))
+
+	block := &PEMBlock{
+		Type:    C.GoString(name),
+		Headers: make(map[string]string),
+		Bytes:   C.GoBytes(unsafe.Pointer(data), C.int(length)),
+		PEM:     text,
+	}
+	for _, line := range strings.Split(C.GoString(header), "\n") {
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		block.Headers[strings.TrimSpace(line[:i])] =
+			strings.TrimSpace(line[i+1:])
+	}
+	kind := pemTypes[block.Type]
+	block.Kind, block.KeyFormat = kind.kind, kind.format
+	block.Encrypted = block.KeyFormat == PEMKeyEncryptedPKCS8 ||
+		strings.HasSuffix(block.Headers["Proc-Type"], ",ENCRYPTED")
+	return block, nil
+}
+
+// Load passes the block to the loader for its kind and returns the result:
+// a *Certificate, PrivateKey, PublicKey, *CertificateRequest, *CRL, *DH or
+// *CMS. Encrypted keys have to be loaded with a password instead, e.g. with
+// LoadPrivateKeyFromPEMWithPassword.
+func (b *PEMBlock) Load() (interface{}, error) {
+	if b.Encrypted {
+		return nil, errors.New("encrypted private key requires a password")
+	}
+	switch b.Kind {
+	case PEMCertificate:
+		return LoadCertificateFromPEM(b.PEM)
+	case PEMPrivateKey:
+		if b.KeyFormat == PEMKeyOpenSSH {
+			return LoadPrivateKeyFromOpenSSHPEM(b.PEM)
+		}
+		return LoadPrivateKeyFromPEM(b.PEM)
+	case PEMPublicKey:
+		return LoadPublicKeyFromPEM(b.PEM)
+	case PEMCertificateRequest:
+		return LoadCertificateRequestFromPEM(b.PEM)
+	case PEMCRL:
+		return LoadCRLFromPEM(b.PEM)
+	case PEMDHParameters:
+		return LoadDHParametersFromPEM(b.PEM)
+	case PEMCMS:
+		return LoadCMSFromPEM(b.PEM)
+	}
+	return nil, fmt.Errorf("no loader for pem block %q", b.Type)
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParsePEM(t *testing.T) {
+	bundle := bytes.Join([][]byte{
+		certBytes, keyBytes, ed25519KeyBytes, encryptedECKeyBytes,
+		dhParamsBytes,
+		[]byte("-----BEGIN SOMETHING ELSE-----\nAAAA\n" +
+			"-----END SOMETHING ELSE-----\n"),
+	}, []byte("\n"))
+	blocks, err := ParsePEM(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		kind      PEMKind
+		format    PEMKeyFormat
+		encrypted bool
+	}{
+		{PEMCertificate, PEMKeyNone, false},
+		{PEMPrivateKey, PEMKeyRSA, false},
+		{PEMPrivateKey, PEMKeyPKCS8, false},
+		{PEMPrivateKey, PEMKeyEC, true},
+		{PEMDHParameters, PEMKeyNone, false},
+		{PEMUnknown, PEMKeyNone, false},
+	}
+	if len(blocks) != len(expected) {
+		t.Fatalf("expected %d blocks, got %d", len(expected), len(blocks))
+	}
+	for i, block := range blocks {
+		if block.Kind != expected[i].kind ||
+			block.KeyFormat != expected[i].format ||
+			block.Encrypted != expected[i].encrypted {
+			t.Fatalf("block %d: unexpected %s block %+v", i, block.Kind,
+				block)
+		}
+	}
+	if dek := blocks[3].Headers["DEK-Info"]; dek !=
+		"AES-128-CBC,B6CCF11C8872D307D333213315003B51" {
+		t.Fatalf("unexpected DEK-Info header %q", dek)
+	}
+	if len(blocks[0].Headers) != 0 || blocks[5].Type != "SOMETHING ELSE" ||
+		!bytes.Equal(blocks[5].Bytes, []byte{0, 0, 0}) {
+		t.Fatal("unexpected block contents")
+	}
+	if !bytes.Equal(blocks[0].PEM, bytes.TrimSpace(certBytes)) {
+		t.Fatal("unexpected block text")
+	}
+
+	if v, err := blocks[0].Load(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := v.(*Certificate); !ok {
+		t.Fatalf("loaded %T for a certificate", v)
+	}
+	for _, i := range []int{1, 2} {
+		if v, err := blocks[i].Load(); err != nil {
+			t.Fatal(err)
+		} else if _, ok := v.(PrivateKey); !ok {
+			t.Fatalf("loaded %T for a private key", v)
+		}
+	}
+	if v, err := blocks[4].Load(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := v.(*DH); !ok {
+		t.Fatalf("loaded %T for dh parameters", v)
+	}
+	for _, i := range []int{3, 5} {
+		if _, err := blocks[i].Load(); err == nil {
+			t.Fatalf("block %d: expected an error", i)
+		}
+	}
+}
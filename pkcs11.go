@@ -0,0 +1,113 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"unsafe"
+)
+
+// PKCS11Options select how a PKCS#11 token is reached.
+type PKCS11Options struct {
+	// EnginePath is the shared library of the libp11 "pkcs11" engine. If
+	// empty, the key is opened through the "pkcs11" provider, which reads
+	// the module to use from its OpenSSL configuration.
+	EnginePath string
+	// ModulePath is the PKCS#11 module of the token, e.g.
+	// /usr/lib/softhsm/libsofthsm2.so. It is only used with EnginePath.
+	ModulePath string
+	// PIN is the user PIN of the token. It may instead be given in the URI
+	// as the pin-value attribute.
+	PIN string
+}
+
+// loadStorePrivateKey loads the first private key found at the OSSL_STORE
+// uri, answering passphrase prompts with pin.
+func loadStorePrivateKey(uri, pin string) (PrivateKey, error) {
+	curi := C.CString(uri)
+	defer C.free(unsafe.Pointer(curi))
+	var cpin *C.char
+	if pin != "" {
+		cpin = C.CString(pin)
+		defer C.free(unsafe.Pointer(cpin))
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	key := C.X_OSSL_STORE_load_private_key(curi, cpin)
+	if key == nil {
+		return nil, errorFromErrorQueue()
+	}
+	p := &pKey{key: key}
+	runtime.SetFinalizer(p, func(p *pKey) {
+		C.X_EVP_PKEY_free(p.key)
+	})
+	return p, nil
+}
+
+// LoadPKCS11PrivateKey loads the private key identified by a PKCS#11 URI
+// (RFC 7512), e.g. "pkcs11:token=server;object=tls-key;type=private". The
+// key material stays on the token: signing and decryption with the returned
+// key are performed by the HSM. A nil opts uses the "pkcs11" provider, which
+// requires OpenSSL 3.0 or later.
+func LoadPKCS11PrivateKey(uri string, opts *PKCS11Options) (PrivateKey,
+	error) {
+	if !strings.HasPrefix(uri, "pkcs11:") {
+		return nil, errors.New("not a pkcs11 uri")
+	}
+	if opts == nil {
+		opts = &PKCS11Options{}
+	}
+	if opts.EnginePath == "" {
+		if err := loadProviderOnce("pkcs11"); err != nil {
+			return nil, err
+		}
+		return loadStorePrivateKey(uri, opts.PIN)
+	}
+
+	pre := []EngineCommand{
+		{Name: "SO_PATH", Value: opts.EnginePath},
+		{Name: "ID", Value: "pkcs11"},
+		{Name: "LIST_ADD", Value: "1"},
+		{Name: "LOAD"},
+	}
+	if opts.ModulePath != "" {
+		pre = append(pre, EngineCommand{Name: "MODULE_PATH",
+			Value: opts.ModulePath})
+	}
+	var post []EngineCommand
+	if opts.PIN != "" {
+		post = append(post, EngineCommand{Name: "PIN", Value: opts.PIN})
+	}
+	engine, err := EngineLoadByID("dynamic", pre, post)
+	if err != nil {
+		return nil, err
+	}
+	return engine.LoadPrivateKey(uri)
+}
+
+// UsePKCS11PrivateKey configures the context to use the private key
+// identified by a PKCS#11 URI for handshakes, see LoadPKCS11PrivateKey.
+func (c *Ctx) UsePKCS11PrivateKey(uri string, opts *PKCS11Options) error {
+	key, err := LoadPKCS11PrivateKey(uri, opts)
+	if err != nil {
+		return err
+	}
+	return c.UsePrivateKey(key)
+}
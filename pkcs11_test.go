@@ -0,0 +1,83 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPKCS11PrivateKey(t *testing.T) {
+	if _, err := LoadPKCS11PrivateKey("file:/etc/key.pem", nil); err == nil {
+		t.Fatal("loaded key from a non-pkcs11 uri")
+	}
+	if _, err := LoadPKCS11PrivateKey("pkcs11:object=tls-key",
+		&PKCS11Options{EnginePath: "/nonexistent/pkcs11.so"}); err == nil {
+		t.Fatal("loaded key through a missing engine")
+	}
+}
+
+// The PKCS#11 provider reads keys through OSSL_STORE, which is exercised
+// here with a passphrase protected key file instead of a token.
+func TestLoadStorePrivateKey(t *testing.T) {
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem, err := key.MarshalEncryptedPKCS8PrivateKeyPEM([]byte("1234"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(name, pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadStorePrivateKey("file:"+name, "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.PublicKeyMatches(loaded) {
+		t.Fatal("loaded key does not match")
+	}
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UsePrivateKey(loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadStorePrivateKey("file:"+name, "4321"); err == nil {
+		t.Fatal("loaded key with wrong pin")
+	}
+	if _, err := loadStorePrivateKey("file:"+name, ""); err == nil {
+		t.Fatal("loaded key without pin")
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"io/ioutil"
+	"runtime"
+	"unsafe"
+)
+
+// PKCS8KDF selects the key derivation function used by PBES2 to turn a
+// passphrase into an encryption key.
+type PKCS8KDF int
+
+const (
+	// PBKDF2 derives the key with PBKDF2 (RFC 8018).
+	PBKDF2 PKCS8KDF = iota
+	// Scrypt derives the key with scrypt (RFC 7914).
+	Scrypt
+)
+
+// PKCS8Options describes how a PKCS#8 private key is encrypted. The zero
+// value selects AES-256-CBC with PBKDF2-HMAC-SHA256.
+type PKCS8Options struct {
+	// Cipher is the content encryption cipher. Defaults to aes-256-cbc.
+	Cipher *Cipher
+
+	// KDF selects PBKDF2 or scrypt.
+	KDF PKCS8KDF
+
+	// Iterations is the PBKDF2 iteration count. Defaults to 100000.
+	Iterations int
+	// PRF is the PBKDF2 pseudo-random function digest. Only EVP_SHA1,
+	// EVP_SHA224, EVP_SHA256, EVP_SHA384 and EVP_SHA512 are supported.
+	// Defaults to EVP_SHA256.
+	PRF EVP_MD
+
+	// ScryptN, ScryptR and ScryptP are the scrypt cost parameters. They
+	// default to N=16384, r=8, p=1.
+	ScryptN uint64
+	ScryptR uint64
+	ScryptP uint64
+}
+
+func pkcs8PRFNid(digest EVP_MD) (C.int, error) {
+	switch digest {
+	case EVP_NULL, EVP_SHA256:
+		return C.NID_hmacWithSHA256, nil
+	case EVP_SHA1:
+		return C.NID_hmacWithSHA1, nil
+	case EVP_SHA224:
+		return C.NID_hmacWithSHA224, nil
+	case EVP_SHA384:
+		return C.NID_hmacWithSHA384, nil
+	case EVP_SHA512:
+		return C.NID_hmacWithSHA512, nil
+	}
+	return 0, errors.New("unsupported pkcs8 prf digest")
+}
+
+func (key *pKey) encryptPKCS8(password []byte, opts *PKCS8Options) (
+	*C.X509_SIG, error) {
+	if opts == nil {
+		opts = &PKCS8Options{}
+	}
+	cipher := opts.Cipher
+	if cipher == nil {
+		var err error
+		cipher, err = GetCipherByName("aes-256-cbc")
+		if err != nil {
+			return nil, err
+		}
+	}
+	prf, err := pkcs8PRFNid(opts.PRF)
+	if err != nil {
+		return nil, err
+	}
+	iter := opts.Iterations
+	if iter <= 0 {
+		iter = 100000
+	}
+	var use_scrypt C.int
+	n, r, p := opts.ScryptN, opts.ScryptR, opts.ScryptP
+	if opts.KDF == Scrypt {
+		use_scrypt = 1
+		if n == 0 {
+			n = 16384
+		}
+		if r == 0 {
+			r = 8
+		}
+		if p == 0 {
+			p = 1
+		}
+	}
+	var cpass *C.char
+	if len(password) > 0 {
+		cpass = (*C.char)(unsafe.Pointer(&password[0]))
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	p8 := C.X_PKCS8_encrypt_pbes2(key.key, cipher.ptr, cpass,
+		C.int(len(password)), C.int(iter), prf, use_scrypt,
+		C.uint64_t(n), C.uint64_t(r), C.uint64_t(p))
+	if p8 == nil {
+		return nil, errorFromErrorQueue()
+	}
+	return p8, nil
+}
+
+// MarshalPKCS8PrivateKeyPEM converts the private key to an unencrypted
+// PEM-encoded PKCS#8 block ("PRIVATE KEY").
+func (key *pKey) MarshalPKCS8PrivateKeyPEM() (pem_block []byte, err error) {
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+
+	if int(C.PEM_write_bio_PKCS8PrivateKey(bio, key.key, nil, nil, 0, nil,
+		nil)) != 1 {
+		return nil, errors.New("failed dumping pkcs8 private key")
+	}
+
+	return ioutil.ReadAll(asAnyBio(bio))
+}
+
+// MarshalPKCS8PrivateKeyDER converts the private key to an unencrypted
+// DER-encoded PKCS#8 PrivateKeyInfo.
+func (key *pKey) MarshalPKCS8PrivateKeyDER() (der_block []byte, err error) {
+	return key.marshalPKCS8PrivateKeyDER()
+}
+
+// MarshalEncryptedPKCS8PrivateKeyPEM encrypts the private key with PBES2
+// and returns it as a PEM "ENCRYPTED PRIVATE KEY" block. opts may be nil.
+func (key *pKey) MarshalEncryptedPKCS8PrivateKeyPEM(password []byte,
+	opts *PKCS8Options) (pem_block []byte, err error) {
+	p8, err := key.encryptPKCS8(password, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer C.X509_SIG_free(p8)
+
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+
+	if int(C.PEM_write_bio_PKCS8(bio, p8)) != 1 {
+		return nil, errors.New("failed dumping encrypted pkcs8 private key")
+	}
+
+	return ioutil.ReadAll(asAnyBio(bio))
+}
+
+// MarshalEncryptedPKCS8PrivateKeyDER encrypts the private key with PBES2
+// and returns the DER-encoded EncryptedPrivateKeyInfo. opts may be nil.
+func (key *pKey) MarshalEncryptedPKCS8PrivateKeyDER(password []byte,
+	opts *PKCS8Options) (der_block []byte, err error) {
+	p8, err := key.encryptPKCS8(password, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer C.X509_SIG_free(p8)
+
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+
+	if int(C.i2d_PKCS8_bio(bio, p8)) != 1 {
+		return nil, errors.New("failed dumping encrypted pkcs8 private key der")
+	}
+
+	return ioutil.ReadAll(asAnyBio(bio))
+}
+
+// LoadPrivateKeyFromEncryptedPKCS8DER loads a private key from a DER-encoded
+// PKCS#8 EncryptedPrivateKeyInfo. PEM-encoded keys can be loaded with
+// LoadPrivateKeyFromPEMWithPassword.
+func LoadPrivateKeyFromEncryptedPKCS8DER(der_block []byte, password []byte) (
+	PrivateKey, error) {
+	if len(der_block) == 0 {
+		return nil, errors.New("empty der block")
+	}
+	bio := C.BIO_new_mem_buf(unsafe.Pointer(&der_block[0]),
+		C.int(len(der_block)))
+	if bio == nil {
+		return nil, errors.New("failed creating bio")
+	}
+	defer C.BIO_free(bio)
+
+	p8 := C.d2i_PKCS8_bio(bio, nil)
+	if p8 == nil {
+		return nil, errors.New("failed reading encrypted pkcs8 private key der")
+	}
+	defer C.X509_SIG_free(p8)
+
+	var cpass *C.char
+	if len(password) > 0 {
+		cpass = (*C.char)(unsafe.Pointer(&password[0]))
+	}
+	p8inf := C.PKCS8_decrypt(p8, cpass, C.int(len(password)))
+	if p8inf == nil {
+		return nil, errors.New("failed decrypting pkcs8 private key")
+	}
+	defer C.PKCS8_PRIV_KEY_INFO_free(p8inf)
+
+	key := C.EVP_PKCS82PKEY(p8inf)
+	if key == nil {
+		return nil, errors.New("failed reading private key")
+	}
+
+	p := &pKey{key: key}
+	runtime.SetFinalizer(p, func(p *pKey) {
+		C.X_EVP_PKEY_free(p.key)
+	})
+	return p, nil
+}
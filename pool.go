@@ -0,0 +1,307 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultMaxIdlePerHost is the number of idle connections a Pool keeps per
+// address unless told otherwise.
+const DefaultMaxIdlePerHost = 2
+
+// aLongTimeAgo is a deadline in the past, used to interrupt pending reads.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// Pool keeps idle client connections for reuse, per network and address,
+// so that bursts of short requests do not each pay for a handshake. When it
+// does have to dial, it resumes the last TLS session of the address, except
+// when its Dialer has InsecureSkipVerify set: sessions of such connections
+// are never kept, since resuming one would skip chain verification. Idle
+// connections are watched in the background and dropped once the server
+// closes them or sends anything. A Pool is safe for concurrent use and its
+// zero value is ready to use.
+type Pool struct {
+	// Dialer makes new connections. If nil, a zero Dialer is used. If its
+	// Ctx is nil, the pool creates one context for all its connections.
+	Dialer *Dialer
+	// MaxIdlePerHost is the number of idle connections kept per address.
+	// Zero means DefaultMaxIdlePerHost and a negative value keeps none.
+	MaxIdlePerHost int
+	// IdleTimeout closes connections that stay idle longer. Zero means no
+	// limit.
+	IdleTimeout time.Duration
+
+	mtx      sync.Mutex
+	ctx      *Ctx
+	idle     map[string][]*idleConn
+	sessions map[string][]byte
+}
+
+type idleConn struct {
+	conn  *Conn
+	since time.Time
+	// done receives the error that ended the background read
+	done chan error
+}
+
+// PooledConn is a connection from a Pool. Closing it returns it to the
+// pool, unless a read or write on it has failed.
+type PooledConn struct {
+	*Conn
+	pool     *Pool
+	key      string
+	insecure bool
+	broken   bool
+	closed   bool
+}
+
+// Get returns an idle connection to addr, or dials a new one if there is
+// none.
+func (p *Pool) Get(ctx context.Context, network, addr string) (*PooledConn,
+	error) {
+	dialer, err := p.dialer()
+	if err != nil {
+		return nil, err
+	}
+	key := network + "!" + addr
+	insecure := dialer.Flags&InsecureSkipVerify != 0
+	if insecure {
+		// never hand out unverified connections to verifying callers
+		key += "!insecure"
+	}
+	for {
+		ic := p.takeIdle(key)
+		if ic == nil {
+			break
+		}
+		if c := p.revive(ic); c != nil {
+			return &PooledConn{Conn: c, pool: p, key: key,
+				insecure: insecure}, nil
+		}
+	}
+	var session []byte
+	if !insecure {
+		p.mtx.Lock()
+		session = p.sessions[key]
+		p.mtx.Unlock()
+	}
+	c, err := dialer.dialSession(ctx, network, addr, session)
+	if err != nil {
+		return nil, err
+	}
+	return &PooledConn{Conn: c, pool: p, key: key, insecure: insecure}, nil
+}
+
+// CloseIdleConnections closes all idle connections.
+func (p *Pool) CloseIdleConnections() {
+	p.mtx.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mtx.Unlock()
+	for _, conns := range idle {
+		for _, ic := range conns {
+			ic.conn.Close()
+		}
+	}
+}
+
+func (p *Pool) dialer() (*Dialer, error) {
+	d := p.Dialer
+	if d == nil {
+		d = &Dialer{}
+	}
+	if d.Ctx != nil {
+		return d, nil
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.ctx == nil {
+		ctx, err := NewCtx()
+		if err != nil {
+			return nil, err
+		}
+		p.ctx = ctx
+	}
+	with_ctx := *d
+	with_ctx.Ctx = p.ctx
+	return &with_ctx, nil
+}
+
+func (p *Pool) maxIdle() int {
+	if p.MaxIdlePerHost == 0 {
+		return DefaultMaxIdlePerHost
+	}
+	return p.MaxIdlePerHost
+}
+
+// takeIdle removes the most recently used idle connection to key.
+func (p *Pool) takeIdle(key string) *idleConn {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	conns := p.idle[key]
+	if len(conns) == 0 {
+		return nil
+	}
+	ic := conns[len(conns)-1]
+	conns[len(conns)-1] = nil
+	p.idle[key] = conns[:len(conns)-1]
+	return ic
+}
+
+// removeIdle removes ic from the idle connections, reporting whether it was
+// still there.
+func (p *Pool) removeIdle(key string, ic *idleConn) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	conns := p.idle[key]
+	for i, c := range conns {
+		if c == ic {
+			copy(conns[i:], conns[i+1:])
+			conns[len(conns)-1] = nil
+			p.idle[key] = conns[:len(conns)-1]
+			return true
+		}
+	}
+	return false
+}
+
+// revive stops the background read of a connection taken from the idle
+// list and returns the connection if it is still healthy, closing it
+// otherwise.
+func (p *Pool) revive(ic *idleConn) *Conn {
+	ic.conn.SetReadDeadline(aLongTimeAgo)
+	err := <-ic.done
+	ic.conn.SetReadDeadline(time.Time{})
+	if !isTimeout(err) || (p.IdleTimeout > 0 &&
+		time.Since(ic.since) > p.IdleTimeout) {
+		ic.conn.Close()
+		return nil
+	}
+	return ic.conn
+}
+
+// put makes c idle, remembering its session for later dials unless it was
+// dialed with InsecureSkipVerify.
+func (p *Pool) put(key string, c *Conn, insecure bool) {
+	// the session of a connection whose chain was never verified must not
+	// be resumed
+	if session, err := c.GetSession(); err == nil && !insecure {
+		p.mtx.Lock()
+		if p.sessions == nil {
+			p.sessions = make(map[string][]byte)
+		}
+		p.sessions[key] = session
+		p.mtx.Unlock()
+	}
+	ic := &idleConn{conn: c, since: time.Now(), done: make(chan error, 1)}
+	p.mtx.Lock()
+	if len(p.idle[key]) >= p.maxIdle() {
+		p.mtx.Unlock()
+		c.Close()
+		return
+	}
+	if p.idle == nil {
+		p.idle = make(map[string][]*idleConn)
+	}
+	// set before the connection is visible to revive, which moves it
+	if p.IdleTimeout > 0 {
+		c.SetReadDeadline(ic.since.Add(p.IdleTimeout))
+	}
+	p.idle[key] = append(p.idle[key], ic)
+	p.mtx.Unlock()
+	go p.watchIdle(key, ic)
+}
+
+// watchIdle reads from an idle connection until the server closes it, it
+// times out, or revive interrupts the read.
+func (p *Pool) watchIdle(key string, ic *idleConn) {
+	var b [1]byte
+	n, err := ic.conn.Read(b[:])
+	if err == nil && n > 0 {
+		err = errors.New("unexpected data on idle connection")
+	}
+	ic.done <- err
+	// unless revive took it, the connection is of no further use
+	if p.removeIdle(key, ic) {
+		ic.conn.Close()
+	}
+}
+
+func isTimeout(err error) bool {
+	net_err, ok := err.(net.Error)
+	return ok && net_err.Timeout()
+}
+
+// Read reads from the connection, marking it broken on failure.
+func (pc *PooledConn) Read(b []byte) (int, error) {
+	n, err := pc.Conn.Read(b)
+	if err != nil {
+		pc.broken = true
+	}
+	return n, err
+}
+
+// Write writes to the connection, marking it broken on failure.
+func (pc *PooledConn) Write(b []byte) (int, error) {
+	n, err := pc.Conn.Write(b)
+	if err != nil {
+		pc.broken = true
+	}
+	return n, err
+}
+
+// ReadFrom copies r to the connection like Conn.ReadFrom, marking it broken
+// on failure, since part of the data may have been sent.
+func (pc *PooledConn) ReadFrom(r io.Reader) (int64, error) {
+	n, err := pc.Conn.ReadFrom(r)
+	if err != nil {
+		pc.broken = true
+	}
+	return n, err
+}
+
+// WriteTo copies the connection to w like Conn.WriteTo. It only returns once
+// the peer has closed the connection or it failed, so it always marks the
+// connection broken.
+func (pc *PooledConn) WriteTo(w io.Writer) (int64, error) {
+	pc.broken = true
+	return pc.Conn.WriteTo(w)
+}
+
+// Close returns the connection to its pool, or closes it if it is broken.
+func (pc *PooledConn) Close() error {
+	if pc.closed {
+		return nil
+	}
+	pc.closed = true
+	if pc.broken {
+		return pc.Conn.Close()
+	}
+	pc.pool.put(pc.key, pc.Conn, pc.insecure)
+	return nil
+}
+
+// Discard closes the connection instead of returning it to its pool, e.g.
+// after a protocol error above the TLS layer.
+func (pc *PooledConn) Discard() error {
+	pc.closed = true
+	return pc.Conn.Close()
+}
@@ -0,0 +1,212 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// newTestEchoServer echoes lines over OpenSSL until the client closes,
+// counting accepted connections.
+func newTestEchoServer(t *testing.T, accepted *int32) net.Listener {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen("tcp", "localhost:0", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(accepted, 1)
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					line, err := r.ReadBytes('\n')
+					if err != nil {
+						return
+					}
+					if _, err := c.Write(line); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+func poolRoundTrip(t *testing.T, p *Pool, addr string) *PooledConn {
+	c, err := p.Get(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bufio.NewReader(c).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestPoolReuse(t *testing.T) {
+	var accepted int32
+	server := newTestEchoServer(t, &accepted)
+	defer server.Close()
+	p := &Pool{Dialer: &Dialer{Flags: InsecureSkipHostVerification}}
+	defer p.CloseIdleConnections()
+
+	for i := 0; i < 3; i++ {
+		poolRoundTrip(t, p, server.Addr().String()).Close()
+	}
+	if n := atomic.LoadInt32(&accepted); n != 1 {
+		t.Fatalf("expected one connection, got %d", n)
+	}
+}
+
+func TestPoolDropsClosedConnections(t *testing.T) {
+	server := newTestTLSServer(t, "tcp", "localhost:0")
+	defer server.Close()
+	p := &Pool{Dialer: &Dialer{Flags: InsecureSkipHostVerification}}
+	defer p.CloseIdleConnections()
+
+	// the server closes after one line, which the pool notices
+	poolRoundTrip(t, p, server.Addr().String()).Close()
+	time.Sleep(100 * time.Millisecond)
+	c := poolRoundTrip(t, p, server.Addr().String())
+	defer c.Discard()
+	if !c.SessionReused() {
+		t.Fatal("expected the new connection to resume the session")
+	}
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	var accepted int32
+	server := newTestEchoServer(t, &accepted)
+	defer server.Close()
+	p := &Pool{
+		Dialer:      &Dialer{Flags: InsecureSkipHostVerification},
+		IdleTimeout: 50 * time.Millisecond,
+	}
+	defer p.CloseIdleConnections()
+
+	poolRoundTrip(t, p, server.Addr().String()).Close()
+	time.Sleep(150 * time.Millisecond)
+	poolRoundTrip(t, p, server.Addr().String()).Close()
+	if n := atomic.LoadInt32(&accepted); n != 2 {
+		t.Fatalf("expected two connections, got %d", n)
+	}
+}
+
+func TestPoolInsecureKeepsNoSessions(t *testing.T) {
+	var accepted int32
+	server := newTestEchoServer(t, &accepted)
+	defer server.Close()
+	// keep no idle connections, so that every Get dials
+	p := &Pool{Dialer: &Dialer{Flags: InsecureSkipVerify}, MaxIdlePerHost: -1}
+
+	for i := 0; i < 2; i++ {
+		c := poolRoundTrip(t, p, server.Addr().String())
+		if c.SessionReused() {
+			t.Fatalf("dial %d resumed an unverified session", i)
+		}
+		c.Close()
+	}
+	if len(p.sessions) != 0 {
+		t.Fatalf("pool kept %d unverified sessions", len(p.sessions))
+	}
+}
+
+func poolIdle(p *Pool, network, addr string) int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return len(p.idle[network+"!"+addr])
+}
+
+func TestPoolCopyMarksBroken(t *testing.T) {
+	var accepted int32
+	echo := newTestEchoServer(t, &accepted)
+	defer echo.Close()
+	p := &Pool{Dialer: &Dialer{Flags: InsecureSkipHostVerification}}
+	defer p.CloseIdleConnections()
+
+	// a reader failing halfway through leaves a partial message behind
+	c, err := p.Get(context.Background(), "tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.Copy(c, io.MultiReader(strings.NewReader("pi"),
+		iotest.ErrReader(errors.New("reader failed"))))
+	if err == nil {
+		t.Fatal("expected the copy to fail")
+	}
+	c.Close()
+	if poolIdle(p, "tcp", echo.Addr().String()) != 0 {
+		t.Fatal("connection with a failed copy returned to the pool")
+	}
+
+	// copying from the connection runs until the peer closes it
+	once := newTestTLSServer(t, "tcp", "localhost:0")
+	defer once.Close()
+	c, err = p.Get(context.Background(), "tcp", once.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "ping\n" {
+		t.Fatalf("unexpected data %q", buf.String())
+	}
+	c.Close()
+	if poolIdle(p, "tcp", once.Addr().String()) != 0 {
+		t.Fatal("closed connection returned to the pool")
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"fmt"
+)
+
+// SecurityProfile is a preset of protocol versions, cipher suites, groups,
+// signature algorithms and options for NewCtxWithProfile. The presets follow
+// the Mozilla server side TLS guidelines (version 5.7) and are updated with
+// them, so that contexts created from a profile stay current.
+type SecurityProfile int
+
+const (
+	// ProfileModern only negotiates TLS 1.3, for peers that are all known
+	// to support it.
+	ProfileModern SecurityProfile = iota + 1
+	// ProfileIntermediate negotiates TLS 1.2 and 1.3 with forward secret
+	// AEAD cipher suites. It is the recommended general purpose profile.
+	ProfileIntermediate
+	// ProfileLegacy also negotiates TLS 1.0 and 1.1 and CBC cipher suites,
+	// and lowers the OpenSSL security level to 0 to allow them, for old
+	// peers that cannot be upgraded.
+	ProfileLegacy
+)
+
+func (p SecurityProfile) String() string {
+	switch p {
+	case ProfileModern:
+		return "modern"
+	case ProfileIntermediate:
+		return "intermediate"
+	case ProfileLegacy:
+		return "legacy"
+	}
+	return fmt.Sprintf("SecurityProfile(%d)", int(p))
+}
+
+type securityPreset struct {
+	min_version  TLSVersion
+	cipher_list  string
+	ciphersuites string
+	groups       []string
+	sigalgs      []string
+	options      Options
+}
+
+const (
+	tls13Ciphersuites = "TLS_AES_128_GCM_SHA256:TLS_AES_256_GCM_SHA384:" +
+		"TLS_CHACHA20_POLY1305_SHA256"
+	intermediateCipherList = "ECDHE-ECDSA-AES128-GCM-SHA256:" +
+		"ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:" +
+		"ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:" +
+		"ECDHE-RSA-CHACHA20-POLY1305:DHE-RSA-AES128-GCM-SHA256:" +
+		"DHE-RSA-AES256-GCM-SHA384:DHE-RSA-CHACHA20-POLY1305"
+	legacyCipherList = intermediateCipherList + ":" +
+		"ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA256:" +
+		"ECDHE-ECDSA-AES128-SHA:ECDHE-RSA-AES128-SHA:" +
+		"ECDHE-ECDSA-AES256-SHA384:ECDHE-RSA-AES256-SHA384:" +
+		"ECDHE-ECDSA-AES256-SHA:ECDHE-RSA-AES256-SHA:" +
+		"DHE-RSA-AES128-SHA256:DHE-RSA-AES256-SHA256:" +
+		"AES128-GCM-SHA256:AES256-GCM-SHA384:AES128-SHA256:AES256-SHA256:" +
+		"AES128-SHA:AES256-SHA:DES-CBC3-SHA:@SECLEVEL=0"
+)
+
+var profileGroups = []string{GroupX25519, GroupP256, GroupP384}
+
+var profileSigalgs = []string{
+	"ecdsa_secp256r1_sha256", "ecdsa_secp384r1_sha384",
+	"ecdsa_secp521r1_sha512", "ed25519", "ed448",
+	"rsa_pss_rsae_sha256", "rsa_pss_rsae_sha384", "rsa_pss_rsae_sha512",
+	"rsa_pss_pss_sha256", "rsa_pss_pss_sha384", "rsa_pss_pss_sha512",
+	"rsa_pkcs1_sha256", "rsa_pkcs1_sha384", "rsa_pkcs1_sha512",
+}
+
+var securityPresets = map[SecurityProfile]securityPreset{
+	ProfileModern: {
+		min_version:  TLSv1_3,
+		ciphersuites: tls13Ciphersuites,
+		groups:       profileGroups,
+		sigalgs:      profileSigalgs,
+		options:      NoCompression | NoSessionResumptionOrRenegotiation,
+	},
+	ProfileIntermediate: {
+		min_version:  TLSv1_2,
+		cipher_list:  intermediateCipherList,
+		ciphersuites: tls13Ciphersuites,
+		groups:       profileGroups,
+		sigalgs:      profileSigalgs,
+		options:      NoCompression | NoSessionResumptionOrRenegotiation,
+	},
+	ProfileLegacy: {
+		min_version:  TLSv1,
+		cipher_list:  legacyCipherList,
+		ciphersuites: tls13Ciphersuites,
+		groups:       profileGroups,
+		sigalgs: append(append([]string{}, profileSigalgs...),
+			"ECDSA+SHA1", "RSA+SHA1"),
+		options: NoCompression | NoSessionResumptionOrRenegotiation |
+			CipherServerPreference,
+	},
+}
+
+// NewCtxWithProfile creates a context configured according to profile. The
+// settings may be adjusted afterwards like those of any other context.
+func NewCtxWithProfile(profile SecurityProfile) (*Ctx, error) {
+	preset, ok := securityPresets[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown security profile %v", profile)
+	}
+	ctx, err := NewCtx()
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.applyPreset(&preset); err != nil {
+		return nil, fmt.Errorf("%v profile: %v", profile, err)
+	}
+	return ctx, nil
+}
+
+func (c *Ctx) applyPreset(preset *securityPreset) error {
+	if err := c.SetMinProtoVersion(preset.min_version); err != nil {
+		return err
+	}
+	if preset.cipher_list != "" {
+		if err := c.SetCipherList(preset.cipher_list); err != nil {
+			return err
+		}
+	}
+	if err := c.SetCiphersuites(preset.ciphersuites); err != nil {
+		return err
+	}
+	if err := c.SetGroups(preset.groups...); err != nil {
+		return err
+	}
+	if err := c.SetSignatureAlgorithms(preset.sigalgs...); err != nil {
+		return err
+	}
+	c.SetOptions(preset.options)
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"testing"
+)
+
+// profileHandshake handshakes a server using profile with a client limited
+// to max_version.
+func profileHandshake(t *testing.T, profile SecurityProfile,
+	max_version TLSVersion) error {
+	server_ctx, err := NewCtxWithProfile(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server_ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := server_ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	client_ctx, err := NewCtxWithProfile(ProfileLegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client_ctx.SetMaxProtoVersion(max_version); err != nil {
+		t.Fatal(err)
+	}
+
+	server_conn, client_conn := NetPipe(t)
+	server, err := Server(server_conn, server_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Client(client_conn, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close_both(server, client)
+	errs := make(chan error, 1)
+	go func() { errs <- server.Handshake() }()
+	err = client.Handshake()
+	if server_err := <-errs; err == nil {
+		err = server_err
+	}
+	return err
+}
+
+func TestNewCtxWithProfile(t *testing.T) {
+	for _, test := range []struct {
+		profile  SecurityProfile
+		accepted TLSVersion
+		rejected TLSVersion
+	}{
+		{ProfileModern, TLSv1_3, TLSv1_2},
+		{ProfileIntermediate, TLSv1_2, TLSv1_1},
+	} {
+		if err := profileHandshake(t, test.profile, test.accepted); err != nil {
+			t.Fatalf("%v: %v", test.profile, err)
+		}
+		if err := profileHandshake(t, test.profile, test.rejected); err == nil {
+			t.Fatalf("%v: accepted version %#x", test.profile,
+				int(test.rejected))
+		}
+	}
+	for _, version := range []TLSVersion{TLSv1, TLSv1_3} {
+		if err := profileHandshake(t, ProfileLegacy, version); err != nil {
+			t.Fatalf("legacy, version %#x: %v", int(version), err)
+		}
+	}
+
+	if _, err := NewCtxWithProfile(SecurityProfile(0)); err == nil {
+		t.Fatal("created context with unknown profile")
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// AlgorithmType selects the kind of algorithm queried through a
+// LibraryContext.
+type AlgorithmType int
+
+const (
+	AlgorithmDigest        AlgorithmType = C.X_ALGORITHM_DIGEST
+	AlgorithmCipher        AlgorithmType = C.X_ALGORITHM_CIPHER
+	AlgorithmMAC           AlgorithmType = C.X_ALGORITHM_MAC
+	AlgorithmKDF           AlgorithmType = C.X_ALGORITHM_KDF
+	AlgorithmSignature     AlgorithmType = C.X_ALGORITHM_SIGNATURE
+	AlgorithmKeyExchange   AlgorithmType = C.X_ALGORITHM_KEYEXCH
+	AlgorithmKeyManagement AlgorithmType = C.X_ALGORITHM_KEYMGMT
+)
+
+// LibraryContext is an OpenSSL 3 library context (OSSL_LIB_CTX). Each
+// context has its own set of loaded providers, so algorithm availability can
+// be controlled independently of the rest of the process.
+type LibraryContext struct {
+	ctx unsafe.Pointer
+}
+
+// DefaultLibraryContext is the process wide library context used by every
+// object not created from an explicit LibraryContext.
+var DefaultLibraryContext = &LibraryContext{}
+
+// NewLibraryContext creates an empty library context. Until a provider is
+// loaded into it, the "default" provider is activated implicitly on first
+// use. Requires OpenSSL 3.0 or later.
+func NewLibraryContext() (*LibraryContext, error) {
+	if C.OPENSSL_VERSION_NUMBER < 0x30000000 {
+		return nil, errors.New("library contexts require OpenSSL 3.0")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	ctx := C.X_OSSL_LIB_CTX_new()
+	if ctx == nil {
+		return nil, errorFromErrorQueue()
+	}
+	l := &LibraryContext{ctx: ctx}
+	runtime.SetFinalizer(l, func(l *LibraryContext) {
+		C.X_OSSL_LIB_CTX_free(l.ctx)
+	})
+	return l, nil
+}
+
+// LoadConfig applies an OpenSSL configuration file to the library context,
+// loading and configuring the providers it lists.
+func (l *LibraryContext) LoadConfig(file string) error {
+	if C.OPENSSL_VERSION_NUMBER < 0x30000000 {
+		return errors.New("library contexts require OpenSSL 3.0")
+	}
+	cfile := C.CString(file)
+	defer C.free(unsafe.Pointer(cfile))
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.X_OSSL_LIB_CTX_load_config(l.ctx, cfile) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
+// SetProviderSearchPath sets the directory third-party provider modules are
+// loaded from, overriding OPENSSL_MODULES and the compiled-in default.
+func (l *LibraryContext) SetProviderSearchPath(path string) error {
+	if C.OPENSSL_VERSION_NUMBER < 0x30000000 {
+		return errors.New("providers require OpenSSL 3.0")
+	}
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.X_OSSL_PROVIDER_set_default_search_path(l.ctx, cpath) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
+// LoadProvider loads the named provider, such as "default", "legacy", "fips"
+// or a third-party module, into the library context. Explicitly loading any
+// provider disables the implicit activation of the "default" one, so load it
+// too if its algorithms are still needed.
+func (l *LibraryContext) LoadProvider(name string) (*Provider, error) {
+	if C.OPENSSL_VERSION_NUMBER < 0x30000000 {
+		return nil, errors.New("providers require OpenSSL 3.0")
+	}
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	p := C.X_OSSL_PROVIDER_load(l.ctx, cname)
+	if p == nil {
+		return nil, errorFromErrorQueue()
+	}
+	return &Provider{p: p, lib: l}, nil
+}
+
+// ProviderAvailable reports whether the named provider is loaded and
+// activated in the library context.
+func (l *LibraryContext) ProviderAvailable(name string) bool {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	return C.X_OSSL_PROVIDER_available(l.ctx, cname) == 1
+}
+
+// AlgorithmAvailable reports whether an implementation of the named
+// algorithm can be fetched from the library context's providers. properties
+// is an optional property query, such as "fips=yes".
+func (l *LibraryContext) AlgorithmAvailable(typ AlgorithmType, name,
+	properties string) bool {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	var cprops *C.char
+	if properties != "" {
+		cprops = C.CString(properties)
+		defer C.free(unsafe.Pointer(cprops))
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	ok := C.X_OSSL_algorithm_available(l.ctx, C.int(typ), cname, cprops) == 1
+	// failed fetches leave an "unsupported" error behind
+	C.ERR_clear_error()
+	return ok
+}
+
+// Algorithms lists the names of all algorithms of the given type provided
+// by the providers loaded in the library context.
+func (l *LibraryContext) Algorithms(typ AlgorithmType) ([]string, error) {
+	if C.OPENSSL_VERSION_NUMBER < 0x30000000 {
+		return nil, errors.New("providers require OpenSSL 3.0")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	cnames := C.X_OSSL_algorithm_names(l.ctx, C.int(typ))
+	if cnames == nil {
+		return nil, errorFromErrorQueue()
+	}
+	defer C.X_OPENSSL_free(unsafe.Pointer(cnames))
+	names := strings.Split(strings.TrimSuffix(C.GoString(cnames), "\n"), "\n")
+	if len(names) == 1 && names[0] == "" {
+		return nil, nil
+	}
+	return names, nil
+}
+
+// Provider is a provider loaded into a LibraryContext.
+type Provider struct {
+	p   unsafe.Pointer
+	lib *LibraryContext
+}
+
+// Name returns the provider's name.
+func (p *Provider) Name() string {
+	return C.GoString(C.X_OSSL_PROVIDER_get0_name(p.p))
+}
+
+// Unload releases the provider. Its algorithms stay available until every
+// object fetched from it has been freed.
+func (p *Provider) Unload() error {
+	if p.p == nil {
+		return errors.New("provider already unloaded")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.X_OSSL_PROVIDER_unload(p.p) != 1 {
+		return errorFromErrorQueue()
+	}
+	p.p = nil
+	return nil
+}
+
+var legacyProvider struct {
+	sync.Mutex
+	loaded bool
+}
+
+var onceProviders struct {
+	sync.Mutex
+	loaded map[string]bool
+}
+
+// LoadLegacyProvider loads OpenSSL 3's "legacy" provider into the default
+// library context, making algorithms such as RC4, Blowfish, CAST5, DES and
+// MD4 available through GetCipherByName and the digest constructors. The
+// "default" provider is loaded alongside it, since explicitly loading any
+// provider disables its automatic activation. Providers stay loaded for the
+// lifetime of the process; calling LoadLegacyProvider again is a no-op.
+//
+// Legacy algorithms are insecure and should only be enabled to interoperate
+// with systems that cannot be updated. Requires OpenSSL 3.0 or later; older
+// versions ship these algorithms built in.
+func LoadLegacyProvider() error {
+	legacyProvider.Lock()
+	defer legacyProvider.Unlock()
+	if legacyProvider.loaded {
+		return nil
+	}
+	if C.OPENSSL_VERSION_NUMBER < 0x30000000 {
+		legacyProvider.loaded = true
+		return nil
+	}
+	for _, name := range []string{"default", "legacy"} {
+		if err := loadProvider(name); err != nil {
+			return err
+		}
+	}
+	legacyProvider.loaded = true
+	return nil
+}
+
+func loadProvider(name string) error {
+	_, err := DefaultLibraryContext.LoadProvider(name)
+	return err
+}
+
+// loadProviderOnce loads the named provider, such as "pkcs11", "tpm2" or
+// "oqsprovider", into the default library context once per process, along
+// with the "default" provider.
+func loadProviderOnce(name string) error {
+	onceProviders.Lock()
+	defer onceProviders.Unlock()
+	if onceProviders.loaded[name] {
+		return nil
+	}
+	if C.OPENSSL_VERSION_NUMBER < 0x30000000 {
+		return fmt.Errorf("%s provider requires OpenSSL 3.0", name)
+	}
+	// loading any provider explicitly disables the implicit default one
+	if !onceProviders.loaded["default"] {
+		if err := loadProvider("default"); err != nil {
+			return err
+		}
+		onceProviders.loaded = map[string]bool{"default": true}
+	}
+	if err := loadProvider(name); err != nil {
+		return err
+	}
+	onceProviders.loaded[name] = true
+	return nil
+}
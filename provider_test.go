@@ -0,0 +1,103 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"testing"
+)
+
+func TestLibraryContextProviders(t *testing.T) {
+	lib, err := NewLibraryContext()
+	if err != nil {
+		t.Skip(err)
+	}
+	prov, err := lib.LoadProvider("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prov.Name() != "default" {
+		t.Fatalf("unexpected provider name %q", prov.Name())
+	}
+	if !lib.ProviderAvailable("default") {
+		t.Fatal("default provider not available")
+	}
+	if lib.ProviderAvailable("legacy") {
+		t.Fatal("legacy provider available without loading it")
+	}
+	if !lib.AlgorithmAvailable(AlgorithmDigest, "SHA256", "") {
+		t.Fatal("SHA256 not available")
+	}
+	if lib.AlgorithmAvailable(AlgorithmDigest, "MD4", "") {
+		t.Fatal("MD4 available without the legacy provider")
+	}
+	if lib.AlgorithmAvailable(AlgorithmDigest, "SHA256", "fips=yes") {
+		t.Fatal("SHA256 available from a FIPS provider that is not loaded")
+	}
+
+	digests, err := lib.Algorithms(AlgorithmDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, name := range digests {
+		if name == "SHA2-256" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("SHA2-256 missing from %v", digests)
+	}
+
+	legacy, err := lib.LoadProvider("legacy")
+	if err != nil {
+		t.Skip(err)
+	}
+	if !lib.AlgorithmAvailable(AlgorithmDigest, "MD4", "") {
+		t.Fatal("MD4 not available with the legacy provider")
+	}
+	if err := legacy.Unload(); err != nil {
+		t.Fatal(err)
+	}
+	if lib.ProviderAvailable("legacy") {
+		t.Fatal("legacy provider available after unloading it")
+	}
+	if err := legacy.Unload(); err == nil {
+		t.Fatal("expected error unloading twice")
+	}
+}
+
+func TestNewCtxWithLibraryContext(t *testing.T) {
+	lib, err := NewLibraryContext()
+	if err != nil {
+		t.Skip(err)
+	}
+	if _, err := lib.LoadProvider("default"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := NewCtxWithLibraryContext(lib, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCtxWithLibraryContext(lib, "fips=yes"); err == nil {
+		t.Fatal("expected error without a FIPS provider")
+	}
+}
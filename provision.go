@@ -0,0 +1,270 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ESTSimpleEnrollPath is the path of the EST (RFC 7030) simple enrollment
+// operation.
+const ESTSimpleEnrollPath = "/.well-known/est/simpleenroll"
+
+// DeviceIdentity describes the subject of a device certificate.
+type DeviceIdentity struct {
+	CommonName string
+	// SerialNumber is the device serial number, set as the serialNumber
+	// attribute of the subject.
+	SerialNumber       string
+	Organization       string
+	OrganizationalUnit string
+	// SubjectAltName is requested in the textual form accepted by
+	// CertificateRequest.AddExtension, e.g. "URI:urn:dev:1234".
+	SubjectAltName string
+	// Extensions are further extensions to request.
+	Extensions map[NID]string
+}
+
+// NewDeviceCSR returns a certificate signing request for the identity, signed
+// by key with SHA-256, or without a digest for Ed25519 keys.
+func NewDeviceCSR(identity *DeviceIdentity, key PrivateKey) (
+	*CertificateRequest, error) {
+	if identity.CommonName == "" {
+		return nil, errors.New("device identity has no common name")
+	}
+	req, err := NewCertificateRequest(key)
+	if err != nil {
+		return nil, err
+	}
+	name, err := req.GetSubjectName()
+	if err != nil {
+		return nil, err
+	}
+	entries := []struct {
+		nid   NID
+		value string
+	}{
+		{NID_organizationName, identity.Organization},
+		{NID_organizationalUnitName, identity.OrganizationalUnit},
+		{NID_commonName, identity.CommonName},
+		{NID_serialNumber, identity.SerialNumber},
+	}
+	for _, entry := range entries {
+		if entry.value == "" {
+			continue
+		}
+		if err := name.AddEntryByNID(entry.nid, entry.value); err != nil {
+			return nil, err
+		}
+	}
+	if identity.SubjectAltName != "" {
+		err := req.AddExtension(NID_subject_alt_name, identity.SubjectAltName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := req.AddExtensions(identity.Extensions); err != nil {
+		return nil, err
+	}
+	digest := EVP_SHA256
+	if key.KeyType() == KeyTypeED25519 {
+		digest = EVP_NULL
+	}
+	if err := req.Sign(key, digest); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// ESTError is returned by EnrollEST when the server does not answer with a
+// certificate.
+type ESTError struct {
+	StatusCode int
+	Status     string
+	// RetryAfter is the delay asked for by a server that accepted the
+	// request for manual approval (status 202), if any.
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *ESTError) Error() string {
+	if e.StatusCode == http.StatusAccepted {
+		return fmt.Sprintf("est enrollment pending, retry after %v",
+			e.RetryAfter)
+	}
+	msg := "est enrollment failed: " + e.Status
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// EnrollEST submits csr to the EST simpleenroll operation over conn, which
+// must be a client connection to the EST server authenticated as required
+// by it, e.g. with a manufacturer certificate. host is sent as the HTTP Host
+// and defaults to the server name of conn, and path defaults to
+// ESTSimpleEnrollPath. It returns the certificates of the response, usually
+// the issued certificate followed by its chain. The connection stays open.
+func EnrollEST(conn *Conn, host, path string, csr *CertificateRequest) (
+	[]*Certificate, error) {
+	der, err := csr.MarshalDER()
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = conn.GetServername()
+	}
+	if host == "" {
+		host = conn.RemoteAddr().String()
+	}
+	if path == "" {
+		path = ESTSimpleEnrollPath
+	}
+	body := base64.StdEncoding.EncodeToString(der)
+	req, err := http.NewRequest("POST", "https://"+host+path,
+		strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/pkcs10")
+	req.Header.Set("Content-Transfer-Encoding", "base64")
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		est_err := &ESTError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       strings.TrimSpace(string(data)),
+		}
+		secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err == nil {
+			est_err.RetryAfter = time.Duration(secs) * time.Second
+		}
+		return nil, est_err
+	}
+	return parseESTCertificates(data)
+}
+
+// parseESTCertificates parses a certs-only CMS response, which is base64
+// encoded as required by RFC 7030 or else DER.
+func parseESTCertificates(data []byte) ([]*Certificate, error) {
+	der, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding,
+		bytes.NewReader(bytes.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+				return -1
+			}
+			return r
+		}, data))))
+	if err != nil {
+		der = data
+	}
+	cms, err := LoadCMSFromDER(der)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := cms.Certificates()
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("est response has no certificates")
+	}
+	return certs, nil
+}
+
+// ProvisionOptions controls ProvisionDevice.
+type ProvisionOptions struct {
+	Identity DeviceIdentity
+	// Curve of the generated device key. Defaults to Prime256v1.
+	Curve EllipticCurve
+	// Host and Path are passed to EnrollEST.
+	Host string
+	Path string
+}
+
+// Provisioned holds the credentials obtained by ProvisionDevice.
+type Provisioned struct {
+	Key         PrivateKey
+	Certificate *Certificate
+	// Chain holds the other certificates of the response.
+	Chain []*Certificate
+}
+
+// ProvisionDevice generates an EC device key, requests a certificate for it
+// through EnrollEST over conn and installs the key, certificate and chain
+// into ctx, so that connections created from ctx afterwards authenticate as
+// the device. The credentials are returned for storage by the caller.
+func ProvisionDevice(conn *Conn, ctx *Ctx, opts *ProvisionOptions) (
+	*Provisioned, error) {
+	curve := opts.Curve
+	if curve == 0 {
+		curve = Prime256v1
+	}
+	key, err := GenerateECKey(curve)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := NewDeviceCSR(&opts.Identity, key)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := EnrollEST(conn, opts.Host, opts.Path, csr)
+	if err != nil {
+		return nil, err
+	}
+	p := &Provisioned{Key: key}
+	for _, cert := range certs {
+		if p.Certificate == nil && cert.PublicKeyMatches(key) {
+			p.Certificate = cert
+		} else {
+			p.Chain = append(p.Chain, cert)
+		}
+	}
+	if p.Certificate == nil {
+		return nil, errors.New(
+			"est response has no certificate for the device key")
+	}
+	if err := ctx.UseCertificate(p.Certificate); err != nil {
+		return nil, err
+	}
+	if err := ctx.UsePrivateKey(key); err != nil {
+		return nil, err
+	}
+	for _, cert := range p.Chain {
+		if err := ctx.AddChainCertificate(cert); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,189 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+// newTestESTServer serves simpleenroll over OpenSSL, issuing certificates
+// from a test CA, or answering with status if it is not zero.
+func newTestESTServer(t *testing.T, status int) (addr string,
+	ca *Certificate) {
+	ca, cakey := newTestCA(t)
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen("tcp", "localhost:0", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(ESTSimpleEnrollPath, func(w http.ResponseWriter,
+		r *http.Request) {
+		if status != 0 {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(status)
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/pkcs10" {
+			http.Error(w, "bad content type", http.StatusBadRequest)
+			return
+		}
+		body, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding,
+			r.Body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		csr, err := LoadCertificateRequestFromDER(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cert, err := IssueCertificate(csr, ca, cakey, &IssuanceProfile{
+			AllowedExtensions: []NID{NID_subject_alt_name},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cms, err := CMSCertificatesOnly([]*Certificate{ca, cert})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		der, err := cms.MarshalDER()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type",
+			"application/pkcs7-mime; smime-type=certs-only")
+		w.Header().Set("Content-Transfer-Encoding", "base64")
+		w.Write([]byte(base64.StdEncoding.EncodeToString(der)))
+	})
+	go http.Serve(l, mux)
+	return l.Addr().String(), ca
+}
+
+func dialTestESTServer(t *testing.T, addr string) *Conn {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := Dial("tcp", addr, ctx, InsecureSkipHostVerification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestProvisionDevice(t *testing.T) {
+	addr, ca := newTestESTServer(t, 0)
+	conn := dialTestESTServer(t, addr)
+
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ProvisionDevice(conn, ctx, &ProvisionOptions{
+		Identity: DeviceIdentity{
+			CommonName:     "device-0001",
+			SerialNumber:   "SN0001",
+			Organization:   "FotaHub",
+			SubjectAltName: "URI:urn:dev:0001",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Certificate.PublicKeyMatches(p.Key) {
+		t.Fatal("certificate does not match the device key")
+	}
+	subject, err := p.Certificate.GetSubjectName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sn, _ := subject.GetEntry(NID_serialNumber); sn != "SN0001" {
+		t.Fatalf("expected serial number SN0001, got %q", sn)
+	}
+	if san := p.Certificate.GetExtensionValue(NID_subject_alt_name); san == nil {
+		t.Fatal("expected subject alt name")
+	}
+	if len(p.Chain) != 1 {
+		t.Fatalf("expected chain of 1 certificate, got %d", len(p.Chain))
+	}
+	caDER, err := ca.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainDER, err := p.Chain[0].MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chainDER, caDER) {
+		t.Fatal("expected the ca certificate in the chain")
+	}
+	if ctx.cert != p.Certificate || ctx.key != p.Key {
+		t.Fatal("credentials not installed into ctx")
+	}
+}
+
+func TestEnrollESTPending(t *testing.T) {
+	addr, _ := newTestESTServer(t, http.StatusAccepted)
+	conn := dialTestESTServer(t, addr)
+
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := NewDeviceCSR(&DeviceIdentity{CommonName: "device"}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = EnrollEST(conn, "", "", csr)
+	var est_err *ESTError
+	if !errors.As(err, &est_err) {
+		t.Fatalf("expected ESTError, got %v", err)
+	}
+	if est_err.StatusCode != http.StatusAccepted ||
+		est_err.RetryAfter.Seconds() != 60 {
+		t.Fatalf("unexpected error %+v", est_err)
+	}
+}
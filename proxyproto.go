@@ -0,0 +1,271 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ListenerOption configures listeners created by NewListener and Listen.
+type ListenerOption func(*listener)
+
+// WithProxyProtocol makes the listener read a PROXY protocol (v1 or v2)
+// header, as sent by L4 load balancers such as HAProxy or AWS NLB, from each
+// connection before the TLS handshake. Connections without a valid header
+// fail their handshake. RemoteAddr and LocalAddr of accepted connections
+// report the original client and destination addresses carried in the
+// header once it has been read, which happens during the handshake; until
+// then they report the addresses of the underlying connection. header_timeout
+// bounds the time to receive the header; zero means no limit. Deadlines set on
+// the connection, such as the handshake timeout of WithEagerHandshake, apply
+// throughout.
+func WithProxyProtocol(header_timeout time.Duration) ListenerOption {
+	return func(l *listener) {
+		l.proxy_protocol = true
+		l.proxy_header_timeout = header_timeout
+	}
+}
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxyConn reads the PROXY protocol header on first Read.
+type proxyConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	err    error
+	remote net.Addr
+	local  net.Addr
+	// parsed is set once remote and local hold the addresses from a valid
+	// header
+	parsed uint32
+
+	// the read deadline of the caller, and that of the header while it is
+	// being read, of which the earlier applies
+	deadline_mtx    sync.Mutex
+	read_deadline   time.Time
+	header_deadline time.Time
+}
+
+func newProxyConn(conn net.Conn, timeout time.Duration) *proxyConn {
+	return &proxyConn{
+		Conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+	}
+}
+
+func (c *proxyConn) readHeader() error {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.setHeaderDeadline(time.Now().Add(c.timeout))
+			defer c.setHeaderDeadline(time.Time{})
+		}
+		c.remote, c.local, c.err = readProxyHeader(c.reader)
+		if c.err != nil {
+			c.err = fmt.Errorf("proxy protocol: %v", c.err)
+			return
+		}
+		atomic.StoreUint32(&c.parsed, 1)
+	})
+	return c.err
+}
+
+// setHeaderDeadline bounds reading the header by t, or restores the
+// deadline of the caller if t is zero.
+func (c *proxyConn) setHeaderDeadline(t time.Time) {
+	c.deadline_mtx.Lock()
+	defer c.deadline_mtx.Unlock()
+	c.header_deadline = t
+	c.Conn.SetReadDeadline(earlierDeadline(c.read_deadline, t))
+}
+
+// SetDeadline sets the deadlines of the underlying connection, keeping any
+// earlier deadline for reading the header.
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetWriteDeadline(t); err != nil {
+		return err
+	}
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying connection,
+// keeping any earlier deadline for reading the header.
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.deadline_mtx.Lock()
+	defer c.deadline_mtx.Unlock()
+	c.read_deadline = t
+	return c.Conn.SetReadDeadline(earlierDeadline(t, c.header_deadline))
+}
+
+// earlierDeadline returns the earlier of a and b, where zero means no
+// deadline.
+func earlierDeadline(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if err := c.readHeader(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address from the PROXY header, or the
+// address of the proxy itself for LOCAL and UNKNOWN headers and while the
+// header has not been read. It never blocks.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if atomic.LoadUint32(&c.parsed) == 0 || c.remote == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.remote
+}
+
+// LocalAddr returns the destination address from the PROXY header, or the
+// local address of the connection for LOCAL and UNKNOWN headers and while the
+// header has not been read. It never blocks.
+func (c *proxyConn) LocalAddr() net.Addr {
+	if atomic.LoadUint32(&c.parsed) == 0 || c.local == nil {
+		return c.Conn.LocalAddr()
+	}
+	return c.local
+}
+
+// SyscallConn passes on the raw connection of the underlying connection.
+func (c *proxyConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("%T does not implement syscall.Conn", c.Conn)
+	}
+	return sc.SyscallConn()
+}
+
+// readProxyHeader parses a v1 or v2 PROXY header. Both addresses are nil for
+// headers that do not carry any.
+func readProxyHeader(r *bufio.Reader) (remote, local net.Addr, err error) {
+	// every valid header is at least as long as the v2 signature
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(sig, proxyV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(sig, proxyV1Prefix) {
+		return readProxyHeaderV1(r)
+	}
+	return nil, nil, errors.New("missing header")
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (remote, local net.Addr, err error) {
+	// the longest valid v1 header is 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("v1 header too long")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, errors.New("malformed v1 header")
+	}
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	sport, serr := strconv.ParseUint(fields[4], 10, 16)
+	dport, derr := strconv.ParseUint(fields[5], 10, 16)
+	if src == nil || dst == nil || serr != nil || derr != nil {
+		return nil, nil, errors.New("malformed v1 header addresses")
+	}
+	if (fields[1] == "TCP4") != (src.To4() != nil && dst.To4() != nil) {
+		return nil, nil, errors.New("v1 header address family mismatch")
+	}
+	return &net.TCPAddr{IP: src, Port: int(sport)},
+		&net.TCPAddr{IP: dst, Port: int(dport)}, nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (remote, local net.Addr, err error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported version %d", hdr[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+	switch hdr[12] & 0xf {
+	case 0x0:
+		// LOCAL: health checks from the proxy itself
+		return nil, nil, nil
+	case 0x1:
+	default:
+		return nil, nil, fmt.Errorf("unsupported command %d", hdr[12]&0xf)
+	}
+	var ip_len int
+	switch hdr[13] >> 4 {
+	case 0x1:
+		ip_len = net.IPv4len
+	case 0x2:
+		ip_len = net.IPv6len
+	default:
+		// AF_UNSPEC and AF_UNIX carry no IP addresses
+		return nil, nil, nil
+	}
+	if len(payload) < 2*ip_len+4 {
+		return nil, nil, errors.New("truncated v2 addresses")
+	}
+	src := net.IP(append([]byte(nil), payload[:ip_len]...))
+	dst := net.IP(append([]byte(nil), payload[ip_len:2*ip_len]...))
+	sport := int(binary.BigEndian.Uint16(payload[2*ip_len:]))
+	dport := int(binary.BigEndian.Uint16(payload[2*ip_len+2:]))
+	if hdr[13]&0xf == 0x2 {
+		return &net.UDPAddr{IP: src, Port: sport},
+			&net.UDPAddr{IP: dst, Port: dport}, nil
+	}
+	return &net.TCPAddr{IP: src, Port: sport},
+		&net.TCPAddr{IP: dst, Port: dport}, nil
+}
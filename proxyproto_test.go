@@ -0,0 +1,241 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func proxyV2Header(cmd, fam byte, addrs []byte) []byte {
+	hdr := append([]byte(nil), proxyV2Signature...)
+	hdr = append(hdr, 0x20|cmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(hdr[14:], uint16(len(addrs)))
+	return append(hdr, addrs...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v4 := []byte{192, 0, 2, 1, 198, 51, 100, 7, 0xd4, 0x31, 0x01, 0xbb}
+	v6 := append(append(net.ParseIP("2001:db8::1").To16(),
+		net.ParseIP("2001:db8::2").To16()...), 0x30, 0x39, 0x01, 0xbb)
+	for _, test := range []struct {
+		header string
+		remote string
+		local  string
+		err    bool
+	}{
+		{"PROXY TCP4 192.0.2.1 198.51.100.7 54321 443\r\n",
+			"192.0.2.1:54321", "198.51.100.7:443", false},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 12345 443\r\n",
+			"[2001:db8::1]:12345", "[2001:db8::2]:443", false},
+		{"PROXY UNKNOWN\r\n", "", "", false},
+		{string(proxyV2Header(1, 0x11, v4)),
+			"192.0.2.1:54321", "198.51.100.7:443", false},
+		{string(proxyV2Header(1, 0x21, append(v6, 0x04, 0, 1, 'x'))),
+			"[2001:db8::1]:12345", "[2001:db8::2]:443", false},
+		{string(proxyV2Header(0, 0x00, nil)), "", "", false},
+		{"PROXY TCP4 192.0.2.1 2001:db8::2 54321 443\r\n", "", "", true},
+		{"PROXY TCP4 192.0.2.1\r\n", "", "", true},
+		{"PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", "", "", true},
+		{string(proxyV2Header(1, 0x11, v4[:6])), "", "", true},
+		{"\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03\x00", "", "", true},
+	} {
+		r := bufio.NewReader(strings.NewReader(test.header + "rest"))
+		remote, local, err := readProxyHeader(r)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected error", test.header)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.header, err)
+			continue
+		}
+		if test.remote == "" {
+			if remote != nil || local != nil {
+				t.Errorf("%q: unexpected addresses %v %v", test.header,
+					remote, local)
+			}
+		} else if remote.String() != test.remote ||
+			local.String() != test.local {
+			t.Errorf("%q: got %v %v", test.header, remote, local)
+		}
+		if rest, _ := r.ReadString(0); rest != "rest" {
+			t.Errorf("%q: header over-read, left %q", test.header, rest)
+		}
+	}
+}
+
+func TestListenerProxyProtocol(t *testing.T) {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen("tcp", "localhost:0", ctx, WithProxyProtocol(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		c.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.7 54321 443\r\n"))
+		conn, err := Client(c, ctx)
+		if err != nil {
+			c.Close()
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("hello\n"))
+		// keep the connection open until the server is done with it
+		bufio.NewReader(conn).ReadBytes('\n')
+	}()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(line, []byte("hello\n")) {
+		t.Fatalf("unexpected data %q", line)
+	}
+	if conn.RemoteAddr().String() != "192.0.2.1:54321" {
+		t.Fatalf("unexpected remote address %v", conn.RemoteAddr())
+	}
+	if conn.LocalAddr().String() != "198.51.100.7:443" {
+		t.Fatalf("unexpected local address %v", conn.LocalAddr())
+	}
+	conn.Write([]byte("bye\n"))
+
+	// a client speaking TLS directly is refused
+	go func() {
+		conn, err := Dial("tcp", l.Addr().String(), ctx,
+			InsecureSkipHostVerification)
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn2, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	if err := conn2.(*Conn).Handshake(); err == nil ||
+		!strings.Contains(err.Error(), "proxy protocol") {
+		t.Fatalf("expected proxy protocol error, got %v", err)
+	}
+}
+
+func TestListenerProxyProtocolSilentClient(t *testing.T) {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen("tcp", "localhost:0", ctx, WithProxyProtocol(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// a client that connects and never sends its header must not hold up
+	// Accept for the clients behind it
+	silent, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	valid, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer valid.Close()
+	valid.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.7 54321 443\r\n"))
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case conn := <-accepted:
+			defer conn.Close()
+			// the header has not been read yet
+			if conn.RemoteAddr().String() == "192.0.2.1:54321" {
+				t.Fatal("address from an unread header")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Accept blocked on a silent client")
+		}
+	}
+}
+
+func TestListenerProxyProtocolHandshakeTimeout(t *testing.T) {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen("tcp", "localhost:0", ctx, WithProxyProtocol(time.Minute),
+		WithEagerHandshake(200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go l.Accept()
+
+	// the handshake timeout still applies once the header has been read
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.7 54321 443\r\n"))
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("expected the server to give up the handshake, got %v", err)
+	}
+}
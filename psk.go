@@ -0,0 +1,166 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"os"
+	"runtime"
+	"unsafe"
+
+	"github.com/mattn/go-pointer"
+)
+
+const (
+	// pskCipherList are the TLS 1.2 cipher suites of NewPSKCtx, forward
+	// secret ones first.
+	pskCipherList = "ECDHE-PSK-CHACHA20-POLY1305:ECDHE-PSK-AES128-CBC-SHA256:" +
+		"PSK-CHACHA20-POLY1305:PSK-AES128-GCM-SHA256:PSK-AES256-GCM-SHA384"
+	// pskCiphersuites are the TLS 1.3 cipher suites of NewPSKCtx. PSKs from
+	// the callbacks are only usable with SHA-256 based suites.
+	pskCiphersuites = "TLS_CHACHA20_POLY1305_SHA256:TLS_AES_128_GCM_SHA256"
+	// pskRecordSize is the record size of NewPSKCtx.
+	pskRecordSize = 4 * 1024
+)
+
+// PSKClientCallback returns the identity and pre-shared key a client uses
+// for a server that sent hint, which may be empty. An error fails the
+// handshake.
+type PSKClientCallback func(hint string) (identity string, psk []byte,
+	err error)
+
+// PSKServerCallback returns the pre-shared key of the client identity. An
+// error fails the handshake.
+type PSKServerCallback func(identity string) (psk []byte, err error)
+
+// NewPSKCtx creates a context for certificate-less deployments authenticated
+// by pre-shared keys, e.g. on constrained devices. It only negotiates TLS 1.2
+// and 1.3 with PSK cipher suites, installs no certificate callbacks, uses
+// small records, releases idle buffers and disables the session cache and
+// tickets. Set SetPSKClientCallback or SetPSKServerCallback to use it.
+func NewPSKCtx() (*Ctx, error) {
+	ctx, err := NewCtx()
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.SetMinProtoVersion(TLSv1_2); err != nil {
+		return nil, err
+	}
+	if err := ctx.SetCipherList(pskCipherList); err != nil {
+		return nil, err
+	}
+	if err := ctx.SetCiphersuites(pskCiphersuites); err != nil {
+		return nil, err
+	}
+	if err := ctx.SetRecordSize(pskRecordSize); err != nil {
+		return nil, err
+	}
+	ctx.SetReleaseBuffers(true)
+	ctx.SetSessionCacheMode(SessionCacheOff)
+	ctx.SetOptions(NoTicket)
+	return ctx, nil
+}
+
+// SetPSKClientCallback makes client connections authenticate with the
+// pre-shared key returned by cb. A nil cb disables PSK for clients.
+func (c *Ctx) SetPSKClientCallback(cb PSKClientCallback) {
+	c.psk_client_cb = cb
+	if cb == nil {
+		C.SSL_CTX_set_psk_client_callback(c.ctx, nil)
+	} else {
+		C.SSL_CTX_set_psk_client_callback(c.ctx,
+			(*[0]byte)(C.X_SSL_CTX_psk_client_cb))
+	}
+}
+
+// SetPSKServerCallback makes server connections accept clients presenting
+// an identity for which cb returns a pre-shared key. A nil cb disables PSK
+// for servers.
+func (c *Ctx) SetPSKServerCallback(cb PSKServerCallback) {
+	c.psk_server_cb = cb
+	if cb == nil {
+		C.SSL_CTX_set_psk_server_callback(c.ctx, nil)
+	} else {
+		C.SSL_CTX_set_psk_server_callback(c.ctx,
+			(*[0]byte)(C.X_SSL_CTX_psk_server_cb))
+	}
+}
+
+// UsePSKIdentityHint sets the hint servers send to help clients choose their
+// identity. TLS 1.3 does not send hints.
+func (c *Ctx) UsePSKIdentityHint(hint string) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	chint := C.CString(hint)
+	defer C.free(unsafe.Pointer(chint))
+	if C.SSL_CTX_use_psk_identity_hint(c.ctx, chint) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
+//export go_ssl_ctx_psk_client_thunk
+func go_ssl_ctx_psk_client_thunk(p unsafe.Pointer, hint *C.char,
+	identity *C.char, max_identity_len C.uint, psk *C.uchar,
+	max_psk_len C.uint) C.uint {
+	defer func() {
+		if err := recover(); err != nil {
+			logCallbackPanic("psk client callback", err)
+			os.Exit(1)
+		}
+	}()
+	cb := pointer.Restore(p).(*Ctx).psk_client_cb
+	if cb == nil {
+		return 0
+	}
+	var hint_str string
+	if hint != nil {
+		hint_str = C.GoString(hint)
+	}
+	id, key, err := cb(hint_str)
+	// the identity is NUL terminated
+	if err != nil || len(id) >= int(max_identity_len) || len(key) == 0 ||
+		len(key) > int(max_psk_len) {
+		return 0
+	}
+	id_buf := unsafe.Slice((*byte)(unsafe.Pointer(identity)), len(id)+1)
+	copy(id_buf, id)
+	id_buf[len(id)] = 0
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(psk)), len(key)), key)
+	return C.uint(len(key))
+}
+
+//export go_ssl_ctx_psk_server_thunk
+func go_ssl_ctx_psk_server_thunk(p unsafe.Pointer, identity *C.char,
+	psk *C.uchar, max_psk_len C.uint) C.uint {
+	defer func() {
+		if err := recover(); err != nil {
+			logCallbackPanic("psk server callback", err)
+			os.Exit(1)
+		}
+	}()
+	cb := pointer.Restore(p).(*Ctx).psk_server_cb
+	if cb == nil || identity == nil {
+		return 0
+	}
+	key, err := cb(C.GoString(identity))
+	if err != nil || len(key) == 0 || len(key) > int(max_psk_len) {
+		return 0
+	}
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(psk)), len(key)), key)
+	return C.uint(len(key))
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var testPSK = bytes.Repeat([]byte{0x42}, 32)
+
+func pskHandshake(t *testing.T, max_version TLSVersion,
+	client_key []byte) (server, client *Conn, err error) {
+	server_ctx, err := NewPSKCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server_ctx.SetPSKServerCallback(func(identity string) ([]byte, error) {
+		if identity != "device-1" {
+			return nil, errors.New("unknown identity")
+		}
+		return testPSK, nil
+	})
+	client_ctx, err := NewPSKCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client_ctx.SetMaxProtoVersion(max_version); err != nil {
+		t.Fatal(err)
+	}
+	client_ctx.SetPSKClientCallback(func(hint string) (string, []byte,
+		error) {
+		return "device-1", client_key, nil
+	})
+
+	server_conn, client_conn := NetPipe(t)
+	server, err = Server(server_conn, server_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = Client(client_conn, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- server.Handshake() }()
+	err = client.Handshake()
+	if server_err := <-errs; err == nil {
+		err = server_err
+	}
+	return server, client, err
+}
+
+func TestPSKCtx(t *testing.T) {
+	for _, version := range []TLSVersion{TLSv1_2, TLSv1_3} {
+		server, client, err := pskHandshake(t, version, testPSK)
+		if err != nil {
+			t.Fatalf("version %#x: %v", int(version), err)
+		}
+		if _, err := client.PeerCertificate(); err == nil {
+			t.Fatal("expected no server certificate")
+		}
+		close_both(server, client)
+	}
+}
+
+func TestPSKCtxWrongKey(t *testing.T) {
+	server, client, err := pskHandshake(t, TLSv1_3,
+		bytes.Repeat([]byte{0x17}, 32))
+	defer close_both(server, client)
+	if err == nil {
+		t.Fatal("expected a handshake with the wrong key to fail")
+	}
+}
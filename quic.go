@@ -0,0 +1,242 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/mattn/go-pointer"
+)
+
+var errQUICUnsupported = errors.New("QUIC requires OpenSSL 3.2")
+
+// NewQUICClientCtx creates a context for QUIC client connections made with
+// DialQUIC. QUIC always uses TLS 1.3 and needs the application protocols to
+// be set with SetNextProtos. Requires OpenSSL 3.2 or later.
+func NewQUICClientCtx() (*Ctx, error) {
+	if C.OPENSSL_VERSION_NUMBER < 0x30200000 {
+		return nil, errQUICUnsupported
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	ctx := C.SSL_CTX_new(C.X_OSSL_QUIC_client_method())
+	if ctx == nil {
+		return nil, errorFromErrorQueue()
+	}
+	c := &Ctx{ctx: ctx}
+	C.SSL_CTX_set_ex_data(ctx, get_ssl_ctx_idx(), pointer.Save(c))
+	runtime.SetFinalizer(c, func(c *Ctx) {
+		C.SSL_CTX_free(c.ctx)
+	})
+	return c, nil
+}
+
+// QUICConn is a QUIC client connection. Data is exchanged on streams, which
+// are opened with OpenStream or accepted from the server with AcceptStream.
+// All calls block; OpenSSL drives the connection from inside them.
+type QUICConn struct {
+	ssl *C.SSL
+	ctx *Ctx // for gc
+
+	mtx    sync.Mutex
+	closed bool
+}
+
+// DialQUIC connects to the host:port addr over QUIC using a context made
+// by NewQUICClientCtx, and performs the handshake. The server certificate
+// is checked against the host name when the context verifies peers.
+func DialQUIC(addr string, ctx *Ctx) (*QUICConn, error) {
+	if C.OPENSSL_VERSION_NUMBER < 0x30200000 {
+		return nil, errQUICUnsupported
+	}
+	if len(ctx.next_protos) == 0 {
+		return nil, errors.New("QUIC requires application protocols")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	chost := C.CString(host)
+	defer C.free(unsafe.Pointer(chost))
+	cport := C.CString(port)
+	defer C.free(unsafe.Pointer(cport))
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	ssl := C.SSL_new(ctx.ctx)
+	if ssl == nil {
+		return nil, errorFromErrorQueue()
+	}
+	c := &QUICConn{ssl: ssl, ctx: ctx}
+	runtime.SetFinalizer(c, func(c *QUICConn) {
+		C.SSL_free(c.ssl)
+	})
+	if C.X_SSL_set_quic_peer(ssl, chost, cport) != 1 {
+		return nil, errorFromErrorQueue()
+	}
+	if net.ParseIP(host) == nil {
+		if C.X_SSL_set_tlsext_host_name(ssl, chost) == 0 {
+			return nil, errorFromErrorQueue()
+		}
+		if C.SSL_set1_host(ssl, chost) != 1 {
+			return nil, errorFromErrorQueue()
+		}
+	}
+	if C.SSL_connect(ssl) != 1 {
+		return nil, errorFromErrorQueue()
+	}
+	return c, nil
+}
+
+// NegotiatedProtocol returns the application protocol selected by the
+// server.
+func (c *QUICConn) NegotiatedProtocol() string {
+	var data *C.uchar
+	var length C.uint
+	C.SSL_get0_alpn_selected(c.ssl, &data, &length)
+	if data == nil || length == 0 {
+		return ""
+	}
+	return string(C.GoBytes(unsafe.Pointer(data), C.int(length)))
+}
+
+// OpenStream opens a new stream, which is bidirectional unless uni is set.
+func (c *QUICConn) OpenStream(uni bool) (*QUICStream, error) {
+	var cuni C.int
+	if uni {
+		cuni = 1
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	stream := C.X_SSL_new_stream(c.ssl, cuni)
+	if stream == nil {
+		return nil, errorFromErrorQueue()
+	}
+	return newQUICStream(c, stream), nil
+}
+
+// AcceptStream waits for the server to open a stream.
+func (c *QUICConn) AcceptStream() (*QUICStream, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	stream := C.X_SSL_accept_stream(c.ssl)
+	if stream == nil {
+		return nil, errorFromErrorQueue()
+	}
+	return newQUICStream(c, stream), nil
+}
+
+// Close shuts the connection down, waiting until the server acknowledges
+// it. Streams must not be used afterwards.
+func (c *QUICConn) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	for {
+		rv := C.SSL_shutdown(c.ssl)
+		if rv == 1 {
+			return nil
+		}
+		if rv < 0 {
+			return errorFromErrorQueue()
+		}
+	}
+}
+
+// QUICStream is a stream of a QUICConn.
+type QUICStream struct {
+	ssl  *C.SSL
+	conn *QUICConn // for gc
+}
+
+func newQUICStream(conn *QUICConn, ssl *C.SSL) *QUICStream {
+	s := &QUICStream{ssl: ssl, conn: conn}
+	runtime.SetFinalizer(s, func(s *QUICStream) {
+		C.SSL_free(s.ssl)
+	})
+	return s
+}
+
+// ID returns the QUIC stream ID.
+func (s *QUICStream) ID() uint64 {
+	return uint64(C.X_SSL_get_stream_id(s.ssl))
+}
+
+// Read reads stream data. It returns io.EOF once the peer has concluded the
+// stream.
+func (s *QUICStream) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var n C.size_t
+	rv := C.SSL_read_ex(s.ssl, unsafe.Pointer(&b[0]), C.size_t(len(b)), &n)
+	if rv == 1 {
+		return int(n), nil
+	}
+	if C.SSL_get_error(s.ssl, rv) == C.SSL_ERROR_ZERO_RETURN {
+		return 0, io.EOF
+	}
+	return 0, errorFromErrorQueue()
+}
+
+// Write writes stream data.
+func (s *QUICStream) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var n C.size_t
+	if C.SSL_write_ex(s.ssl, unsafe.Pointer(&b[0]), C.size_t(len(b)),
+		&n) != 1 {
+		return int(n), errorFromErrorQueue()
+	}
+	return int(n), nil
+}
+
+// CloseWrite concludes the sending part of the stream, so the peer reads
+// io.EOF after the data written so far.
+func (s *QUICStream) CloseWrite() error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.X_SSL_stream_conclude(s.ssl) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
+// Close releases the stream. Unless CloseWrite was called, the sending part
+// is reset.
+func (s *QUICStream) Close() error {
+	runtime.SetFinalizer(s, nil)
+	C.SSL_free(s.ssl)
+	s.ssl = nil
+	return nil
+}
@@ -0,0 +1,153 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"time"
+	"unsafe"
+)
+
+type randReader struct {
+	private bool
+}
+
+// Rand is a cryptographically secure random number generator backed by
+// OpenSSL's RAND_bytes. It can be used in place of crypto/rand.Reader so
+// that all randomness is sourced from OpenSSL, e.g. when running in FIPS
+// mode.
+var Rand io.Reader = &randReader{}
+
+// PrivateRand is like Rand but backed by RAND_priv_bytes, which draws from a
+// separate DRBG instance intended for long-term secrets such as private key
+// material. With OpenSSL versions before 1.1.1 it is equivalent to Rand.
+var PrivateRand io.Reader = &randReader{private: true}
+
+func (r *randReader) Read(b []byte) (int, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	n := 0
+	for n < len(b) {
+		chunk := len(b) - n
+		if chunk > math.MaxInt32 {
+			chunk = math.MaxInt32
+		}
+		buf := (*C.uchar)(unsafe.Pointer(&b[n]))
+		var rc C.int
+		if r.private {
+			rc = C.X_RAND_priv_bytes(buf, C.int(chunk))
+		} else {
+			rc = C.RAND_bytes(buf, C.int(chunk))
+		}
+		if rc != 1 {
+			return n, errorFromErrorQueue()
+		}
+		n += chunk
+	}
+	return n, nil
+}
+
+// RandAdd mixes buf into the state of OpenSSL's random number generator.
+// entropy is the caller's lower-bound estimate, in bytes, of the randomness
+// contained in buf.
+func RandAdd(buf []byte, entropy float64) {
+	if len(buf) == 0 {
+		return
+	}
+	C.RAND_add(unsafe.Pointer(&buf[0]), C.int(len(buf)), C.double(entropy))
+}
+
+// RandStatus reports whether the random number generator has been seeded
+// with enough entropy.
+func RandStatus() bool {
+	return C.RAND_status() == 1
+}
+
+// SetRandReseedInterval sets how often the primary DRBG reseeds from its
+// entropy source: after the given number of generate requests, or once
+// interval has elapsed. A zero value disables the respective trigger.
+//
+// The public and private DRBGs that serve requests are per thread. On
+// OpenSSL 3 they keep their own intervals but reseed from the primary DRBG
+// whenever it reseeded. On OpenSSL 1.1.1 the intervals become their
+// defaults, which only apply to threads that have not generated random
+// bytes yet, so call it before anything else uses OpenSSL. Requires OpenSSL
+// 1.1.1 or later.
+func SetRandReseedInterval(requests uint, interval time.Duration) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	C.ERR_clear_error()
+	if C.X_RAND_set_reseed_interval(C.uint(requests),
+		C.int64_t(interval/time.Second)) != 1 {
+		return randConfigError("setting the reseed interval")
+	}
+	return nil
+}
+
+// SetDRBGType selects the DRBG algorithm ("CTR-DRBG", "HASH-DRBG" or
+// "HMAC-DRBG") and its underlying cipher or digest for the default library
+// context, e.g. SetDRBGType("CTR-DRBG", "AES-256-CTR", ""). Empty strings
+// keep the defaults. It only takes effect if called before any random bytes
+// are generated. Requires OpenSSL 3.0 or later.
+func SetDRBGType(drbg, cipher, digest string) error {
+	cstr := func(s string) *C.char {
+		if s == "" {
+			return nil
+		}
+		return C.CString(s)
+	}
+	cdrbg, ccipher, cdigest := cstr(drbg), cstr(cipher), cstr(digest)
+	defer C.free(unsafe.Pointer(cdrbg))
+	defer C.free(unsafe.Pointer(ccipher))
+	defer C.free(unsafe.Pointer(cdigest))
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	C.ERR_clear_error()
+	if C.X_RAND_set_DRBG_type(cdrbg, ccipher, cdigest) != 1 {
+		return randConfigError("selecting the DRBG type")
+	}
+	return nil
+}
+
+// SetRandSeedSource selects the entropy source used to seed the primary DRBG,
+// e.g. "SEED-SRC" for the operating system or "JITTER" where available. It
+// only takes effect if called before any random bytes are generated.
+// Requires OpenSSL 3.0 or later.
+func SetRandSeedSource(seed string) error {
+	cseed := C.CString(seed)
+	defer C.free(unsafe.Pointer(cseed))
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	C.ERR_clear_error()
+	if C.X_RAND_set_seed_source_type(cseed) != 1 {
+		return randConfigError("selecting the seed source")
+	}
+	return nil
+}
+
+func randConfigError(what string) error {
+	if C.ERR_peek_error() == 0 {
+		return fmt.Errorf("%s is not supported by this OpenSSL version", what)
+	}
+	return errorFromErrorQueue()
+}
@@ -0,0 +1,60 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRand(t *testing.T) {
+	for name, r := range map[string]io.Reader{
+		"public":  Rand,
+		"private": PrivateRand,
+	} {
+		t.Run(name, func(t *testing.T) {
+			a := make([]byte, 64)
+			b := make([]byte, 64)
+			if _, err := io.ReadFull(r, a); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadFull(r, b); err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(a, b) || bytes.Equal(a, make([]byte, 64)) {
+				t.Fatal("random output repeated")
+			}
+			if n, err := r.Read(nil); n != 0 || err != nil {
+				t.Fatalf("empty read returned %d, %v", n, err)
+			}
+		})
+	}
+}
+
+func TestRandConfiguration(t *testing.T) {
+	RandAdd([]byte("some additional input"), 0)
+	if !RandStatus() {
+		t.Fatal("random number generator is not seeded")
+	}
+	if err := SetRandReseedInterval(1<<16, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 32)
+	if _, err := io.ReadFull(Rand, buf); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/mattn/go-pointer"
+)
+
+// credentials are a certificate, its chain and private key as loaded from
+// files.
+type credentials struct {
+	cert  *Certificate
+	chain []*Certificate
+	sk    *C.struct_stack_st_X509
+	key   PrivateKey
+}
+
+func loadCredentials(cert_file, key_file string) (*credentials, error) {
+	cert_bytes, err := ioutil.ReadFile(cert_file)
+	if err != nil {
+		return nil, err
+	}
+	certs := SplitPEM(cert_bytes)
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificate found in '%s'", cert_file)
+	}
+	creds := &credentials{}
+	creds.cert, err = LoadCertificateFromPEM(certs[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, pem := range certs[1:] {
+		cert, err := LoadCertificateFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+		creds.chain = append(creds.chain, cert)
+	}
+	key_bytes, err := ioutil.ReadFile(key_file)
+	if err != nil {
+		return nil, err
+	}
+	creds.key, err = LoadPrivateKeyFromPEM(key_bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !creds.cert.PublicKeyMatches(creds.key) {
+		return nil, fmt.Errorf("private key in '%s' does not match "+
+			"certificate in '%s'", key_file, cert_file)
+	}
+	creds.sk, err = certificateStack(creds.chain)
+	if err != nil {
+		return nil, err
+	}
+	runtime.SetFinalizer(creds, func(creds *credentials) {
+		C.X_sk_X509_free(creds.sk)
+	})
+	return creds, nil
+}
+
+// use configures the connection to present the credentials.
+func (creds *credentials) use(ssl *C.SSL) bool {
+	ok := C.SSL_use_certificate(ssl, creds.cert.x) == 1 &&
+		C.SSL_use_PrivateKey(ssl, creds.key.evpPKey()) == 1 &&
+		C.X_SSL_set1_chain(ssl, creds.sk) == 1
+	runtime.KeepAlive(creds)
+	return ok
+}
+
+type fileStamp struct {
+	size     int64
+	mod_time time.Time
+}
+
+func statFile(name string) fileStamp {
+	info, err := os.Stat(name)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{size: info.Size(), mod_time: info.ModTime()}
+}
+
+// CredentialWatcher reloads a context's certificate, chain and private key
+// from files when they change, e.g. after a certbot renewal.
+type CredentialWatcher struct {
+	ctx       *Ctx
+	cert_file string
+	key_file  string
+	on_error  func(error)
+
+	mu     sync.RWMutex
+	creds  *credentials
+	stamps [2]fileStamp
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// WatchCredentialFiles loads the certificate and private key like
+// NewCtxFromFiles and polls the files for changes every interval. Changed
+// credentials only take effect once both files hold a matching pair; the
+// swap is atomic, so every handshake presents either the old or the new
+// credentials. If a reload fails, the previous credentials stay in use and
+// on_error, if not nil, is called from the watching goroutine.
+//
+// The credentials are applied to each connection as it starts its
+// handshake, overriding UseCertificate, AddChainCertificate and
+// UsePrivateKey on the context.
+func (c *Ctx) WatchCredentialFiles(cert_file, key_file string,
+	interval time.Duration, on_error func(error)) (*CredentialWatcher, error) {
+	if interval <= 0 {
+		return nil, errors.New("invalid credential poll interval")
+	}
+	w := &CredentialWatcher{
+		ctx:       c,
+		cert_file: cert_file,
+		key_file:  key_file,
+		on_error:  on_error,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+	c.watcher_mu.Lock()
+	defer c.watcher_mu.Unlock()
+	if c.watcher != nil {
+		return nil, errors.New("context already watches credential files")
+	}
+	c.watcher = w
+	C.SSL_CTX_set_cert_cb(c.ctx, (*[0]byte)(C.X_SSL_CTX_cert_cb), nil)
+	go w.poll(interval)
+	return w, nil
+}
+
+// Reload loads the credential files now, whether they changed or not.
+func (w *CredentialWatcher) Reload() error {
+	stamps := [2]fileStamp{statFile(w.cert_file), statFile(w.key_file)}
+	creds, err := loadCredentials(w.cert_file, w.key_file)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// a failed reload is retried once the files change again
+	w.stamps = stamps
+	if err != nil {
+		return err
+	}
+	w.creds = creds
+	return nil
+}
+
+// Certificate returns the certificate currently presented.
+func (w *CredentialWatcher) Certificate() *Certificate {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.creds.cert
+}
+
+// Close stops watching the files and detaches the watcher from its context,
+// which may then watch files again. The last loaded credentials stay in use
+// until then.
+func (w *CredentialWatcher) Close() error {
+	select {
+	case <-w.stop:
+	default:
+		close(w.stop)
+	}
+	<-w.done
+	w.ctx.watcher_mu.Lock()
+	defer w.ctx.watcher_mu.Unlock()
+	if w.ctx.watcher == w {
+		w.mu.RLock()
+		w.ctx.watched_creds = w.creds
+		w.mu.RUnlock()
+		w.ctx.watcher = nil
+	}
+	return nil
+}
+
+func (w *CredentialWatcher) changed() bool {
+	stamps := [2]fileStamp{statFile(w.cert_file), statFile(w.key_file)}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return stamps != w.stamps
+}
+
+func (w *CredentialWatcher) poll(interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+		if !w.changed() {
+			continue
+		}
+		if err := w.Reload(); err != nil && w.on_error != nil {
+			w.on_error(err)
+		}
+	}
+}
+
+//export go_ssl_ctx_cert_cb_thunk
+func go_ssl_ctx_cert_cb_thunk(p unsafe.Pointer, ssl *C.SSL) C.int {
+	defer func() {
+		if err := recover(); err != nil {
+			logCallbackPanic("certificate callback", err)
+			os.Exit(1)
+		}
+	}()
+	c := pointer.Restore(p).(*Ctx)
+	c.watcher_mu.Lock()
+	w, creds := c.watcher, c.watched_creds
+	c.watcher_mu.Unlock()
+	if w != nil {
+		w.mu.RLock()
+		creds = w.creds
+		w.mu.RUnlock()
+	}
+	if creds == nil {
+		return 1
+	}
+	if !creds.use(ssl) {
+		return 0
+	}
+	return 1
+}
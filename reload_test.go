@@ -0,0 +1,275 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCredentials(t *testing.T, dir string, ca *Certificate,
+	cakey PrivateKey, cn string) {
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := NewCertificateRequest(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := req.GetSubjectName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := name.AddTextEntry("CN", cn); err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Sign(key, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := IssueCertificate(req, ca, cakey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := cert.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := ca.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "cert.pem"), append(leaf, chain...))
+	writeTestKey(t, dir, key)
+}
+
+func writeTestKey(t *testing.T, dir string, key PrivateKey) {
+	pem, err := key.MarshalPKCS1PrivateKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "key.pem"), pem)
+}
+
+// writeTestFile writes data with a distinct modification time, so that
+// rewrites within the file system's timestamp granularity are noticed
+func writeTestFile(t *testing.T, name string, data []byte) {
+	if err := ioutil.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	mod_time := time.Now().Add(time.Duration(len(data)) * time.Second)
+	if err := os.Chtimes(name, mod_time, mod_time); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func peerCommonName(t *testing.T, server_ctx *Ctx) (string, int) {
+	client_ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+	server, err := Server(server_conn, server_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Client(client_conn, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- server.Handshake() }()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	cert, err := client.PeerCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := client.PeerCertificateChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := cert.GetSubjectName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn, _ := name.GetEntry(NID_commonName)
+	return cn, len(chain)
+}
+
+func TestCtxWatchCredentialFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, cakey := newTestCA(t)
+	writeTestCredentials(t, dir, ca, cakey, "server-1")
+
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reload_errs := make(chan error, 10)
+	watcher, err := ctx.WatchCredentialFiles(filepath.Join(dir, "cert.pem"),
+		filepath.Join(dir, "key.pem"), 10*time.Millisecond,
+		func(err error) {
+			select {
+			case reload_errs <- err:
+			default:
+			}
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	if cn, n := peerCommonName(t, ctx); cn != "server-1" || n != 2 {
+		t.Fatalf("expected server-1 with chain, got %s with %d certs", cn, n)
+	}
+
+	writeTestCredentials(t, dir, ca, cakey, "server-2")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		name, err := watcher.Certificate().GetSubjectName()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cn, _ := name.GetEntry(NID_commonName); cn == "server-2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("credentials not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cn, _ := peerCommonName(t, ctx); cn != "server-2" {
+		t.Fatalf("expected server-2, got %s", cn)
+	}
+
+	// the certificate may have been read before its new key was written
+	for len(reload_errs) > 0 {
+		<-reload_errs
+	}
+
+	// a key that does not match the certificate is reported and the
+	// previous credentials stay in use
+	other, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestKey(t, dir, other)
+	select {
+	case err := <-reload_errs:
+		if err == nil {
+			t.Fatal("expected reload error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload error not reported")
+	}
+	if cn, _ := peerCommonName(t, ctx); cn != "server-2" {
+		t.Fatalf("expected server-2, got %s", cn)
+	}
+	if err := watcher.Reload(); err == nil {
+		t.Fatal("reloaded mismatched credentials")
+	}
+
+	if _, err := ctx.WatchCredentialFiles(filepath.Join(dir, "cert.pem"),
+		filepath.Join(dir, "key.pem"), time.Second, nil); err == nil {
+		t.Fatal("watched credential files twice")
+	}
+}
+
+func TestCredentialWatcherClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, cakey := newTestCA(t)
+	writeTestCredentials(t, dir, ca, cakey, "server-1")
+	cert_file := filepath.Join(dir, "cert.pem")
+	key_file := filepath.Join(dir, "key.pem")
+
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := ctx.WatchCredentialFiles(cert_file, key_file,
+		time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// handshakes read the watcher while it is replaced
+	done := make(chan struct{})
+	handshakes := make(chan string)
+	go func() {
+		defer close(handshakes)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			cn, _ := peerCommonName(t, ctx)
+			handshakes <- cn
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if cn := <-handshakes; cn != "server-1" {
+			t.Fatalf("expected server-1, got %s", cn)
+		}
+		if err := watcher.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := watcher.Close(); err != nil {
+			t.Fatal(err)
+		}
+		watcher, err = ctx.WatchCredentialFiles(cert_file, key_file,
+			time.Millisecond, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	for range handshakes {
+	}
+
+	// the last credentials stay in use once the watcher is closed
+	if err := watcher.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestCredentials(t, dir, ca, cakey, "server-2")
+	if cn, _ := peerCommonName(t, ctx); cn != "server-1" {
+		t.Fatalf("expected server-1, got %s", cn)
+	}
+	watcher, err = ctx.WatchCredentialFiles(cert_file, key_file, time.Second,
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	if cn, _ := peerCommonName(t, ctx); cn != "server-2" {
+		t.Fatalf("expected server-2, got %s", cn)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"runtime"
+	"unsafe"
+)
+
+// RIPEMD160Hash is a RIPEMD-160 digest. RIPEMD-160 is a legacy algorithm
+// and should only be used to interoperate with existing data, such as old
+// firmware manifests, which is why its constructors carry a Legacy prefix.
+type RIPEMD160Hash struct {
+	ctx    *C.EVP_MD_CTX
+	engine *Engine
+}
+
+func NewLegacyRIPEMD160Hash() (*RIPEMD160Hash, error) {
+	return NewLegacyRIPEMD160HashWithEngine(nil)
+}
+
+func NewLegacyRIPEMD160HashWithEngine(e *Engine) (*RIPEMD160Hash, error) {
+	hash := &RIPEMD160Hash{engine: e}
+	hash.ctx = C.X_EVP_MD_CTX_new()
+	if hash.ctx == nil {
+		return nil, errors.New("openssl: ripemd160: unable to allocate ctx")
+	}
+	runtime.SetFinalizer(hash, func(hash *RIPEMD160Hash) { hash.Close() })
+	if err := hash.Reset(); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+func (s *RIPEMD160Hash) Close() {
+	if s.ctx != nil {
+		C.X_EVP_MD_CTX_free(s.ctx)
+		s.ctx = nil
+	}
+}
+
+func (s *RIPEMD160Hash) Reset() error {
+	if C.X_EVP_DigestInit_ex(s.ctx, C.X_EVP_ripemd160(),
+		engineRef(s.engine)) != 1 {
+		return errors.New("openssl: ripemd160: cannot init digest ctx")
+	}
+	return nil
+}
+
+func (s *RIPEMD160Hash) Write(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if C.X_EVP_DigestUpdate(s.ctx, unsafe.Pointer(&p[0]),
+		C.size_t(len(p))) != 1 {
+		return 0, errors.New("openssl: ripemd160: cannot update digest")
+	}
+	return len(p), nil
+}
+
+func (s *RIPEMD160Hash) Sum() (result [20]byte, err error) {
+	if C.X_EVP_DigestFinal_ex(s.ctx,
+		(*C.uchar)(unsafe.Pointer(&result[0])), nil) != 1 {
+		return result, errors.New("openssl: ripemd160: cannot finalize ctx")
+	}
+	return result, s.Reset()
+}
+
+func LegacyRIPEMD160(data []byte) (result [20]byte, err error) {
+	hash, err := NewLegacyRIPEMD160Hash()
+	if err != nil {
+		return result, err
+	}
+	defer hash.Close()
+	if _, err := hash.Write(data); err != nil {
+		return result, err
+	}
+	return hash.Sum()
+}
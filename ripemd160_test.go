@@ -0,0 +1,61 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestRIPEMD160Examples(t *testing.T) {
+	for _, tc := range []struct {
+		in, out string
+	}{
+		{"", "9c1185a5c5e9fc54612808977ee8f548b2258d31"},
+		{"abc", "8eb208f7e05d987a9b044a8e98c6b087f15a0bfc"},
+		{"message digest", "5d0689ef49d2fae572b881b123a85ffa21595f36"},
+		{strings.Repeat("1234567890", 8),
+			"9b752e45573d4b39f4dbd3323cab82bf63326bfb"},
+	} {
+		got, err := LegacyRIPEMD160([]byte(tc.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got[:]) != tc.out {
+			t.Fatalf("%q: exp:%s got:%x", tc.in, tc.out, got)
+		}
+	}
+}
+
+func TestRIPEMD160Writer(t *testing.T) {
+	hash, err := NewLegacyRIPEMD160Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hash.Close()
+	for _, part := range []string{"message", " ", "digest"} {
+		if _, err := hash.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := hash.Sum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(got[:]) != "5d0689ef49d2fae572b881b123a85ffa21595f36" {
+		t.Fatalf("got:%x", got)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// methodForHash returns the OpenSSL digest matching a crypto.Hash.
+func methodForHash(hash crypto.Hash) (Method, error) {
+	switch hash {
+	case crypto.MD5:
+		return C.X_EVP_md5(), nil
+	case crypto.SHA1:
+		return C.X_EVP_sha1(), nil
+	case crypto.SHA224:
+		return C.X_EVP_sha224(), nil
+	case crypto.SHA256:
+		return C.X_EVP_sha256(), nil
+	case crypto.SHA384:
+		return C.X_EVP_sha384(), nil
+	case crypto.SHA512:
+		return C.X_EVP_sha512(), nil
+	}
+	return nil, fmt.Errorf("unsupported hash function %v", hash)
+}
+
+// Public returns the public half of the key as a standard library type
+// (*rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey), so that the key
+// can be used wherever a crypto.Signer or crypto.Decrypter is expected.
+func (key *pKey) Public() crypto.PublicKey {
+	pub, err := key.ToStdlibPublicKey()
+	if err != nil {
+		return nil
+	}
+	return pub
+}
+
+// Decrypt decrypts msg with an RSA private key. opts may be nil or
+// *rsa.PKCS1v15DecryptOptions for PKCS#1 v1.5 padding, or *rsa.OAEPOptions
+// for OAEP padding. The key material never leaves OpenSSL.
+func (key *pKey) Decrypt(rand io.Reader, msg []byte,
+	opts crypto.DecrypterOpts) ([]byte, error) {
+	if key.BaseType() != KeyTypeRSA {
+		return nil, errors.New("decrypt: key is not an RSA key")
+	}
+	if len(msg) == 0 {
+		return nil, errors.New("decrypt: 0-length ciphertext")
+	}
+
+	switch opts := opts.(type) {
+	case nil:
+		return key.decryptPKCS1v15(msg)
+	case *rsa.PKCS1v15DecryptOptions:
+		if opts.SessionKeyLen == 0 {
+			return key.decryptPKCS1v15(msg)
+		}
+		return key.decryptPKCS1v15SessionKey(rand, msg, opts.SessionKeyLen)
+	case *rsa.OAEPOptions:
+		md, err := methodForHash(opts.Hash)
+		if err != nil {
+			return nil, err
+		}
+		mgf1 := md
+		if opts.MGFHash != 0 {
+			mgf1, err = methodForHash(opts.MGFHash)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return key.decrypt(msg, C.RSA_PKCS1_OAEP_PADDING, md, mgf1,
+			opts.Label)
+	default:
+		return nil, fmt.Errorf("decrypt: unsupported options %T", opts)
+	}
+}
+
+func (key *pKey) decryptPKCS1v15(msg []byte) ([]byte, error) {
+	return key.decrypt(msg, C.RSA_PKCS1_PADDING, nil, nil, nil)
+}
+
+// decryptPKCS1v15SessionKey mirrors rsa.DecryptPKCS1v15SessionKey: a random
+// session key is returned instead of an error on a padding failure, so that
+// an attacker cannot use the outcome as an oracle. The padding is checked in
+// constant time on the raw decryption, as OpenSSL's own check returns early.
+func (key *pKey) decryptPKCS1v15SessionKey(rand io.Reader, msg []byte,
+	keyLen int) ([]byte, error) {
+	if rand == nil {
+		return nil, errors.New("decrypt: no source of randomness")
+	}
+	k := int(C.EVP_PKEY_size(key.key))
+	// the padding takes at least 11 bytes
+	if k-(keyLen+3+8) < 0 {
+		return nil, rsa.ErrDecryption
+	}
+	sessionKey := make([]byte, keyLen)
+	if _, err := io.ReadFull(rand, sessionKey); err != nil {
+		return nil, err
+	}
+	// fails only for ciphertexts too long for the key, which is public
+	em, err := key.decrypt(msg, C.RSA_NO_PADDING, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(em) != k {
+		return nil, rsa.ErrDecryption
+	}
+	valid, index := checkPKCS1v15Padding(em)
+	valid &= subtle.ConstantTimeEq(int32(len(em)-index), int32(keyLen))
+	subtle.ConstantTimeCopy(valid, sessionKey, em[len(em)-keyLen:])
+	return sessionKey, nil
+}
+
+// checkPKCS1v15Padding checks the encryption padding of em,
+// 0x00 0x02 PS 0x00 M with at least 8 nonzero bytes of PS, in time
+// independent of its contents. valid is 1 if it holds, and index the offset
+// of M then.
+func checkPKCS1v15Padding(em []byte) (valid, index int) {
+	firstZero := subtle.ConstantTimeByteEq(em[0], 0)
+	secondTwo := subtle.ConstantTimeByteEq(em[1], 2)
+	looking := 1
+	for i := 2; i < len(em); i++ {
+		zero := subtle.ConstantTimeByteEq(em[i], 0)
+		index = subtle.ConstantTimeSelect(looking&zero, i, index)
+		looking = subtle.ConstantTimeSelect(zero, 0, looking)
+	}
+	validPS := subtle.ConstantTimeLessOrEq(2+8, index)
+	valid = firstZero & secondTwo & (^looking & 1) & validPS
+	index = subtle.ConstantTimeSelect(valid, index+1, 0)
+	return valid, index
+}
+
+func (key *pKey) decrypt(msg []byte, padding C.int, md, mgf1 Method,
+	label []byte) ([]byte, error) {
+	ctx := C.EVP_PKEY_CTX_new(key.key, nil)
+	if ctx == nil {
+		return nil, errors.New("decrypt: failed to allocate context")
+	}
+	defer C.EVP_PKEY_CTX_free(ctx)
+
+	if C.EVP_PKEY_decrypt_init(ctx) != 1 {
+		return nil, errors.New("decrypt: failed to init decryption")
+	}
+	if C.X_EVP_PKEY_CTX_set_rsa_padding(ctx, padding) != 1 {
+		return nil, errors.New("decrypt: failed to set padding")
+	}
+	if md != nil {
+		if C.X_EVP_PKEY_CTX_set_rsa_oaep_md(ctx, md) != 1 {
+			return nil, errors.New("decrypt: failed to set OAEP digest")
+		}
+	}
+	if mgf1 != nil {
+		if C.X_EVP_PKEY_CTX_set_rsa_mgf1_md(ctx, mgf1) != 1 {
+			return nil, errors.New("decrypt: failed to set MGF1 digest")
+		}
+	}
+	if len(label) > 0 {
+		// the context takes ownership of the label
+		clabel := C.X_OPENSSL_malloc(C.size_t(len(label)))
+		if clabel == nil {
+			return nil, errors.New("decrypt: failed to allocate label")
+		}
+		C.memcpy(clabel, unsafe.Pointer(&label[0]), C.size_t(len(label)))
+		if C.X_EVP_PKEY_CTX_set0_rsa_oaep_label(ctx, (*C.uchar)(clabel),
+			C.int(len(label))) != 1 {
+			C.X_OPENSSL_free(clabel)
+			return nil, errors.New("decrypt: failed to set OAEP label")
+		}
+	}
+
+	var outlen C.size_t
+	if C.EVP_PKEY_decrypt(ctx, nil, &outlen,
+		(*C.uchar)(unsafe.Pointer(&msg[0])), C.size_t(len(msg))) != 1 {
+		return nil, errors.New("decrypt: failed to determine plaintext length")
+	}
+	out := make([]byte, outlen)
+	if C.EVP_PKEY_decrypt(ctx, (*C.uchar)(unsafe.Pointer(&out[0])), &outlen,
+		(*C.uchar)(unsafe.Pointer(&msg[0])), C.size_t(len(msg))) != 1 {
+		C.ERR_clear_error()
+		return nil, errors.New("decrypt: decryption error")
+	}
+	return out[:outlen], nil
+}
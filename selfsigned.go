@@ -0,0 +1,149 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// SelfSignedOptions adjusts the certificates made by GenerateSelfSigned.
+type SelfSignedOptions struct {
+	// CommonName of the subject. Defaults to the first host.
+	CommonName string
+	// Organization of the subject, left out if empty.
+	Organization string
+	// Backdate moves the start of the validity period into the past to
+	// tolerate clock skew. Defaults to one hour; negative values disable it.
+	Backdate time.Duration
+	// IsCA makes the certificate a CA that can issue certificates, for
+	// example with IssueCertificate, in addition to serving TLS.
+	IsCA bool
+	// ClientAuth allows the certificate to be used for TLS clients as well
+	// as servers.
+	ClientAuth bool
+	// Digest used to sign the certificate. Defaults to EVP_SHA256, or
+	// EVP_NULL for Ed25519 keys.
+	Digest EVP_MD
+}
+
+// GenerateSelfSigned creates a version 3 certificate for key, valid for
+// hosts during validity from now (one year if zero) and signed by key
+// itself, for tests, local development and bootstrap identities. Hosts are
+// DNS names, wildcards included, or IP addresses and become subject
+// alternative names. The key usages and extended key usages fit TLS servers
+// and, with options, clients and CAs. opts may be nil.
+func GenerateSelfSigned(hosts []string, key PrivateKey, validity time.Duration,
+	opts *SelfSignedOptions) (*Certificate, error) {
+	if opts == nil {
+		opts = &SelfSignedOptions{}
+	}
+	if len(hosts) == 0 && opts.CommonName == "" {
+		return nil, errors.New("no hosts for self-signed certificate")
+	}
+	sans := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if host == "" {
+			return nil, errors.New("empty host for self-signed certificate")
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			sans = append(sans, "IP:"+ip.String())
+		} else {
+			sans = append(sans, "DNS:"+host)
+		}
+	}
+	common_name := opts.CommonName
+	if common_name == "" {
+		common_name = hosts[0]
+	}
+	if validity == 0 {
+		validity = 365 * 24 * time.Hour
+	}
+	backdate := opts.Backdate
+	if backdate == 0 {
+		backdate = time.Hour
+	} else if backdate < 0 {
+		backdate = 0
+	}
+	digest := opts.Digest
+	if digest == EVP_NULL && key.KeyType() != KeyTypeED25519 {
+		digest = EVP_SHA256
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := NewCertificate(&CertificateInfo{
+		Serial:       serial,
+		Organization: opts.Organization,
+		CommonName:   common_name,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := cert.SetVersion(X509_V3); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err := cert.SetNotBefore(now.Add(-backdate)); err != nil {
+		return nil, err
+	}
+	if err := cert.SetNotAfter(now.Add(validity)); err != nil {
+		return nil, err
+	}
+
+	key_usage := []string{"critical", "digitalSignature"}
+	if key.KeyType() == KeyTypeRSA {
+		key_usage = append(key_usage, "keyEncipherment")
+	}
+	basic_constraints := "critical,CA:FALSE"
+	if opts.IsCA {
+		key_usage = append(key_usage, "keyCertSign", "cRLSign")
+		basic_constraints = "critical,CA:TRUE"
+	}
+	ext_key_usage := "serverAuth"
+	if opts.ClientAuth {
+		ext_key_usage += ",clientAuth"
+	}
+	// in order, so that the key identifiers exist when referenced
+	extensions := []struct {
+		nid   NID
+		value string
+	}{
+		{NID_basic_constraints, basic_constraints},
+		{NID_key_usage, strings.Join(key_usage, ",")},
+		{NID_ext_key_usage, ext_key_usage},
+		{NID_subject_key_identifier, "hash"},
+		{NID_authority_key_identifier, "keyid"},
+	}
+	for _, ext := range extensions {
+		if err := cert.AddExtension(ext.nid, ext.value); err != nil {
+			return nil, err
+		}
+	}
+	if len(sans) > 0 {
+		err := cert.AddExtension(NID_subject_alt_name, strings.Join(sans, ","))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := cert.Sign(key, digest); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
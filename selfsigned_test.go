@@ -0,0 +1,93 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGenerateSelfSigned(t *testing.T) {
+	eckey, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edkey, err := GenerateED25519Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := []string{"localhost", "*.example.com", "127.0.0.1", "::1"}
+
+	for _, test := range []struct {
+		name string
+		key  PrivateKey
+		opts *SelfSignedOptions
+	}{
+		{"ecdsa", eckey, nil},
+		{"ed25519", edkey, &SelfSignedOptions{IsCA: true, ClientAuth: true}},
+	} {
+		cert, err := GenerateSelfSigned(hosts, test.key, time.Hour, test.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		der, err := cert.MarshalDER()
+		if err != nil {
+			t.Fatal(err)
+		}
+		std, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if err := std.CheckSignature(std.SignatureAlgorithm,
+			std.RawTBSCertificate, std.Signature); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if std.Subject.CommonName != "localhost" {
+			t.Fatalf("%s: unexpected subject %v", test.name, std.Subject)
+		}
+		if len(std.DNSNames) != 2 || std.DNSNames[1] != "*.example.com" ||
+			len(std.IPAddresses) != 2 || !std.IPAddresses[1].Equal(net.IPv6loopback) {
+			t.Fatalf("%s: unexpected names %v %v", test.name, std.DNSNames,
+				std.IPAddresses)
+		}
+		if lifetime := std.NotAfter.Sub(time.Now()); lifetime > time.Hour {
+			t.Fatalf("%s: unexpected lifetime %v", test.name, lifetime)
+		}
+		if std.NotBefore.After(time.Now().Add(-time.Minute)) {
+			t.Fatalf("%s: certificate not backdated", test.name)
+		}
+		is_ca := test.opts != nil && test.opts.IsCA
+		if std.IsCA != is_ca ||
+			(std.KeyUsage&x509.KeyUsageCertSign != 0) != is_ca {
+			t.Fatalf("%s: unexpected ca flags", test.name)
+		}
+		if std.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+			t.Fatalf("%s: missing digital signature usage", test.name)
+		}
+		if len(std.ExtKeyUsage) == 0 ||
+			std.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+			t.Fatalf("%s: missing server auth usage", test.name)
+		}
+		if err := cert.VerifyHostname("api.example.com"); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+	}
+
+	if _, err := GenerateSelfSigned(nil, eckey, 0, nil); err == nil {
+		t.Fatal("generated certificate without hosts")
+	}
+}
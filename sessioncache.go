@@ -0,0 +1,174 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"container/list"
+	"os"
+	"sync"
+	"unsafe"
+
+	"github.com/mattn/go-pointer"
+)
+
+// DefaultClientSessionCacheSize is the capacity of client session caches
+// created with a capacity of zero or less.
+const DefaultClientSessionCacheSize = 64
+
+// ClientSessionCache is a least recently used cache of client sessions, keyed
+// by "host:port/servername". Contexts with a cache set by
+// SetClientSessionCache resume sessions in Dial and its variants without the
+// application managing sessions. A cache is safe for concurrent use and may be
+// shared by several contexts.
+type ClientSessionCache struct {
+	mtx      sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	lru      *list.List
+}
+
+type clientSessionEntry struct {
+	key     string
+	session []byte
+	// TLS 1.3 tickets are used only once, see RFC 8446 appendix C.4
+	single_use bool
+}
+
+// NewClientSessionCache creates a client session cache holding at most
+// capacity sessions, or DefaultClientSessionCacheSize if capacity is zero or
+// less.
+func NewClientSessionCache(capacity int) *ClientSessionCache {
+	if capacity <= 0 {
+		capacity = DefaultClientSessionCacheSize
+	}
+	return &ClientSessionCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Get returns the session stored under key, as returned by Conn.GetSession.
+// Sessions negotiated with TLS 1.3 are removed from the cache as they are
+// returned.
+func (c *ClientSessionCache) Get(key string) ([]byte, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*clientSessionEntry)
+	if entry.single_use {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	} else {
+		c.lru.MoveToFront(elem)
+	}
+	return entry.session, true
+}
+
+// Put stores session under key, evicting the least recently used session if
+// the cache is full.
+func (c *ClientSessionCache) Put(key string, session []byte) {
+	c.put(key, session, false)
+}
+
+func (c *ClientSessionCache) put(key string, session []byte, single_use bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	entry := &clientSessionEntry{
+		key:        key,
+		session:    session,
+		single_use: single_use,
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*clientSessionEntry).key)
+	}
+}
+
+// Remove removes the session stored under key, if any.
+func (c *ClientSessionCache) Remove(key string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// Len returns the number of sessions in the cache.
+func (c *ClientSessionCache) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.lru.Len()
+}
+
+func clientSessionKey(addr, servername string) string {
+	return addr + "/" + servername
+}
+
+// SetClientSessionCache sets the cache Dial and its variants use to resume
+// client sessions when no session is passed explicitly, and enables client
+// side session caching with it in place of OpenSSL's internal cache. Sessions
+// the server issues, including TLS 1.3 tickets received after the handshake,
+// are stored as they arrive. A nil cache disables it again.
+func (c *Ctx) SetClientSessionCache(cache *ClientSessionCache) {
+	c.client_sessions = cache
+	if cache == nil {
+		C.X_SSL_CTX_set_new_session_cb(c.ctx, 0)
+		return
+	}
+	mode := SessionCacheModes(C.X_SSL_CTX_get_session_cache_mode(c.ctx))
+	c.SetSessionCacheMode(mode | SessionCacheClient | NoInternalStore)
+	C.X_SSL_CTX_set_new_session_cb(c.ctx, 1)
+}
+
+// ClientSessionCache returns the cache set by SetClientSessionCache, if any.
+func (c *Ctx) ClientSessionCache() *ClientSessionCache {
+	return c.client_sessions
+}
+
+//export go_ssl_new_session_thunk
+func go_ssl_new_session_thunk(p unsafe.Pointer, session *C.SSL_SESSION,
+	version C.int) {
+	defer func() {
+		if err := recover(); err != nil {
+			logCallbackPanic("new session callback", err)
+			os.Exit(1)
+		}
+	}()
+	s := pointer.Restore(p).(*SSL)
+	if s.session_cache == nil {
+		return
+	}
+	encoded, err := marshalSession(session)
+	if err != nil {
+		return
+	}
+	s.session_cache.put(s.session_key, encoded,
+		TLSVersion(version) >= TLSv1_3)
+}
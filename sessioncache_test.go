@@ -0,0 +1,116 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bufio"
+	"fmt"
+	"testing"
+)
+
+func TestClientSessionCacheEviction(t *testing.T) {
+	cache := NewClientSessionCache(2)
+	cache.Put("a", []byte("a"))
+	cache.Put("b", []byte("b"))
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatal("missing session a")
+	}
+	cache.Put("c", []byte("c"))
+	if _, ok := cache.Get("b"); ok {
+		t.Fatal("least recently used session was not evicted")
+	}
+	if cache.Len() != 2 {
+		t.Fatalf("unexpected cache length %d", cache.Len())
+	}
+	cache.put("d", []byte("d"), true)
+	if _, ok := cache.Get("d"); !ok {
+		t.Fatal("missing session d")
+	}
+	if _, ok := cache.Get("d"); ok {
+		t.Fatal("single use session was returned twice")
+	}
+	cache.Remove("a")
+	if _, ok := cache.Get("a"); ok {
+		t.Fatal("removed session was returned")
+	}
+}
+
+func TestDialClientSessionCache(t *testing.T) {
+	l := newTestTLSServer(t, "tcp", "localhost:0")
+	defer l.Close()
+	addr := l.Addr().String()
+
+	for _, version := range []TLSVersion{TLSv1_2, TLSv1_3} {
+		ctx, err := NewCtx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ctx.SetMaxProtoVersion(version); err != nil {
+			t.Fatal(err)
+		}
+		cache := NewClientSessionCache(0)
+		ctx.SetClientSessionCache(cache)
+
+		for i := 0; i < 3; i++ {
+			conn, err := Dial("tcp", addr, ctx, InsecureSkipHostVerification)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// TLS 1.3 tickets arrive with the first application data
+			fmt.Fprintln(conn, "ping")
+			if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+			if reused := conn.SessionReused(); reused != (i > 0) {
+				t.Fatalf("version %x dial %d: session reused %v", version, i,
+					reused)
+			}
+			if cache.Len() == 0 {
+				t.Fatalf("version %x dial %d: no session cached", version, i)
+			}
+		}
+	}
+}
+
+func TestDialInsecureSkipsClientSessionCache(t *testing.T) {
+	l := newTestTLSServer(t, "tcp", "localhost:0")
+	defer l.Close()
+	addr := l.Addr().String()
+
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewClientSessionCache(0)
+	ctx.SetClientSessionCache(cache)
+	for i := 0; i < 2; i++ {
+		conn, err := Dial("tcp", addr, ctx, InsecureSkipVerify)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintln(conn, "ping")
+		if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		if conn.SessionReused() {
+			t.Fatalf("dial %d resumed an unverified session", i)
+		}
+		if cache.Len() != 0 {
+			t.Fatalf("dial %d cached an unverified session", i)
+		}
+	}
+}
@@ -15,6 +15,7 @@
  *
  */
 
+#include <errno.h>
 #include <string.h>
 
 #include <openssl/conf.h>
@@ -25,6 +26,10 @@
 #include <openssl/err.h>
 #include <openssl/evp.h>
 #include <openssl/ssl.h>
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L
+#include <openssl/kdf.h>
+#include <openssl/provider.h>
+#endif
 
 #include "_cgo_export.h"
 
@@ -38,6 +43,368 @@ static int go_write_bio_puts(BIO *b, const char *str) {
 	return go_write_bio_write(b, (char*)str, (int)strlen(str));
 }
 
+/*
+ ************************************************
+ * v3.0.0 and later implementation
+ ************************************************
+ */
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L
+
+int X_EVP_PKEY_eq(const EVP_PKEY *a, const EVP_PKEY *b) {
+	return EVP_PKEY_eq(a, b);
+}
+
+void *X_OSSL_PROVIDER_load(void *libctx, const char *name) {
+	return OSSL_PROVIDER_load(libctx, name);
+}
+
+int X_OSSL_PROVIDER_unload(void *prov) {
+	return OSSL_PROVIDER_unload(prov);
+}
+
+const char *X_OSSL_PROVIDER_get0_name(void *prov) {
+	return OSSL_PROVIDER_get0_name(prov);
+}
+
+int X_OSSL_PROVIDER_available(void *libctx, const char *name) {
+	return OSSL_PROVIDER_available(libctx, name);
+}
+
+int X_OSSL_PROVIDER_set_default_search_path(void *libctx, const char *path) {
+	return OSSL_PROVIDER_set_default_search_path(libctx, path);
+}
+
+void *X_OSSL_LIB_CTX_new() {
+	return OSSL_LIB_CTX_new();
+}
+
+void X_OSSL_LIB_CTX_free(void *libctx) {
+	OSSL_LIB_CTX_free(libctx);
+}
+
+int X_OSSL_LIB_CTX_load_config(void *libctx, const char *file) {
+	return OSSL_LIB_CTX_load_config(libctx, file);
+}
+
+SSL_CTX *X_SSL_CTX_new_ex(void *libctx, const char *propq) {
+	return SSL_CTX_new_ex(libctx, propq, X_SSLv23_method());
+}
+
+const char *X_OPENSSL_cpu_settings() {
+	return OPENSSL_info(OPENSSL_INFO_CPU_SETTINGS);
+}
+
+/*
+ * Algorithm queries. Names are collected newline separated into a growing
+ * buffer, which the caller frees.
+ */
+struct X_names {
+	char *buf;
+	size_t len;
+	size_t cap;
+};
+
+static void X_names_add(struct X_names *names, const char *name) {
+	size_t n;
+	char *buf;
+
+	if (name == NULL || names->buf == NULL) {
+		return;
+	}
+	n = strlen(name);
+	if (names->len + n + 2 > names->cap) {
+		names->cap = (names->len + n + 2) * 2;
+		buf = OPENSSL_realloc(names->buf, names->cap);
+		if (buf == NULL) {
+			OPENSSL_free(names->buf);
+			names->buf = NULL;
+			return;
+		}
+		names->buf = buf;
+	}
+	memcpy(names->buf + names->len, name, n);
+	names->len += n;
+	names->buf[names->len++] = '\n';
+	names->buf[names->len] = '\0';
+}
+
+static void X_add_md(EVP_MD *md, void *arg) {
+	X_names_add(arg, EVP_MD_get0_name(md));
+}
+
+static void X_add_cipher(EVP_CIPHER *cipher, void *arg) {
+	X_names_add(arg, EVP_CIPHER_get0_name(cipher));
+}
+
+static void X_add_mac(EVP_MAC *mac, void *arg) {
+	X_names_add(arg, EVP_MAC_get0_name(mac));
+}
+
+static void X_add_kdf(EVP_KDF *kdf, void *arg) {
+	X_names_add(arg, EVP_KDF_get0_name(kdf));
+}
+
+static void X_add_signature(EVP_SIGNATURE *sig, void *arg) {
+	X_names_add(arg, EVP_SIGNATURE_get0_name(sig));
+}
+
+static void X_add_keyexch(EVP_KEYEXCH *exch, void *arg) {
+	X_names_add(arg, EVP_KEYEXCH_get0_name(exch));
+}
+
+static void X_add_keymgmt(EVP_KEYMGMT *keymgmt, void *arg) {
+	X_names_add(arg, EVP_KEYMGMT_get0_name(keymgmt));
+}
+
+char *X_OSSL_algorithm_names(void *libctx, int type) {
+	struct X_names names;
+
+	names.cap = 256;
+	names.len = 0;
+	names.buf = OPENSSL_malloc(names.cap);
+	if (names.buf == NULL) {
+		return NULL;
+	}
+	names.buf[0] = '\0';
+	switch (type) {
+	case X_ALGORITHM_DIGEST:
+		EVP_MD_do_all_provided(libctx, X_add_md, &names);
+		break;
+	case X_ALGORITHM_CIPHER:
+		EVP_CIPHER_do_all_provided(libctx, X_add_cipher, &names);
+		break;
+	case X_ALGORITHM_MAC:
+		EVP_MAC_do_all_provided(libctx, X_add_mac, &names);
+		break;
+	case X_ALGORITHM_KDF:
+		EVP_KDF_do_all_provided(libctx, X_add_kdf, &names);
+		break;
+	case X_ALGORITHM_SIGNATURE:
+		EVP_SIGNATURE_do_all_provided(libctx, X_add_signature, &names);
+		break;
+	case X_ALGORITHM_KEYEXCH:
+		EVP_KEYEXCH_do_all_provided(libctx, X_add_keyexch, &names);
+		break;
+	case X_ALGORITHM_KEYMGMT:
+		EVP_KEYMGMT_do_all_provided(libctx, X_add_keymgmt, &names);
+		break;
+	}
+	return names.buf;
+}
+
+int X_OSSL_algorithm_available(void *libctx, int type, const char *name,
+		const char *propq) {
+	switch (type) {
+	case X_ALGORITHM_DIGEST: {
+		EVP_MD *md = EVP_MD_fetch(libctx, name, propq);
+		EVP_MD_free(md);
+		return md != NULL;
+	}
+	case X_ALGORITHM_CIPHER: {
+		EVP_CIPHER *cipher = EVP_CIPHER_fetch(libctx, name, propq);
+		EVP_CIPHER_free(cipher);
+		return cipher != NULL;
+	}
+	case X_ALGORITHM_MAC: {
+		EVP_MAC *mac = EVP_MAC_fetch(libctx, name, propq);
+		EVP_MAC_free(mac);
+		return mac != NULL;
+	}
+	case X_ALGORITHM_KDF: {
+		EVP_KDF *kdf = EVP_KDF_fetch(libctx, name, propq);
+		EVP_KDF_free(kdf);
+		return kdf != NULL;
+	}
+	case X_ALGORITHM_SIGNATURE: {
+		EVP_SIGNATURE *sig = EVP_SIGNATURE_fetch(libctx, name, propq);
+		EVP_SIGNATURE_free(sig);
+		return sig != NULL;
+	}
+	case X_ALGORITHM_KEYEXCH: {
+		EVP_KEYEXCH *exch = EVP_KEYEXCH_fetch(libctx, name, propq);
+		EVP_KEYEXCH_free(exch);
+		return exch != NULL;
+	}
+	case X_ALGORITHM_KEYMGMT: {
+		EVP_KEYMGMT *keymgmt = EVP_KEYMGMT_fetch(libctx, name, propq);
+		EVP_KEYMGMT_free(keymgmt);
+		return keymgmt != NULL;
+	}
+	}
+	return 0;
+}
+
+#else
+
+int X_EVP_PKEY_eq(const EVP_PKEY *a, const EVP_PKEY *b) {
+	return EVP_PKEY_cmp(a, b);
+}
+
+void *X_OSSL_PROVIDER_load(void *libctx, const char *name) {
+	return NULL;
+}
+
+int X_OSSL_PROVIDER_unload(void *prov) {
+	return 0;
+}
+
+const char *X_OSSL_PROVIDER_get0_name(void *prov) {
+	return NULL;
+}
+
+int X_OSSL_PROVIDER_available(void *libctx, const char *name) {
+	return 0;
+}
+
+int X_OSSL_PROVIDER_set_default_search_path(void *libctx, const char *path) {
+	return 0;
+}
+
+void *X_OSSL_LIB_CTX_new() {
+	return NULL;
+}
+
+void X_OSSL_LIB_CTX_free(void *libctx) {
+}
+
+int X_OSSL_LIB_CTX_load_config(void *libctx, const char *file) {
+	return 0;
+}
+
+SSL_CTX *X_SSL_CTX_new_ex(void *libctx, const char *propq) {
+	if (libctx != NULL || propq != NULL) {
+		return NULL;
+	}
+	return SSL_CTX_new(X_SSLv23_method());
+}
+
+const char *X_OPENSSL_cpu_settings() {
+	return NULL;
+}
+
+char *X_OSSL_algorithm_names(void *libctx, int type) {
+	return NULL;
+}
+
+int X_OSSL_algorithm_available(void *libctx, int type, const char *name,
+		const char *propq) {
+	return 0;
+}
+
+#endif
+
+/*
+ ************************************************
+ * v3.2.0 and later implementation
+ ************************************************
+ */
+#if OPENSSL_VERSION_NUMBER >= 0x30200000L
+
+#include <openssl/quic.h>
+
+const SSL_METHOD *X_OSSL_QUIC_client_method() {
+	return OSSL_QUIC_client_method();
+}
+
+/*
+ * Connects a non-blocking UDP socket to host:port and attaches it to ssl,
+ * which libssl's QUIC engine then polls itself.
+ */
+int X_SSL_set_quic_peer(SSL *ssl, const char *host, const char *port) {
+	BIO_ADDRINFO *res;
+	const BIO_ADDRINFO *ai;
+	BIO_ADDR *peer = NULL;
+	BIO *bio;
+	int sock = -1;
+	int ok;
+
+	if (!BIO_lookup_ex(host, port, BIO_LOOKUP_CLIENT, AF_UNSPEC, SOCK_DGRAM,
+			0, &res)) {
+		return 0;
+	}
+	for (ai = res; ai != NULL; ai = BIO_ADDRINFO_next(ai)) {
+		sock = BIO_socket(BIO_ADDRINFO_family(ai), SOCK_DGRAM, 0, 0);
+		if (sock == -1) {
+			continue;
+		}
+		if (!BIO_connect(sock, BIO_ADDRINFO_address(ai), 0) ||
+				!BIO_socket_nbio(sock, 1)) {
+			BIO_closesocket(sock);
+			sock = -1;
+			continue;
+		}
+		peer = BIO_ADDR_dup(BIO_ADDRINFO_address(ai));
+		break;
+	}
+	BIO_ADDRINFO_free(res);
+	if (sock == -1) {
+		return 0;
+	}
+	if (peer == NULL) {
+		BIO_closesocket(sock);
+		return 0;
+	}
+	bio = BIO_new(BIO_s_datagram());
+	if (bio == NULL) {
+		BIO_ADDR_free(peer);
+		BIO_closesocket(sock);
+		return 0;
+	}
+	BIO_set_fd(bio, sock, BIO_CLOSE);
+	SSL_set_bio(ssl, bio, bio);
+	ok = SSL_set1_initial_peer_addr(ssl, peer);
+	BIO_ADDR_free(peer);
+	if (!ok) {
+		return 0;
+	}
+	/* streams are opened and accepted explicitly */
+	return SSL_set_default_stream_mode(ssl, SSL_DEFAULT_STREAM_MODE_NONE);
+}
+
+SSL *X_SSL_new_stream(SSL *ssl, int uni) {
+	return SSL_new_stream(ssl, uni ? SSL_STREAM_FLAG_UNI : 0);
+}
+
+SSL *X_SSL_accept_stream(SSL *ssl) {
+	return SSL_accept_stream(ssl, 0);
+}
+
+int X_SSL_stream_conclude(SSL *stream) {
+	return SSL_stream_conclude(stream, 0);
+}
+
+unsigned long long X_SSL_get_stream_id(SSL *stream) {
+	return SSL_get_stream_id(stream);
+}
+
+#else
+
+const SSL_METHOD *X_OSSL_QUIC_client_method() {
+	return NULL;
+}
+
+int X_SSL_set_quic_peer(SSL *ssl, const char *host, const char *port) {
+	return 0;
+}
+
+SSL *X_SSL_new_stream(SSL *ssl, int uni) {
+	return NULL;
+}
+
+SSL *X_SSL_accept_stream(SSL *ssl) {
+	return NULL;
+}
+
+int X_SSL_stream_conclude(SSL *stream) {
+	return 0;
+}
+
+unsigned long long X_SSL_get_stream_id(SSL *stream) {
+	return 0;
+}
+
+#endif
+
 /*
  ************************************************
  * v1.1.1 and later implementation
@@ -69,6 +436,10 @@ int X_EVP_DigestVerify(EVP_MD_CTX *ctx, const unsigned char *sigret,
 	return EVP_DigestVerify(ctx, sigret, siglen, tbs, tbslen);
 }
 
+int X_RAND_priv_bytes(unsigned char *buf, int num) {
+	return RAND_priv_bytes(buf, num);
+}
+
 #else
 
 const int X_ED25519_SUPPORT = 0;
@@ -95,6 +466,10 @@ int X_EVP_DigestVerify(EVP_MD_CTX *ctx, const unsigned char *sigret,
 	return 0;
 }
 
+int X_RAND_priv_bytes(unsigned char *buf, int num) {
+	return RAND_bytes(buf, num);
+}
+
 #endif
 
 /*
@@ -132,11 +507,126 @@ static int x_bio_free(BIO *b) {
 	return 1;
 }
 
+/*
+ * The coalescing BIO sits in front of the Go write BIO and gathers the
+ * records libssl writes one at a time, so that the Go callback runs once
+ * per batch instead of once per record. Its buffer is allocated on first
+ * use and can be released by X_BIO_CTRL_DRAIN.
+ */
+#define X_BIO_COALESCE_SIZE (64 * 1024)
+
+typedef struct {
+	size_t len;
+	unsigned char *buf;
+} x_bio_coalesce_t;
+
+static int x_bio_coalesce_drain(BIO *b, int release) {
+	x_bio_coalesce_t *c = BIO_get_data(b);
+	int n;
+
+	if (c->len > 0) {
+		n = BIO_write(BIO_next(b), c->buf, (int)c->len);
+		if (n <= 0) {
+			BIO_copy_next_retry(b);
+			return n;
+		}
+		memmove(c->buf, c->buf + n, c->len - n);
+		c->len -= n;
+	}
+	if (release && c->len == 0) {
+		OPENSSL_free(c->buf);
+		c->buf = NULL;
+	}
+	return c->len == 0;
+}
+
+static int x_bio_coalesce_write(BIO *b, const char *data, int len) {
+	x_bio_coalesce_t *c = BIO_get_data(b);
+	int rv;
+
+	BIO_clear_retry_flags(b);
+	if (len <= 0 || BIO_next(b) == NULL) {
+		return 0;
+	}
+	if (c->len + len > X_BIO_COALESCE_SIZE) {
+		rv = x_bio_coalesce_drain(b, 0);
+		if (rv <= 0) {
+			return rv == 0 ? -1 : rv;
+		}
+	}
+	if (len >= X_BIO_COALESCE_SIZE) {
+		rv = BIO_write(BIO_next(b), data, len);
+		BIO_copy_next_retry(b);
+		return rv;
+	}
+	if (c->buf == NULL) {
+		c->buf = OPENSSL_malloc(X_BIO_COALESCE_SIZE);
+		if (c->buf == NULL) {
+			return -1;
+		}
+	}
+	memcpy(c->buf + c->len, data, len);
+	c->len += len;
+	return len;
+}
+
+static long x_bio_coalesce_ctrl(BIO *b, int cmd, long num, void *ptr) {
+	x_bio_coalesce_t *c = BIO_get_data(b);
+	BIO *next = BIO_next(b);
+
+	if (next == NULL) {
+		return 0;
+	}
+	switch (cmd) {
+	case X_BIO_CTRL_DRAIN:
+		return x_bio_coalesce_drain(b, (int)num);
+	case BIO_CTRL_WPENDING:
+		return (long)c->len + BIO_ctrl(next, cmd, num, ptr);
+	case BIO_CTRL_FLUSH:
+		if (x_bio_coalesce_drain(b, 0) <= 0) {
+			return 0;
+		}
+		break;
+	}
+	return BIO_ctrl(next, cmd, num, ptr);
+}
+
+static int x_bio_coalesce_create(BIO *b) {
+	x_bio_coalesce_t *c = OPENSSL_zalloc(sizeof(*c));
+	if (c == NULL) {
+		return 0;
+	}
+	BIO_set_data(b, c);
+	BIO_set_init(b, 1);
+	return 1;
+}
+
+static int x_bio_coalesce_free(BIO *b) {
+	x_bio_coalesce_t *c = BIO_get_data(b);
+	if (c != NULL) {
+		OPENSSL_free(c->buf);
+		OPENSSL_free(c);
+		BIO_set_data(b, NULL);
+	}
+	return 1;
+}
+
 static BIO_METHOD *writeBioMethod;
 static BIO_METHOD *readBioMethod;
+static BIO_METHOD *coalesceBioMethod;
+static BIO_METHOD *readerBioMethod;
 
 BIO_METHOD* BIO_s_readBio() { return readBioMethod; }
 BIO_METHOD* BIO_s_writeBio() { return writeBioMethod; }
+BIO_METHOD* BIO_s_readerBio() { return readerBioMethod; }
+
+BIO *X_BIO_push_coalesce(BIO *next) {
+	BIO *b = BIO_new(coalesceBioMethod);
+	if (b == NULL) {
+		return NULL;
+	}
+	return BIO_push(b, next);
+}
 
 int x_bio_init_methods() {
 	writeBioMethod = BIO_meth_new(BIO_TYPE_SOURCE_SINK, "Go Write BIO");
@@ -177,6 +667,37 @@ int x_bio_init_methods() {
 		return 11;
 	}
 
+	coalesceBioMethod = BIO_meth_new(BIO_TYPE_FILTER, "Coalescing BIO");
+	if (!coalesceBioMethod) {
+		return 12;
+	}
+	if (1 != BIO_meth_set_write(coalesceBioMethod, x_bio_coalesce_write)) {
+		return 13;
+	}
+	if (1 != BIO_meth_set_ctrl(coalesceBioMethod, x_bio_coalesce_ctrl)) {
+		return 14;
+	}
+	if (1 != BIO_meth_set_create(coalesceBioMethod, x_bio_coalesce_create)) {
+		return 15;
+	}
+	if (1 != BIO_meth_set_destroy(coalesceBioMethod, x_bio_coalesce_free)) {
+		return 16;
+	}
+
+	readerBioMethod = BIO_meth_new(BIO_TYPE_SOURCE_SINK, "Go Reader BIO");
+	if (!readerBioMethod) {
+		return 17;
+	}
+	if (1 != BIO_meth_set_read(readerBioMethod, go_reader_bio_read)) {
+		return 18;
+	}
+	if (1 != BIO_meth_set_create(readerBioMethod, x_bio_create)) {
+		return 19;
+	}
+	if (1 != BIO_meth_set_destroy(readerBioMethod, x_bio_free)) {
+		return 20;
+	}
+
 	return 0;
 }
 
@@ -216,6 +737,14 @@ void X_HMAC_CTX_free(HMAC_CTX *ctx) {
 	HMAC_CTX_free(ctx);
 }
 
+EVP_ENCODE_CTX *X_EVP_ENCODE_CTX_new(void) {
+	return EVP_ENCODE_CTX_new();
+}
+
+void X_EVP_ENCODE_CTX_free(EVP_ENCODE_CTX *ctx) {
+	EVP_ENCODE_CTX_free(ctx);
+}
+
 int X_PEM_write_bio_PrivateKey_traditional(BIO *bio, EVP_PKEY *key, const EVP_CIPHER *enc, unsigned char *kstr, int klen, pem_password_cb *cb, void *u) {
 	return PEM_write_bio_PrivateKey_traditional(bio, key, enc, kstr, klen, cb, u);
 }
@@ -270,6 +799,25 @@ static BIO_METHOD readBioMethod = {
 
 static BIO_METHOD* BIO_s_readBio() { return &readBioMethod; }
 
+static BIO_METHOD readerBioMethod = {
+	BIO_TYPE_SOURCE_SINK,
+	"Go Reader BIO",
+	NULL,
+	go_reader_bio_read,
+	NULL,
+	NULL,
+	NULL,
+	x_bio_create,
+	x_bio_free,
+	NULL};
+
+static BIO_METHOD* BIO_s_readerBio() { return &readerBioMethod; }
+
+BIO *X_BIO_push_coalesce(BIO *next) {
+	/* records go straight to the Go write BIO */
+	return next;
+}
+
 int x_bio_init_methods() {
 	/* statically initialized above */
 	return 0;
@@ -338,6 +886,18 @@ void X_HMAC_CTX_free(HMAC_CTX *ctx) {
 	}
 }
 
+EVP_ENCODE_CTX *X_EVP_ENCODE_CTX_new(void) {
+	EVP_ENCODE_CTX *ctx = (EVP_ENCODE_CTX *)OPENSSL_malloc(sizeof(EVP_ENCODE_CTX));
+	if (ctx) {
+		memset(ctx, 0, sizeof(EVP_ENCODE_CTX));
+	}
+	return ctx;
+}
+
+void X_EVP_ENCODE_CTX_free(EVP_ENCODE_CTX *ctx) {
+	OPENSSL_free(ctx);
+}
+
 int X_PEM_write_bio_PrivateKey_traditional(BIO *bio, EVP_PKEY *key, const EVP_CIPHER *enc, unsigned char *kstr, int klen, pem_password_cb *cb, void *u) {
 	/* PEM_write_bio_PrivateKey always tries to use the PKCS8 format if it
 	 * is available, instead of using the "traditional" format as stated in the
@@ -405,6 +965,14 @@ void X_OPENSSL_free(void *ref) {
 	OPENSSL_free(ref);
 }
 
+int X_ERR_GET_LIB(unsigned long err) {
+	return ERR_GET_LIB(err);
+}
+
+int X_ERR_GET_REASON(unsigned long err) {
+	return ERR_GET_REASON(err);
+}
+
 long X_SSL_set_options(SSL* ssl, long options) {
 	return SSL_set_options(ssl, options);
 }
@@ -431,46 +999,166 @@ int X_SSL_new_index() {
 	return SSL_get_ex_new_index(0, NULL, NULL, NULL, go_ssl_crypto_ex_free);
 }
 
-int X_SSL_verify_cb(int ok, X509_STORE_CTX* store) {
-	SSL* ssl = (SSL *)X509_STORE_CTX_get_ex_data(store,
-			SSL_get_ex_data_X509_STORE_CTX_idx());
-	void* p = SSL_get_ex_data(ssl, get_ssl_idx());
-	// get the pointer to the go Ctx object and pass it back into the thunk
-	return go_ssl_verify_cb_thunk(p, ok, store);
-}
-
-const SSL_METHOD *X_SSLv23_method() {
-	return SSLv23_method();
+long X_SSL_set1_chain(SSL *ssl, STACK_OF(X509) *sk) {
+	return SSL_set1_chain(ssl, sk);
 }
 
-int X_SSL_CTX_new_index() {
-	return SSL_CTX_get_ex_new_index(0, NULL, NULL, NULL, NULL);
+long X_SSL_set_mode(SSL *ssl, long modes) {
+	return SSL_set_mode(ssl, modes);
 }
 
-long X_SSL_CTX_set_options(SSL_CTX* ctx, long options) {
-	return SSL_CTX_set_options(ctx, options);
+long X_SSL_clear_mode(SSL *ssl, long modes) {
+	return SSL_clear_mode(ssl, modes);
 }
 
-long X_SSL_CTX_clear_options(SSL_CTX* ctx, long options) {
-	return SSL_CTX_clear_options(ctx, options);
+long X_SSL_release_write_buffer(SSL *ssl) {
+	return BIO_ctrl(SSL_get_wbio(ssl), X_BIO_CTRL_DRAIN, 1, NULL);
 }
 
-long X_SSL_CTX_get_options(SSL_CTX* ctx) {
-	return SSL_CTX_get_options(ctx);
+long X_SSL_set_max_send_fragment(SSL *ssl, long size) {
+	return SSL_set_max_send_fragment(ssl, size);
 }
 
-long X_SSL_CTX_set_mode(SSL_CTX* ctx, long modes) {
-	return SSL_CTX_set_mode(ctx, modes);
+long X_SSL_set1_groups_list(SSL *ssl, const char *groups) {
+	return SSL_set1_groups_list(ssl, groups);
 }
 
-long X_SSL_CTX_get_mode(SSL_CTX* ctx) {
-	return SSL_CTX_get_mode(ctx);
-}
+const char *X_SSL_get_negotiated_group_name(SSL *ssl) {
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L
+	int nid = SSL_get_negotiated_group(ssl);
+
+	if (nid == NID_undef) {
+		return NULL;
+	}
+	return SSL_group_to_name(ssl, nid);
+#else
+	int nid = SSL_get_shared_group(ssl, 0);
+
+	if (nid <= 0) {
+		return NULL;
+	}
+	return OBJ_nid2sn(nid);
+#endif
+}
+
+int X_SSL_verify_cb(int ok, X509_STORE_CTX* store) {
+	SSL* ssl = (SSL *)X509_STORE_CTX_get_ex_data(store,
+			SSL_get_ex_data_X509_STORE_CTX_idx());
+	void* p = SSL_get_ex_data(ssl, get_ssl_idx());
+	// get the pointer to the go Ctx object and pass it back into the thunk
+	return go_ssl_verify_cb_thunk(p, ok, store);
+}
+
+void *X_X509_STORE_CTX_get_ssl_data(X509_STORE_CTX* store) {
+	SSL* ssl = (SSL *)X509_STORE_CTX_get_ex_data(store,
+			SSL_get_ex_data_X509_STORE_CTX_idx());
+	if (ssl == NULL) {
+		return NULL;
+	}
+	return SSL_get_ex_data(ssl, get_ssl_idx());
+}
+
+const SSL_METHOD *X_SSLv23_method() {
+	return SSLv23_method();
+}
+
+const SSL_METHOD *X_DTLS_method() {
+	return DTLS_method();
+}
+
+long X_SSL_set_mtu(SSL *ssl, long mtu) {
+	return SSL_set_mtu(ssl, mtu);
+}
+
+size_t X_DTLS_get_data_mtu(const SSL *ssl) {
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+	return DTLS_get_data_mtu(ssl);
+#else
+	return 0;
+#endif
+}
+
+int X_DTLSv1_get_timeout(SSL *ssl, long long *usec) {
+	struct timeval tv;
+	if (!DTLSv1_get_timeout(ssl, &tv)) {
+		return 0;
+	}
+	*usec = (long long)tv.tv_sec * 1000000 + tv.tv_usec;
+	return 1;
+}
+
+int X_DTLSv1_handle_timeout(SSL *ssl) {
+	return DTLSv1_handle_timeout(ssl);
+}
+
+int X_DTLSv1_listen(SSL *ssl) {
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+	BIO_ADDR *client = BIO_ADDR_new();
+	int rv;
+	if (client == NULL) {
+		return -1;
+	}
+	rv = DTLSv1_listen(ssl, client);
+	BIO_ADDR_free(client);
+	return rv;
+#else
+	/* older versions need a peeking datagram BIO */
+	return -1;
+#endif
+}
+
+int X_DTLS_cookie_generate_cb(SSL *ssl, unsigned char *cookie,
+		unsigned int *cookie_len) {
+	void* p = SSL_get_ex_data(ssl, get_ssl_idx());
+	return go_dtls_cookie_generate_thunk(p, cookie, cookie_len);
+}
+
+int X_DTLS_cookie_verify_cb(SSL *ssl, const unsigned char *cookie,
+		unsigned int cookie_len) {
+	void* p = SSL_get_ex_data(ssl, get_ssl_idx());
+	return go_dtls_cookie_verify_thunk(p, (unsigned char *)cookie, cookie_len);
+}
+
+int X_SSL_CTX_new_index() {
+	return SSL_CTX_get_ex_new_index(0, NULL, NULL, NULL, NULL);
+}
+
+long X_SSL_CTX_set_options(SSL_CTX* ctx, long options) {
+	return SSL_CTX_set_options(ctx, options);
+}
+
+long X_SSL_CTX_clear_mode(SSL_CTX* ctx, long modes) {
+	return SSL_CTX_clear_mode(ctx, modes);
+}
+
+long X_SSL_CTX_set_max_send_fragment(SSL_CTX* ctx, long size) {
+	return SSL_CTX_set_max_send_fragment(ctx, size);
+}
+
+long X_SSL_CTX_clear_options(SSL_CTX* ctx, long options) {
+	return SSL_CTX_clear_options(ctx, options);
+}
+
+long X_SSL_CTX_get_options(SSL_CTX* ctx) {
+	return SSL_CTX_get_options(ctx);
+}
+
+long X_SSL_CTX_set_mode(SSL_CTX* ctx, long modes) {
+	return SSL_CTX_set_mode(ctx, modes);
+}
+
+long X_SSL_CTX_get_mode(SSL_CTX* ctx) {
+	return SSL_CTX_get_mode(ctx);
+}
 
 long X_SSL_CTX_set_session_cache_mode(SSL_CTX* ctx, long modes) {
 	return SSL_CTX_set_session_cache_mode(ctx, modes);
 }
 
+long X_SSL_CTX_get_session_cache_mode(SSL_CTX* ctx) {
+	return SSL_CTX_get_session_cache_mode(ctx);
+}
+
 long X_SSL_CTX_sess_set_cache_size(SSL_CTX* ctx, long t) {
 	return SSL_CTX_sess_set_cache_size(ctx, t);
 }
@@ -479,6 +1167,23 @@ long X_SSL_CTX_sess_get_cache_size(SSL_CTX* ctx) {
 	return SSL_CTX_sess_get_cache_size(ctx);
 }
 
+void X_SSL_CTX_sess_stats(SSL_CTX* ctx, long *stats) {
+	stats[X_SSL_CTX_STAT_NUMBER] = SSL_CTX_sess_number(ctx);
+	stats[X_SSL_CTX_STAT_CONNECT] = SSL_CTX_sess_connect(ctx);
+	stats[X_SSL_CTX_STAT_CONNECT_GOOD] = SSL_CTX_sess_connect_good(ctx);
+	stats[X_SSL_CTX_STAT_CONNECT_RENEGOTIATE] =
+		SSL_CTX_sess_connect_renegotiate(ctx);
+	stats[X_SSL_CTX_STAT_ACCEPT] = SSL_CTX_sess_accept(ctx);
+	stats[X_SSL_CTX_STAT_ACCEPT_GOOD] = SSL_CTX_sess_accept_good(ctx);
+	stats[X_SSL_CTX_STAT_ACCEPT_RENEGOTIATE] =
+		SSL_CTX_sess_accept_renegotiate(ctx);
+	stats[X_SSL_CTX_STAT_HITS] = SSL_CTX_sess_hits(ctx);
+	stats[X_SSL_CTX_STAT_CB_HITS] = SSL_CTX_sess_cb_hits(ctx);
+	stats[X_SSL_CTX_STAT_MISSES] = SSL_CTX_sess_misses(ctx);
+	stats[X_SSL_CTX_STAT_TIMEOUTS] = SSL_CTX_sess_timeouts(ctx);
+	stats[X_SSL_CTX_STAT_CACHE_FULL] = SSL_CTX_sess_cache_full(ctx);
+}
+
 long X_SSL_CTX_set_timeout(SSL_CTX* ctx, long t) {
 	return SSL_CTX_set_timeout(ctx, t);
 }
@@ -495,6 +1200,14 @@ long X_SSL_CTX_set_tmp_ecdh(SSL_CTX* ctx, EC_KEY *key) {
 	return SSL_CTX_set_tmp_ecdh(ctx, key);
 }
 
+long X_SSL_CTX_set1_groups_list(SSL_CTX* ctx, const char *groups) {
+	return SSL_CTX_set1_groups_list(ctx, groups);
+}
+
+long X_SSL_CTX_set1_sigalgs_list(SSL_CTX* ctx, const char *sigalgs) {
+	return SSL_CTX_set1_sigalgs_list(ctx, sigalgs);
+}
+
 long X_SSL_CTX_set_tlsext_servername_callback(
 		SSL_CTX* ctx, int (*cb)(SSL *con, int *ad, void *args)) {
 	return SSL_CTX_set_tlsext_servername_callback(ctx, cb);
@@ -509,10 +1222,182 @@ int X_SSL_CTX_verify_cb(int ok, X509_STORE_CTX* store) {
 	return go_ssl_ctx_verify_cb_thunk(p, ok, store);
 }
 
+static int x_ssl_ctx_cert_verify_cb(X509_STORE_CTX *store, void *arg) {
+	SSL* ssl = (SSL *)X509_STORE_CTX_get_ex_data(store,
+			SSL_get_ex_data_X509_STORE_CTX_idx());
+	SSL_CTX* ssl_ctx = SSL_get_SSL_CTX(ssl);
+	void* p = SSL_CTX_get_ex_data(ssl_ctx, get_ssl_ctx_idx());
+	void* ssl_p = SSL_get_ex_data(ssl, get_ssl_idx());
+	return go_ssl_ctx_cert_verify_thunk(p, ssl_p, store);
+}
+
+void X_SSL_CTX_set_cert_verify_cb(SSL_CTX *ctx, int enable) {
+	SSL_CTX_set_cert_verify_callback(ctx,
+			enable ? x_ssl_ctx_cert_verify_cb : NULL, NULL);
+}
+
+STACK_OF(X509) *X_X509_STORE_CTX_get0_untrusted(X509_STORE_CTX *store) {
+#if OPENSSL_VERSION_NUMBER >= 0x1010000fL
+	return X509_STORE_CTX_get0_untrusted(store);
+#else
+	return store->untrusted;
+#endif
+}
+
+int X_SSL_CTX_cert_cb(SSL *ssl, void *arg) {
+	SSL_CTX* ssl_ctx = SSL_get_SSL_CTX(ssl);
+	void* p = SSL_CTX_get_ex_data(ssl_ctx, get_ssl_ctx_idx());
+	// get the pointer to the go Ctx object and pass it back into the thunk
+	return go_ssl_ctx_cert_cb_thunk(p, ssl);
+}
+
+unsigned int X_SSL_CTX_psk_client_cb(SSL *ssl, const char *hint,
+		char *identity, unsigned int max_identity_len, unsigned char *psk,
+		unsigned int max_psk_len) {
+	SSL_CTX* ssl_ctx = SSL_get_SSL_CTX(ssl);
+	void* p = SSL_CTX_get_ex_data(ssl_ctx, get_ssl_ctx_idx());
+	return go_ssl_ctx_psk_client_thunk(p, (char *)hint, identity,
+		max_identity_len, psk, max_psk_len);
+}
+
+unsigned int X_SSL_CTX_psk_server_cb(SSL *ssl, const char *identity,
+		unsigned char *psk, unsigned int max_psk_len) {
+	SSL_CTX* ssl_ctx = SSL_get_SSL_CTX(ssl);
+	void* p = SSL_CTX_get_ex_data(ssl_ctx, get_ssl_ctx_idx());
+	return go_ssl_ctx_psk_server_thunk(p, (char *)identity, psk,
+		max_psk_len);
+}
+
+static int x_ssl_new_session_cb(SSL *ssl, SSL_SESSION *session) {
+	void* p = SSL_get_ex_data(ssl, get_ssl_idx());
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+	if (!SSL_SESSION_is_resumable(session)) {
+		return 0;
+	}
+#endif
+#if OPENSSL_VERSION_NUMBER >= 0x1010000fL
+	int version = SSL_SESSION_get_protocol_version(session);
+#else
+	int version = session->ssl_version;
+#endif
+	if (p != NULL) {
+		go_ssl_new_session_thunk(p, session, version);
+	}
+	// the thunk keeps an encoded copy, not the session
+	return 0;
+}
+
+void X_SSL_CTX_set_new_session_cb(SSL_CTX *ctx, int enable) {
+	SSL_CTX_sess_set_new_cb(ctx, enable ? x_ssl_new_session_cb : NULL);
+}
+
+long X_SSL_CTX_set_min_proto_version(SSL_CTX *ctx, int version) {
+	return SSL_CTX_set_min_proto_version(ctx, version);
+}
+
+long X_SSL_CTX_set_max_proto_version(SSL_CTX *ctx, int version) {
+	return SSL_CTX_set_max_proto_version(ctx, version);
+}
+
+int X_SSL_CTX_set_ciphersuites(SSL_CTX *ctx, const char *suites) {
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+	return SSL_CTX_set_ciphersuites(ctx, suites);
+#else
+	// there is no TLS 1.3 to configure
+	return 1;
+#endif
+}
+
+int X_SSL_CTX_set_max_fragment_length(SSL_CTX *ctx, int len) {
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+	uint8_t mode;
+	switch (len) {
+	case 512:
+		mode = TLSEXT_max_fragment_length_512;
+		break;
+	case 1024:
+		mode = TLSEXT_max_fragment_length_1024;
+		break;
+	case 2048:
+		mode = TLSEXT_max_fragment_length_2048;
+		break;
+	case 4096:
+		mode = TLSEXT_max_fragment_length_4096;
+		break;
+	default:
+		return 0;
+	}
+	return SSL_CTX_set_tlsext_max_fragment_length(ctx, mode);
+#else
+	// the extension is missing, peers keep sending full size records
+	return 1;
+#endif
+}
+
+int X_SSL_CTX_set_max_early_data(SSL_CTX *ctx, uint32_t max) {
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+	return SSL_CTX_set_max_early_data(ctx, max) &&
+		SSL_CTX_set_recv_max_early_data(ctx, max);
+#else
+	return 0;
+#endif
+}
+
+uint32_t X_SSL_CTX_get_max_early_data(SSL_CTX *ctx) {
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+	return SSL_CTX_get_max_early_data(ctx);
+#else
+	return 0;
+#endif
+}
+
+int X_SSL_get_early_data_status(SSL *ssl) {
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+	return SSL_get_early_data_status(ssl);
+#else
+	return 0;
+#endif
+}
+
+uint32_t X_SSL_get_session_max_early_data(SSL *ssl) {
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+	SSL_SESSION *session = SSL_get_session(ssl);
+	if (session == NULL) {
+		return 0;
+	}
+	return SSL_SESSION_get_max_early_data(session);
+#else
+	return 0;
+#endif
+}
+
+int X_SSL_CTX_alpn_select_cb(SSL *ssl, const unsigned char **out,
+		unsigned char *outlen, const unsigned char *in, unsigned int inlen,
+		void *arg) {
+	SSL_CTX* ssl_ctx = SSL_get_SSL_CTX(ssl);
+	void* p = SSL_CTX_get_ex_data(ssl_ctx, get_ssl_ctx_idx());
+	// the thunk returns the offset of the selected length-prefixed protocol
+	int offset = go_ssl_ctx_alpn_select_thunk(p, (unsigned char *)in, inlen);
+	if (offset < 0) {
+		return SSL_TLSEXT_ERR_NOACK;
+	}
+	*outlen = in[offset];
+	*out = in + offset + 1;
+	return SSL_TLSEXT_ERR_OK;
+}
+
 long X_SSL_CTX_set_tmp_dh(SSL_CTX* ctx, DH *dh) {
     return SSL_CTX_set_tmp_dh(ctx, dh);
 }
 
+long X_SSL_CTX_set_dh_auto(SSL_CTX* ctx, int onoff) {
+#if OPENSSL_VERSION_NUMBER >= 0x10100000L
+    return SSL_CTX_set_dh_auto(ctx, onoff);
+#else
+    return 0;
+#endif
+}
+
 long X_PEM_read_DHparams(SSL_CTX* ctx, DH *dh) {
     return SSL_CTX_set_tmp_dh(ctx, dh);
 }
@@ -534,6 +1419,11 @@ int X_SSL_CTX_ticket_key_cb(SSL *s, unsigned char key_name[16],
 	return go_ticket_key_cb_thunk(p, s, key_name, iv, cctx, hctx, enc);
 }
 
+int X_pem_password_cb(char *buf, int size, int rwflag, void *u) {
+	// u is the saved pointer to the go callback
+	return go_pem_password_cb_thunk(u, buf, size, rwflag);
+}
+
 int X_BIO_get_flags(BIO *b) {
 	return BIO_get_flags(b);
 }
@@ -554,6 +1444,10 @@ int X_BIO_write(BIO *b, const void *buf, int len) {
 	return BIO_write(b, buf, len);
 }
 
+int X_BIO_flush(BIO *b) {
+	return BIO_flush(b);
+}
+
 BIO *X_BIO_new_write_bio() {
 	return BIO_new(BIO_s_writeBio());
 }
@@ -562,6 +1456,113 @@ BIO *X_BIO_new_read_bio() {
 	return BIO_new(BIO_s_readBio());
 }
 
+BIO *X_BIO_new_reader_bio() {
+	return BIO_new(BIO_s_readerBio());
+}
+
+/*
+ * The batch functions move as many records as possible per cgo call. Reads
+ * continue while libssl holds more data, which with read-ahead enabled
+ * avoids calling back into Go for every record, and both drain the
+ * coalescing BIO so the output is ready to be written by Go.
+ */
+int X_SSL_read_batch(SSL *ssl, void *buf, int len, int release) {
+	int total = 0;
+	int rv, err;
+
+	do {
+		rv = SSL_read(ssl, (char *)buf + total, len - total);
+		if (rv <= 0) {
+			break;
+		}
+		total += rv;
+#if OPENSSL_VERSION_NUMBER >= 0x10100000L
+	} while (total < len && SSL_has_pending(ssl));
+#else
+	} while (total < len && SSL_pending(ssl) > 0);
+#endif
+	if (total > 0 && rv <= 0) {
+		/* the data read is returned instead; the next read meets the
+		 * failure again, so its queued errors must not linger until a
+		 * later call on this thread reports them */
+		ERR_clear_error();
+	}
+	err = errno;
+	BIO_ctrl(SSL_get_wbio(ssl), X_BIO_CTRL_DRAIN, release, NULL);
+	errno = err;
+	return total > 0 ? total : rv;
+}
+
+int X_SSL_write_batch(SSL *ssl, const void *buf, int len, int release) {
+	int rv, err;
+
+	rv = SSL_write(ssl, buf, len);
+	err = errno;
+	BIO_ctrl(SSL_get_wbio(ssl), X_BIO_CTRL_DRAIN, release, NULL);
+	errno = err;
+	return rv;
+}
+
+/*
+ * Counts records and handshake messages and notes alerts from the message
+ * callback, so that connection statistics cost no callback into Go. It also
+ * writes the transcript of connections being traced.
+ */
+static void x_ssl_stats_cb(int write_p, int version, int content_type,
+		const void *buf, size_t len, SSL *ssl, void *arg) {
+	x_ssl_msg_state *state = arg;
+	unsigned long long *stats = state->stats;
+	const unsigned char *msg = buf;
+
+	if (state->trace) {
+#ifndef OPENSSL_NO_SSL_TRACE
+		SSL_trace(write_p, version, content_type, buf, len, ssl,
+			state->trace);
+#else
+		BIO_printf(state->trace, "%s message, content type %d, %lu bytes\n",
+			write_p ? "Sent" : "Received", content_type,
+			(unsigned long)len);
+#endif
+	}
+
+	switch (content_type) {
+#ifdef SSL3_RT_HEADER
+	case SSL3_RT_HEADER:
+		if (write_p) {
+			stats[X_SSL_STAT_RECORDS_OUT]++;
+		} else {
+			stats[X_SSL_STAT_RECORDS_IN]++;
+		}
+		break;
+#endif
+	case SSL3_RT_HANDSHAKE:
+		if (len == 0) {
+			break;
+		}
+		if (msg[0] == SSL3_MT_FINISHED && !write_p) {
+			stats[X_SSL_STAT_FINISHED_IN]++;
+		}
+#ifdef SSL3_MT_KEY_UPDATE
+		if (msg[0] == SSL3_MT_KEY_UPDATE) {
+			stats[X_SSL_STAT_KEY_UPDATES]++;
+		}
+#endif
+		break;
+	case SSL3_RT_ALERT:
+		if (len < 2) {
+			break;
+		}
+		stats[write_p ? X_SSL_STAT_ALERT_OUT : X_SSL_STAT_ALERT_IN] =
+			X_SSL_ALERT_SEEN | msg[0] << 8 | msg[1];
+		break;
+	}
+}
+
+void X_SSL_set_stats(SSL *ssl, x_ssl_msg_state *state) {
+	SSL_set_msg_callback(ssl, x_ssl_stats_cb);
+	SSL_set_msg_callback_arg(ssl, state);
+}
+
 const EVP_MD *X_EVP_get_digestbyname(const char *name) {
 	return EVP_get_digestbyname(name);
 }
@@ -709,6 +1710,22 @@ int X_EVP_PKEY_CTX_set_ec_paramgen_curve_nid(EVP_PKEY_CTX *ctx, int nid) {
 	return EVP_PKEY_CTX_set_ec_paramgen_curve_nid(ctx, nid);
 }
 
+int X_EVP_PKEY_CTX_set_rsa_padding(EVP_PKEY_CTX *ctx, int pad) {
+	return EVP_PKEY_CTX_set_rsa_padding(ctx, pad);
+}
+
+int X_EVP_PKEY_CTX_set_rsa_oaep_md(EVP_PKEY_CTX *ctx, const EVP_MD *md) {
+	return EVP_PKEY_CTX_set_rsa_oaep_md(ctx, md);
+}
+
+int X_EVP_PKEY_CTX_set_rsa_mgf1_md(EVP_PKEY_CTX *ctx, const EVP_MD *md) {
+	return EVP_PKEY_CTX_set_rsa_mgf1_md(ctx, md);
+}
+
+int X_EVP_PKEY_CTX_set0_rsa_oaep_label(EVP_PKEY_CTX *ctx, unsigned char *label, int len) {
+	return EVP_PKEY_CTX_set0_rsa_oaep_label(ctx, label, len);
+}
+
 size_t X_HMAC_size(const HMAC_CTX *e) {
 	return HMAC_size(e);
 }
@@ -733,6 +1750,42 @@ X509 *X_sk_X509_value(STACK_OF(X509)* sk, int i) {
    return sk_X509_value(sk, i);
 }
 
+STACK_OF(X509) *X_sk_X509_new_null() {
+	return sk_X509_new_null();
+}
+
+int X_sk_X509_push(STACK_OF(X509) *sk, X509 *x) {
+	return sk_X509_push(sk, x);
+}
+
+void X_sk_X509_free(STACK_OF(X509) *sk) {
+	sk_X509_free(sk);
+}
+
+STACK_OF(X509_CRL) *X_sk_X509_CRL_new_null() {
+	return sk_X509_CRL_new_null();
+}
+
+int X_sk_X509_CRL_push(STACK_OF(X509_CRL) *sk, X509_CRL *crl) {
+	return sk_X509_CRL_push(sk, crl);
+}
+
+void X_sk_X509_CRL_free(STACK_OF(X509_CRL) *sk) {
+	sk_X509_CRL_free(sk);
+}
+
+int X_sk_CMS_SignerInfo_num(STACK_OF(CMS_SignerInfo) *sk) {
+	return sk_CMS_SignerInfo_num(sk);
+}
+
+CMS_SignerInfo *X_sk_CMS_SignerInfo_value(STACK_OF(CMS_SignerInfo) *sk, int i) {
+	return sk_CMS_SignerInfo_value(sk, i);
+}
+
+int X_sk_CMS_RecipientInfo_num(STACK_OF(CMS_RecipientInfo) *sk) {
+	return sk_CMS_RecipientInfo_num(sk);
+}
+
 long X_X509_get_version(const X509 *x) {
 	return X509_get_version(x);
 }
@@ -741,6 +1794,334 @@ int X_X509_set_version(X509 *x, long version) {
 	return X509_set_version(x, version);
 }
 
+void X_sk_X509_EXTENSION_pop_free(STACK_OF(X509_EXTENSION) *exts) {
+	sk_X509_EXTENSION_pop_free(exts, X509_EXTENSION_free);
+}
+
+int X_sk_X509_REVOKED_num(STACK_OF(X509_REVOKED) *sk) {
+	return sk_X509_REVOKED_num(sk);
+}
+
+X509_REVOKED *X_sk_X509_REVOKED_value(STACK_OF(X509_REVOKED) *sk, int i) {
+	return sk_X509_REVOKED_value(sk, i);
+}
+
+int X_NAME_CONSTRAINTS_check_CN(X509 *x, NAME_CONSTRAINTS *nc) {
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+	return NAME_CONSTRAINTS_check_CN(x, nc);
+#else
+	// common names are not checked as host names
+	return X509_V_OK;
+#endif
+}
+
 int X_BN_set_word(BIGNUM *a, unsigned long w) {
 	return BN_set_word(a, w);
-}
\ No newline at end of file
+}
+
+X509_SIG *X_PKCS8_encrypt_pbes2(EVP_PKEY *pkey, const EVP_CIPHER *cipher,
+		const char *pass, int passlen, int iter, int prf_nid, int use_scrypt,
+		uint64_t scrypt_n, uint64_t scrypt_r, uint64_t scrypt_p) {
+	PKCS8_PRIV_KEY_INFO *p8inf = NULL;
+	X509_ALGOR *pbe = NULL;
+	X509_SIG *p8 = NULL;
+
+	p8inf = EVP_PKEY2PKCS8(pkey);
+	if (p8inf == NULL) {
+		return NULL;
+	}
+	if (use_scrypt) {
+#ifndef OPENSSL_NO_SCRYPT
+		pbe = PKCS5_pbe2_set_scrypt(cipher, NULL, 0, NULL,
+				scrypt_n, scrypt_r, scrypt_p);
+#endif
+	} else {
+		pbe = PKCS5_pbe2_set_iv(cipher, iter, NULL, 0, NULL, prf_nid);
+	}
+	if (pbe == NULL) {
+		PKCS8_PRIV_KEY_INFO_free(p8inf);
+		return NULL;
+	}
+	p8 = PKCS8_set0_pbe(pass, passlen, p8inf, pbe);
+	if (p8 == NULL) {
+		X509_ALGOR_free(pbe);
+	}
+	PKCS8_PRIV_KEY_INFO_free(p8inf);
+	return p8;
+}
+
+int X_i2d_OCSP_REQUEST_bio(BIO *bp, OCSP_REQUEST *req) {
+	return i2d_OCSP_REQUEST_bio(bp, req);
+}
+
+OCSP_REQUEST *X_d2i_OCSP_REQUEST_bio(BIO *bp) {
+	return d2i_OCSP_REQUEST_bio(bp, NULL);
+}
+
+int X_i2d_OCSP_RESPONSE_bio(BIO *bp, OCSP_RESPONSE *resp) {
+	return i2d_OCSP_RESPONSE_bio(bp, resp);
+}
+
+OCSP_RESPONSE *X_d2i_OCSP_RESPONSE_bio(BIO *bp) {
+	return d2i_OCSP_RESPONSE_bio(bp, NULL);
+}
+
+/* X_OCSP_id_issued_by reports whether id names a certificate issued by
+ * issuer, hashing the issuer with the digest the id was created with. */
+int X_OCSP_id_issued_by(OCSP_CERTID *id, X509 *issuer) {
+	ASN1_OBJECT *md_oid = NULL;
+	ASN1_INTEGER *serial = NULL;
+	const EVP_MD *md;
+	OCSP_CERTID *issuer_id;
+	int ret;
+
+	if (!OCSP_id_get0_info(NULL, &md_oid, NULL, &serial, id)) {
+		return 0;
+	}
+	md = EVP_get_digestbyobj(md_oid);
+	if (md == NULL) {
+		return 0;
+	}
+	issuer_id = OCSP_cert_id_new(md, X509_get_subject_name(issuer),
+			X509_get0_pubkey_bitstr(issuer), serial);
+	if (issuer_id == NULL) {
+		return 0;
+	}
+	ret = OCSP_id_issuer_cmp(id, issuer_id) == 0;
+	OCSP_CERTID_free(issuer_id);
+	return ret;
+}
+
+/* X_CMS_signed_add1_attr_der adds a signed attribute with a single value
+ * given as DER to si. */
+int X_CMS_signed_add1_attr_der(CMS_SignerInfo *si, const ASN1_OBJECT *obj,
+		const unsigned char *der, int len) {
+	ASN1_TYPE *value;
+	void *data;
+	int ret;
+
+	value = d2i_ASN1_TYPE(NULL, &der, len);
+	if (value == NULL) {
+		return 0;
+	}
+	if (value->type == V_ASN1_BOOLEAN) {
+		data = value->value.boolean ? (void *)value : NULL;
+	} else {
+		data = value->value.ptr;
+	}
+	ret = CMS_signed_add1_attr_by_OBJ(si, obj, value->type, data, -1);
+	ASN1_TYPE_free(value);
+	return ret;
+}
+
+/* X_CMS_signed_get_attr_der returns the DER encoding of the first value of
+ * the signed attribute obj, to be freed with OPENSSL_free, and its length in
+ * len. It returns NULL if si has no such attribute. */
+unsigned char *X_CMS_signed_get_attr_der(CMS_SignerInfo *si,
+		const ASN1_OBJECT *obj, int *len) {
+	X509_ATTRIBUTE *attr;
+	ASN1_TYPE *value;
+	unsigned char *der = NULL;
+	int loc;
+
+	*len = 0;
+	loc = CMS_signed_get_attr_by_OBJ(si, obj, -1);
+	if (loc < 0) {
+		return NULL;
+	}
+	attr = CMS_signed_get_attr(si, loc);
+	value = X509_ATTRIBUTE_get0_type(attr, 0);
+	if (value == NULL) {
+		return NULL;
+	}
+	*len = i2d_ASN1_TYPE(value, &der);
+	if (*len <= 0) {
+		*len = 0;
+		return NULL;
+	}
+	return der;
+}
+
+/*
+ * Private keys from OSSL_STORE URIs, e.g. "pkcs11:" URIs handled by a
+ * provider. The PIN is handed to the loader through a PEM password callback.
+ */
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+
+#include <openssl/store.h>
+#include <openssl/ui.h>
+
+static int X_store_pin_cb(char *buf, int size, int rwflag, void *u) {
+	const char *pin = u;
+	int len;
+
+	if (pin == NULL) {
+		return -1;
+	}
+	len = strlen(pin);
+	if (len > size) {
+		len = size;
+	}
+	memcpy(buf, pin, len);
+	return len;
+}
+
+EVP_PKEY *X_OSSL_STORE_load_private_key(const char *uri, const char *pin) {
+	UI_METHOD *ui;
+	OSSL_STORE_CTX *ctx;
+	OSSL_STORE_INFO *info;
+	EVP_PKEY *pkey = NULL;
+
+	ui = UI_UTIL_wrap_read_pem_callback(X_store_pin_cb, 0);
+	if (ui == NULL) {
+		return NULL;
+	}
+	ctx = OSSL_STORE_open(uri, ui, (void *)pin, NULL, NULL);
+	if (ctx != NULL) {
+		// not every loader can filter; the loop below skips other objects
+		OSSL_STORE_expect(ctx, OSSL_STORE_INFO_PKEY);
+		while (pkey == NULL && !OSSL_STORE_eof(ctx)) {
+			info = OSSL_STORE_load(ctx);
+			if (info == NULL) {
+				break;
+			}
+			if (OSSL_STORE_INFO_get_type(info) == OSSL_STORE_INFO_PKEY) {
+				pkey = OSSL_STORE_INFO_get1_PKEY(info);
+			}
+			OSSL_STORE_INFO_free(info);
+		}
+		OSSL_STORE_close(ctx);
+	}
+	UI_destroy_method(ui);
+	return pkey;
+}
+
+EVP_PKEY *X_ENGINE_load_private_key(ENGINE *e, const char *id,
+		const char *pin) {
+	UI_METHOD *ui;
+	EVP_PKEY *pkey;
+
+	ui = UI_UTIL_wrap_read_pem_callback(X_store_pin_cb, 0);
+	if (ui == NULL) {
+		return NULL;
+	}
+	pkey = ENGINE_load_private_key(e, id, ui, (void *)pin);
+	UI_destroy_method(ui);
+	return pkey;
+}
+
+#else
+
+EVP_PKEY *X_OSSL_STORE_load_private_key(const char *uri, const char *pin) {
+	return NULL;
+}
+
+EVP_PKEY *X_ENGINE_load_private_key(ENGINE *e, const char *id,
+		const char *pin) {
+	return ENGINE_load_private_key(e, id, NULL, (void *)pin);
+}
+
+#endif
+
+/*
+ * FIPS mode. OpenSSL 1.x toggles a validated module built into libcrypto,
+ * while 3.x loads a fips provider and restricts fetches to it through the
+ * default property query.
+ */
+
+int X_FIPS_mode_set(int onoff) {
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L
+	return EVP_default_properties_enable_fips(NULL, onoff);
+#else
+	return FIPS_mode_set(onoff);
+#endif
+}
+
+int X_FIPS_mode() {
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L
+	return EVP_default_properties_is_fips_enabled(NULL) &&
+		OSSL_PROVIDER_available(NULL, "fips");
+#else
+	return FIPS_mode();
+#endif
+}
+
+/*
+ * DRBG configuration. OpenSSL 3 exposes the DRBGs as EVP_RAND_CTX objects,
+ * 1.1.1 as RAND_DRBG objects and older versions not at all.
+ */
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L
+
+#include <openssl/core_names.h>
+
+int X_RAND_set_reseed_interval(unsigned int requests, int64_t seconds) {
+	/* the public and private DRBGs are per thread and reseed from the
+	 * primary whenever it reseeded */
+	EVP_RAND_CTX *primary = RAND_get0_primary(NULL);
+	OSSL_PARAM params[3];
+	time_t interval = (time_t)seconds;
+
+	if (primary == NULL) {
+		return 0;
+	}
+	params[0] = OSSL_PARAM_construct_uint(OSSL_DRBG_PARAM_RESEED_REQUESTS,
+			&requests);
+	params[1] = OSSL_PARAM_construct_time_t(
+			OSSL_DRBG_PARAM_RESEED_TIME_INTERVAL, &interval);
+	params[2] = OSSL_PARAM_construct_end();
+	return EVP_RAND_CTX_set_params(primary, params);
+}
+
+int X_RAND_set_DRBG_type(const char *drbg, const char *cipher,
+		const char *digest) {
+	return RAND_set_DRBG_type(NULL, drbg, NULL, cipher, digest);
+}
+
+int X_RAND_set_seed_source_type(const char *seed) {
+	return RAND_set_seed_source_type(NULL, seed, NULL);
+}
+
+#elif OPENSSL_VERSION_NUMBER >= 0x1010100fL
+
+#include <openssl/rand_drbg.h>
+
+int X_RAND_set_reseed_interval(unsigned int requests, int64_t seconds) {
+	RAND_DRBG *master;
+
+	/* the public and private DRBGs are per thread and take the defaults
+	 * when a thread first uses them */
+	if (!RAND_DRBG_set_reseed_defaults(requests, requests, (time_t)seconds,
+			(time_t)seconds)) {
+		return 0;
+	}
+	master = RAND_DRBG_get0_master();
+	return master != NULL &&
+		RAND_DRBG_set_reseed_interval(master, requests) &&
+		RAND_DRBG_set_reseed_time_interval(master, (time_t)seconds);
+}
+
+int X_RAND_set_DRBG_type(const char *drbg, const char *cipher,
+		const char *digest) {
+	return 0;
+}
+
+int X_RAND_set_seed_source_type(const char *seed) {
+	return 0;
+}
+
+#else
+
+int X_RAND_set_reseed_interval(unsigned int requests, int64_t seconds) {
+	return 0;
+}
+
+int X_RAND_set_DRBG_type(const char *drbg, const char *cipher,
+		const char *digest) {
+	return 0;
+}
+
+int X_RAND_set_seed_source_type(const char *seed) {
+	return 0;
+}
+
+#endif
@@ -24,8 +24,14 @@
 #include <openssl/err.h>
 #include <openssl/evp.h>
 #include <openssl/hmac.h>
+#include <openssl/ocsp.h>
 #include <openssl/pem.h>
+#include <openssl/cms.h>
+#include <openssl/pkcs12.h>
+#include <openssl/rand.h>
+#include <openssl/rsa.h>
 #include <openssl/ssl.h>
+#include <openssl/ts.h>
 #include <openssl/x509v3.h>
 #include <openssl/ec.h>
 
@@ -38,12 +44,48 @@
 #define SSL_OP_NO_COMPRESSION 0
 #endif
 
+#ifndef SSL_EARLY_DATA_NOT_SENT
+#define SSL_EARLY_DATA_NOT_SENT 0
+#define SSL_EARLY_DATA_REJECTED 1
+#define SSL_EARLY_DATA_ACCEPTED 2
+#endif
+
 /* shim  methods */
 extern int X_shim_init();
 
 /* Library methods */
 extern void X_OPENSSL_free(void *ref);
 extern void *X_OPENSSL_malloc(size_t size);
+extern int X_ERR_GET_LIB(unsigned long err);
+extern int X_ERR_GET_REASON(unsigned long err);
+
+/* Provider methods */
+extern void *X_OSSL_PROVIDER_load(void *libctx, const char *name);
+extern int X_OSSL_PROVIDER_unload(void *prov);
+extern const char *X_OSSL_PROVIDER_get0_name(void *prov);
+extern int X_OSSL_PROVIDER_available(void *libctx, const char *name);
+extern int X_OSSL_PROVIDER_set_default_search_path(void *libctx, const char *path);
+extern void *X_OSSL_LIB_CTX_new();
+extern void X_OSSL_LIB_CTX_free(void *libctx);
+extern int X_OSSL_LIB_CTX_load_config(void *libctx, const char *file);
+extern SSL_CTX *X_SSL_CTX_new_ex(void *libctx, const char *propq);
+extern const char *X_OPENSSL_cpu_settings();
+
+#define X_ALGORITHM_DIGEST 1
+#define X_ALGORITHM_CIPHER 2
+#define X_ALGORITHM_MAC 3
+#define X_ALGORITHM_KDF 4
+#define X_ALGORITHM_SIGNATURE 5
+#define X_ALGORITHM_KEYEXCH 6
+#define X_ALGORITHM_KEYMGMT 7
+extern char *X_OSSL_algorithm_names(void *libctx, int type);
+extern int X_OSSL_algorithm_available(void *libctx, int type, const char *name, const char *propq);
+
+/* FIPS methods */
+extern int X_FIPS_mode_set(int onoff);
+extern int X_FIPS_mode();
+extern EVP_PKEY *X_OSSL_STORE_load_private_key(const char *uri, const char *pin);
+extern EVP_PKEY *X_ENGINE_load_private_key(ENGINE *e, const char *id, const char *pin);
 
 /* SSL methods */
 extern long X_SSL_set_options(SSL* ssl, long options);
@@ -53,31 +95,118 @@ extern long X_SSL_set_tlsext_host_name(SSL *ssl, const char *name);
 extern const char * X_SSL_get_cipher_name(const SSL *ssl);
 extern int X_SSL_session_reused(SSL *ssl);
 extern int X_SSL_new_index();
+extern long X_SSL_set1_chain(SSL *ssl, STACK_OF(X509) *sk);
+extern long X_SSL_set1_groups_list(SSL *ssl, const char *groups);
+extern long X_SSL_set_max_send_fragment(SSL *ssl, long size);
+extern long X_SSL_set_mode(SSL *ssl, long modes);
+extern long X_SSL_clear_mode(SSL *ssl, long modes);
+extern long X_SSL_release_write_buffer(SSL *ssl);
+extern const char *X_SSL_get_negotiated_group_name(SSL *ssl);
+extern int X_SSL_read_batch(SSL *ssl, void *buf, int len, int release);
+extern int X_SSL_write_batch(SSL *ssl, const void *buf, int len, int release);
+
+/* indexes into the counters maintained by X_SSL_set_stats */
+#define X_SSL_STAT_RECORDS_IN 0
+#define X_SSL_STAT_RECORDS_OUT 1
+#define X_SSL_STAT_FINISHED_IN 2
+#define X_SSL_STAT_KEY_UPDATES 3
+/* the last alert received and sent, as X_SSL_ALERT_SEEN | level << 8 | description */
+#define X_SSL_STAT_ALERT_IN 4
+#define X_SSL_STAT_ALERT_OUT 5
+#define X_SSL_STAT_COUNT 6
+#define X_SSL_ALERT_SEEN 0x10000
+/* state of the message callback installed by X_SSL_set_stats */
+#ifndef X_SSL_MSG_STATE
+#define X_SSL_MSG_STATE
+typedef struct x_ssl_msg_state {
+	unsigned long long stats[X_SSL_STAT_COUNT];
+	/* if set, receives a decoded transcript of the messages */
+	BIO *trace;
+} x_ssl_msg_state;
+#endif
+extern void X_SSL_set_stats(SSL *ssl, x_ssl_msg_state *state);
 
 extern const SSL_METHOD *X_SSLv23_method();
 
+/* DTLS methods */
+extern const SSL_METHOD *X_DTLS_method();
+extern long X_SSL_set_mtu(SSL *ssl, long mtu);
+extern size_t X_DTLS_get_data_mtu(const SSL *ssl);
+extern int X_DTLSv1_get_timeout(SSL *ssl, long long *usec);
+extern int X_DTLSv1_handle_timeout(SSL *ssl);
+extern int X_DTLSv1_listen(SSL *ssl);
+extern int X_DTLS_cookie_generate_cb(SSL *ssl, unsigned char *cookie,
+		unsigned int *cookie_len);
+extern int X_DTLS_cookie_verify_cb(SSL *ssl, const unsigned char *cookie,
+		unsigned int cookie_len);
+
+/* QUIC methods */
+extern const SSL_METHOD *X_OSSL_QUIC_client_method();
+extern int X_SSL_set_quic_peer(SSL *ssl, const char *host, const char *port);
+extern SSL *X_SSL_new_stream(SSL *ssl, int uni);
+extern SSL *X_SSL_accept_stream(SSL *ssl);
+extern int X_SSL_stream_conclude(SSL *stream);
+extern unsigned long long X_SSL_get_stream_id(SSL *stream);
+
 #if defined SSL_CTRL_SET_TLSEXT_HOSTNAME
 extern int sni_cb(SSL *ssl_conn, int *ad, void *arg);
 #endif
 extern int X_SSL_verify_cb(int ok, X509_STORE_CTX* store);
+extern void *X_X509_STORE_CTX_get_ssl_data(X509_STORE_CTX* store);
 
 /* SSL_CTX methods */
 extern int X_SSL_CTX_new_index();
 extern long X_SSL_CTX_set_options(SSL_CTX* ctx, long options);
 extern long X_SSL_CTX_clear_options(SSL_CTX* ctx, long options);
+extern long X_SSL_CTX_set_max_send_fragment(SSL_CTX* ctx, long size);
 extern long X_SSL_CTX_get_options(SSL_CTX* ctx);
 extern long X_SSL_CTX_set_mode(SSL_CTX* ctx, long modes);
 extern long X_SSL_CTX_get_mode(SSL_CTX* ctx);
+extern long X_SSL_CTX_clear_mode(SSL_CTX* ctx, long modes);
 extern long X_SSL_CTX_set_session_cache_mode(SSL_CTX* ctx, long modes);
+extern long X_SSL_CTX_get_session_cache_mode(SSL_CTX* ctx);
+extern void X_SSL_CTX_set_new_session_cb(SSL_CTX *ctx, int enable);
 extern long X_SSL_CTX_sess_set_cache_size(SSL_CTX* ctx, long t);
 extern long X_SSL_CTX_sess_get_cache_size(SSL_CTX* ctx);
+/* indexes into the counters filled in by X_SSL_CTX_sess_stats */
+#define X_SSL_CTX_STAT_NUMBER 0
+#define X_SSL_CTX_STAT_CONNECT 1
+#define X_SSL_CTX_STAT_CONNECT_GOOD 2
+#define X_SSL_CTX_STAT_CONNECT_RENEGOTIATE 3
+#define X_SSL_CTX_STAT_ACCEPT 4
+#define X_SSL_CTX_STAT_ACCEPT_GOOD 5
+#define X_SSL_CTX_STAT_ACCEPT_RENEGOTIATE 6
+#define X_SSL_CTX_STAT_HITS 7
+#define X_SSL_CTX_STAT_CB_HITS 8
+#define X_SSL_CTX_STAT_MISSES 9
+#define X_SSL_CTX_STAT_TIMEOUTS 10
+#define X_SSL_CTX_STAT_CACHE_FULL 11
+#define X_SSL_CTX_STAT_COUNT 12
+extern void X_SSL_CTX_sess_stats(SSL_CTX* ctx, long *stats);
 extern long X_SSL_CTX_set_timeout(SSL_CTX* ctx, long t);
 extern long X_SSL_CTX_get_timeout(SSL_CTX* ctx);
 extern long X_SSL_CTX_add_extra_chain_cert(SSL_CTX* ctx, X509 *cert);
 extern long X_SSL_CTX_set_tmp_ecdh(SSL_CTX* ctx, EC_KEY *key);
+extern long X_SSL_CTX_set1_groups_list(SSL_CTX* ctx, const char *groups);
+extern long X_SSL_CTX_set1_sigalgs_list(SSL_CTX* ctx, const char *sigalgs);
 extern long X_SSL_CTX_set_tlsext_servername_callback(SSL_CTX* ctx, int (*cb)(SSL *con, int *ad, void *args));
 extern int X_SSL_CTX_verify_cb(int ok, X509_STORE_CTX* store);
+extern int X_SSL_CTX_cert_cb(SSL *ssl, void *arg);
+extern void X_SSL_CTX_set_cert_verify_cb(SSL_CTX *ctx, int enable);
+extern STACK_OF(X509) *X_X509_STORE_CTX_get0_untrusted(X509_STORE_CTX *store);
+extern unsigned int X_SSL_CTX_psk_client_cb(SSL *ssl, const char *hint, char *identity, unsigned int max_identity_len, unsigned char *psk, unsigned int max_psk_len);
+extern unsigned int X_SSL_CTX_psk_server_cb(SSL *ssl, const char *identity, unsigned char *psk, unsigned int max_psk_len);
+extern long X_SSL_CTX_set_min_proto_version(SSL_CTX *ctx, int version);
+extern long X_SSL_CTX_set_max_proto_version(SSL_CTX *ctx, int version);
+extern int X_SSL_CTX_set_ciphersuites(SSL_CTX *ctx, const char *suites);
+extern int X_SSL_CTX_set_max_fragment_length(SSL_CTX *ctx, int len);
+extern int X_SSL_CTX_set_max_early_data(SSL_CTX *ctx, uint32_t max);
+extern uint32_t X_SSL_CTX_get_max_early_data(SSL_CTX *ctx);
+extern int X_SSL_get_early_data_status(SSL *ssl);
+extern uint32_t X_SSL_get_session_max_early_data(SSL *ssl);
+extern int X_SSL_CTX_alpn_select_cb(SSL *ssl, const unsigned char **out, unsigned char *outlen, const unsigned char *in, unsigned int inlen, void *arg);
 extern long X_SSL_CTX_set_tmp_dh(SSL_CTX* ctx, DH *dh);
+extern long X_SSL_CTX_set_dh_auto(SSL_CTX* ctx, int onoff);
 extern long X_PEM_read_DHparams(SSL_CTX* ctx, DH *dh);
 extern int X_SSL_CTX_set_tlsext_ticket_key_cb(SSL_CTX *sslctx,
         int (*cb)(SSL *s, unsigned char key_name[16],
@@ -90,6 +219,8 @@ extern int SSL_CTX_set_alpn_protos(SSL_CTX *ctx, const unsigned char *protos,
                              unsigned int protos_len);
 
 /* BIO methods */
+/* drains a coalescing BIO into the next one; a nonzero arg frees its buffer */
+#define X_BIO_CTRL_DRAIN 0x7801
 extern int X_BIO_get_flags(BIO *b);
 extern void X_BIO_set_flags(BIO *bio, int flags);
 extern void X_BIO_clear_flags(BIO *bio, int flags);
@@ -97,8 +228,11 @@ extern void X_BIO_set_data(BIO *bio, void* data);
 extern void *X_BIO_get_data(BIO *bio);
 extern int X_BIO_read(BIO *b, void *buf, int len);
 extern int X_BIO_write(BIO *b, const void *buf, int len);
+extern int X_BIO_flush(BIO *b);
 extern BIO *X_BIO_new_write_bio();
+extern BIO *X_BIO_push_coalesce(BIO *next);
 extern BIO *X_BIO_new_read_bio();
+extern BIO *X_BIO_new_reader_bio();
 
 /* EVP methods */
 extern const int X_ED25519_SUPPORT;
@@ -129,6 +263,7 @@ extern int X_EVP_DigestSign(EVP_MD_CTX *ctx, unsigned char *sigret, size_t *sigl
 extern EVP_PKEY *X_EVP_PKEY_new(void);
 extern void X_EVP_PKEY_free(EVP_PKEY *pkey);
 extern int X_EVP_PKEY_size(EVP_PKEY *pkey);
+extern int X_EVP_PKEY_eq(const EVP_PKEY *a, const EVP_PKEY *b);
 extern struct rsa_st *X_EVP_PKEY_get1_RSA(EVP_PKEY *pkey);
 extern int X_EVP_PKEY_set1_RSA(EVP_PKEY *pkey, struct rsa_st *key);
 extern int X_EVP_PKEY_assign_charp(EVP_PKEY *pkey, int type, char *key);
@@ -149,11 +284,17 @@ extern void X_EVP_CIPHER_CTX_set_padding(EVP_CIPHER_CTX *ctx, int padding);
 extern const EVP_CIPHER *X_EVP_CIPHER_CTX_cipher(EVP_CIPHER_CTX *ctx);
 extern int X_EVP_CIPHER_CTX_encrypting(const EVP_CIPHER_CTX *ctx);
 extern int X_EVP_PKEY_CTX_set_ec_paramgen_curve_nid(EVP_PKEY_CTX *ctx, int nid);
+extern int X_EVP_PKEY_CTX_set_rsa_padding(EVP_PKEY_CTX *ctx, int pad);
+extern int X_EVP_PKEY_CTX_set_rsa_oaep_md(EVP_PKEY_CTX *ctx, const EVP_MD *md);
+extern int X_EVP_PKEY_CTX_set_rsa_mgf1_md(EVP_PKEY_CTX *ctx, const EVP_MD *md);
+extern int X_EVP_PKEY_CTX_set0_rsa_oaep_label(EVP_PKEY_CTX *ctx, unsigned char *label, int len);
 
 /* HMAC methods */
 extern size_t X_HMAC_size(const HMAC_CTX *e);
 extern HMAC_CTX *X_HMAC_CTX_new(void);
 extern void X_HMAC_CTX_free(HMAC_CTX *ctx);
+extern EVP_ENCODE_CTX *X_EVP_ENCODE_CTX_new(void);
+extern void X_EVP_ENCODE_CTX_free(EVP_ENCODE_CTX *ctx);
 extern int X_HMAC_Init_ex(HMAC_CTX *ctx, const void *key, int len, const EVP_MD *md, ENGINE *impl);
 extern int X_HMAC_Update(HMAC_CTX *ctx, const unsigned char *data, size_t len);
 extern int X_HMAC_Final(HMAC_CTX *ctx, unsigned char *md, unsigned int *len);
@@ -164,11 +305,49 @@ extern const ASN1_TIME *X_X509_get0_notBefore(const X509 *x);
 extern const ASN1_TIME *X_X509_get0_notAfter(const X509 *x);
 extern int X_sk_X509_num(STACK_OF(X509) *sk);
 extern X509 *X_sk_X509_value(STACK_OF(X509)* sk, int i);
+extern STACK_OF(X509) *X_sk_X509_new_null();
+extern int X_sk_X509_push(STACK_OF(X509) *sk, X509 *x);
+extern void X_sk_X509_free(STACK_OF(X509) *sk);
+extern STACK_OF(X509_CRL) *X_sk_X509_CRL_new_null();
+extern int X_sk_X509_CRL_push(STACK_OF(X509_CRL) *sk, X509_CRL *crl);
+extern void X_sk_X509_CRL_free(STACK_OF(X509_CRL) *sk);
+extern int X_sk_CMS_SignerInfo_num(STACK_OF(CMS_SignerInfo) *sk);
+extern CMS_SignerInfo *X_sk_CMS_SignerInfo_value(STACK_OF(CMS_SignerInfo) *sk, int i);
+extern int X_sk_CMS_RecipientInfo_num(STACK_OF(CMS_RecipientInfo) *sk);
 extern long X_X509_get_version(const X509 *x);
 extern int X_X509_set_version(X509 *x, long version);
+extern void X_sk_X509_EXTENSION_pop_free(STACK_OF(X509_EXTENSION) *exts);
+extern int X_sk_X509_REVOKED_num(STACK_OF(X509_REVOKED) *sk);
+extern X509_REVOKED *X_sk_X509_REVOKED_value(STACK_OF(X509_REVOKED) *sk, int i);
+extern int X_NAME_CONSTRAINTS_check_CN(X509 *x, NAME_CONSTRAINTS *nc);
+
+/* OCSP methods */
+extern int X_i2d_OCSP_REQUEST_bio(BIO *bp, OCSP_REQUEST *req);
+extern OCSP_REQUEST *X_d2i_OCSP_REQUEST_bio(BIO *bp);
+extern int X_i2d_OCSP_RESPONSE_bio(BIO *bp, OCSP_RESPONSE *resp);
+extern OCSP_RESPONSE *X_d2i_OCSP_RESPONSE_bio(BIO *bp);
+extern int X_OCSP_id_issued_by(OCSP_CERTID *id, X509 *issuer);
+
+/* CMS methods */
+extern int X_CMS_signed_add1_attr_der(CMS_SignerInfo *si, const ASN1_OBJECT *obj,
+		const unsigned char *der, int len);
+extern unsigned char *X_CMS_signed_get_attr_der(CMS_SignerInfo *si,
+		const ASN1_OBJECT *obj, int *len);
 
 /* PEM methods */
 extern int X_PEM_write_bio_PrivateKey_traditional(BIO *bio, EVP_PKEY *key, const EVP_CIPHER *enc, unsigned char *kstr, int klen, pem_password_cb *cb, void *u);
+extern int X_pem_password_cb(char *buf, int size, int rwflag, void *u);
+
+/* PKCS8 methods */
+extern X509_SIG *X_PKCS8_encrypt_pbes2(EVP_PKEY *pkey, const EVP_CIPHER *cipher,
+		const char *pass, int passlen, int iter, int prf_nid, int use_scrypt,
+		uint64_t scrypt_n, uint64_t scrypt_r, uint64_t scrypt_p);
+
+/* RAND methods */
+extern int X_RAND_priv_bytes(unsigned char *buf, int num);
+extern int X_RAND_set_reseed_interval(unsigned int requests, int64_t seconds);
+extern int X_RAND_set_DRBG_type(const char *drbg, const char *cipher, const char *digest);
+extern int X_RAND_set_seed_source_type(const char *seed);
 
 /* Object methods */
 extern int OBJ_create(const char *oid,const char *sn,const char *ln);
@@ -178,4 +357,4 @@ extern const unsigned char * get_extention(X509 *x, int NID, int *data_len);
 extern int add_custom_ext(X509 *cert, int nid, char *value, int len);
 
 /* BN methods */
-int X_BN_set_word(BIGNUM *a, unsigned long w);
\ No newline at end of file
+int X_BN_set_word(BIGNUM *a, unsigned long w);
@@ -0,0 +1,130 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+)
+
+// socketOptions are the options of sockets created by listeners and dialers.
+type socketOptions struct {
+	reuse_port bool
+	control    func(network, address string, c syscall.RawConn) error
+
+	// applied to connected TCP sockets
+	set_no_delay bool
+	no_delay     bool
+	keep_alive   time.Duration
+}
+
+// controlFunc returns the function setting the options of new sockets, or
+// nil if there are none.
+func (o *socketOptions) controlFunc() func(string, string,
+	syscall.RawConn) error {
+	if !o.reuse_port {
+		return o.control
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if err := setReusePort(c); err != nil {
+			return err
+		}
+		if o.control != nil {
+			return o.control(network, address, c)
+		}
+		return nil
+	}
+}
+
+// apply sets the options of the connected socket c, if it is a TCP socket.
+func (o *socketOptions) apply(c net.Conn) error {
+	tcp, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if o.set_no_delay {
+		if err := tcp.SetNoDelay(o.no_delay); err != nil {
+			return err
+		}
+	}
+	if o.keep_alive < 0 {
+		return tcp.SetKeepAlive(false)
+	}
+	if o.keep_alive > 0 {
+		if err := tcp.SetKeepAlive(true); err != nil {
+			return err
+		}
+		return tcp.SetKeepAlivePeriod(o.keep_alive)
+	}
+	return nil
+}
+
+// WithNoDelay sets TCP_NODELAY on accepted TCP connections. Go enables it by
+// default; passing false enables Nagle's algorithm, trading latency for fewer
+// packets.
+func WithNoDelay(no_delay bool) ListenerOption {
+	return func(l *listener) {
+		l.sockopts.set_no_delay = true
+		l.sockopts.no_delay = no_delay
+	}
+}
+
+// WithKeepAlive sets the TCP keep-alive period of accepted TCP connections.
+// A negative period disables keep-alives; zero keeps the default.
+func WithKeepAlive(period time.Duration) ListenerOption {
+	return func(l *listener) {
+		l.sockopts.keep_alive = period
+	}
+}
+
+// WithReusePort sets SO_REUSEPORT on the listening socket, so that several
+// processes can listen on the same address with the kernel balancing
+// connections among them. Only Listen and its variants create sockets;
+// NewListener ignores the option.
+func WithReusePort() ListenerOption {
+	return func(l *listener) {
+		l.sockopts.reuse_port = true
+	}
+}
+
+// WithControl calls control after creating the listening socket and before
+// binding it, to set arbitrary socket options, as net.ListenConfig does. Only
+// Listen and its variants create sockets; NewListener ignores the option.
+func WithControl(control func(network, address string,
+	c syscall.RawConn) error) ListenerOption {
+	return func(l *listener) {
+		l.sockopts.control = control
+	}
+}
+
+// listen creates the listening socket for Listen and its variants, with the
+// socket options among opts.
+func listen(network, laddr string, opts []ListenerOption) (net.Listener,
+	error) {
+	var settings listener
+	for _, opt := range opts {
+		opt(&settings)
+	}
+	if settings.err != nil {
+		return nil, settings.err
+	}
+	lc := net.ListenConfig{
+		Control:   settings.sockopts.controlFunc(),
+		KeepAlive: settings.sockopts.keep_alive,
+	}
+	return lc.Listen(context.Background(), network, laddr)
+}
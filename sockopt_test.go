@@ -0,0 +1,147 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux darwin freebsd openbsd
+
+package openssl
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func tcpNoDelay(t *testing.T, c net.Conn) bool {
+	raw, err := c.(syscall.Conn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value int
+	var opt_err error
+	err = raw.Control(func(fd uintptr) {
+		value, opt_err = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP,
+			unix.TCP_NODELAY)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt_err != nil {
+		t.Fatal(opt_err)
+	}
+	return value != 0
+}
+
+func TestListenSocketOptions(t *testing.T) {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var controlled int
+	control := func(network, address string, c syscall.RawConn) error {
+		controlled++
+		return nil
+	}
+	l, err := Listen("tcp", "localhost:0", ctx, WithReusePort(),
+		WithControl(control), WithNoDelay(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if controlled != 1 {
+		t.Fatalf("control called %d times", controlled)
+	}
+	addr := l.Addr().String()
+	if _, err := Listen("tcp", addr, ctx); err == nil {
+		t.Fatal("listened twice without SO_REUSEPORT")
+	}
+	l2, err := Listen("tcp", addr, ctx, WithReusePort())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l2.Close()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+	if tcpNoDelay(t, accepted) {
+		t.Fatal("TCP_NODELAY still set")
+	}
+}
+
+func TestDialerSocketReuseNoDelay(t *testing.T) {
+	l := newTestTLSServer(t, "tcp", "localhost:0")
+	defer l.Close()
+	d := &Dialer{
+		Flags:          InsecureSkipVerify,
+		ReusePort:      true,
+		DisableNoDelay: true,
+	}
+	conn, err := d.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if tcpNoDelay(t, conn) {
+		t.Fatal("TCP_NODELAY still set")
+	}
+}
+
+func TestConnSyscallConn(t *testing.T) {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen("tcp", "localhost:0", ctx, WithProxyProtocol(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 1000 443" +
+		"\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+	// Go enables TCP_NODELAY on accepted connections
+	if !tcpNoDelay(t, accepted) {
+		t.Fatal("TCP_NODELAY not set")
+	}
+
+	server_conn, _ := net.Pipe()
+	defer server_conn.Close()
+	conn, err := Server(server_conn, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.SyscallConn(); err == nil {
+		t.Fatal("expected an error for a pipe")
+	}
+}
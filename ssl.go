@@ -45,13 +45,25 @@ func get_ssl_idx() C.int {
 type SSL struct {
 	ssl       *C.SSL
 	verify_cb VerifyCallback
+
+	// the error a VerifyPeerCertificateCallback rejected the peer with
+	verify_peer_err error
+
+	// sessions received are stored in session_cache under session_key
+	session_cache *ClientSessionCache
+	session_key   string
+
+	// the cookie a listening DTLS server expects from the current peer
+	dtls_cookie []byte
+
+	user_data interface{}
 }
 
 //export go_ssl_verify_cb_thunk
 func go_ssl_verify_cb_thunk(p unsafe.Pointer, ok C.int, ctx *C.X509_STORE_CTX) C.int {
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Critf("openssl: verify callback panic'd: %v", err)
+			logCallbackPanic("verify callback", err)
 			os.Exit(1)
 		}
 	}()
@@ -141,6 +153,19 @@ func (s *SSL) GetVerifyDepth() int {
 	return int(C.SSL_get_verify_depth(s.ssl))
 }
 
+// SetUserData attaches application data to the connection, such as the
+// session object it belongs to, for callbacks to retrieve with UserData. The
+// SSL handed to SNI callbacks and returned by CertificateStoreCtx.SSL in
+// verify callbacks is that of the connection. Set it before the handshake.
+func (s *SSL) SetUserData(data interface{}) {
+	s.user_data = data
+}
+
+// UserData returns the data attached with SetUserData, or nil.
+func (s *SSL) UserData() interface{} {
+	return s.user_data
+}
+
 // SetSSLCtx changes context to new one. Useful for Server Name Indication (SNI)
 // rfc6066 http://tools.ietf.org/html/rfc6066. See
 // http://stackoverflow.com/questions/22373332/serving-multiple-domains-in-one-box-with-sni
@@ -156,16 +181,22 @@ func (s *SSL) SetSSLCtx(ctx *Ctx) {
 func sni_cb_thunk(p unsafe.Pointer, con *C.SSL, ad unsafe.Pointer, arg unsafe.Pointer) C.int {
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Critf("openssl: verify callback sni panic'd: %v", err)
+			logCallbackPanic("verify callback sni", err)
 			os.Exit(1)
 		}
 	}()
 
 	sni_cb := pointer.Restore(p).(*Ctx).sni_cb
 
-	s := &SSL{ssl: con}
-	// This attaches a pointer to our SSL struct into the SNI callback.
-	C.SSL_set_ex_data(s.ssl, get_ssl_idx(), pointer.Save(s))
+	// connections made by this package already carry their SSL struct
+	var s *SSL
+	if ssl_p := C.SSL_get_ex_data(con, get_ssl_idx()); ssl_p != nil {
+		s = pointer.Restore(ssl_p).(*SSL)
+	} else {
+		s = &SSL{ssl: con}
+		// This attaches a pointer to our SSL struct into the SNI callback.
+		C.SSL_set_ex_data(s.ssl, get_ssl_idx(), pointer.Save(s))
+	}
 
 	// Note: this is ctx.sni_cb, not C.sni_cb
 	return C.int(sni_cb(s))
@@ -22,7 +22,6 @@ import (
 	"testing"
 	"fmt"
 	"strings"
-	"errors"
 
 )
 
@@ -241,30 +240,10 @@ func TestOpenSSLClientServer(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		certs := SplitPEM(serverFullChainBytes)
-		if len(certs) == 0 {
-			t.Fatal(errors.New("No PEM certificate(s) found"))
-		}
-		fmt.Printf("    [Server] Server full chain length: %d\n", len(certs))
-		first, certs := certs[0], certs[1:]
-		cert, err := LoadCertificateFromPEM(first)
+		err = ctx.UseCertificateChainPEM(serverFullChainBytes)
 		if err != nil {
 			t.Fatal(err)
 		}
-		err = ctx.UseCertificate(cert)
-		if err != nil {
-			t.Fatal(err)
-		}
-		for _, pem := range certs {
-			cert, err := LoadCertificateFromPEM(pem)
-			if err != nil {
-				t.Fatal(err)
-			}
-			err = ctx.AddChainCertificate(cert)
-			if err != nil {
-				t.Fatal(err)
-			}
-		}
 	
 		fmt.Println("    [Server] Starting server")
 		l, err := Listen("tcp", "localhost:8080", ctx)
@@ -592,6 +592,221 @@ func TestStdlibOpenSSLFullDuplexRenegotiation(t *testing.T) {
 	FullDuplexRenegotiationTest(t, StdlibOpenSSLConstructor)
 }
 
+// handshakeBoth completes the handshake of both ends of a connection.
+func handshakeBoth(t testing.TB, server, client HandshakingConn) {
+	errs := make(chan error, 1)
+	go func() { errs <- server.Handshake() }()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenSSLFullDuplexClose(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+	server, client := OpenSSLConstructor(t, server_conn, client_conn)
+	defer server.Close()
+	handshakeBoth(t, server, client)
+
+	// the server echoes while the client writes and reads concurrently,
+	// until the client is closed from a third goroutine
+	go io.Copy(server, server)
+	data := make([]byte, SSLRecordSize)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			if _, err := client.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(ioutil.Discard, client)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	closed := make(chan error, 1)
+	go func() { closed <- client.Close() }()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not unblock Read and Write")
+	}
+	<-closed
+}
+
+func TestOpenSSLCloseUnblocksRead(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+	server, client := OpenSSLConstructor(t, server_conn, client_conn)
+	defer server.Close()
+	handshakeBoth(t, server, client)
+
+	errs := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		_, err := client.Read(b[:])
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	go client.Close()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected the pending Read to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not unblock Read")
+	}
+}
+
+func TestOpenSSLCloseUnblocksWrite(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+	server, client := OpenSSLConstructor(t, server_conn, client_conn)
+	defer server.Close()
+	handshakeBoth(t, server, client)
+
+	// the server never reads, so writes block once the socket buffers fill
+	errs := make(chan error, 1)
+	go func() {
+		data := make([]byte, 1<<20)
+		for {
+			if _, err := client.Write(data); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	closed := make(chan error, 1)
+	go func() { closed <- client.Close() }()
+	timeout := time.After(5 * time.Second)
+	select {
+	case <-errs:
+	case <-timeout:
+		t.Fatal("Close did not unblock Write")
+	}
+	select {
+	case <-closed:
+	case <-timeout:
+		t.Fatal("Close blocked on the peer")
+	}
+}
+
+func TestOpenSSLSmallReads(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+
+	data := make([]byte, 4*SSLRecordSize+17)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		t.Fatal(err)
+	}
+	server, client := OpenSSLConstructor(t, server_conn, client_conn)
+	defer close_both(server, client)
+
+	go client.Write(data)
+	// reads smaller than a record leave ciphertext and plaintext pending
+	// between calls
+	got := make([]byte, 0, len(data))
+	buf := make([]byte, 7)
+	for len(got) < len(data) {
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("mismatched data")
+	}
+}
+
+func TestOpenSSLLargeWrite(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+
+	// spans several output segments in a single flush
+	data := make([]byte, 10*writeBioSegmentSize+3)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		t.Fatal(err)
+	}
+	server, client := OpenSSLConstructor(t, server_conn, client_conn)
+	defer close_both(server, client)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.Write(data)
+		errs <- err
+	}()
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("mismatched data")
+	}
+}
+
+func TestOpenSSLReleaseBuffers(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+	server, client := OpenSSLConstructor(t, server_conn, client_conn)
+	defer close_both(server, client)
+
+	echo := func(data []byte) {
+		errs := make(chan error, 1)
+		go func() {
+			_, err := client.Write(data)
+			errs <- err
+		}()
+		got := make([]byte, len(data))
+		if _, err := io.ReadFull(server, got); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatal("mismatched data")
+		}
+	}
+	echo([]byte("before"))
+	conn := client.(*Conn)
+	released := func() bool {
+		conn.from_ssl.data_mtx.Lock()
+		defer conn.from_ssl.data_mtx.Unlock()
+		return conn.from_ssl.bufs == nil
+	}
+	conn.SetReleaseBuffers(true)
+	if !released() {
+		t.Fatal("idle write buffer was not released")
+	}
+	echo([]byte("after"))
+	if !released() {
+		t.Fatal("write buffer was not released after flushing")
+	}
+}
+
 func LotsOfConns(t *testing.T, payload_size int64, loops, clients int,
 	sleep time.Duration, newListener func(net.Listener) net.Listener,
 	newClient func(net.Conn) (net.Conn, error)) {
@@ -719,4 +934,109 @@ func TestOpenSSLLotsOfConns(t *testing.T) {
 			}, func(c net.Conn) (net.Conn, error) {
 				return Client(c, ctx)
 			})
-}
\ No newline at end of file
+}
+func TestConnUserData(t *testing.T) {
+	type session struct{ id int }
+	server_ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server_ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := server_ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	var sni_data interface{}
+	server_ctx.SetTLSExtServernameCallback(func(ssl *SSL) SSLTLSExtErr {
+		sni_data = ssl.UserData()
+		return SSLTLSExtErrOK
+	})
+	client_ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var verify_data interface{}
+	client_ctx.SetVerify(VerifyPeer, func(ok bool,
+		store *CertificateStoreCtx) bool {
+		if ssl := store.SSL(); ssl != nil {
+			verify_data = ssl.UserData()
+		}
+		return true
+	})
+
+	server_conn, client_conn := NetPipe(t)
+	server, err := Server(server_conn, server_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Client(client_conn, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close_both(server, client)
+	if err := client.SetTlsExtHostName("localhost"); err != nil {
+		t.Fatal(err)
+	}
+	server_session, client_session := &session{1}, &session{2}
+	server.SetUserData(server_session)
+	client.SetUserData(client_session)
+	handshakeBoth(t, server, client)
+
+	if sni_data != server_session {
+		t.Fatalf("unexpected user data in sni callback %v", sni_data)
+	}
+	if verify_data != client_session {
+		t.Fatalf("unexpected user data in verify callback %v", verify_data)
+	}
+	if client.UserData() != client_session {
+		t.Fatal("unexpected user data")
+	}
+}
+
+func TestConnReadFromWriteTo(t *testing.T) {
+	var _ io.ReaderFrom = (*Conn)(nil)
+	var _ io.WriterTo = (*Conn)(nil)
+
+	server_conn, client_conn := NetPipe(t)
+	server, client := OpenSSLConstructor(t, server_conn, client_conn)
+	defer close_both(server, client)
+	handshakeBoth(t, server, client)
+
+	data := make([]byte, 3*copyBufferSize+1234)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		// LimitReader hides the WriterTo of bytes.Reader from ReadFrom
+		n, err := server.(*Conn).ReadFrom(io.LimitReader(
+			bytes.NewReader(data), int64(len(data))))
+		if err == nil && n != int64(len(data)) {
+			err = io.ErrShortWrite
+		}
+		if err == nil {
+			err = server.Close()
+		}
+		errs <- err
+	}()
+	var received bytes.Buffer
+	n, err := client.(*Conn).WriteTo(&received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(received.Bytes(), data) {
+		t.Fatalf("received %d bytes of %d", n, len(data))
+	}
+}
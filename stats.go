@@ -0,0 +1,149 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"time"
+)
+
+// ConnStats are the traffic counters of a connection, as returned by
+// Conn.Stats.
+type ConnStats struct {
+	// BytesRead and BytesWritten count application data.
+	BytesRead    uint64
+	BytesWritten uint64
+	// TransportBytesRead and TransportBytesWritten count the bytes moved
+	// over the underlying connection, including record overhead and
+	// handshakes.
+	TransportBytesRead    uint64
+	TransportBytesWritten uint64
+	// RecordsRead and RecordsWritten count TLS records. They stay zero on
+	// OpenSSL versions before 1.0.2.
+	RecordsRead    uint64
+	RecordsWritten uint64
+	// HandshakeDuration is how long the initial handshake took, from the
+	// first I/O on the connection, or zero while it is incomplete.
+	HandshakeDuration time.Duration
+	// Renegotiations counts the handshakes completed after the initial
+	// one.
+	Renegotiations uint64
+	// KeyUpdates counts the TLS 1.3 key updates sent and received.
+	KeyUpdates uint64
+}
+
+// Stats returns the connection's traffic counters.
+func (c *Conn) Stats() ConnStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	counters := &c.msg_state.stats
+	rv := ConnStats{
+		BytesRead:             c.bytes_read,
+		BytesWritten:          c.bytes_written,
+		TransportBytesRead:    c.into_ssl.Read(),
+		TransportBytesWritten: c.from_ssl.Written(),
+		RecordsRead:           uint64(counters[C.X_SSL_STAT_RECORDS_IN]),
+		RecordsWritten:        uint64(counters[C.X_SSL_STAT_RECORDS_OUT]),
+		HandshakeDuration:     c.handshake_duration,
+		KeyUpdates:            uint64(counters[C.X_SSL_STAT_KEY_UPDATES]),
+	}
+	if finished := uint64(counters[C.X_SSL_STAT_FINISHED_IN]); finished > 1 {
+		rv.Renegotiations = finished - 1
+	}
+	return rv
+}
+
+// CtxStats are the aggregate counters of all connections made from a
+// context, as returned by Ctx.Stats.
+type CtxStats struct {
+	// Connects and Accepts count the handshakes started as client and
+	// server, including renegotiations, and ConnectsGood and AcceptsGood
+	// those that completed.
+	Connects     int64
+	ConnectsGood int64
+	Accepts      int64
+	AcceptsGood  int64
+	// ConnectFailures and AcceptFailures are the handshakes started but
+	// not completed, which includes those still in progress.
+	ConnectFailures int64
+	AcceptFailures  int64
+	// ConnectRenegotiations and AcceptRenegotiations count the
+	// renegotiations started.
+	ConnectRenegotiations int64
+	AcceptRenegotiations  int64
+
+	// Sessions is the number of sessions in the internal session cache.
+	Sessions int64
+	// SessionHits counts the sessions resumed, SessionCallbackHits those
+	// found through an external cache, and SessionMisses the sessions
+	// proposed by clients that were not found. On servers using session
+	// tickets, hits count ticket resumptions as well.
+	SessionHits         int64
+	SessionCallbackHits int64
+	SessionMisses       int64
+	// SessionTimeouts counts the sessions proposed by clients that were
+	// found but had expired, and SessionCacheFull the sessions dropped
+	// because the cache was full.
+	SessionTimeouts  int64
+	SessionCacheFull int64
+}
+
+// Stats returns the context's aggregate counters, e.g. to monitor the
+// listener using it.
+func (c *Ctx) Stats() CtxStats {
+	var counters [C.X_SSL_CTX_STAT_COUNT]C.long
+	C.X_SSL_CTX_sess_stats(c.ctx, &counters[0])
+	rv := CtxStats{
+		Connects:              int64(counters[C.X_SSL_CTX_STAT_CONNECT]),
+		ConnectsGood:          int64(counters[C.X_SSL_CTX_STAT_CONNECT_GOOD]),
+		Accepts:               int64(counters[C.X_SSL_CTX_STAT_ACCEPT]),
+		AcceptsGood:           int64(counters[C.X_SSL_CTX_STAT_ACCEPT_GOOD]),
+		ConnectRenegotiations: int64(counters[C.X_SSL_CTX_STAT_CONNECT_RENEGOTIATE]),
+		AcceptRenegotiations:  int64(counters[C.X_SSL_CTX_STAT_ACCEPT_RENEGOTIATE]),
+		Sessions:              int64(counters[C.X_SSL_CTX_STAT_NUMBER]),
+		SessionHits:           int64(counters[C.X_SSL_CTX_STAT_HITS]),
+		SessionCallbackHits:   int64(counters[C.X_SSL_CTX_STAT_CB_HITS]),
+		SessionMisses:         int64(counters[C.X_SSL_CTX_STAT_MISSES]),
+		SessionTimeouts:       int64(counters[C.X_SSL_CTX_STAT_TIMEOUTS]),
+		SessionCacheFull:      int64(counters[C.X_SSL_CTX_STAT_CACHE_FULL]),
+	}
+	rv.ConnectFailures = rv.Connects - rv.ConnectsGood
+	rv.AcceptFailures = rv.Accepts - rv.AcceptsGood
+	return rv
+}
+
+// startHandshakeTimer notes when the initial handshake began, starting its
+// span. c.mtx must be held.
+func (c *Conn) startHandshakeTimer() {
+	if c.handshake_start.IsZero() {
+		c.handshake_start = time.Now()
+		c.startHandshakeSpan()
+	}
+}
+
+// stopHandshakeTimer records the initial handshake's duration once it has
+// finished and reports it to the metrics and tracer. c.mtx must be held.
+func (c *Conn) stopHandshakeTimer() {
+	if c.handshake_duration == 0 && C.SSL_is_init_finished(c.ssl) == 1 {
+		c.handshake_duration = time.Since(c.handshake_start)
+		if c.metrics != nil {
+			c.metrics.HandshakeDone(c.handshake_duration,
+				C.X_SSL_session_reused(c.ssl) == 1)
+		}
+		c.endHandshakeSpan(nil)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"io"
+	"testing"
+)
+
+func TestConnStats(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+	server, client := OpenSSLConstructor(t, server_conn, client_conn)
+	defer close_both(server, client)
+
+	data := make([]byte, 3*SSLRecordSize)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.Write(data)
+		errs <- err
+	}()
+	if _, err := io.ReadFull(server, make([]byte, len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	cs := client.(*Conn).Stats()
+	ss := server.(*Conn).Stats()
+	if cs.BytesWritten != uint64(len(data)) ||
+		ss.BytesRead != uint64(len(data)) {
+		t.Fatalf("unexpected application bytes %d/%d", cs.BytesWritten,
+			ss.BytesRead)
+	}
+	if cs.TransportBytesWritten <= cs.BytesWritten {
+		t.Fatalf("transport bytes %d do not include record overhead",
+			cs.TransportBytesWritten)
+	}
+	if cs.TransportBytesRead == 0 || ss.TransportBytesWritten == 0 {
+		t.Fatal("handshake traffic not counted")
+	}
+	if cs.RecordsWritten < 3 || ss.RecordsRead < 3 {
+		t.Fatalf("unexpected record counts %d/%d", cs.RecordsWritten,
+			ss.RecordsRead)
+	}
+	if cs.HandshakeDuration <= 0 || ss.HandshakeDuration <= 0 {
+		t.Fatal("handshake duration not recorded")
+	}
+	if cs.Renegotiations != 0 || ss.Renegotiations != 0 {
+		t.Fatal("unexpected renegotiations")
+	}
+}
+
+func TestConnRecordSize(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+	server, client := OpenSSLConstructor(t, server_conn, client_conn)
+	defer close_both(server, client)
+
+	if err := client.(*Conn).SetRecordSize(1024); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.(*Conn).SetRecordSize(1024); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 8*1024)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.Write(data)
+		errs <- err
+	}()
+	if _, err := io.ReadFull(server, make([]byte, len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if n := server.(*Conn).Stats().RecordsRead; n < 8 {
+		t.Fatalf("expected at least 8 records, got %d", n)
+	}
+}
+
+func TestCtxStats(t *testing.T) {
+	server_ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server_ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := server_ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	client_ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handshake := func(verify VerifyOptions) error {
+		server_conn, client_conn := NetPipe(t)
+		server, err := Server(server_conn, server_ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := Client(client_conn, client_ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer close_both(server, client)
+		client.SetVerify(verify, nil)
+		errs := make(chan error, 1)
+		go func() { errs <- server.Handshake() }()
+		err = client.Handshake()
+		if err != nil {
+			client_conn.Close()
+		}
+		<-errs
+		return err
+	}
+	if err := handshake(VerifyNone); err != nil {
+		t.Fatal(err)
+	}
+	// the self-signed server certificate is not trusted
+	if err := handshake(VerifyPeer); err == nil {
+		t.Fatal("expected the handshake to fail")
+	}
+
+	ss := server_ctx.Stats()
+	if ss.Accepts != 2 || ss.AcceptsGood != 1 || ss.AcceptFailures != 1 ||
+		ss.Connects != 0 {
+		t.Fatalf("unexpected server stats %+v", ss)
+	}
+	cs := client_ctx.Stats()
+	if cs.Connects != 2 || cs.ConnectsGood != 1 || cs.ConnectFailures != 1 ||
+		cs.Accepts != 0 {
+		t.Fatalf("unexpected client stats %+v", cs)
+	}
+}
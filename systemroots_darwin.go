@@ -0,0 +1,81 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build darwin
+
+package openssl
+
+/*
+#cgo LDFLAGS: -framework CoreFoundation -framework Security
+
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+#include <openssl/err.h>
+#include <openssl/x509.h>
+
+// go_add_anchor_certs adds the system trust anchors to store and returns
+// the number added, or -1 if the keychain could not be read.
+int go_add_anchor_certs(X509_STORE *store) {
+	CFArrayRef anchors = NULL;
+	CFIndex i, n;
+	int added = 0;
+
+	if (SecTrustCopyAnchorCertificates(&anchors) != errSecSuccess) {
+		return -1;
+	}
+	n = CFArrayGetCount(anchors);
+	for (i = 0; i < n; i++) {
+		SecCertificateRef cert =
+			(SecCertificateRef)CFArrayGetValueAtIndex(anchors, i);
+		CFDataRef data = SecCertificateCopyData(cert);
+		const unsigned char *p;
+		X509 *x;
+
+		if (data == NULL) {
+			continue;
+		}
+		p = CFDataGetBytePtr(data);
+		x = d2i_X509(NULL, &p, CFDataGetLength(data));
+		CFRelease(data);
+		if (x == NULL) {
+			continue;
+		}
+		if (X509_STORE_add_cert(store, x) == 1) {
+			added++;
+		}
+		X509_free(x);
+	}
+	CFRelease(anchors);
+	// unparseable and duplicate certificates are skipped
+	ERR_clear_error();
+	return added;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"runtime"
+)
+
+// LoadSystemRoots trusts the system trust anchors from the macOS keychain.
+// Trust settings added by users or administrators are not consulted.
+func (s *CertificateStore) LoadSystemRoots() error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.go_add_anchor_certs(s.store) < 0 {
+		return errors.New("failed to read system trust anchors")
+	}
+	return nil
+}
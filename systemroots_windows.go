@@ -0,0 +1,70 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+package openssl
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// cryptENotFound is returned by CertEnumCertificatesInStore once all
+// certificates have been enumerated.
+const cryptENotFound = 0x80092004
+
+// LoadSystemRoots trusts the certificates in the Windows "ROOT" system
+// certificate store, as crypto/x509 does.
+func (s *CertificateStore) LoadSystemRoots() error {
+	root, err := syscall.UTF16PtrFromString("ROOT")
+	if err != nil {
+		return err
+	}
+	store, err := syscall.CertOpenSystemStore(0, root)
+	if err != nil {
+		return err
+	}
+	defer syscall.CertCloseStore(store, 0)
+
+	var ctx *syscall.CertContext
+	for {
+		ctx, err = syscall.CertEnumCertificatesInStore(store, ctx)
+		if err != nil {
+			if errno, ok := err.(syscall.Errno); ok &&
+				errno == cryptENotFound {
+				break
+			}
+			return err
+		}
+		if ctx == nil {
+			break
+		}
+		if ctx.Length == 0 {
+			continue
+		}
+		der := make([]byte, ctx.Length)
+		encoded := (*[1 << 20]byte)(unsafe.Pointer(ctx.EncodedCert))
+		copy(der, encoded[:ctx.Length:ctx.Length])
+		// skip certificates OpenSSL cannot parse rather than failing
+		cert, err := LoadCertificateFromDER(der)
+		if err != nil {
+			continue
+		}
+		if err := s.AddCertificate(cert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -124,7 +124,7 @@ func go_ticket_key_cb_thunk(p unsafe.Pointer, s *C.SSL, key_name *C.uchar,
 	// so just abort everything.
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Critf("openssl: ticket key callback panic'd: %v", err)
+			logCallbackPanic("ticket key callback", err)
 			os.Exit(1)
 		}
 	}()
@@ -0,0 +1,353 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"runtime"
+	"time"
+	"unsafe"
+)
+
+// TimestampStatus is the PKIStatus of an RFC 3161 time-stamp response.
+type TimestampStatus int
+
+const (
+	TimestampGranted                TimestampStatus = C.TS_STATUS_GRANTED
+	TimestampGrantedWithMods        TimestampStatus = C.TS_STATUS_GRANTED_WITH_MODS
+	TimestampRejection              TimestampStatus = C.TS_STATUS_REJECTION
+	TimestampWaiting                TimestampStatus = C.TS_STATUS_WAITING
+	TimestampRevocationWarning      TimestampStatus = C.TS_STATUS_REVOCATION_WARNING
+	TimestampRevocationNotification TimestampStatus = C.TS_STATUS_REVOCATION_NOTIFICATION
+)
+
+// TimestampRequest is an RFC 3161 time-stamp query (TSQ), to be sent to a
+// time-stamping authority (TSA).
+type TimestampRequest struct {
+	req *C.TS_REQ
+}
+
+func newTimestampRequest(req *C.TS_REQ) *TimestampRequest {
+	r := &TimestampRequest{req: req}
+	runtime.SetFinalizer(r, func(r *TimestampRequest) {
+		C.TS_REQ_free(r.req)
+	})
+	return r
+}
+
+// NewTimestampRequest creates a version 1 request for a time-stamp on data,
+// which is hashed with digest, one of EVP_SHA256, EVP_SHA384 or EVP_SHA512.
+// The TSA is asked to include its certificate in the response.
+func NewTimestampRequest(data []byte, digest EVP_MD) (*TimestampRequest,
+	error) {
+	switch digest {
+	case EVP_SHA256:
+	case EVP_SHA384:
+	case EVP_SHA512:
+	default:
+		return nil, errors.New("unsupported digest; " +
+			"you're probably looking for 'EVP_SHA256' or 'EVP_SHA512'")
+	}
+	md := getDigestFunction(digest)
+	var hash [C.EVP_MAX_MD_SIZE]C.uchar
+	var hashLen C.uint
+	var p unsafe.Pointer
+	if len(data) > 0 {
+		p = unsafe.Pointer(&data[0])
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.EVP_Digest(p, C.size_t(len(data)), &hash[0], &hashLen, md,
+		nil) != 1 {
+		return nil, errorFromErrorQueue()
+	}
+	req := C.TS_REQ_new()
+	if req == nil {
+		return nil, errors.New("failed to allocate timestamp request")
+	}
+	r := newTimestampRequest(req)
+	if C.TS_REQ_set_version(r.req, 1) != 1 ||
+		C.TS_REQ_set_cert_req(r.req, 1) != 1 {
+		return nil, errorFromErrorQueue()
+	}
+	algo := C.X509_ALGOR_new()
+	if algo == nil {
+		return nil, errors.New("failed to allocate algorithm identifier")
+	}
+	defer C.X509_ALGOR_free(algo)
+	C.X509_ALGOR_set_md(algo, md)
+	imprint := C.TS_MSG_IMPRINT_new()
+	if imprint == nil {
+		return nil, errors.New("failed to allocate message imprint")
+	}
+	defer C.TS_MSG_IMPRINT_free(imprint)
+	if C.TS_MSG_IMPRINT_set_algo(imprint, algo) != 1 ||
+		C.TS_MSG_IMPRINT_set_msg(imprint, &hash[0], C.int(hashLen)) != 1 ||
+		C.TS_REQ_set_msg_imprint(r.req, imprint) != 1 {
+		return nil, errorFromErrorQueue()
+	}
+	return r, nil
+}
+
+// AddNonce adds a random 64 bit nonce, which the TSA echoes to prevent
+// replay of old responses.
+func (r *TimestampRequest) AddNonce() error {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return err
+	}
+	ai, err := asn1Integer(nonce)
+	if err != nil {
+		return err
+	}
+	defer C.ASN1_INTEGER_free(ai)
+	if C.TS_REQ_set_nonce(r.req, ai) != 1 {
+		return errors.New("failed to set timestamp nonce")
+	}
+	return nil
+}
+
+// SetPolicy asks the TSA to issue the time-stamp under the policy
+// identified by the dotted object identifier oid.
+func (r *TimestampRequest) SetPolicy(oid string) error {
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	obj := C.OBJ_txt2obj(coid, 1)
+	if obj == nil {
+		return errors.New("invalid object identifier")
+	}
+	defer C.ASN1_OBJECT_free(obj)
+	if C.TS_REQ_set_policy_id(r.req, obj) != 1 {
+		return errors.New("failed to set timestamp policy")
+	}
+	return nil
+}
+
+// SetCertRequested sets whether the TSA should include its certificate in
+// the response. Without it, the TSA certificate has to be passed to Verify.
+func (r *TimestampRequest) SetCertRequested(requested bool) error {
+	var certReq C.int
+	if requested {
+		certReq = 1
+	}
+	if C.TS_REQ_set_cert_req(r.req, certReq) != 1 {
+		return errors.New("failed to set timestamp certificate request")
+	}
+	return nil
+}
+
+// MarshalDER converts the request to a DER-encoded block, as sent with the
+// application/timestamp-query content type.
+func (r *TimestampRequest) MarshalDER() (der_block []byte, err error) {
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+	if int(C.i2d_TS_REQ_bio(bio, r.req)) != 1 {
+		return nil, errors.New("failed dumping timestamp request der")
+	}
+	return ioutil.ReadAll(asAnyBio(bio))
+}
+
+// LoadTimestampRequestFromDER loads a time-stamp request from a DER-encoded
+// block.
+func LoadTimestampRequestFromDER(der_block []byte) (*TimestampRequest,
+	error) {
+	if len(der_block) == 0 {
+		return nil, errors.New("empty der block")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	bio := C.BIO_new_mem_buf(unsafe.Pointer(&der_block[0]),
+		C.int(len(der_block)))
+	req := C.d2i_TS_REQ_bio(bio, nil)
+	C.BIO_free(bio)
+	if req == nil {
+		return nil, errorFromErrorQueue()
+	}
+	return newTimestampRequest(req), nil
+}
+
+// TimestampResponse is an RFC 3161 time-stamp response (TSR).
+type TimestampResponse struct {
+	resp *C.TS_RESP
+}
+
+func newTimestampResponse(resp *C.TS_RESP) *TimestampResponse {
+	r := &TimestampResponse{resp: resp}
+	runtime.SetFinalizer(r, func(r *TimestampResponse) {
+		C.TS_RESP_free(r.resp)
+	})
+	return r
+}
+
+// LoadTimestampResponseFromDER loads a time-stamp response from a
+// DER-encoded block. The signature is not checked.
+func LoadTimestampResponseFromDER(der_block []byte) (*TimestampResponse,
+	error) {
+	if len(der_block) == 0 {
+		return nil, errors.New("empty der block")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	bio := C.BIO_new_mem_buf(unsafe.Pointer(&der_block[0]),
+		C.int(len(der_block)))
+	resp := C.d2i_TS_RESP_bio(bio, nil)
+	C.BIO_free(bio)
+	if resp == nil {
+		return nil, errorFromErrorQueue()
+	}
+	return newTimestampResponse(resp), nil
+}
+
+// MarshalDER converts the response to a DER-encoded block.
+func (r *TimestampResponse) MarshalDER() (der_block []byte, err error) {
+	bio := C.BIO_new(C.BIO_s_mem())
+	if bio == nil {
+		return nil, errors.New("failed to allocate memory BIO")
+	}
+	defer C.BIO_free(bio)
+	if int(C.i2d_TS_RESP_bio(bio, r.resp)) != 1 {
+		return nil, errors.New("failed dumping timestamp response der")
+	}
+	return ioutil.ReadAll(asAnyBio(bio))
+}
+
+// Status returns the status of the response. Only granted responses carry
+// a time-stamp.
+func (r *TimestampResponse) Status() TimestampStatus {
+	info := C.TS_RESP_get_status_info(r.resp)
+	return TimestampStatus(C.ASN1_INTEGER_get(
+		C.TS_STATUS_INFO_get0_status(info)))
+}
+
+func (r *TimestampResponse) tstInfo() (*C.TS_TST_INFO, error) {
+	info := C.TS_RESP_get_tst_info(r.resp)
+	if info == nil {
+		return nil, errors.New("no time-stamp in response")
+	}
+	return info, nil
+}
+
+// Time returns the time at which the TSA time-stamped the data, truncated
+// to seconds.
+func (r *TimestampResponse) Time() (time.Time, error) {
+	info, err := r.tstInfo()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return goTime(C.TS_TST_INFO_get_time(info))
+}
+
+// SerialNumber returns the serial number the TSA assigned to the
+// time-stamp.
+func (r *TimestampResponse) SerialNumber() (*big.Int, error) {
+	info, err := r.tstInfo()
+	if err != nil {
+		return nil, err
+	}
+	return bigInt(C.TS_TST_INFO_get_serial(info))
+}
+
+// Policy returns the dotted object identifier of the policy the time-stamp
+// was issued under.
+func (r *TimestampResponse) Policy() (string, error) {
+	info, err := r.tstInfo()
+	if err != nil {
+		return "", err
+	}
+	return objectOID(C.TS_TST_INFO_get_policy_id(info)), nil
+}
+
+// Verify checks that the response is a granted, correctly signed answer to
+// req: the message imprint, nonce and requested policy must match, and the
+// TSA certificate must chain to store and be authorized for time-stamping.
+// certs are extra untrusted certificates, e.g. the TSA certificate when it
+// was not requested in the response.
+func (r *TimestampResponse) Verify(req *TimestampRequest,
+	store *CertificateStore, certs []*Certificate) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	ctx := C.TS_REQ_to_TS_VERIFY_CTX(req.req, nil)
+	if ctx == nil {
+		return errorFromErrorQueue()
+	}
+	defer C.TS_VERIFY_CTX_free(ctx)
+	return r.verify(ctx, store, certs)
+}
+
+// VerifyData checks that the response is a granted, correctly signed
+// time-stamp on data, without the original request. The TSA certificate
+// must chain to store and be authorized for time-stamping; certs are extra
+// untrusted certificates.
+func (r *TimestampResponse) VerifyData(data []byte, store *CertificateStore,
+	certs []*Certificate) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	ctx := C.TS_VERIFY_CTX_new()
+	if ctx == nil {
+		return errors.New("failed to allocate timestamp verify context")
+	}
+	defer C.TS_VERIFY_CTX_free(ctx)
+	bio := cmsContentBIO(data)
+	if bio == nil {
+		return errors.New("failed creating bio")
+	}
+	// the context frees the bio
+	C.TS_VERIFY_CTX_set_data(ctx, bio)
+	C.TS_VERIFY_CTX_set_flags(ctx, C.TS_VFY_VERSION|C.TS_VFY_SIGNER|
+		C.TS_VFY_DATA)
+	err := r.verify(ctx, store, certs)
+	runtime.KeepAlive(data)
+	return err
+}
+
+// verify checks the response with ctx, which the caller has locked to the
+// thread. store and certs are handed to ctx, which frees them.
+func (r *TimestampResponse) verify(ctx *C.TS_VERIFY_CTX,
+	store *CertificateStore, certs []*Certificate) error {
+	if store == nil {
+		return errors.New("no certificate store")
+	}
+	if C.X509_STORE_up_ref(store.store) != 1 {
+		return errors.New("failed to reference certificate store")
+	}
+	C.TS_VERIFY_CTX_set_store(ctx, store.store)
+	if len(certs) > 0 {
+		sk, err := certificateStack(certs)
+		if err != nil {
+			return err
+		}
+		chain := C.X509_chain_up_ref(sk)
+		C.X_sk_X509_free(sk)
+		if chain == nil {
+			return errors.New("failed to reference certificates")
+		}
+		C.TS_VERIFY_CTX_set_certs(ctx, chain)
+	}
+	C.TS_VERIFY_CTX_add_flags(ctx, C.TS_VFY_SIGNATURE)
+	if C.TS_RESP_verify_response(ctx, r.resp) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
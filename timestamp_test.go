@@ -0,0 +1,212 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+// time-stamp issued by the openssl ts command for timestampData, under
+// policy 1.3.6.1.4.1.55555.3.1 by a TSA certified by timestampRoot
+var (
+	timestampData = []byte("release-1.2.3.tar.gz contents")
+	timestampRoot = []byte(`-----BEGIN CERTIFICATE-----
+MIIBdTCCARygAwIBAgIUBeEOpckmSMKMZh8f7ydglZTLvXwwCgYIKoZIzj0EAwIw
+GDEWMBQGA1UEAwwNVGVzdCBUU0EgUm9vdDAgFw0yNjEwMTYxNzM0MjBaGA8yMTI2
+MDkyMjE3MzQyMFowGDEWMBQGA1UEAwwNVGVzdCBUU0EgUm9vdDBZMBMGByqGSM49
+AgEGCCqGSM49AwEHA0IABDg4VM+/o0/COVgefuz+eDJ0/5tj37jrrZtvrfxNrb4Z
+zoCCfxln1dfleqJWxi291/hMFA8AkR3TqgcgYhfrBaSjQjBAMA8GA1UdEwEB/wQF
+MAMBAf8wDgYDVR0PAQH/BAQDAgEGMB0GA1UdDgQWBBS/bx4mC/mgQIvwydy31Ca9
+YdtK5TAKBggqhkjOPQQDAgNHADBEAiA+zBf0AXjdfMH1BFtvj3xtIwJuHmOdVnnf
+lCQOfSlv6QIgIFkcGjc7qWiTHSk1eMJwxTimSo1wB+UII6qrfQbiERE=
+-----END CERTIFICATE-----
+`)
+	timestampQuery = `
+MEQCAQEwMTANBglghkgBZQMEAgEFAAQgsjRnof0WsCujhnHfLBXh12VCxdbK1J1ItrSAfT9z9WgC
+CQCkCDCZFMRAeAEB/w==`
+	timestampReply = `
+MIIFFDADAgEAMIIFCwYJKoZIhvcNAQcCoIIE/DCCBPgCAQMxDzANBglghkgBZQMEAgEFADB8Bgsq
+hkiG9w0BCRABBKBtBGswaQIBAQYKKwYBBAGDsgMDATAxMA0GCWCGSAFlAwQCAQUABCCyNGeh/Raw
+K6OGcd8sFeHXZULF1srUnUi2tIB9P3P1aAIBAhgPMjAyNjEwMTYxNzM0MjBaMAMCAQEBAf8CCQCk
+CDCZFMRAeKCCAy4wggGTMIIBOqADAgECAgECMAoGCCqGSM49BAMCMBgxFjAUBgNVBAMMDVRlc3Qg
+VFNBIFJvb3QwIBcNMjYxMDE2MTczNDIwWhgPMjEyNjA5MjIxNzM0MjBaMBMxETAPBgNVBAMMCFRl
+c3QgVFNBMFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEybmaIR88YYUI0qkBAtkKiAAGoHOn4NTh
+zT90wTuVQ2t8wsGGzI08hx3gKl3JoXiOTjEg95NA8dR0MdwWguDBWqN4MHYwDAYDVR0TAQH/BAIw
+ADAOBgNVHQ8BAf8EBAMCB4AwFgYDVR0lAQH/BAwwCgYIKwYBBQUHAwgwHQYDVR0OBBYEFCnaMQ53
+jJLV2QecJp3+e2DqiRThMB8GA1UdIwQYMBaAFL9vHiYL+aBAi/DJ3LfUJr1h20rlMAoGCCqGSM49
+BAMCA0cAMEQCIF8s76ECZljHSLZxoBoL12Jhzir6FA0h68yDlXQfuH6dAiB22nVFR/IrYmKmasVT
+i86mY8q/JK4E89EdivAfiPPykTCCAZMwggE6oAMCAQICAQIwCgYIKoZIzj0EAwIwGDEWMBQGA1UE
+AwwNVGVzdCBUU0EgUm9vdDAgFw0yNjEwMTYxNzM0MjBaGA8yMTI2MDkyMjE3MzQyMFowEzERMA8G
+A1UEAwwIVGVzdCBUU0EwWTATBgcqhkjOPQIBBggqhkjOPQMBBwNCAATJuZohHzxhhQjSqQEC2QqI
+AAagc6fg1OHNP3TBO5VDa3zCwYbMjTyHHeAqXcmheI5OMSD3k0Dx1HQx3BaC4MFao3gwdjAMBgNV
+HRMBAf8EAjAAMA4GA1UdDwEB/wQEAwIHgDAWBgNVHSUBAf8EDDAKBggrBgEFBQcDCDAdBgNVHQ4E
+FgQUKdoxDneMktXZB5wmnf57YOqJFOEwHwYDVR0jBBgwFoAUv28eJgv5oECL8Mnct9QmvWHbSuUw
+CgYIKoZIzj0EAwIDRwAwRAIgXyzvoQJmWMdItnGgGgvXYmHOKvoUDSHrzIOVdB+4fp0CIHbadUVH
+8itiYqZqxVOLzqZjyr8krgTz0R2K8B+I8/KRMYIBMDCCASwCAQEwHTAYMRYwFAYDVQQDDA1UZXN0
+IFRTQSBSb290AgECMA0GCWCGSAFlAwQCAQUAoIGkMBoGCSqGSIb3DQEJAzENBgsqhkiG9w0BCRAB
+BDAcBgkqhkiG9w0BCQUxDxcNMjYxMDE2MTczNDIwWjAvBgkqhkiG9w0BCQQxIgQgIz3JHuFN0gtq
+jAZbOGRccqCuIYWyzcA3vuBhiF4i8SwwNwYLKoZIhvcNAQkQAi8xKDAmMCQwIgQgLC3eEQUwh/tg
+zgwPblrOyEyAWWYyU7fkryy1XMpI/U8wCgYIKoZIzj0EAwIERjBEAiB/z1honbKK0CEwZvaYFSry
+E0FtZcd+i8LACJg1pNcQrgIgJzAKCqfkGy/s8U5uFk2sUrxiuMAJcyDKBWwjtUA+bMU=`
+)
+
+func decodeTimestampFixture(t *testing.T, s string) []byte {
+	der, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestTimestampResponse(t *testing.T) {
+	root, err := LoadCertificateFromPEM(timestampRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddCertificate(root); err != nil {
+		t.Fatal(err)
+	}
+	req, err := LoadTimestampRequestFromDER(
+		decodeTimestampFixture(t, timestampQuery))
+	if err != nil {
+		t.Fatal(err)
+	}
+	der := decodeTimestampFixture(t, timestampReply)
+	resp, err := LoadTimestampResponseFromDER(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := resp.Status(); status != TimestampGranted {
+		t.Fatalf("expected granted status, got %d", status)
+	}
+	stamped, err := resp.Time()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2026, 10, 16, 17, 34, 20, 0, time.UTC)
+	if !stamped.Equal(want) {
+		t.Fatalf("expected time %v, got %v", want, stamped)
+	}
+	serial, err := resp.SerialNumber()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial.Int64() != 2 {
+		t.Fatalf("expected serial 2, got %v", serial)
+	}
+	policy, err := resp.Policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy != "1.3.6.1.4.1.55555.3.1" {
+		t.Fatalf("unexpected policy %s", policy)
+	}
+	marshaled, err := resp.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(marshaled) != string(der) {
+		t.Fatal("response changed by round trip")
+	}
+
+	if err := resp.Verify(req, store, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := resp.VerifyData(timestampData, store, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := resp.VerifyData([]byte("release-1.2.4.tar.gz contents"), store,
+		nil); err == nil {
+		t.Fatal("verified time-stamp for other data")
+	}
+	empty, err := NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resp.VerifyData(timestampData, empty, nil); err == nil {
+		t.Fatal("verified time-stamp of an untrusted TSA")
+	}
+
+	// a fresh request carries a different nonce
+	other, err := NewTimestampRequest(timestampData, EVP_SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.AddNonce(); err != nil {
+		t.Fatal(err)
+	}
+	if err := resp.Verify(other, store, nil); err == nil {
+		t.Fatal("verified response to another request")
+	}
+}
+
+func TestTimestampRequest(t *testing.T) {
+	req, err := NewTimestampRequest(timestampData, EVP_SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.SetPolicy("1.3.6.1.4.1.55555.3.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := req.SetPolicy("not an oid"); err == nil {
+		t.Fatal("set invalid policy")
+	}
+	der, err := req.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the fixture response matches the imprint and policy of a request
+	// without a nonce
+	root, err := LoadCertificateFromPEM(timestampRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddCertificate(root); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadTimestampRequestFromDER(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := LoadTimestampResponseFromDER(
+		decodeTimestampFixture(t, timestampReply))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resp.Verify(loaded, store, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.SetPolicy("1.3.6.1.4.1.55555.3.2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := resp.Verify(loaded, store, nil); err == nil {
+		t.Fatal("verified response under another policy")
+	}
+
+	if _, err := NewTimestampRequest(timestampData, EVP_MD5); err == nil {
+		t.Fatal("created request with weak digest")
+	}
+}
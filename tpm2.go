@@ -0,0 +1,87 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"errors"
+	"strings"
+)
+
+// TPM2Options select how the TPM is reached.
+type TPM2Options struct {
+	// EnginePath is the shared library of the tpm2-tss engine
+	// (libtpm2tss.so). If empty, the key is opened through the "tpm2"
+	// provider, which requires OpenSSL 3.0 or later.
+	EnginePath string
+	// Password is the authorization value of the key, if it has one.
+	Password string
+}
+
+// tpm2StoreURI returns the tpm2 provider URI of key, a persistent handle
+// such as "0x81000001" or the path of a "TSS2 PRIVATE KEY" PEM file.
+func tpm2StoreURI(key string) (string, error) {
+	if key == "" {
+		return "", errors.New("empty tpm2 key")
+	}
+	if strings.HasPrefix(key, "0x") || strings.HasPrefix(key, "0X") {
+		return "handle:" + key, nil
+	}
+	return "object:" + key, nil
+}
+
+// LoadTPM2PrivateKey loads a key held by a TPM 2.0, identified by its
+// persistent handle, e.g. "0x81000001", or by the path of a "TSS2 PRIVATE
+// KEY" PEM file holding the key wrapped by the TPM. The key never leaves the
+// TPM: signatures with the returned key, including those for TLS client
+// authentication, are computed by the TPM.
+func LoadTPM2PrivateKey(key string, opts *TPM2Options) (PrivateKey, error) {
+	if opts == nil {
+		opts = &TPM2Options{}
+	}
+	if opts.EnginePath == "" {
+		uri, err := tpm2StoreURI(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := loadProviderOnce("tpm2"); err != nil {
+			return nil, err
+		}
+		return loadStorePrivateKey(uri, opts.Password)
+	}
+
+	if key == "" {
+		return nil, errors.New("empty tpm2 key")
+	}
+	engine, err := EngineLoadByID("dynamic", []EngineCommand{
+		{Name: "SO_PATH", Value: opts.EnginePath},
+		{Name: "ID", Value: "tpm2tss"},
+		{Name: "LIST_ADD", Value: "1"},
+		{Name: "LOAD"},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return engine.loadPrivateKey(key, opts.Password)
+}
+
+// UseTPM2PrivateKey configures the context to use a key held by a TPM 2.0
+// for handshakes, see LoadTPM2PrivateKey.
+func (c *Ctx) UseTPM2PrivateKey(key string, opts *TPM2Options) error {
+	k, err := LoadTPM2PrivateKey(key, opts)
+	if err != nil {
+		return err
+	}
+	return c.UsePrivateKey(k)
+}
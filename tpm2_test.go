@@ -0,0 +1,47 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"testing"
+)
+
+func TestTPM2StoreURI(t *testing.T) {
+	for key, want := range map[string]string{
+		"0x81000001":              "handle:0x81000001",
+		"/var/lib/device/id.tss2": "object:/var/lib/device/id.tss2",
+	} {
+		uri, err := tpm2StoreURI(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uri != want {
+			t.Fatalf("expected %s, got %s", want, uri)
+		}
+	}
+	if _, err := tpm2StoreURI(""); err == nil {
+		t.Fatal("accepted empty key")
+	}
+}
+
+func TestLoadTPM2PrivateKey(t *testing.T) {
+	if _, err := LoadTPM2PrivateKey("", nil); err == nil {
+		t.Fatal("loaded empty key")
+	}
+	if _, err := LoadTPM2PrivateKey("0x81000001",
+		&TPM2Options{EnginePath: "/nonexistent/libtpm2tss.so"}); err == nil {
+		t.Fatal("loaded key through a missing engine")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"context"
+)
+
+// Names of the spans started by a Tracer.
+const (
+	SpanDial      = "openssl.dial"
+	SpanHandshake = "openssl.handshake"
+	SpanShutdown  = "openssl.shutdown"
+)
+
+// Keys of the attributes set on spans.
+const (
+	AttrNetwork  = "net.transport"
+	AttrPeerAddr = "net.peer.name"
+	AttrSNI      = "tls.server_name"
+	AttrALPN     = "tls.alpn"
+	AttrVersion  = "tls.version"
+	AttrCipher   = "tls.cipher"
+	AttrResumed  = "tls.resumed"
+)
+
+// TraceAttribute is a key/value pair set on a span.
+type TraceAttribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttributes(attrs ...TraceAttribute)
+	// End ends the span, recording err if it is not nil.
+	End(err error)
+}
+
+// Tracer starts the spans of dials, handshakes and shutdowns of the
+// connections of a Ctx, e.g. by adapting an OpenTelemetry tracer. The
+// handshake span is a child of the dial span, or of the context given to
+// HandshakeContext. Spans may be started and ended with the connection
+// locked, so they must not call back into the connection.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// SetTracer makes connections created from the context afterwards report
+// spans to t. A nil t disables tracing.
+func (c *Ctx) SetTracer(t Tracer) {
+	c.tracer = t
+}
+
+// startHandshakeSpan starts the handshake span when the handshake begins.
+// c.mtx must be held.
+func (c *Conn) startHandshakeSpan() {
+	if c.tracer == nil || c.handshake_span != nil {
+		return
+	}
+	trace_ctx := c.trace_ctx
+	if trace_ctx == nil {
+		trace_ctx = context.Background()
+	}
+	_, c.handshake_span = c.tracer.StartSpan(trace_ctx, SpanHandshake)
+}
+
+// endHandshakeSpan ends the handshake span, if it is still open, setting the
+// negotiated parameters on success. c.mtx must be held.
+func (c *Conn) endHandshakeSpan(err error) {
+	span := c.handshake_span
+	if span == nil || c.handshake_span_done {
+		return
+	}
+	c.handshake_span_done = true
+	if err == nil {
+		attrs := []TraceAttribute{
+			{AttrVersion, C.GoString(C.SSL_get_version(c.ssl))},
+			{AttrResumed, C.X_SSL_session_reused(c.ssl) == 1},
+		}
+		if cipher := C.X_SSL_get_cipher_name(c.ssl); cipher != nil {
+			attrs = append(attrs, TraceAttribute{AttrCipher,
+				C.GoString(cipher)})
+		}
+		if sni := c.GetServername(); sni != "" {
+			attrs = append(attrs, TraceAttribute{AttrSNI, sni})
+		}
+		if proto := c.negotiatedProtocol(); proto != "" {
+			attrs = append(attrs, TraceAttribute{AttrALPN, proto})
+		}
+		span.SetAttributes(attrs...)
+	}
+	span.End(err)
+}
+
+// setTraceContext makes ctx the parent of the handshake and shutdown spans,
+// unless the handshake has already begun.
+func (c *Conn) setTraceContext(ctx context.Context) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.tracer != nil && c.handshake_span == nil {
+		c.trace_ctx = ctx
+	}
+}
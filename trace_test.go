@@ -0,0 +1,123 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+)
+
+type spanKey struct{}
+
+type recordedSpan struct {
+	name   string
+	parent string
+	attrs  map[string]interface{}
+	ended  bool
+	err    error
+}
+
+type recordingTracer struct {
+	mtx   sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) StartSpan(ctx context.Context, name string) (
+	context.Context, Span) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	span := &recordedSpan{name: name, attrs: map[string]interface{}{}}
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), &recordingSpan{t, span}
+}
+
+func (t *recordingTracer) span(name string) *recordedSpan {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for _, span := range t.spans {
+		if span.name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+type recordingSpan struct {
+	tracer *recordingTracer
+	span   *recordedSpan
+}
+
+func (s *recordingSpan) SetAttributes(attrs ...TraceAttribute) {
+	s.tracer.mtx.Lock()
+	defer s.tracer.mtx.Unlock()
+	for _, attr := range attrs {
+		s.span.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordingSpan) End(err error) {
+	s.tracer.mtx.Lock()
+	defer s.tracer.mtx.Unlock()
+	s.span.ended = true
+	s.span.err = err
+}
+
+func TestTracer(t *testing.T) {
+	server := newTestTLSServer(t, "tcp", "localhost:0")
+	defer server.Close()
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := &recordingTracer{}
+	ctx.SetTracer(tracer)
+	dialer := &Dialer{Ctx: ctx, Flags: InsecureSkipHostVerification}
+	conn, err := dialer.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	dial := tracer.span(SpanDial)
+	if dial == nil || !dial.ended || dial.err != nil {
+		t.Fatalf("expected a successful dial span, got %+v", dial)
+	}
+	if dial.attrs[AttrPeerAddr] != server.Addr().String() {
+		t.Fatalf("unexpected dial attributes %v", dial.attrs)
+	}
+	handshake := tracer.span(SpanHandshake)
+	if handshake == nil || !handshake.ended || handshake.err != nil {
+		t.Fatalf("expected a successful handshake span, got %+v", handshake)
+	}
+	if handshake.parent != SpanDial {
+		t.Fatalf("expected the handshake span to be a child of the dial "+
+			"span, got %q", handshake.parent)
+	}
+	host, _, _ := net.SplitHostPort(server.Addr().String())
+	if handshake.attrs[AttrSNI] != host ||
+		handshake.attrs[AttrVersion] == "" ||
+		handshake.attrs[AttrResumed] != false {
+		t.Fatalf("unexpected handshake attributes %v", handshake.attrs)
+	}
+	if shutdown := tracer.span(SpanShutdown); shutdown == nil ||
+		!shutdown.ended {
+		t.Fatalf("expected a shutdown span, got %+v", shutdown)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// VerifyFlags are X509_V_FLAG_* options controlling chain verification.
+type VerifyFlags int
+
+const (
+	// VerifyCRLCheck checks the leaf certificate against the CRLs.
+	VerifyCRLCheck VerifyFlags = C.X509_V_FLAG_CRL_CHECK
+	// VerifyCRLCheckAll checks every certificate in the chain against the
+	// CRLs. It must be combined with VerifyCRLCheck.
+	VerifyCRLCheckAll VerifyFlags = C.X509_V_FLAG_CRL_CHECK_ALL
+	// VerifyPartialChain accepts a chain ending in any trusted certificate,
+	// not only a self-signed root.
+	VerifyPartialChain VerifyFlags = C.X509_V_FLAG_PARTIAL_CHAIN
+	// VerifyX509Strict disables workarounds for broken certificates.
+	VerifyX509Strict VerifyFlags = C.X509_V_FLAG_X509_STRICT
+	// VerifyNoCheckTime skips the validity period checks.
+	VerifyNoCheckTime VerifyFlags = C.X509_V_FLAG_NO_CHECK_TIME
+)
+
+// VerifyPurpose is the X509_PURPOSE_* the leaf certificate must be valid
+// for, checked against its key usage and extended key usage extensions.
+type VerifyPurpose int
+
+const (
+	PurposeSSLClient     VerifyPurpose = C.X509_PURPOSE_SSL_CLIENT
+	PurposeSSLServer     VerifyPurpose = C.X509_PURPOSE_SSL_SERVER
+	PurposeSMIMESign     VerifyPurpose = C.X509_PURPOSE_SMIME_SIGN
+	PurposeSMIMEEncrypt  VerifyPurpose = C.X509_PURPOSE_SMIME_ENCRYPT
+	PurposeCRLSign       VerifyPurpose = C.X509_PURPOSE_CRL_SIGN
+	PurposeAny           VerifyPurpose = C.X509_PURPOSE_ANY
+	PurposeOCSPHelper    VerifyPurpose = C.X509_PURPOSE_OCSP_HELPER
+	PurposeTimestampSign VerifyPurpose = C.X509_PURPOSE_TIMESTAMP_SIGN
+)
+
+// VerifyTrust is the X509_TRUST_* setting used to decide whether a trusted
+// certificate is acceptable as a trust anchor.
+type VerifyTrust int
+
+const (
+	TrustCompat      VerifyTrust = C.X509_TRUST_COMPAT
+	TrustSSLClient   VerifyTrust = C.X509_TRUST_SSL_CLIENT
+	TrustSSLServer   VerifyTrust = C.X509_TRUST_SSL_SERVER
+	TrustEmail       VerifyTrust = C.X509_TRUST_EMAIL
+	TrustObjectSign  VerifyTrust = C.X509_TRUST_OBJECT_SIGN
+	TrustOCSPSign    VerifyTrust = C.X509_TRUST_OCSP_SIGN
+	TrustOCSPRequest VerifyTrust = C.X509_TRUST_OCSP_REQUEST
+	TrustTSA         VerifyTrust = C.X509_TRUST_TSA
+)
+
+// SetFlags adds flags to every verification done with the store, including
+// TLS peer verification when the store belongs to a Ctx.
+func (s *CertificateStore) SetFlags(flags VerifyFlags) error {
+	if C.X509_STORE_set_flags(s.store, C.ulong(flags)) != 1 {
+		return errors.New("failed to set verify flags")
+	}
+	return nil
+}
+
+// SetPurpose sets the purpose every verification done with the store checks
+// the chain for.
+func (s *CertificateStore) SetPurpose(purpose VerifyPurpose) error {
+	if C.X509_STORE_set_purpose(s.store, C.int(purpose)) != 1 {
+		return errors.New("invalid verify purpose")
+	}
+	return nil
+}
+
+// SetTrust sets the trust setting every verification done with the store
+// checks the trust anchor for.
+func (s *CertificateStore) SetTrust(trust VerifyTrust) error {
+	if C.X509_STORE_set_trust(s.store, C.int(trust)) != 1 {
+		return errors.New("invalid verify trust")
+	}
+	return nil
+}
+
+// AddCRL adds a revocation list to the store. It is only consulted when
+// VerifyCRLCheck is set, either with SetFlags or per verification.
+func (s *CertificateStore) AddCRL(crl *CRL) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.X509_STORE_add_crl(s.store, crl.crl) != 1 {
+		return errorFromErrorQueue()
+	}
+	return nil
+}
+
+// ChainVerifyOptions configures CertificateStore.Verify. The zero value
+// verifies with OpenSSL's defaults and no purpose check.
+type ChainVerifyOptions struct {
+	Flags VerifyFlags
+	// Purpose, if set, is checked for the leaf and the CAs above it.
+	Purpose VerifyPurpose
+	// Depth limits the number of intermediate CAs. Zero keeps the default.
+	Depth int
+	// CRLs are consulted when Flags contains VerifyCRLCheck.
+	CRLs []*CRL
+	// Time, if set, is the time at which validity periods are checked
+	// instead of now, e.g. the timestamp of a signature being verified.
+	Time time.Time
+}
+
+// VerifyError describes why a certificate chain failed verification.
+type VerifyError struct {
+	Result VerifyResult
+	// Depth is the position in the chain of the certificate that caused the
+	// error, the leaf being at depth 0.
+	Depth int
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("openssl: certificate verification failed at depth "+
+		"%d: %s", e.Depth, C.GoString(C.X509_verify_cert_error_string(
+		C.long(e.Result))))
+}
+
+// Is matches ErrCertVerification.
+func (e *VerifyError) Is(target error) bool {
+	return target == ErrCertVerification
+}
+
+// Verify builds and verifies a chain from leaf to a certificate trusted by
+// the store, using intermediates as untrusted candidates for the chain. On
+// success the chain is returned leaf first. A verification failure is
+// returned as a *VerifyError. opts may be nil.
+func (s *CertificateStore) Verify(leaf *Certificate,
+	intermediates []*Certificate, opts *ChainVerifyOptions) (
+	[]*Certificate, error) {
+	if opts == nil {
+		opts = &ChainVerifyOptions{}
+	}
+	untrusted := C.X_sk_X509_new_null()
+	if untrusted == nil {
+		return nil, errors.New("failed to allocate certificate stack")
+	}
+	defer C.X_sk_X509_free(untrusted)
+	for _, cert := range intermediates {
+		if C.X_sk_X509_push(untrusted, cert.x) <= 0 {
+			return nil, errors.New("failed to add intermediate certificate")
+		}
+	}
+	crls := C.X_sk_X509_CRL_new_null()
+	if crls == nil {
+		return nil, errors.New("failed to allocate crl stack")
+	}
+	defer C.X_sk_X509_CRL_free(crls)
+	for _, crl := range opts.CRLs {
+		if C.X_sk_X509_CRL_push(crls, crl.crl) <= 0 {
+			return nil, errors.New("failed to add crl")
+		}
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	ctx := C.X509_STORE_CTX_new()
+	if ctx == nil {
+		return nil, errors.New("failed to allocate X509_STORE_CTX")
+	}
+	defer C.X509_STORE_CTX_free(ctx)
+	if C.X509_STORE_CTX_init(ctx, s.store, leaf.x, untrusted) != 1 {
+		return nil, errorFromErrorQueue()
+	}
+	if opts.Flags != 0 {
+		C.X509_STORE_CTX_set_flags(ctx, C.ulong(opts.Flags))
+	}
+	if opts.Purpose != 0 {
+		if C.X509_STORE_CTX_set_purpose(ctx, C.int(opts.Purpose)) != 1 {
+			return nil, errors.New("invalid verify purpose")
+		}
+	}
+	if opts.Depth > 0 {
+		C.X509_STORE_CTX_set_depth(ctx, C.int(opts.Depth))
+	}
+	if len(opts.CRLs) > 0 {
+		C.X509_STORE_CTX_set0_crls(ctx, crls)
+	}
+	if !opts.Time.IsZero() {
+		C.X509_STORE_CTX_set_time(ctx, 0, C.time_t(opts.Time.Unix()))
+	}
+
+	if C.X509_verify_cert(ctx) != 1 {
+		result := VerifyResult(C.X509_STORE_CTX_get_error(ctx))
+		if result == Ok {
+			return nil, errorFromErrorQueue()
+		}
+		C.ERR_clear_error()
+		return nil, &VerifyError{
+			Result: result,
+			Depth:  int(C.X509_STORE_CTX_get_error_depth(ctx)),
+		}
+	}
+	runtime.KeepAlive(leaf)
+	runtime.KeepAlive(intermediates)
+	runtime.KeepAlive(opts)
+
+	sk := C.X509_STORE_CTX_get1_chain(ctx)
+	if sk == nil {
+		return nil, errors.New("failed to get verified chain")
+	}
+	// the certificate references taken by get1 are handed to the returned
+	// certificates, so only the stack itself is freed
+	defer C.X_sk_X509_free(sk)
+	n := int(C.X_sk_X509_num(sk))
+	chain := make([]*Certificate, 0, n)
+	for i := 0; i < n; i++ {
+		cert := &Certificate{x: C.X_sk_X509_value(sk, C.int(i))}
+		runtime.SetFinalizer(cert, func(cert *Certificate) {
+			C.X509_free(cert.x)
+		})
+		chain = append(chain, cert)
+	}
+	return chain, nil
+}
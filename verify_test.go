@@ -0,0 +1,287 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertificateStoreVerify(t *testing.T) {
+	root, rootKey := newTestCA(t)
+
+	issue := func(issuer *Certificate, issuerKey PrivateKey, serial int64,
+		exts map[NID]string) (*Certificate, PrivateKey) {
+		key, err := GenerateECKey(Prime256v1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := NewCertificateRequest(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := req.Sign(key, EVP_SHA256); err != nil {
+			t.Fatal(err)
+		}
+		exts[NID_authority_key_identifier] = "keyid:always"
+		exts[NID_subject_key_identifier] = "hash"
+		cert, err := IssueCertificate(req, issuer, issuerKey,
+			&IssuanceProfile{
+				Validity:   time.Hour,
+				Extensions: exts,
+				Serial: func() (*big.Int, error) {
+					return big.NewInt(serial), nil
+				},
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+	inter, interKey := issue(root, rootKey, 10, map[NID]string{
+		NID_basic_constraints: "critical,CA:TRUE,pathlen:0",
+		NID_key_usage:         "critical,keyCertSign,cRLSign",
+	})
+	leaf, _ := issue(inter, interKey, 100, map[NID]string{
+		NID_basic_constraints: "critical,CA:FALSE",
+		NID_key_usage:         "critical,digitalSignature",
+		NID_ext_key_usage:     "clientAuth",
+	})
+
+	store, err := NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddCertificate(root); err != nil {
+		t.Fatal(err)
+	}
+
+	chain, err := store.Verify(leaf, []*Certificate{inter}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 {
+		t.Fatalf("expected a chain of 3 certificates, got %d", len(chain))
+	}
+	for i, want := range []*Certificate{leaf, inter, root} {
+		if chain[i].GetSerialNumberHex() != want.GetSerialNumberHex() {
+			t.Fatalf("unexpected certificate at depth %d", i)
+		}
+	}
+
+	checkResult := func(err error, result VerifyResult) {
+		t.Helper()
+		verr, ok := err.(*VerifyError)
+		if !ok {
+			t.Fatalf("expected a verify error, got %v", err)
+		}
+		if verr.Result != result {
+			t.Fatalf("expected result %d, got %d (%v)", result, verr.Result,
+				verr)
+		}
+	}
+
+	_, err = store.Verify(leaf, nil, nil)
+	checkResult(err, UnableToGetIssuerCertLocally)
+
+	// validity is checked as of the given time
+	_, err = store.Verify(leaf, []*Certificate{inter},
+		&ChainVerifyOptions{Time: time.Now().Add(2 * time.Hour)})
+	checkResult(err, CertHasExpired)
+	_, err = store.Verify(leaf, []*Certificate{inter},
+		&ChainVerifyOptions{Time: time.Now().Add(-time.Hour)})
+	checkResult(err, CertNotYetValid)
+	if _, err := store.Verify(leaf, []*Certificate{inter},
+		&ChainVerifyOptions{Time: time.Now().Add(30 * time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Verify(leaf, []*Certificate{inter},
+		&ChainVerifyOptions{Purpose: PurposeSSLServer})
+	checkResult(err, InvalidPurpose)
+	if _, err := store.Verify(leaf, []*Certificate{inter},
+		&ChainVerifyOptions{Purpose: PurposeSSLClient}); err != nil {
+		t.Fatal(err)
+	}
+
+	// trusting only the intermediate needs a partial chain
+	interStore, err := NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := interStore.AddCertificate(inter); err != nil {
+		t.Fatal(err)
+	}
+	_, err = interStore.Verify(leaf, nil, nil)
+	checkResult(err, UnableToGetIssuerCert)
+	chain, err = interStore.Verify(leaf, nil,
+		&ChainVerifyOptions{Flags: VerifyPartialChain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 {
+		t.Fatalf("expected a chain of 2 certificates, got %d", len(chain))
+	}
+
+	crl, err := NewCRL(inter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.SetNextUpdate(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.AddRevoked(big.NewInt(100), time.Now().Add(-time.Minute),
+		ReasonKeyCompromise); err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.Sign(interKey, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Verify(leaf, []*Certificate{inter},
+		&ChainVerifyOptions{Flags: VerifyCRLCheck, CRLs: []*CRL{crl}})
+	checkResult(err, CertRevoked)
+	_, err = store.Verify(leaf, []*Certificate{inter},
+		&ChainVerifyOptions{Flags: VerifyCRLCheck})
+	checkResult(err, UnableToGetCrl)
+
+	// the same policy can be set on the store itself
+	if err := store.SetFlags(VerifyCRLCheck); err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Verify(leaf, []*Certificate{inter}, nil)
+	checkResult(err, UnableToGetCrl)
+	if err := store.AddCRL(crl); err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Verify(leaf, []*Certificate{inter}, nil)
+	checkResult(err, CertRevoked)
+
+	policyStore, err := NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := policyStore.AddCertificate(root); err != nil {
+		t.Fatal(err)
+	}
+	if err := policyStore.SetPurpose(PurposeSSLServer); err != nil {
+		t.Fatal(err)
+	}
+	if err := policyStore.SetTrust(TrustSSLServer); err != nil {
+		t.Fatal(err)
+	}
+	_, err = policyStore.Verify(leaf, []*Certificate{inter}, nil)
+	checkResult(err, InvalidPurpose)
+	if err := policyStore.SetPurpose(PurposeSSLClient); err != nil {
+		t.Fatal(err)
+	}
+	if err := policyStore.SetTrust(TrustSSLClient); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := policyStore.Verify(leaf, []*Certificate{inter},
+		nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := policyStore.SetPurpose(VerifyPurpose(1000)); err == nil {
+		t.Fatal("expected an error for an invalid purpose")
+	}
+}
+
+func TestCertificateStoreLocations(t *testing.T) {
+	ca, cakey := newTestCA(t)
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := NewCertificateRequest(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Sign(key, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := IssueCertificate(req, ca, cakey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPEM, err := ca.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	err = ioutil.WriteFile(filepath.Join(dir,
+		fmt.Sprintf("%08x.0", ca.SubjectNameHash())), caPEM, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LoadLocationsFromDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Verify(leaf, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(file, caPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LoadLocations(file, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Verify(leaf, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LoadLocations(filepath.Join(dir, "missing.pem"),
+		""); err == nil {
+		t.Fatal("expected an error for a missing ca file")
+	}
+
+	// the default paths can be pointed at our directory
+	os.Setenv("SSL_CERT_DIR", dir)
+	defer os.Unsetenv("SSL_CERT_DIR")
+	store, err = NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetDefaultVerifyPaths(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Verify(leaf, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertificateStoreSystemRoots(t *testing.T) {
+	store, err := NewCertificateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LoadSystemRoots(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"os"
+	"unsafe"
+
+	"github.com/mattn/go-pointer"
+)
+
+// VerifyPeerCertificateCallback checks the certificate chain presented by the
+// peer, given as DER certificates starting with the peer's own. Returning an
+// error rejects the chain.
+type VerifyPeerCertificateCallback func(rawCerts [][]byte) error
+
+// SetVerifyPeerCertificate sets a callback that checks peer certificate
+// chains after OpenSSL verified them, or in place of OpenSSL when a
+// connection was dialed with InsecureSkipVerify. Like OpenSSL's own
+// verification, a rejected chain aborts the handshake, with the error
+// returned by the callback, when the verify mode includes VerifyPeer, and is
+// otherwise only recorded. Passing nil removes the callback.
+func (c *Ctx) SetVerifyPeerCertificate(cb VerifyPeerCertificateCallback) {
+	c.verify_peer_cb = cb
+	if cb == nil {
+		C.X_SSL_CTX_set_cert_verify_cb(c.ctx, 0)
+	} else {
+		C.X_SSL_CTX_set_cert_verify_cb(c.ctx, 1)
+	}
+}
+
+//export go_ssl_ctx_cert_verify_thunk
+func go_ssl_ctx_cert_verify_thunk(p unsafe.Pointer, ssl_p unsafe.Pointer,
+	store *C.X509_STORE_CTX) C.int {
+	defer func() {
+		if err := recover(); err != nil {
+			logCallbackPanic("verify peer certificate callback", err)
+			os.Exit(1)
+		}
+	}()
+	if C.X509_verify_cert(store) <= 0 {
+		return 0
+	}
+	verify_peer_cb := pointer.Restore(p).(*Ctx).verify_peer_cb
+	if verify_peer_cb == nil {
+		return 1
+	}
+	sk := C.X_X509_STORE_CTX_get0_untrusted(store)
+	raw_certs := make([][]byte, 0, int(C.X_sk_X509_num(sk)))
+	for i := 0; i < int(C.X_sk_X509_num(sk)); i++ {
+		cert := &Certificate{x: C.X_sk_X509_value(sk, C.int(i))}
+		der, err := cert.MarshalDER()
+		if err != nil {
+			C.X509_STORE_CTX_set_error(store, C.X509_V_ERR_UNSPECIFIED)
+			return 0
+		}
+		raw_certs = append(raw_certs, der)
+	}
+	if err := verify_peer_cb(raw_certs); err != nil {
+		if ssl_p != nil {
+			pointer.Restore(ssl_p).(*SSL).verify_peer_err = err
+		}
+		C.X509_STORE_CTX_set_error(store,
+			C.X509_V_ERR_APPLICATION_VERIFICATION)
+		return 0
+	}
+	return 1
+}
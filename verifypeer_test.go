@@ -0,0 +1,83 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDialInsecureSkipVerify(t *testing.T) {
+	l := newTestTLSServer(t, "tcp", "localhost:0")
+	defer l.Close()
+	addr := l.Addr().String()
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := cert.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.SetVerifyMode(VerifyPeer)
+	if _, err := Dial("tcp", addr, ctx, 0); err == nil {
+		t.Fatal("dialed untrusted server")
+	}
+	conn, err := Dial("tcp", addr, ctx, InsecureSkipVerify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	rejected := errors.New("certificate not pinned")
+	var calls int
+	ctx.SetVerifyPeerCertificate(func(rawCerts [][]byte) error {
+		calls++
+		if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], pinned) {
+			return rejected
+		}
+		return nil
+	})
+	conn, err = Dial("tcp", addr, ctx, InsecureSkipVerify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if calls != 1 {
+		t.Fatalf("callback called %d times", calls)
+	}
+	if _, err := Dial("tcp", addr, ctx, 0); err == nil {
+		t.Fatal("callback ran in place of chain verification")
+	}
+
+	// chains OpenSSL accepts are still subject to the callback
+	if err := ctx.GetCertificateStore().AddCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	pinned = nil
+	if _, err := Dial("tcp", addr, ctx,
+		InsecureSkipHostVerification); err != rejected {
+		t.Fatalf("expected callback error, got %v", err)
+	}
+	if _, err := Dial("tcp", addr, ctx, InsecureSkipVerify); err != rejected {
+		t.Fatalf("expected callback error, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// virtualHosts maps server names to contexts.
+type virtualHosts struct {
+	exact       map[string]*Ctx
+	wildcard    map[string]*Ctx
+	default_ctx *Ctx
+}
+
+func newVirtualHosts(default_ctx *Ctx,
+	hosts map[string]*Ctx) (*virtualHosts, error) {
+	if default_ctx == nil {
+		return nil, errors.New("no default ssl context provided")
+	}
+	v := &virtualHosts{
+		exact:       make(map[string]*Ctx),
+		wildcard:    make(map[string]*Ctx),
+		default_ctx: default_ctx,
+	}
+	for pattern, ctx := range hosts {
+		if ctx == nil {
+			return nil, fmt.Errorf("no ssl context for host %q", pattern)
+		}
+		name := normalizeServerName(pattern)
+		if strings.HasPrefix(name, "*.") {
+			suffix := name[2:]
+			if suffix == "" || strings.Contains(suffix, "*") {
+				return nil, fmt.Errorf("invalid host pattern %q", pattern)
+			}
+			v.wildcard[suffix] = ctx
+			continue
+		}
+		if name == "" || strings.Contains(name, "*") {
+			return nil, fmt.Errorf("invalid host pattern %q", pattern)
+		}
+		v.exact[name] = ctx
+	}
+	return v, nil
+}
+
+func normalizeServerName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// lookup returns the context for servername: the context of the exact
+// pattern, else of the wildcard pattern covering its first label, else the
+// default one.
+func (v *virtualHosts) lookup(servername string) *Ctx {
+	name := normalizeServerName(servername)
+	if name == "" {
+		return v.default_ctx
+	}
+	if ctx, ok := v.exact[name]; ok {
+		return ctx
+	}
+	if dot := strings.IndexByte(name, '.'); dot > 0 {
+		if ctx, ok := v.wildcard[name[dot+1:]]; ok {
+			return ctx
+		}
+	}
+	return v.default_ctx
+}
+
+// servername switches connections to the context of the name they ask for.
+func (v *virtualHosts) servername(ssl *SSL) SSLTLSExtErr {
+	ctx := v.lookup(ssl.GetServername())
+	if ctx == v.default_ctx {
+		return SSLTLSExtErrOK
+	}
+	ssl.SetSSLCtx(ctx)
+	// SSL_set_SSL_CTX leaves the verification settings of the connection
+	// alone
+	ssl.SetVerify(ctx.VerifyMode(), ctx.verify_cb)
+	ssl.SetVerifyDepth(ctx.GetVerifyDepth())
+	return SSLTLSExtErrOK
+}
+
+// NewVirtualHostListener wraps inner like NewListener, serving each
+// connection with the context of the server name it asks for through SNI.
+// Patterns in hosts are host names, matched without regard to case, or
+// wildcards such as "*.example.com", matching names with exactly one more
+// label in front. Exact names take precedence over wildcards. Connections
+// without SNI or asking for any other name are served with default_ctx,
+// whose servername callback is replaced. Protocol versions, cipher lists and
+// options are those of default_ctx for all connections; certificates, keys
+// and verification settings are those of the matched context.
+func NewVirtualHostListener(inner net.Listener, default_ctx *Ctx,
+	hosts map[string]*Ctx, opts ...ListenerOption) (net.Listener, error) {
+	v, err := newVirtualHosts(default_ctx, hosts)
+	if err != nil {
+		return nil, err
+	}
+	default_ctx.SetTLSExtServernameCallback(v.servername)
+	return NewListener(inner, default_ctx, opts...), nil
+}
+
+// ListenVirtualHosts is like Listen, serving connections as described for
+// NewVirtualHostListener.
+func ListenVirtualHosts(network, laddr string, default_ctx *Ctx,
+	hosts map[string]*Ctx, opts ...ListenerOption) (net.Listener, error) {
+	v, err := newVirtualHosts(default_ctx, hosts)
+	if err != nil {
+		return nil, err
+	}
+	l, err := listen(network, laddr, opts)
+	if err != nil {
+		return nil, err
+	}
+	default_ctx.SetTLSExtServernameCallback(v.servername)
+	return NewListener(l, default_ctx, opts...), nil
+}
@@ -0,0 +1,114 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestVirtualHostCtx(t *testing.T, host string) *Ctx {
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := GenerateSelfSigned([]string{host}, key, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UseCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UsePrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+func TestListenVirtualHosts(t *testing.T) {
+	default_ctx := newTestVirtualHostCtx(t, "default.test")
+	hosts := map[string]*Ctx{
+		"api.example.com": newTestVirtualHostCtx(t, "api.example.com"),
+		"*.example.com":   newTestVirtualHostCtx(t, "*.example.com"),
+		"*.example.org":   newTestVirtualHostCtx(t, "*.example.org"),
+	}
+	l, err := ListenVirtualHosts("tcp", "localhost:0", default_ctx, hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				c.(*Conn).Handshake()
+				c.Read(make([]byte, 1))
+			}()
+		}
+	}()
+
+	for servername, want := range map[string]string{
+		"":                 "default.test",
+		"API.example.com.": "api.example.com",
+		"www.example.com":  "*.example.com",
+		"a.b.example.com":  "default.test",
+		"example.org":      "default.test",
+		"cdn.example.org":  "*.example.org",
+	} {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := Client(c, default_ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if servername != "" {
+			if err := client.SetTlsExtHostName(servername); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := client.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		cert, err := client.PeerCertificate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+		name, err := cert.GetSubjectName()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cn, _ := name.GetEntry(NID_commonName); cn != want {
+			t.Fatalf("%q: served certificate for %q, want %q", servername,
+				cn, want)
+		}
+	}
+
+	if _, err := ListenVirtualHosts("tcp", "localhost:0", default_ctx,
+		map[string]*Ctx{"api.*.com": default_ctx}); err == nil {
+		t.Fatal("accepted invalid host pattern")
+	}
+}
@@ -0,0 +1,406 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"unsafe"
+)
+
+// KeyUsage is the set of purposes permitted by a certificate's key usage
+// extension. Bit i corresponds to bit i of the RFC 5280 KeyUsage BIT STRING.
+type KeyUsage int
+
+const (
+	KeyUsageDigitalSignature KeyUsage = 1 << iota
+	KeyUsageNonRepudiation
+	KeyUsageKeyEncipherment
+	KeyUsageDataEncipherment
+	KeyUsageKeyAgreement
+	KeyUsageKeyCertSign
+	KeyUsageCRLSign
+	KeyUsageEncipherOnly
+	KeyUsageDecipherOnly
+)
+
+// extension returns the DER-encoded value of the extension at loc and
+// whether it is marked critical.
+func (c *Certificate) extension(loc C.int) (value []byte, critical bool) {
+	ext := C.X509_get_ext(c.x, loc)
+	data := C.X509_EXTENSION_get_data(ext)
+	value = C.GoBytes(unsafe.Pointer(C.ASN1_STRING_get0_data(data)),
+		C.ASN1_STRING_length(data))
+	return value, C.X509_EXTENSION_get_critical(ext) != 0
+}
+
+// GetExtension returns the DER-encoded value of the extension identified by
+// the dotted object identifier oid (e.g. "2.5.29.17") and whether it is marked
+// critical. value is nil if the certificate does not carry the extension.
+func (c *Certificate) GetExtension(oid string) (value []byte, critical bool,
+	err error) {
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	obj := C.OBJ_txt2obj(coid, 1)
+	if obj == nil {
+		return nil, false, errors.New("invalid object identifier")
+	}
+	defer C.ASN1_OBJECT_free(obj)
+	loc := C.X509_get_ext_by_OBJ(c.x, obj, -1)
+	if loc < 0 {
+		return nil, false, nil
+	}
+	value, critical = c.extension(loc)
+	return value, critical, nil
+}
+
+// CertificateExtension is an extension with a DER-encoded value.
+type CertificateExtension struct {
+	// OID is the extension type as a dotted object identifier.
+	OID      string
+	Critical bool
+	// Value is the DER encoding of the extension value, without the
+	// wrapping OCTET STRING.
+	Value []byte
+}
+
+// AddExtensionByOID adds an extension of any type, identified by the dotted
+// object identifier oid, with the DER-encoded value. It fails if the
+// certificate already carries an extension of that type.
+func (c *Certificate) AddExtensionByOID(oid string, critical bool,
+	value []byte) error {
+	if len(value) == 0 {
+		return errors.New("empty extension value")
+	}
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	obj := C.OBJ_txt2obj(coid, 1)
+	if obj == nil {
+		return errors.New("invalid object identifier")
+	}
+	defer C.ASN1_OBJECT_free(obj)
+	if C.X509_get_ext_by_OBJ(c.x, obj, -1) >= 0 {
+		return errors.New("duplicate x509v3 extension")
+	}
+	data := C.ASN1_OCTET_STRING_new()
+	if data == nil {
+		return errors.New("failed to allocate octet string")
+	}
+	defer C.ASN1_OCTET_STRING_free(data)
+	if C.ASN1_OCTET_STRING_set(data, (*C.uchar)(unsafe.Pointer(&value[0])),
+		C.int(len(value))) != 1 {
+		return errors.New("failed to set extension value")
+	}
+	var crit C.int
+	if critical {
+		crit = 1
+	}
+	ex := C.X509_EXTENSION_create_by_OBJ(nil, obj, crit, data)
+	if ex == nil {
+		return errors.New("failed to create x509v3 extension")
+	}
+	defer C.X509_EXTENSION_free(ex)
+	if C.X509_add_ext(c.x, ex, -1) <= 0 {
+		return errors.New("failed to add x509v3 extension")
+	}
+	return nil
+}
+
+// extensionByNID is like GetExtension but looks the extension up by NID.
+func (c *Certificate) extensionByNID(nid NID) (value []byte, found bool) {
+	loc := C.X509_get_ext_by_NID(c.x, C.int(nid), -1)
+	if loc < 0 {
+		return nil, false
+	}
+	value, _ = c.extension(loc)
+	return value, true
+}
+
+// KeyUsage returns the key usage bits of the certificate, or zero if it has
+// no key usage extension.
+func (c *Certificate) KeyUsage() (KeyUsage, error) {
+	der, found := c.extensionByNID(NID_key_usage)
+	if !found {
+		return 0, nil
+	}
+	var bits asn1.BitString
+	if rest, err := asn1.Unmarshal(der, &bits); err != nil {
+		return 0, err
+	} else if len(rest) != 0 {
+		return 0, errors.New("trailing data after key usage")
+	}
+	var usage KeyUsage
+	for i := 0; i < 9; i++ {
+		if bits.At(i) != 0 {
+			usage |= 1 << uint(i)
+		}
+	}
+	return usage, nil
+}
+
+// ExtendedKeyUsage returns the purposes listed in the certificate's extended
+// key usage extension, such as NID_server_auth or NID_client_auth, or nil if
+// it has none. Purposes unknown to OpenSSL are returned as NID_undef.
+func (c *Certificate) ExtendedKeyUsage() ([]NID, error) {
+	der, found := c.extensionByNID(NID_ext_key_usage)
+	if !found {
+		return nil, nil
+	}
+	var oids []asn1.ObjectIdentifier
+	if rest, err := asn1.Unmarshal(der, &oids); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("trailing data after extended key usage")
+	}
+	nids := make([]NID, 0, len(oids))
+	for _, oid := range oids {
+		coid := C.CString(oid.String())
+		nids = append(nids, NID(C.OBJ_txt2nid(coid)))
+		C.free(unsafe.Pointer(coid))
+	}
+	return nids, nil
+}
+
+// BasicConstraints reports whether the certificate is a CA and the maximum
+// number of intermediate CAs that may follow it. maxPathLen is -1 if no
+// limit is set or the certificate has no basic constraints extension.
+func (c *Certificate) BasicConstraints() (isCA bool, maxPathLen int,
+	err error) {
+	der, found := c.extensionByNID(NID_basic_constraints)
+	if !found {
+		return false, -1, nil
+	}
+	var constraints struct {
+		IsCA       bool `asn1:"optional"`
+		MaxPathLen int  `asn1:"optional,default:-1"`
+	}
+	if rest, err := asn1.Unmarshal(der, &constraints); err != nil {
+		return false, -1, err
+	} else if len(rest) != 0 {
+		return false, -1, errors.New("trailing data after basic constraints")
+	}
+	return constraints.IsCA, constraints.MaxPathLen, nil
+}
+
+// SubjectKeyId returns the certificate's subject key identifier, or nil if it
+// has none.
+func (c *Certificate) SubjectKeyId() ([]byte, error) {
+	der, found := c.extensionByNID(NID_subject_key_identifier)
+	if !found {
+		return nil, nil
+	}
+	var id []byte
+	if rest, err := asn1.Unmarshal(der, &id); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("trailing data after subject key identifier")
+	}
+	return id, nil
+}
+
+// AuthorityKeyId returns the key identifier from the certificate's authority
+// key identifier extension, or nil if it has none. See
+// AuthorityKeyIdentifier for the issuer name and serial number alternative.
+func (c *Certificate) AuthorityKeyId() ([]byte, error) {
+	aki, err := c.AuthorityKeyIdentifier()
+	if aki == nil {
+		return nil, err
+	}
+	return aki.KeyId, nil
+}
+
+// AuthorityKeyIdentifier is the content of an authority key identifier
+// extension, which identifies the key that signed a certificate either by
+// key identifier or by the issuer's issuer name and serial number.
+type AuthorityKeyIdentifier struct {
+	// KeyId is the subject key identifier of the issuer, if present.
+	KeyId []byte
+	// IssuerNames holds the directory names among the authority cert
+	// issuer names. Other name types are skipped.
+	IssuerNames []*Name
+	// SerialNumber is the serial number of the issuer's certificate, or nil.
+	SerialNumber *big.Int
+}
+
+// AuthorityKeyIdentifier returns the complete authority key identifier
+// extension of the certificate, or nil if it has none.
+func (c *Certificate) AuthorityKeyIdentifier() (*AuthorityKeyIdentifier,
+	error) {
+	der, found := c.extensionByNID(NID_authority_key_identifier)
+	if !found {
+		return nil, nil
+	}
+	var raw struct {
+		KeyId        []byte        `asn1:"optional,tag:0"`
+		Issuer       asn1.RawValue `asn1:"optional,tag:1"`
+		SerialNumber *big.Int      `asn1:"optional,tag:2"`
+	}
+	if rest, err := asn1.Unmarshal(der, &raw); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("trailing data after authority key identifier")
+	}
+	aki := &AuthorityKeyIdentifier{
+		KeyId:        raw.KeyId,
+		SerialNumber: raw.SerialNumber,
+	}
+	for rest := raw.Issuer.Bytes; len(rest) > 0; {
+		var v asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &v); err != nil {
+			return nil, err
+		}
+		if v.Class != asn1.ClassContextSpecific || v.Tag != 4 {
+			continue
+		}
+		name, err := loadNameFromDER(v.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		aki.IssuerNames = append(aki.IssuerNames, name)
+	}
+	return aki, nil
+}
+
+// tlsFeatureStatusRequest is the status_request TLS extension, whose
+// presence in the TLS feature extension requires OCSP stapling (RFC 7633).
+const tlsFeatureStatusRequest = 5
+
+// MustStaple reports whether the certificate's TLS feature extension
+// requires servers to staple an OCSP response.
+func (c *Certificate) MustStaple() (bool, error) {
+	der, found := c.extensionByNID(NID_tlsfeature)
+	if !found {
+		return false, nil
+	}
+	var features []int
+	if rest, err := asn1.Unmarshal(der, &features); err != nil {
+		return false, err
+	} else if len(rest) != 0 {
+		return false, errors.New("trailing data after tls feature")
+	}
+	for _, feature := range features {
+		if feature == tlsFeatureStatusRequest {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// subjectAltNames holds the names found in a subject alternative name
+// extension, grouped by GeneralName type.
+type subjectAltNames struct {
+	dnsNames       []string
+	emailAddresses []string
+	ipAddresses    []net.IP
+	uris           []*url.URL
+}
+
+// parseSubjectAltNames decodes the certificate's subject alternative name
+// extension. Name types other than DNS, email, IP and URI are skipped.
+func (c *Certificate) parseSubjectAltNames() (*subjectAltNames, error) {
+	names := &subjectAltNames{}
+	der, found := c.extensionByNID(NID_subject_alt_name)
+	if !found {
+		return names, nil
+	}
+	var seq asn1.RawValue
+	if rest, err := asn1.Unmarshal(der, &seq); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("trailing data after subject alternative name")
+	}
+	if !seq.IsCompound || seq.Tag != asn1.TagSequence ||
+		seq.Class != asn1.ClassUniversal {
+		return nil, errors.New("invalid subject alternative name")
+	}
+	rest := seq.Bytes
+	for len(rest) > 0 {
+		var v asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &v); err != nil {
+			return nil, err
+		}
+		if v.Class != asn1.ClassContextSpecific {
+			return nil, errors.New("invalid subject alternative name")
+		}
+		switch v.Tag {
+		case 1:
+			names.emailAddresses = append(names.emailAddresses,
+				string(v.Bytes))
+		case 2:
+			names.dnsNames = append(names.dnsNames, string(v.Bytes))
+		case 6:
+			uri, err := url.Parse(string(v.Bytes))
+			if err != nil {
+				return nil, err
+			}
+			names.uris = append(names.uris, uri)
+		case 7:
+			if len(v.Bytes) != net.IPv4len && len(v.Bytes) != net.IPv6len {
+				return nil, errors.New("invalid ip address length")
+			}
+			names.ipAddresses = append(names.ipAddresses,
+				net.IP(append([]byte(nil), v.Bytes...)))
+		}
+	}
+	return names, nil
+}
+
+// DNSNames returns the DNS names from the certificate's subject alternative
+// name extension.
+func (c *Certificate) DNSNames() ([]string, error) {
+	names, err := c.parseSubjectAltNames()
+	if err != nil {
+		return nil, err
+	}
+	return names.dnsNames, nil
+}
+
+// IPAddresses returns the IP addresses from the certificate's subject
+// alternative name extension.
+func (c *Certificate) IPAddresses() ([]net.IP, error) {
+	names, err := c.parseSubjectAltNames()
+	if err != nil {
+		return nil, err
+	}
+	return names.ipAddresses, nil
+}
+
+// EmailAddresses returns the email addresses from the certificate's subject
+// alternative name extension.
+func (c *Certificate) EmailAddresses() ([]string, error) {
+	names, err := c.parseSubjectAltNames()
+	if err != nil {
+		return nil, err
+	}
+	return names.emailAddresses, nil
+}
+
+// URIs returns the URIs from the certificate's subject alternative name
+// extension.
+func (c *Certificate) URIs() ([]*url.URL, error) {
+	names, err := c.parseSubjectAltNames()
+	if err != nil {
+		return nil, err
+	}
+	return names.uris, nil
+}